@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const configFileName = "novfmt.toml"
+
+// config holds flag defaults read from novfmt.toml. Values are kept as the
+// strings flag.Value.Set would receive; lists become repeated Set calls.
+type config struct {
+	path     string
+	global   map[string][]string
+	commands map[string]map[string][]string
+}
+
+// activeConfig is loaded once in main and consulted by parseFlags.
+var activeConfig *config
+
+// loadConfig reads the file named by NOVFMT_CONFIG, or else novfmt.toml from
+// the working directory or novfmt/ in the user config directory. A missing
+// default file is not an error.
+func loadConfig() (*config, error) {
+	if p := os.Getenv("NOVFMT_CONFIG"); p != "" {
+		return loadConfigFile(p)
+	}
+	candidates := []string{configFileName}
+	if dir, err := userConfigDir(); err == nil {
+		candidates = append(candidates, filepath.Join(dir, "novfmt", configFileName))
+	}
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("config %s: %w", p, err)
+		}
+		return loadConfigFile(p)
+	}
+	return nil, nil
+}
+
+// userConfigDir is $XDG_CONFIG_HOME, or ~/.config when that is unset, on
+// every platform, so the file lives where the docs say.
+func userConfigDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config"), nil
+}
+
+func loadConfigFile(path string) (*config, error) {
+	var raw map[string]any
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+
+	cfg := &config{
+		path:     path,
+		global:   map[string][]string{},
+		commands: map[string]map[string][]string{},
+	}
+	for key, val := range raw {
+		if table, ok := val.(map[string]any); ok {
+			section := map[string][]string{}
+			for k, v := range table {
+				vals, err := configValues(v)
+				if err != nil {
+					return nil, fmt.Errorf("config %s: [%s] %s: %w", path, key, k, err)
+				}
+				section[k] = vals
+			}
+			cfg.commands[key] = section
+			continue
+		}
+		vals, err := configValues(val)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %s: %w", path, key, err)
+		}
+		cfg.global[key] = vals
+	}
+	return cfg, nil
+}
+
+func configValues(v any) ([]string, error) {
+	switch t := v.(type) {
+	case string, bool, int64, float64:
+		return []string{fmt.Sprint(t)}, nil
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, item := range t {
+			vals, err := configValues(item)
+			if err != nil {
+				return nil, err
+			}
+			if len(vals) != 1 {
+				return nil, fmt.Errorf("nested arrays are not supported")
+			}
+			out = append(out, vals[0])
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T", v)
+}
+
+// envName returns the environment variable for a flag, either scoped to one
+// command (NOVFMT_MERGE_TEMP_DIR) or shared by all (NOVFMT_TEMP_DIR).
+func envName(command, flagName string) string {
+	name := "NOVFMT_"
+	if command != "" {
+		name += command + "_"
+	}
+	name += flagName
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// parseFlags parses args into fs, then fills every flag the command line left
+// unset, in order of precedence: NOVFMT_<COMMAND>_<FLAG> and NOVFMT_<FLAG>
+// environment variables, the command's config table, top-level config keys.
+// Keys in a command table must name one of that command's flags.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// set holds the flags given a value so far. Marking one also marks the
+	// aliases sharing its variable (-o and -out), so a value given under one
+	// spelling isn't overridden under the other.
+	set := map[string]bool{}
+	markSet := func(name string) {
+		v := fs.Lookup(name).Value
+		fs.VisitAll(func(f *flag.Flag) {
+			if f.Value == v {
+				set[f.Name] = true
+			}
+		})
+	}
+	fs.Visit(func(f *flag.Flag) {
+		markSet(f.Name)
+	})
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		// Single-letter aliases share their long flag's variable.
+		if envErr != nil || set[f.Name] || len(f.Name) == 1 {
+			return
+		}
+		for _, key := range []string{envName(fs.Name(), f.Name), envName("", f.Name)} {
+			val, ok := os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+			if err := fs.Set(f.Name, val); err != nil {
+				envErr = fmt.Errorf("%s: %w", key, err)
+				return
+			}
+			markSet(f.Name)
+			return
+		}
+	})
+	if envErr != nil {
+		return envErr
+	}
+
+	if activeConfig == nil {
+		return nil
+	}
+
+	section := activeConfig.commands[fs.Name()]
+	for name := range section {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("config %s: [%s] unknown option %q", activeConfig.path, fs.Name(), name)
+		}
+	}
+
+	apply := func(name string, vals []string) error {
+		if set[name] || fs.Lookup(name) == nil {
+			return nil
+		}
+		for _, v := range vals {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("config %s: %s: %w", activeConfig.path, name, err)
+			}
+		}
+		markSet(name)
+		return nil
+	}
+	for name, vals := range section {
+		if err := apply(name, vals); err != nil {
+			return err
+		}
+	}
+	for name, vals := range activeConfig.global {
+		if err := apply(name, vals); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) *config {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), configFileName)
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg, err := loadConfigFile(p)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	return cfg
+}
+
+func withConfig(t *testing.T, cfg *config) {
+	t.Helper()
+	prev := activeConfig
+	activeConfig = cfg
+	t.Cleanup(func() { activeConfig = prev })
+}
+
+func TestParseFlagsConfigPrecedence(t *testing.T) {
+	withConfig(t, writeConfig(t, `
+compression = 9
+title = "Global Title"
+
+[merge]
+out = "from-config.epub"
+creator = ["Config A", "Config B"]
+no-sort = true
+`))
+
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	out := fs.String("out", "merged.epub", "")
+	title := fs.String("title", "", "")
+	compression := fs.String("compression", "default", "")
+	noSort := fs.Bool("no-sort", false, "")
+	var creators multiValue
+	fs.Var(&creators, "creator", "")
+
+	if err := parseFlags(fs, []string{"-title", "Flag Title", "a.epub"}); err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+
+	if *title != "Flag Title" {
+		t.Fatalf("flag should beat config, title=%q", *title)
+	}
+	if *out != "from-config.epub" {
+		t.Fatalf("config should beat built-in default, out=%q", *out)
+	}
+	if *compression != "9" {
+		t.Fatalf("top-level key should apply, compression=%q", *compression)
+	}
+	if !*noSort {
+		t.Fatalf("bool config value not applied")
+	}
+	if len(creators) != 2 || creators[0] != "Config A" || creators[1] != "Config B" {
+		t.Fatalf("creators = %v", creators)
+	}
+	if fs.NArg() != 1 || fs.Arg(0) != "a.epub" {
+		t.Fatalf("positional args lost: %v", fs.Args())
+	}
+}
+
+func TestParseFlagsConfigRepeatableOverride(t *testing.T) {
+	withConfig(t, writeConfig(t, `
+[merge]
+creator = ["Config A"]
+`))
+
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	var creators multiValue
+	fs.Var(&creators, "creator", "")
+
+	if err := parseFlags(fs, []string{"-creator", "Flag A"}); err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	if len(creators) != 1 || creators[0] != "Flag A" {
+		t.Fatalf("command-line creators should replace config ones, got %v", creators)
+	}
+}
+
+func TestParseFlagsConfigShortAliases(t *testing.T) {
+	withConfig(t, writeConfig(t, `
+[merge]
+out = "config.epub"
+title = "Config Title"
+creator = ["Config A"]
+`))
+
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	out := fs.String("out", "merged.epub", "")
+	fs.StringVar(out, "o", "merged.epub", "")
+	title := fs.String("title", "", "")
+	fs.StringVar(title, "t", "", "")
+	var creators multiValue
+	fs.Var(&creators, "creator", "")
+	fs.Var(&creators, "c", "")
+
+	if err := parseFlags(fs, []string{"-o", "x.epub", "-t", "Flag Title", "-c", "Flag A"}); err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	if *out != "x.epub" || *title != "Flag Title" {
+		t.Fatalf("short flags should beat config, out=%q title=%q", *out, *title)
+	}
+	if len(creators) != 1 || creators[0] != "Flag A" {
+		t.Fatalf("command-line creators should replace config ones, got %v", creators)
+	}
+}
+
+func TestParseFlagsConfigUnknownKey(t *testing.T) {
+	withConfig(t, writeConfig(t, `
+[merge]
+no-such-flag = 1
+`))
+
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	if err := parseFlags(fs, nil); err == nil {
+		t.Fatalf("expected error for unknown key in command table")
+	}
+}
+
+func TestParseFlagsConfigGlobalIgnoresMissingFlags(t *testing.T) {
+	withConfig(t, writeConfig(t, `creator = ["Someone"]`))
+
+	fs := flag.NewFlagSet("rewrite", flag.ContinueOnError)
+	if err := parseFlags(fs, nil); err != nil {
+		t.Fatalf("top-level keys for other commands should be ignored: %v", err)
+	}
+}
+
+func TestParseFlagsEnvPrecedence(t *testing.T) {
+	withConfig(t, writeConfig(t, `
+[merge]
+out = "config.epub"
+temp-dir = "/config/tmp"
+compression = 3
+title = "Config Title"
+`))
+	t.Setenv("NOVFMT_OUT", "env.epub")
+	t.Setenv("NOVFMT_TEMP_DIR", "/env/tmp")
+	t.Setenv("NOVFMT_MERGE_TEMP_DIR", "/env/merge/tmp")
+	t.Setenv("NOVFMT_TITLE", "Env Title")
+
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	out := fs.String("out", "merged.epub", "")
+	fs.StringVar(out, "o", "merged.epub", "")
+	tempDir := fs.String("temp-dir", "", "")
+	compression := fs.String("compression", "default", "")
+	title := fs.String("title", "", "")
+
+	if err := parseFlags(fs, []string{"-title", "Flag Title"}); err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+
+	if *title != "Flag Title" {
+		t.Fatalf("flag should beat env, title=%q", *title)
+	}
+	if *out != "env.epub" {
+		t.Fatalf("env should beat config, out=%q", *out)
+	}
+	if *tempDir != "/env/merge/tmp" {
+		t.Fatalf("command-scoped env should beat shared env, temp-dir=%q", *tempDir)
+	}
+	if *compression != "3" {
+		t.Fatalf("config should apply when env is unset, compression=%q", *compression)
+	}
+}
+
+func TestParseFlagsEnvShortAliases(t *testing.T) {
+	withConfig(t, nil)
+	t.Setenv("NOVFMT_OUT", "foo.txt")
+	t.Setenv("NOVFMT_TITLE", "Env Title")
+
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	out := fs.String("out", "merged.epub", "")
+	fs.StringVar(out, "o", "merged.epub", "")
+	title := fs.String("title", "", "")
+	fs.StringVar(title, "t", "", "")
+
+	if err := parseFlags(fs, []string{"-o", "x.epub", "-t", "Flag Title"}); err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	if *out != "x.epub" || *title != "Flag Title" {
+		t.Fatalf("short flags should beat env, out=%q title=%q", *out, *title)
+	}
+}
+
+func TestParseFlagsEnvInvalid(t *testing.T) {
+	withConfig(t, nil)
+	t.Setenv("NOVFMT_DRY_RUN", "maybe")
+
+	fs := flag.NewFlagSet("rewrite", flag.ContinueOnError)
+	fs.Bool("dry-run", false, "")
+	if err := parseFlags(fs, nil); err == nil {
+		t.Fatalf("expected error for invalid bool in environment")
+	}
+}
+
+func TestLoadConfigXDG(t *testing.T) {
+	t.Setenv("NOVFMT_CONFIG", "")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	write := func(dir string) string {
+		t.Helper()
+		p := filepath.Join(dir, "novfmt", configFileName)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("compression = 9\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	want := write(filepath.Join(home, ".config"))
+	if cfg, err := loadConfig(); err != nil || cfg == nil || cfg.path != want {
+		t.Fatalf("loadConfig = %+v, %v; want %s", cfg, err, want)
+	}
+
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	want = write(xdg)
+	if cfg, err := loadConfig(); err != nil || cfg == nil || cfg.path != want {
+		t.Fatalf("loadConfig = %+v, %v; want %s", cfg, err, want)
+	}
+}
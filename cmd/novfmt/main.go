@@ -4,8 +4,11 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -14,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
 
 	"github.com/kototok903/novfmt/internal/epub"
 )
@@ -27,7 +31,13 @@ func main() {
 		os.Exit(1)
 	}
 
-	var err error
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	activeConfig = cfg
+
 	switch os.Args[1] {
 	case "merge":
 		err = runMerge(ctx, os.Args[2:])
@@ -35,6 +45,40 @@ func main() {
 		err = runEditMeta(ctx, os.Args[2:])
 	case "rewrite":
 		err = runRewrite(ctx, os.Args[2:])
+	case "fonts":
+		err = runFonts(ctx, os.Args[2:])
+	case "fix-mediatypes":
+		err = runFixMediaTypes(ctx, os.Args[2:])
+	case "fix-mimetype":
+		err = runFixMimetype(ctx, os.Args[2:])
+	case "md":
+		err = runMarkdown(ctx, os.Args[2:])
+	case "check-chapters":
+		err = runCheckChapters(ctx, os.Args[2:])
+	case "provenance":
+		err = runProvenance(ctx, os.Args[2:])
+	case "links":
+		err = runLinks(ctx, os.Args[2:])
+	case "toc-diff":
+		err = runTOCDiff(ctx, os.Args[2:])
+	case "grep":
+		err = runGrep(ctx, os.Args[2:])
+	case "images":
+		err = runImages(ctx, os.Args[2:])
+	case "meta":
+		err = runMeta(ctx, os.Args[2:])
+	case "normalize":
+		err = runNormalize(ctx, os.Args[2:])
+	case "unpack":
+		err = runUnpack(ctx, os.Args[2:])
+	case "pack":
+		err = runPack(ctx, os.Args[2:])
+	case "split":
+		err = runSplit(ctx, os.Args[2:])
+	case "info":
+		err = runInfo(ctx, os.Args[2:])
+	case "validate":
+		err = runValidate(ctx, os.Args[2:])
 	case "help", "-h", "--help":
 		printUsage()
 		return
@@ -60,22 +104,206 @@ Commands:
   merge       combine multiple EPUB volumes into one
   edit-meta   view or modify EPUB metadata and navigation
   rewrite     search/replace text inside an EPUB
+  fonts       list embedded fonts and whether they are obfuscated
+  fix-mediatypes
+              fill in missing manifest media-types
+  fix-mimetype
+              make the mimetype entry first and uncompressed
+  md          convert a chapter (or the whole book) to Markdown
+  check-chapters
+              flag nearly empty chapters left by a broken conversion
+  provenance  show which source volume each spine item of a merged book came from
+  links       list (or strip) links to web and mail addresses
+  toc-diff    compare the TOCs of two EPUBs entry by entry
+  grep        search the text of many EPUBs for a pattern
+  images      copy a book's images into a folder
+  meta        metadata tools: export and import a JSON sidecar, or clean
+  normalize   repack a book in a canonical, reproducible form
+  unpack      extract a book into a folder for hand editing
+  pack        zip such a folder back into an EPUB
+  split       break a merged book back into one EPUB per volume
+  info        summarize a book's metadata, spine, manifest and TOC
+  validate    check books for structural problems
 `
 
 const usageMerge = `Merge:
   novfmt merge [options] <vol1.epub> <vol2.epub> [...]
 
   Requires at least 2 input volumes (from any combination of positional
-  args, -list, and -dir). Volumes are appended in the order given.
+  args, -list, and -dir), or 1 with -preserve-nav. Volumes are appended in
+  the order given.
 
   -o, -out <path>       output file path (default: merged.epub)
+  -out-dir <dir>        directory a relative -o path is written to, handy as a
+                        novfmt.toml default (default: the current directory)
   -t, -title <str>      title for the merged book (default: first volume's title)
-  -lang <code>          language code, e.g. "en" (default: first volume's language)
-  -c, -creator <name>   author credit; repeatable; replaces original creator lists
+  -lang <code>          language code, e.g. "en"; normalized to BCP 47 (en_US -> en-US)
+                        (default: first volume's language)
+  -c, -creator <name>   creator credit; repeatable; replaces original creator lists;
+                        append a MARC relator code for other roles, e.g.
+                        "Some Name:trl" or "Other Name:ill" (default: aut)
+  -identifier <id>      unique identifier for the merged book, e.g. "urn:uuid:..."
+                        or an ISBN; reuse it to keep one identity across re-merges
+                        (default: a new random urn:uuid each run); not allowed
+                        when -max-size splits the output
+  -keep-source-identifiers-as-isbn
+                        also list the volumes' ISBN identifiers (opf:scheme="ISBN"
+                        or urn:isbn:) as ISBNs of the merged book; the unique
+                        identifier is unchanged
+  -sort-creators        list creators alphabetically (default: the order given,
+                        or the order they appear in the volumes)
+  -rights <str>         license/rights statement (dc:rights) for the merged book
+  -rights-from <which>  first (default), last, or all: which volumes' dc:rights to
+                        keep when -rights isn't given; all keeps each distinct one
+  -publisher <str>      publisher (dc:publisher) for the merged book (default: the
+                        first volume's that has one)
+  -description <str>    description (dc:description) for the merged book
+                        (default: the first volume's that has one)
+  -collection <name>    series the merged book belongs to (belongs-to-collection)
+                        (default: the volumes' collection, or -title if they differ)
   -list <file>          text file with one volume path per line; blank lines and
-                        lines starting with # are ignored; repeatable
+                        lines starting with # are ignored; repeatable. A line
+                        "arc: <name>" nests the volumes after it under an arc
+                        heading in the TOC, up to the next arc line ("arc:"
+                        alone returns to the top level)
+  -save-list <file>     write the final input order (after -dir sorting and
+                        -volumes) to file as a -list file before merging
   -dir <path>           directory to scan for .epub files, sorted numerically
-                        when filenames contain numbers; repeatable
+                        when filenames contain numbers (7.5 after 7, 10-11 after
+                        10); repeatable
+  -no-sort              keep -dir files in directory listing order instead of
+                        sorting by volume number
+  -recursive            also scan -dir's subfolders (not symlinked ones); a folder's
+                        own files come first, then its subfolders, sorted by
+                        number like the files
+  -volumes, -range <sel>
+                        merge only these volumes, e.g. 5-10 or 5,7,9 or 1-3,8;
+                        numbers come from the file names as for -dir (or, when
+                        any input name has no number, its position in the input
+                        list); every selected volume must exist. A range also
+                        takes the side stories in it: 5-10 includes Vol 7.5
+  -preserve-times       keep each file's modified time from its source volume in
+                        the output archive (default: entry times are left unset)
+  -checksum             also write the output's SHA-256 to <out>.sha256
+  -verify               after writing, check that every volume section still
+                        resolves on its own (spine, manifest files, nav links)
+  -validate-after       after writing, check the whole book's structure
+                        (mimetype, metadata, manifest, spine, nav, well-formed
+                        XHTML) and fail if there are errors, unless -force
+  -max-size <size>      split the output into parts of at most this size, e.g.
+                        300MB, written as <out>.part01.epub, .part02, ...; parts
+                        break between volumes, and a volume over the limit gets
+                        a part of its own
+  -dry-run              stage the merge and print its size and an estimate of the
+                        compressed output without writing anything
+  -print-opf            with -dry-run, print the generated package document and
+                        nav to stdout
+  -strip-title-prefix <str>
+                        remove a leading string (e.g. the series name) from each
+                        volume's TOC title
+  -strip-title-regex    treat -strip-title-prefix as a Go regular expression
+  -rename-title-conflicts
+                        number volume TOC titles that appear more than once,
+                        e.g. "Series (1)", "Series (2)"
+  -toc-title <str>      title and heading of the generated table of contents
+                        (default: "Table of Contents", or the usual heading for
+                        -lang in Japanese, Chinese, Korean and a few others)
+  -ncx                  also write a toc.ncx of the TOC for older readers and
+                        Kindle conversion
+  -collapse-toc         fold TOC entries that have a single child into one entry,
+                        e.g. "Vol 1" > "Part 1" becomes "Vol 1: Part 1"
+  -dedupe-nav           drop TOC entries with the same title and link as an
+                        earlier entry, keeping the first
+  -landmarks            add a landmarks nav with "begin reading" at the first
+                        volume's first chapter (skipping covers, title and
+                        contents pages) and a start entry for each volume
+  -guess-cover          for volumes that declare no cover, use the image of their
+                        first page when that page is a single full-page image
+  -cover-entries        list each volume's cover page first under the volume in
+                        the TOC (its cover landmark, or a first page that is the
+                        cover image), replacing the volume's own cover entry
+  -cover-entry-title <title>
+                        title of those entries (default: Cover)
+  -illustrations <sources>
+                        add a list of illustrations grouped by volume, taken from
+                        each volume's first source that has any: loi (its own
+                        list or loi landmark), toc (TOC entries titled like
+                        "Illustrations" or "Insert"), pages (pages that are just
+                        an image); comma-separated, or auto for all three
+  -illustration-pattern <regex>
+                        TOC titles the toc source picks (default: illustration,
+                        insert, color pages and their Japanese/Chinese names)
+  -progress-anchors <n> give every nth paragraph an id and list them in a hidden
+                        page-list nav, for finer progress in readers that use
+                        page lists
+  -normalize-headings <level>
+                        retag each volume's headings so chapters use the same
+                        level: h1-h6, or auto for the level most volumes use;
+                        subheadings shift with them
+  -volume-dir <template>
+                        name each volume's folder under Volumes/: {index} or
+                        {index:N} (zero-padded) for the volume number, {title}
+                        for its title made file-name safe, e.g. "v{index:2}" or
+                        "Vol-{index:2}-{title}" (default: v{index:4})
+  -cover-mode <mode>    first (default: use the first volume's cover), last, none
+                        (no cover), grid (tile every volume's cover into a
+                        generated image), or the path of an image file to use
+  -cover-columns <n>    columns for -cover-mode grid (default: roughly square)
+  -cover-background <color>
+                        background for -cover-mode grid as #rrggbb (default: #ffffff)
+  -metadata-template <file>
+                        XML file with a <metadata> element used as the merged
+                        book's metadata instead of the first volume's; -title,
+                        -lang and -creator still override it
+  -preserve-nav         keep the source nav document (landmarks, page list,
+                        styling) instead of generating one; only possible when
+                        the output holds a single volume, e.g. to repackage one
+                        book; otherwise the nav is still generated, with a warning
+  -index-page           start the book with a generated page linking to each volume
+  -index-covers         show each volume's cover on the -index-page
+  -volume-dividers      start each volume with a generated page showing its title
+  -no-tool-meta         omit the novfmt-specific meta and prefix declaration
+  -rewrite-rules <file> JSON rule file (as for rewrite -rules) applied to each
+                        volume's content documents before merging
+  -strip-comments       remove <!-- --> comments from the volumes' content
+                        documents (as for rewrite -strip-comments)
+  -dedupe-images        store byte-identical images shared by several volumes once
+                        (each volume's cover is kept)
+  -dedupe-resources     store byte-identical stylesheets, fonts and images once,
+                        in a shared Shared/ folder (each volume's cover is kept)
+  -flatten-images <size>
+                        inline images up to this size (e.g. 2KB) that are only
+                        shown by <img> as data URIs, removing their files; covers
+                        are kept
+  -ppd <dir>            page progression direction for the merged book: ltr, rtl or
+                        default (default: the first volume that declares one);
+                        a warning is printed when rtl and ltr volumes are mixed
+  -order <o>            spine (default) or nav — follow each volume's nav instead
+                        of its spine for the reading order; useful for books with a
+                        scrambled spine
+  -manifest-order <o>   source (default), href, or type (media type, then href) —
+                        sort each volume's manifest items for a stable,
+                        readable package document
+  -interleave           alternate the chapters of exactly two volumes (e.g. the
+                        original and a translation) and pair them in the TOC;
+                        both must have the same number of spine documents
+  -kobo                 experimental: wrap each sentence in the koboSpan spans Kobo
+                        readers use and write the output as <out>.kepub.epub
+  -trace-ids            print how each volume's manifest ids were renamed and
+                        the final manifest (id = href) to stderr, for
+                        tracking down broken links
+  -force                write the output even if its name doesn't end in .epub,
+                        keep a -lang value that isn't a valid BCP 47 tag, and
+                        only report -validate-after errors
+  -content-dir <name>   directory holding the book's files inside the EPUB
+                        (default: OEBPS; EPUB is the EPUB 3 convention)
+  -opf-name <name>      package document file name (default: content.opf)
+  -compression <level>  default, store (no compression), or a deflate level 1-9
+  -temp-dir <path>      directory for extracted and staged files (default: system temp)
+  -deadline <duration>  abort the whole merge if it runs longer than this, e.g. 10m
+                        (default: no limit)
+  -threads <n>          maximum number of volumes extracted in parallel
+                        (default: number of CPUs)
 `
 
 const usageEditMeta = `Edit-meta:
@@ -85,7 +313,7 @@ const usageEditMeta = `Edit-meta:
   Can run in dump-only mode (just -dump-meta / -dump-nav, no edits).
 
   -title <str>          set primary title
-  -lang <code>          set language code
+  -lang <code>          set language code; normalized to BCP 47 (en_US -> en-US)
   -identifier <str>     set primary identifier (e.g. ISBN, UUID)
   -description <str>    set description text
   -creator <name>       author credit; repeatable; replaces existing creator list
@@ -96,29 +324,290 @@ const usageEditMeta = `Edit-meta:
   -dump-nav <file>      export current nav document (XHTML) to <file>
   -o, -out <path>       write result to a new file instead of editing in place
   -no-touch-modified    don't update the last-modified timestamp (dcterms:modified)
+  -force                keep a language code that isn't a valid BCP 47 tag
 
   CLI flags override values from -meta when both are given.
 `
 
 const usageRewrite = `Rewrite:
   novfmt rewrite [options] <book.epub>
+  novfmt rewrite -batch -report-dir <dir> [options] <library-dir>
 
   Without -out the input file is modified in place.
-  At least one of -find or -rules is required.
+  At least one of -find, -rule, -rules, -trim-whitespace or -strip-comments is required.
 
   -find <str>           literal string to search for (see -regex)
   -replace <str>        replacement text (default: empty string, i.e. delete matches)
-  -regex                treat -find as a Go regular expression
+  -rule <find=replace>  another find/replace pair; repeatable, applied in order after
+                        -rules and before -find. Write \= for a "=" in the find
+                        text. -regex, -i, -w and -selector apply to these too
+  -regex                treat -find as a Go regular expression; -replace can use
+                        its groups as $1, ${2} or ${name}
   -i, -ignore-case      make matching case-insensitive (default: case-sensitive)
-  -scope <s>            body, meta, or all — limit where rewrites apply (default: body)
+  -w, -whole-word       only match whole words, so "Rin" leaves "Ring" alone
+  -scope <s>            body, meta, all, or cover — limit where rewrites apply
+                        (default: body); cover touches only the cover and title page
+                        documents named by the landmarks nav (or the page showing
+                        the cover image)
   -selector <sel>       CSS-like selector to target elements (e.g. p, .note, p.chapter);
                         repeatable; applies to the -find/-replace rule
   -rules <file>         JSON file with an array of rule objects, each with:
-                        find, replace, regex, ignore_case, selectors
+                        find, replace, regex, ignore_case, whole_word, selectors,
+                        scope (body, meta or all, within -scope); applied before
+                        the -find rule. Rules are checked up front and errors give
+                        the rule's number and line
+  -trim-whitespace      collapse runs of spaces and drop trailing spaces and
+                        indentation in body text (not in pre, script or style);
+                        leaves ideographic and no-break spaces alone
+  -strip-comments       remove <!-- --> comments from body documents (not in
+                        script or style, and not IE conditional comments)
   -dry-run              report match counts without writing any changes
+  -verbose              print every changed document to stdout as "<matches>\t<href>",
+                        most matches first (also with -dry-run)
+  -batch                rewrite every .epub under the directory given instead of a
+                        single book, writing a JSON report per book to -report-dir;
+                        a book that fails is reported and the rest carry on.
+                        -o then names an output directory (default: in place)
+  -report-dir <dir>     batch: where the per-book reports go (required)
+  -jobs <n>             batch: maximum number of books rewritten in parallel
+                        (default: one per CPU)
+  -resume               batch: skip books whose report shows they were already
+                        rewritten with the same rules and haven't changed since
+  -threads <n>          maximum number of documents rewritten in parallel
+                        (default: number of CPUs)
   -o, -out <path>       write result to a new file instead of editing in place
 `
 
+const usageFonts = `Fonts:
+  novfmt fonts <book.epub>
+
+  Lists font manifest items with their size and obfuscation (IDPF or Adobe,
+  per META-INF/encryption.xml). Read-only.
+`
+
+const usageFixMediaTypes = `Fix media types:
+  novfmt fix-mediatypes [options] <book.epub>
+
+  Fills in manifest items that have no media-type, inferring it from the file
+  extension or contents, and rewrites aliases such as image/jpg to the EPUB
+  names. Items whose type can't be determined are reported and left alone.
+  merge and normalize apply the same fix.
+
+  -out, -o <path>       write to a new file instead of modifying in place; it
+                        gets a copy of the input when there is nothing to fix
+  -dry-run              report the fixes without writing
+`
+
+const usageFixMimetype = `Fix mimetype:
+  novfmt fix-mimetype [options] <book.epub>
+
+  Rewrites the archive so the mimetype entry comes first, is stored
+  uncompressed and reads application/epub+zip, as strict readers require.
+  Books that are already valid are left untouched, or copied as they are
+  to -out.
+
+  -out, -o <path>       write to a new file instead of modifying in place
+`
+
+const usageMarkdown = `Markdown:
+  novfmt md -href <doc> [options] <book.epub>
+  novfmt md -all [options] <book.epub>
+
+  Converts a content document to Markdown (headings, emphasis, paragraphs,
+  lists, links, images) for pasting into reviews. Read-only.
+
+  -href <doc>           manifest href of the document; a bare file name works
+                        when it's unique
+  -all                  convert every spine document, in reading order
+  -out, -o <path>       write to a file instead of stdout
+`
+
+const usageCheckChapters = `Check chapters:
+  novfmt check-chapters [options] <book.epub>
+
+  Lists spine documents whose text falls below a word count, which usually
+  means a chapter was lost in conversion. Cover and title pages are skipped.
+  Chinese and Japanese characters count as one word each. Read-only.
+
+  -min-chapter-words <n>  flag documents with fewer words (default: 50)
+`
+
+const usageProvenance = `Provenance:
+  novfmt provenance [options] <merged.epub>
+
+  For a book merged by novfmt, lists each spine item with the volume it came
+  from (by its Volumes/vNNNN path) and its id in that volume (from the
+  vNNNN_ id prefix). Generated pages show volume "-". Read-only.
+
+  -json                 print JSON instead of a table
+`
+
+const usageLinks = `Links:
+  novfmt links [options] <book.epub>
+
+  Lists the external references (http, https and mailto) in the book's
+  content documents, one per line with the document they appear in.
+  Read-only unless -strip-external is given.
+
+  -strip-external       unwrap external <a> links, keeping their text; external
+                        images and media are listed but not removed
+  -dry-run              with -strip-external, list what would be removed
+  -json                 print JSON instead of a table
+  -out, -o <path>       with -strip-external, write to a new file instead of
+                        modifying in place
+`
+
+const usageTOCDiff = `TOC diff:
+  novfmt toc-diff [options] <a.epub> <b.epub>
+
+  Compares the nav TOCs of two books, e.g. a source and its re-export, and
+  lists entries added or removed in b, entries moved to another parent or
+  out of order, and changed titles and link targets. Entries are paired by
+  href, then by title; hrefs are resolved against each nav's folder, so
+  books laid out differently still compare. Read-only.
+
+  -ignore-hrefs         pair entries by title only and don't report changed
+                        link targets
+  -json                 print JSON instead of a table
+`
+
+const usageGrep = `Grep:
+  novfmt grep [options] <pattern> <book.epub|dir> [...]
+
+  Searches the text of each book's spine documents for a regular expression
+  (Go syntax) and prints one line per match: the book, the chapter href and
+  a snippet of the paragraph around it. Directories are searched for .epub
+  files as with merge -dir. Books that fail to open are reported and
+  skipped. Read-only.
+
+  -i                    match case-insensitively
+  -json                 print JSON instead of text, one object per match
+`
+
+const usageImages = `Images:
+  novfmt images [options] -o <dir> <book.epub>
+
+  Copies every image in the book's manifest into dir under its own file
+  name (repeats get -2, -3, ...) and writes dir/index.json mapping each
+  image's href in the book to its file. Read-only.
+
+  -out, -o <dir>        directory to copy the images into (required)
+  -type <type>          only images of this media type, e.g. jpeg, png or
+                        image/svg+xml; repeatable
+  -min-width <px>       skip images narrower than this
+  -min-height <px>      skip images shorter than this; images whose size can't
+                        be read (SVG, WebP) are never skipped for size
+`
+
+const usageMeta = `Meta:
+  novfmt meta export [-o meta.json] <book.epub>
+  novfmt meta import [options] <book.epub> <meta.json>
+  novfmt meta clean [options] <book.epub>
+
+  export writes the title, creators (with roles and sort names), language,
+  identifier, description, subjects and dates (with their EPUB 2 events) as
+  JSON, to stdout unless -o is given. Read-only.
+
+  import applies such a file. Title, language and identifier are required;
+  fields left out or empty are removed from the book. Everything else (other
+  metadata, the cover, manifest and spine) is kept.
+
+  clean rewrites the metadata to a minimal set: the title, the authors, the
+  language, the unique identifier, the cover reference and a fresh
+  dcterms:modified. Contributors, publishers, dates, descriptions, subjects,
+  rights, refinements and tool or custom meta are dropped.
+
+  -out, -o <path>       export: write the JSON here; import and clean: write
+                        to a new file instead of modifying in place
+  -new-id               clean: replace the identifier with a new urn:uuid
+`
+
+const usageNormalize = `Normalize:
+  novfmt normalize [options] <book.epub>
+
+  Repacks the book for archiving without changing its content: the mimetype
+  entry first and stored, META-INF/container.xml next, every other entry in
+  sorted order with fixed permissions and no timestamps, manifest media-types
+  in their canonical form (image/jpg -> image/jpeg, missing ones inferred),
+  the package document re-serialized, and __MACOSX, .DS_Store and Thumbs.db
+  left out. The result is then validated; normalizing the same book twice
+  gives identical files.
+
+  -out, -o <path>       write to a new file instead of modifying in place
+  -force                succeed even if the normalized book has validation errors
+`
+
+const usageUnpack = `Unpack and pack:
+  novfmt unpack [-o dir] <book.epub>
+  novfmt pack [-o book.epub] <dir>
+
+  unpack extracts the book's files into a folder, keeping their layout, so
+  they can be inspected or edited with any tool. The folder must be new or
+  empty. pack zips such a folder back up with the mimetype entry first and
+  stored; it refuses a folder without META-INF/container.xml or the package
+  document it names.
+
+  -out, -o <path>       unpack: the folder (default: the book's name without
+                        .epub); pack: the EPUB (default: the folder's name
+                        plus .epub)
+`
+
+const usageSplit = `Split:
+  novfmt split [options] <merged.epub>
+
+  Writes one EPUB per volume of a merged book. Volumes are found by the
+  Volumes/<folder>/ layout merge uses; other books are split at each
+  top-level TOC entry. Each volume is titled after its TOC entry, gets a TOC
+  of that entry's children, and holds only its own pages and the
+  stylesheets, fonts and images they use.
+
+  -out, -o <dir>        folder for the volumes (default: the book's folder)
+  -prefix <template>    file name of each volume, with a printf number verb
+                        (default: "<book name> %02d.epub"), e.g. "Vol %02d.epub"
+`
+
+const usageInfo = `Info:
+  novfmt info [options] <book.epub>
+
+  Prints the book's title, language, identifier, creators, EPUB version,
+  spine length, manifest size, cover item and TOC. Read-only.
+
+  -format <text|json>   output format (default: text); the JSON fields are
+                        title, language, identifier, creators, version,
+                        spine_length, manifest_items, cover_id and toc (a
+                        tree of title, href and children)
+`
+
+const usageValidate = `Validate:
+  novfmt validate [options] <book.epub> [...]
+
+  Checks each book's structure: the mimetype entry, required metadata,
+  that manifest files exist, that spine, nav and id references resolve,
+  that the cover is an image, and that XHTML documents are well-formed.
+  Exits with an error if any book has errors. Read-only; a quick check, not
+  a replacement for epubcheck.
+
+  -json                 print the issues as JSON, one object per book
+`
+
+const usageConfig = `Configuration:
+  Defaults for any long flag can be set in novfmt.toml, read from the current
+  directory or, failing that, from ~/.config/novfmt/novfmt.toml (NOVFMT_CONFIG
+  names another file). Top-level keys apply to every command that has the flag;
+  [merge], [edit-meta] and [rewrite] tables apply to one command.
+
+    compression = 9
+    [merge]
+    temp-dir = "/fast/tmp"
+    creator = ["Author A", "Author B"]
+
+  Environment variables override the file: NOVFMT_<FLAG> for every command
+  (NOVFMT_TEMP_DIR, NOVFMT_COMPRESSION, NOVFMT_OUT) or NOVFMT_<COMMAND>_<FLAG>
+  for one (NOVFMT_MERGE_OUT). Each variable supplies a single value.
+
+  Precedence: command-line flag > environment > config file > built-in default.
+`
+
 const usageExamples = `Examples:
   novfmt merge -o combined.epub vol1.epub vol2.epub vol3.epub
   novfmt merge -title "Full Series" -dir ./volumes -o series.epub
@@ -129,7 +618,7 @@ const usageExamples = `Examples:
 `
 
 func printUsage() {
-	fmt.Fprint(os.Stderr, usageHeader+"\n"+usageMerge+"\n"+usageEditMeta+"\n"+usageRewrite+"\n"+usageExamples)
+	fmt.Fprint(os.Stderr, usageHeader+"\n"+usageMerge+"\n"+usageEditMeta+"\n"+usageRewrite+"\n"+usageFonts+"\n"+usageFixMediaTypes+"\n"+usageFixMimetype+"\n"+usageMarkdown+"\n"+usageCheckChapters+"\n"+usageProvenance+"\n"+usageLinks+"\n"+usageTOCDiff+"\n"+usageGrep+"\n"+usageImages+"\n"+usageMeta+"\n"+usageNormalize+"\n"+usageUnpack+"\n"+usageSplit+"\n"+usageInfo+"\n"+usageValidate+"\n"+usageConfig+"\n"+usageExamples)
 }
 
 type multiValue []string
@@ -143,100 +632,344 @@ func (m *multiValue) Set(value string) error {
 	return nil
 }
 
-func expandListFiles(paths []string) ([]string, error) {
-	var volumes []string
+// arcDirective starts a -list line that puts the volumes after it under a
+// story arc in the TOC; an empty name returns to the top level.
+const arcDirective = "arc:"
+
+// expandListFiles reads -list files, returning the volume paths and, in
+// parallel, the arc each was listed under ("" outside any arc).
+func expandListFiles(paths []string) ([]string, []string, error) {
+	var volumes, arcs []string
 	for _, p := range paths {
 		f, err := os.Open(p)
 		if err != nil {
-			return nil, fmt.Errorf("list %s: %w", p, err)
+			return nil, nil, fmt.Errorf("list %s: %w", p, err)
 		}
+		arc := ""
 		scanner := bufio.NewScanner(f)
 		for scanner.Scan() {
 			line := strings.TrimSpace(scanner.Text())
 			if line == "" || strings.HasPrefix(line, "#") {
 				continue
 			}
+			if name, ok := strings.CutPrefix(line, arcDirective); ok {
+				arc = strings.TrimSpace(name)
+				continue
+			}
 			volumes = append(volumes, line)
+			arcs = append(arcs, arc)
 		}
 		if err := scanner.Err(); err != nil {
 			f.Close()
-			return nil, fmt.Errorf("list %s: %w", p, err)
+			return nil, nil, fmt.Errorf("list %s: %w", p, err)
 		}
 		f.Close()
 	}
-	return volumes, nil
+	return volumes, arcs, nil
+}
+
+// writeListFile saves files, in order, as a -list file, with an arc line
+// wherever the arc changes (arcs may be nil). Paths are made absolute so
+// the list works from any directory.
+func writeListFile(dest string, files, arcs []string) error {
+	var b strings.Builder
+	b.WriteString("# novfmt merge inputs, in merge order; edit and pass back with -list\n")
+	arc := ""
+	for i, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return err
+		}
+		if i < len(arcs) && arcs[i] != arc {
+			arc = arcs[i]
+			b.WriteString(strings.TrimSpace(arcDirective + " " + arc))
+			b.WriteByte('\n')
+		}
+		b.WriteString(abs)
+		b.WriteByte('\n')
+	}
+	if err := os.WriteFile(dest, []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("save list: %w", err)
+	}
+	return nil
+}
+
+type dirOptions struct {
+	noSort bool
+	// recursive also scans subdirectories, without following symlinks to
+	// directories. Files directly in a directory come before its
+	// subdirectories, which are ordered among themselves like files.
+	recursive bool
 }
 
-func expandDirectories(dirs []string) ([]string, error) {
+func expandDirectories(dirs []string, opts dirOptions) ([]string, error) {
 	var volumes []string
 	for _, dir := range dirs {
-		entries, err := os.ReadDir(dir)
+		names, err := scanDirectory(dir, opts.recursive)
 		if err != nil {
 			return nil, fmt.Errorf("dir %s: %w", dir, err)
 		}
-		candidates := make([]dirEntry, 0, len(entries))
+		if !opts.noSort {
+			sort.SliceStable(names, func(i, j int) bool { return lessVolumePath(names[i], names[j]) })
+		}
+		for _, name := range names {
+			volumes = append(volumes, filepath.Join(dir, filepath.FromSlash(name)))
+		}
+	}
+	return volumes, nil
+}
+
+// scanDirectory lists the .epub files in dir, or under it when recursive,
+// as slash-separated paths relative to it in directory listing order.
+func scanDirectory(dir string, recursive bool) ([]string, error) {
+	if !recursive {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
 		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
-			}
-			name := entry.Name()
-			if !strings.EqualFold(filepath.Ext(name), ".epub") {
-				continue
-			}
-			num, hasNum := extractVolumeNumber(name)
-			candidates = append(candidates, dirEntry{
-				path:      filepath.Join(dir, name),
-				name:      name,
-				number:    num,
-				hasNumber: hasNum,
-			})
-		}
-		sort.SliceStable(candidates, func(i, j int) bool {
-			a := candidates[i]
-			b := candidates[j]
-			if a.hasNumber && b.hasNumber {
-				if a.number != b.number {
-					return a.number < b.number
-				}
-				return strings.ToLower(a.name) < strings.ToLower(b.name)
-			}
-			if a.hasNumber != b.hasNumber {
-				return a.hasNumber
+			if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".epub") {
+				names = append(names, entry.Name())
 			}
-			an := strings.ToLower(a.name)
-			bn := strings.ToLower(b.name)
-			if an == bn {
-				return a.name < b.name
+		}
+		return names, nil
+	}
+	var names []string
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(p), ".epub") {
+			return nil
+		}
+		if d.Type()&os.ModeSymlink != 0 {
+			if info, err := os.Stat(p); err != nil || info.IsDir() {
+				return nil
 			}
-			return an < bn
-		})
-		for _, c := range candidates {
-			volumes = append(volumes, c.path)
 		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	return names, err
+}
+
+// lessVolumePath orders two slash-separated relative paths for -dir: at the
+// first element they differ in, a file comes before a directory, and
+// otherwise the elements compare with lessVolumeName.
+func lessVolumePath(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		aFile, bFile := i == len(as)-1, i == len(bs)-1
+		if aFile != bFile {
+			return aFile
+		}
+		return lessVolumeName(as[i], bs[i])
 	}
-	return volumes, nil
+	return len(as) < len(bs)
+}
+
+// lessVolumeName orders file (or folder) names by volume number (see
+// parseVolumeKey), then case-insensitively by name; names without a number
+// come last.
+func lessVolumeName(a, b string) bool {
+	ak, aNum := parseVolumeKey(a)
+	bk, bNum := parseVolumeKey(b)
+	if aNum && bNum && ak != bk {
+		return ak.less(bk)
+	}
+	if aNum != bNum {
+		return aNum
+	}
+	an, bn := strings.ToLower(a), strings.ToLower(b)
+	if an == bn {
+		return a < b
+	}
+	return an < bn
+}
+
+// volumeKey is the volume number -dir sorts by: lo and hi are the ends of a
+// range like "10-11", and both the number for a single volume such as "7"
+// or "7.5".
+type volumeKey struct {
+	lo, hi float64
 }
 
-type dirEntry struct {
-	path      string
-	name      string
-	number    int
-	hasNumber bool
+func (k volumeKey) less(o volumeKey) bool {
+	if k.lo != o.lo {
+		return k.lo < o.lo
+	}
+	return k.hi < o.hi
 }
 
-var digitPattern = regexp.MustCompile(`\d+`)
+var volumeRangePattern = regexp.MustCompile(`(\d+(?:\.\d+)?)(?:[-–](\d+(?:\.\d+)?))?`)
 
-func extractVolumeNumber(name string) (int, bool) {
+// parseVolumeKey reads the first number in a file name, decimals and
+// ranges included, so "Vol 7.5" sorts between 7 and 8 and "Vol 10-11"
+// after 10 and before 11. A range whose second number isn't larger, as in
+// a date like "2019-04", counts as its first number alone.
+func parseVolumeKey(name string) (volumeKey, bool) {
 	base := strings.TrimSuffix(name, filepath.Ext(name))
-	match := digitPattern.FindString(base)
-	if match == "" {
-		return 0, false
+	m := volumeRangePattern.FindStringSubmatch(base)
+	if m == nil {
+		return volumeKey{}, false
 	}
-	num, err := strconv.Atoi(match)
+	lo, err := strconv.ParseFloat(m[1], 64)
 	if err != nil {
-		return 0, false
+		return volumeKey{}, false
+	}
+	key := volumeKey{lo: lo, hi: lo}
+	if m[2] != "" {
+		if hi, err := strconv.ParseFloat(m[2], 64); err == nil && hi > lo {
+			key.hi = hi
+		}
+	}
+	return key, true
+}
+
+// volumeRange is an inclusive run of volume numbers in a -volumes
+// selection; a single number has lo == hi.
+type volumeRange struct {
+	lo, hi int
+}
+
+func (r volumeRange) String() string {
+	if r.lo == r.hi {
+		return strconv.Itoa(r.lo)
+	}
+	return fmt.Sprintf("%d-%d", r.lo, r.hi)
+}
+
+// parseVolumeSpec parses a -volumes selection such as "5-10", "5,7,9" or
+// "1-3,8" into the ranges of volume numbers it names, in ascending order
+// with overlapping and adjacent ones joined. Ranges are kept as their ends,
+// so a huge one costs no more than a small one.
+func parseVolumeSpec(spec string) ([]volumeRange, error) {
+	var ranges []volumeRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid volume selection %q", part)
+		}
+		last := first
+		if isRange {
+			last, err = strconv.Atoi(strings.TrimSpace(hi))
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid volume range %q", part)
+			}
+		}
+		ranges = append(ranges, volumeRange{first, last})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("empty volume selection %q", spec)
+	}
+	return joinVolumeRanges(ranges), nil
+}
+
+// joinVolumeRanges sorts ranges and merges the ones that overlap or touch.
+func joinVolumeRanges(ranges []volumeRange) []volumeRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].lo < ranges[j].lo })
+	out := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &out[len(out)-1]
+		if r.lo <= last.hi+1 {
+			last.hi = max(last.hi, r.hi)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// selectVolumes returns the indexes of the files whose volume number is in
+// want, in their original order. Numbers come from the file names as for
+// -dir sorting (see parseVolumeKey), so a range of whole numbers also
+// selects the decimal volumes within it, but "-volumes 7" doesn't select
+// "Vol 7.5", and a double volume "Vol 10-11" counts as both 10 and 11. If
+// any file has no number in its name, every file is numbered by its
+// 1-based position in the input list instead. Every wanted number must
+// match.
+func selectVolumes(files []string, want []volumeRange) ([]int, error) {
+	keys := make([]volumeKey, len(files))
+	byName := true
+	for i, f := range files {
+		k, ok := parseVolumeKey(filepath.Base(f))
+		if !ok {
+			byName = false
+			break
+		}
+		keys[i] = k
+	}
+	if !byName {
+		for i := range files {
+			keys[i] = volumeKey{lo: float64(i + 1), hi: float64(i + 1)}
+		}
+	}
+
+	var out []int
+	var found []volumeRange
+	for i, k := range keys {
+		for _, w := range want {
+			if k.lo <= float64(w.hi) && k.hi >= float64(w.lo) {
+				out = append(out, i)
+				if lo, hi := int(math.Ceil(k.lo)), int(math.Floor(k.hi)); lo <= hi {
+					found = append(found, volumeRange{lo, hi})
+				}
+				break
+			}
+		}
+	}
+
+	missing := missingVolumes(want, found)
+	if len(missing) > 0 {
+		how := "in the file names"
+		if !byName {
+			how = "by position, since not every file name has a number"
+		}
+		names := make([]string, len(missing))
+		for i, r := range missing {
+			names[i] = r.String()
+		}
+		return nil, fmt.Errorf("no volume %s among the %d inputs (numbered %s)", strings.Join(names, ", "), len(files), how)
+	}
+	return out, nil
+}
+
+// missingVolumes returns the parts of want, which must be sorted and
+// joined, that the found ranges don't cover.
+func missingVolumes(want, found []volumeRange) []volumeRange {
+	if len(found) > 0 {
+		found = joinVolumeRanges(found)
+	}
+	var missing []volumeRange
+	for _, w := range want {
+		next := w.lo
+		for _, f := range found {
+			if !(f.lo <= w.hi && f.hi >= next) {
+				continue
+			}
+			if f.lo > next {
+				missing = append(missing, volumeRange{next, f.lo - 1})
+			}
+			next = f.hi + 1
+		}
+		if next <= w.hi {
+			missing = append(missing, volumeRange{next, w.hi})
+		}
 	}
-	return num, true
+	return missing
 }
 
 func runMerge(ctx context.Context, args []string) error {
@@ -246,6 +979,7 @@ func runMerge(ctx context.Context, args []string) error {
 
 	out := fs.String("out", "merged.epub", "")
 	fs.StringVar(out, "o", "merged.epub", "")
+	outDir := fs.String("out-dir", "", "")
 
 	title := fs.String("title", "", "")
 	fs.StringVar(title, "t", "", "")
@@ -255,186 +989,1134 @@ func runMerge(ctx context.Context, args []string) error {
 	var creatorVals multiValue
 	fs.Var(&creatorVals, "creator", "")
 	fs.Var(&creatorVals, "c", "")
+	sortCreators := fs.Bool("sort-creators", false, "")
+	identifier := fs.String("identifier", "", "")
+	keepISBNs := fs.Bool("keep-source-identifiers-as-isbn", false, "")
 
 	var listFiles multiValue
 	fs.Var(&listFiles, "list", "")
+	saveList := fs.String("save-list", "", "")
 
 	var dirInputs multiValue
 	fs.Var(&dirInputs, "dir", "")
-
-	if err := fs.Parse(args); err != nil {
+	noSort := fs.Bool("no-sort", false, "")
+	recursive := fs.Bool("recursive", false, "")
+	volumeSpec := fs.String("volumes", "", "")
+	fs.StringVar(volumeSpec, "range", "", "")
+	checksum := fs.Bool("checksum", false, "")
+	preserveTimes := fs.Bool("preserve-times", false, "")
+	verify := fs.Bool("verify", false, "")
+	validateAfter := fs.Bool("validate-after", false, "")
+	dryRun := fs.Bool("dry-run", false, "")
+	printOPF := fs.Bool("print-opf", false, "")
+	maxSizeStr := fs.String("max-size", "", "")
+	stripPrefix := fs.String("strip-title-prefix", "", "")
+	stripRegex := fs.Bool("strip-title-regex", false, "")
+	renameConflicts := fs.Bool("rename-title-conflicts", false, "")
+	tocTitle := fs.String("toc-title", "", "")
+	emitNCX := fs.Bool("ncx", false, "")
+	collapseTOC := fs.Bool("collapse-toc", false, "")
+	dedupeNav := fs.Bool("dedupe-nav", false, "")
+	normalizeHeadings := fs.String("normalize-headings", "", "")
+	volumeDir := fs.String("volume-dir", "", "")
+	landmarks := fs.Bool("landmarks", false, "")
+	coverEntries := fs.Bool("cover-entries", false, "")
+	coverEntryTitle := fs.String("cover-entry-title", "", "")
+	guessCover := fs.Bool("guess-cover", false, "")
+	illustrations := fs.String("illustrations", "", "")
+	illustrationPattern := fs.String("illustration-pattern", "", "")
+	progressAnchors := fs.Int("progress-anchors", 0, "")
+	coverMode := fs.String("cover-mode", "first", "")
+	rights := fs.String("rights", "", "")
+	rightsFrom := fs.String("rights-from", "first", "")
+	publisher := fs.String("publisher", "", "")
+	description := fs.String("description", "", "")
+	collection := fs.String("collection", "", "")
+	coverColumns := fs.Int("cover-columns", 0, "")
+	coverBackground := fs.String("cover-background", "", "")
+	metaTemplate := fs.String("metadata-template", "", "")
+	indexPage := fs.Bool("index-page", false, "")
+	indexCovers := fs.Bool("index-covers", false, "")
+	volumeDividers := fs.Bool("volume-dividers", false, "")
+	noToolMeta := fs.Bool("no-tool-meta", false, "")
+	dedupeImages := fs.Bool("dedupe-images", false, "")
+	dedupeResources := fs.Bool("dedupe-resources", false, "")
+	flattenImagesStr := fs.String("flatten-images", "", "")
+	rewriteRules := fs.String("rewrite-rules", "", "")
+	stripComments := fs.Bool("strip-comments", false, "")
+	preserveNav := fs.Bool("preserve-nav", false, "")
+	kobo := fs.Bool("kobo", false, "")
+	orderStr := fs.String("order", "spine", "")
+	manifestOrderStr := fs.String("manifest-order", "source", "")
+	ppd := fs.String("ppd", "", "")
+	interleave := fs.Bool("interleave", false, "")
+	traceIDs := fs.Bool("trace-ids", false, "")
+	force := fs.Bool("force", false, "")
+	contentDir := fs.String("content-dir", "", "")
+	opfName := fs.String("opf-name", "", "")
+	compression := fs.String("compression", "default", "")
+	tempDir := fs.String("temp-dir", "", "")
+	deadline := fs.Duration("deadline", 0, "")
+	threads := fs.Int("threads", 0, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkThreads(*threads); err != nil {
 		return err
 	}
 
+	if *deadline < 0 {
+		return fmt.Errorf("invalid deadline %s", *deadline)
+	}
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+
 	files := fs.Args()
+	// arcs parallels files once any -list names an arc.
+	var arcs []string
 
 	if len(listFiles) > 0 {
-		fromLists, err := expandListFiles(listFiles)
+		fromLists, listArcs, err := expandListFiles(listFiles)
 		if err != nil {
 			return err
 		}
+		for _, arc := range listArcs {
+			if arc != "" {
+				arcs = make([]string, len(files), len(files)+len(fromLists))
+				arcs = append(arcs, listArcs...)
+				break
+			}
+		}
 		files = append(files, fromLists...)
 	}
 
 	if len(dirInputs) > 0 {
-		fromDirs, err := expandDirectories(dirInputs)
+		fromDirs, err := expandDirectories(dirInputs, dirOptions{noSort: *noSort, recursive: *recursive})
 		if err != nil {
 			return err
 		}
 		files = append(files, fromDirs...)
+		if arcs != nil {
+			arcs = append(arcs, make([]string, len(fromDirs))...)
+		}
 	}
 
-	if len(files) < 2 {
-		return fmt.Errorf("need at least two EPUB files to merge")
+	if *volumeSpec != "" {
+		want, err := parseVolumeSpec(*volumeSpec)
+		if err != nil {
+			return err
+		}
+		keep, err := selectVolumes(files, want)
+		if err != nil {
+			return err
+		}
+		var kept, keptArcs []string
+		for _, i := range keep {
+			kept = append(kept, files[i])
+			if arcs != nil {
+				keptArcs = append(keptArcs, arcs[i])
+			}
+		}
+		files, arcs = kept, keptArcs
 	}
 
-	opts := epub.MergeOptions{
-		Title:    *title,
-		Language: *lang,
-		Creators: creatorVals,
-		OutPath:  *out,
+	if len(files) == 0 || len(files) == 1 && !*preserveNav {
+		return fmt.Errorf("need at least two EPUB files to merge (or one with -preserve-nav)")
 	}
 
-	return epub.MergeEPUBs(ctx, files, opts)
-}
+	if *outDir != "" && !filepath.IsAbs(*out) {
+		*out = filepath.Join(*outDir, *out)
+	}
+	if err := checkOutputExt(*out, *force); err != nil {
+		return err
+	}
+	if *printOPF && !*dryRun {
+		return fmt.Errorf("-print-opf requires -dry-run")
+	}
 
-func runRewrite(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("rewrite", flag.ContinueOnError)
-	fs.SetOutput(os.Stderr)
-	fs.Usage = func() { fmt.Fprint(os.Stderr, usageRewrite) }
+	language, err := checkLanguage(*lang, *force)
+	if err != nil {
+		return err
+	}
 
-	out := fs.String("out", "", "")
-	fs.StringVar(out, "o", "", "")
-	find := fs.String("find", "", "")
-	replace := fs.String("replace", "", "")
-	regex := fs.Bool("regex", false, "")
-	ignoreCase := fs.Bool("ignore-case", false, "")
-	fs.BoolVar(ignoreCase, "i", false, "")
-	scopeStr := fs.String("scope", "body", "")
+	level, err := parseCompression(*compression)
+	if err != nil {
+		return err
+	}
 
-	var selectors multiValue
-	fs.Var(&selectors, "selector", "")
+	maxSize, err := parseSize(*maxSizeStr)
+	if err != nil {
+		return err
+	}
+	flattenImages, err := parseSize(*flattenImagesStr)
+	if err != nil {
+		return fmt.Errorf("-flatten-images: %w", err)
+	}
 
-	rulesPath := fs.String("rules", "", "")
-	dryRun := fs.Bool("dry-run", false, "")
+	var order epub.ReadingOrder
+	switch strings.ToLower(*orderStr) {
+	case "spine":
+		order = epub.ReadingOrderSpine
+	case "nav":
+		order = epub.ReadingOrderNav
+	default:
+		return fmt.Errorf("invalid order %q (want spine, nav)", *orderStr)
+	}
 
-	if err := fs.Parse(args); err != nil {
-		return err
+	var manifestOrder epub.ManifestOrder
+	switch strings.ToLower(*manifestOrderStr) {
+	case "source":
+		manifestOrder = epub.ManifestOrderSource
+	case "href":
+		manifestOrder = epub.ManifestOrderHref
+	case "type":
+		manifestOrder = epub.ManifestOrderType
+	default:
+		return fmt.Errorf("invalid manifest order %q (want source, href, type)", *manifestOrderStr)
 	}
 
-	if fs.NArg() != 1 {
-		return fmt.Errorf("rewrite requires exactly one EPUB path")
+	var template *epub.Metadata
+	if *metaTemplate != "" {
+		template, err = epub.LoadMetadataTemplate(*metaTemplate)
+		if err != nil {
+			return err
+		}
 	}
-	input := fs.Arg(0)
 
 	var rules []epub.RewriteRule
-	if *rulesPath != "" {
-		fileRules, err := epub.LoadRewriteRulesJSON(*rulesPath)
+	if *rewriteRules != "" {
+		rules, err = epub.LoadRewriteRulesJSON(*rewriteRules)
 		if err != nil {
-			return fmt.Errorf("read rules: %w", err)
+			return fmt.Errorf("read rewrite rules: %w", err)
+		}
+		if len(rules) == 0 {
+			return fmt.Errorf("%s: no rewrite rules", *rewriteRules)
 		}
-		rules = append(rules, fileRules...)
 	}
 
-	if *find != "" {
-		rules = append(rules, epub.RewriteRule{
-			Find:       *find,
-			Replace:    *replace,
-			Regex:      *regex,
-			IgnoreCase: *ignoreCase,
-			Selectors:  selectors,
-		})
+	progress := &epub.MergeProgress{}
+	opts := epub.MergeOptions{
+		Title:           *title,
+		Language:        language,
+		Creators:        creatorVals,
+		SortCreators:    *sortCreators,
+		Identifier:      strings.TrimSpace(*identifier),
+		KeepSourceISBNs: *keepISBNs,
+		OutPath:         *out,
+		WriteChecksum:   *checksum,
+		PreserveTimes:   *preserveTimes,
+		Verify:          *verify,
+		ValidateAfter:   *validateAfter,
+		DryRun:          *dryRun,
+		PrintOPF:        *printOPF,
+		MaxSize:         maxSize,
+		TempDir:         *tempDir,
+		Compression:     level,
+		ContentDir:      *contentDir,
+		PackageName:     *opfName,
+
+		StripTitlePrefix: *stripPrefix,
+		StripTitleRegex:  *stripRegex,
+
+		RenameTitleConflicts: *renameConflicts,
+		TOCTitle:             *tocTitle,
+		EmitNCX:              *emitNCX,
+		CollapseTOC:          *collapseTOC,
+		DedupeNav:            *dedupeNav,
+		Arcs:                 arcs,
+		NormalizeHeadings:    strings.ToLower(*normalizeHeadings),
+		VolumeDirTemplate:    *volumeDir,
+		Landmarks:            *landmarks,
+		CoverEntries:         *coverEntries,
+		CoverEntryTitle:      *coverEntryTitle,
+		GuessCover:           *guessCover,
+		Illustrations:        *illustrations,
+		IllustrationPattern:  *illustrationPattern,
+		ProgressAnchors:      *progressAnchors,
+
+		Rights:          *rights,
+		RightsFrom:      strings.ToLower(*rightsFrom),
+		Publisher:       strings.TrimSpace(*publisher),
+		Description:     strings.TrimSpace(*description),
+		Collection:      strings.TrimSpace(*collection),
+		Cover:           coverModeOption(*coverMode),
+		CoverColumns:    *coverColumns,
+		CoverBackground: *coverBackground,
+
+		MetadataTemplate: template,
+		IndexPage:        *indexPage || *indexCovers,
+		IndexCovers:      *indexCovers,
+		VolumeDividers:   *volumeDividers,
+		NoToolMeta:       *noToolMeta,
+		DedupeImages:     *dedupeImages,
+		DedupeResources:  *dedupeResources,
+		FlattenImages:    flattenImages,
+		RewriteRules:     rules,
+		StripComments:    *stripComments,
+		PreserveNav:      *preserveNav,
+		Kobo:             *kobo,
+		ReadingOrder:     order,
+		ManifestOrder:    manifestOrder,
+		PageProgression:  strings.ToLower(*ppd),
+		Interleave:       *interleave,
+		Progress:         progress,
+		Threads:          *threads,
+	}
+	if *traceIDs {
+		opts.TraceIDs = os.Stderr
 	}
 
-	var scope epub.RewriteScope
-	switch strings.ToLower(*scopeStr) {
-	case "body":
-		scope = epub.RewriteScopeBody
+	if *saveList != "" {
+		if err := writeListFile(*saveList, files, arcs); err != nil {
+			return err
+		}
+	}
+
+	stats, err := epub.MergeEPUBs(ctx, files, opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return fmt.Errorf("merge: aborted after exceeding -deadline %s (%s)", *deadline, progress.State())
+		case errors.Is(err, context.Canceled):
+			return fmt.Errorf("merge: interrupted while %s; no output written", progress.State())
+		}
+		return err
+	}
+
+	reportMediaTypeFixes("merge", stats.MediaTypeFixes)
+	for _, w := range stats.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	if len(rules) > 0 {
+		fmt.Fprintf(os.Stderr, "rewrite: %d matches across %d files\n", stats.Rewrite.MatchCount, stats.Rewrite.FilesChanged)
+	}
+	if *stripComments {
+		fmt.Fprintf(os.Stderr, "rewrite: removed %d comments\n", stats.Rewrite.CommentsRemoved)
+	}
+	if *dryRun {
+		outputs := []epub.MergeStats{stats}
+		if len(stats.Parts) > 0 {
+			outputs = stats.Parts
+		}
+		for i, o := range outputs {
+			for _, f := range o.Generated {
+				name := f.Path
+				if len(stats.Parts) > 0 {
+					name = fmt.Sprintf("part %d: %s", i+1, f.Path)
+				}
+				fmt.Printf("==> %s <==\n%s", name, f.Data)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "merge: dry run: %d volumes, %s staged, about %s compressed; nothing written\n",
+			stats.Volumes, formatBytes(stats.StagedBytes), formatBytes(stats.EstimatedBytes))
+		return nil
+	}
+	if len(stats.Parts) > 0 {
+		for i, part := range stats.Parts {
+			fmt.Fprintf(os.Stderr, "merge: part %d: %d volumes -> %s\nsha256: %s\n", i+1, part.Volumes, part.OutPath, part.SHA256)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "merge: %d volumes -> %s\nsha256: %s\n", stats.Volumes, stats.OutPath, stats.SHA256)
+	}
+	if *kobo {
+		fmt.Fprintf(os.Stderr, "kobo: %d documents given kobo spans\n", stats.KoboDocuments)
+	}
+	if *normalizeHeadings != "" {
+		fmt.Fprintf(os.Stderr, "headings: %d retagged\n", stats.HeadingsRetagged)
+	}
+	if stats.LanguageTagged > 0 {
+		fmt.Fprintf(os.Stderr, "lang: %d documents tagged with their volume's language\n", stats.LanguageTagged)
+	}
+	if *illustrations != "" {
+		fmt.Fprintf(os.Stderr, "illustrations: %d listed\n", stats.Illustrations)
+	}
+	if *progressAnchors > 0 {
+		fmt.Fprintf(os.Stderr, "progress: %d anchors (%d ids added)\n", stats.ProgressAnchors, stats.ProgressAnchorsAdded)
+	}
+	if *dedupeNav {
+		fmt.Fprintf(os.Stderr, "nav: %d duplicate entries removed\n", stats.NavDuplicatesRemoved)
+	}
+	if *dedupeImages || *dedupeResources {
+		fmt.Fprintf(os.Stderr, "dedupe: %d duplicate resources removed, %d bytes saved\n", stats.DedupedItems, stats.DedupedBytes)
+	}
+	for _, src := range stats.GuessedCovers {
+		fmt.Fprintf(os.Stderr, "cover: guessed from the first page of %s\n", src)
+	}
+	if flattenImages > 0 {
+		fmt.Fprintf(os.Stderr, "flatten: %d images inlined, %+d bytes\n", stats.FlattenedImages, stats.FlattenedBytes)
+	}
+	if *verify {
+		if err := reportVerification(stats.Verification); err != nil {
+			return err
+		}
+	}
+	if *validateAfter {
+		outputs := []epub.MergeStats{stats}
+		if len(stats.Parts) > 0 {
+			outputs = stats.Parts
+		}
+		for _, o := range outputs {
+			if err := reportValidation(o.OutPath, o.Validation, *force); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "12.3 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// checkLanguage canonicalizes a language code to BCP 47 ("en_US" -> "en-US").
+// Codes that don't parse are an error, or with force a warning and kept as is.
+func checkLanguage(lang string, force bool) (string, error) {
+	if strings.TrimSpace(lang) == "" {
+		return lang, nil
+	}
+	norm, err := epub.NormalizeLanguage(lang)
+	if err == nil {
+		return norm, nil
+	}
+	if !force {
+		return "", fmt.Errorf("%v (use a BCP 47 tag such as \"en\" or \"en-US\", or -force to keep it)", err)
+	}
+	fmt.Fprintf(os.Stderr, "warning: %v; keeping it as given\n", err)
+	return lang, nil
+}
+
+// checkThreads validates a -threads value; 0 means one worker per CPU.
+func checkThreads(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid -threads %d (want 0 or more)", n)
+	}
+	return nil
+}
+
+// reportValidation prints the issues found in one output and fails on
+// errors unless force is set.
+func reportValidation(out string, issues []epub.ValidationIssue, force bool) error {
+	errs, warns := epub.CountIssues(issues)
+	if len(issues) == 0 {
+		fmt.Fprintf(os.Stderr, "validate: %s ok\n", out)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "validate: %s: %d errors, %d warnings\n", out, errs, warns)
+	for _, issue := range issues {
+		where := ""
+		if issue.Path != "" {
+			where = issue.Path + ": "
+		}
+		fmt.Fprintf(os.Stderr, "  %s: %s%s\n", issue.Severity, where, issue.Message)
+	}
+	if errs > 0 && !force {
+		return fmt.Errorf("validate: %s has %d errors (use -force to only report them)", out, errs)
+	}
+	return nil
+}
+
+// reportVerification prints one line per volume section and fails if any
+// section has problems.
+func reportVerification(checks []epub.VolumeCheck) error {
+	failed := 0
+	for _, c := range checks {
+		if c.OK() {
+			fmt.Fprintf(os.Stderr, "verify: %s ok (%d documents)\n", c.Prefix, c.Documents)
+			continue
+		}
+		failed++
+		fmt.Fprintf(os.Stderr, "verify: %s FAILED\n", c.Prefix)
+		for _, p := range c.Problems {
+			fmt.Fprintf(os.Stderr, "  %s\n", p)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("verify: %d of %d volumes failed", failed, len(checks))
+	}
+	return nil
+}
+
+func parseCompression(s string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "default":
+		return epub.CompressionDefault, nil
+	case "store", "none", "0":
+		return epub.CompressionStore, nil
+	}
+	level, err := strconv.Atoi(s)
+	if err != nil || level < 1 || level > 9 {
+		return 0, fmt.Errorf("invalid compression %q (want default, store, or 1-9)", s)
+	}
+	return level, nil
+}
+
+// parseSize reads a byte size such as "300MB", "1.5G" or "524288000".
+// Units are binary (1 MB = 1024 KB); an empty string means no limit.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	upper := strings.ToUpper(s)
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	} {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 300MB)", s)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, so equal is already too big.
+	size := n * float64(mult)
+	if size < 1 || size >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is out of range", s)
+	}
+	return int64(size), nil
+}
+
+// coverModeOption passes -cover-mode on as MergeOptions.Cover: modes in
+// any case, anything else as the image path it is.
+func coverModeOption(mode string) string {
+	switch lower := strings.ToLower(mode); lower {
+	case epub.CoverFirst, epub.CoverLast, epub.CoverNone, epub.CoverGrid:
+		return lower
+	}
+	return mode
+}
+
+// parseRuleFlag splits a -rule value at its first unescaped "=" into the
+// find and replace text. "\=" stands for a "=" in the find text, so
+// "a\=b=c" finds "a=b"; other backslashes are kept as they are, which
+// leaves regex escapes like \d alone.
+func parseRuleFlag(value string) (find, replace string, err error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && i+1 < len(value) && value[i+1] == '=':
+			i++
+			b.WriteByte(value[i])
+		case c == '=':
+			if b.Len() == 0 {
+				return "", "", fmt.Errorf("invalid -rule %q (empty find text)", value)
+			}
+			return b.String(), value[i+1:], nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("invalid -rule %q (want find=replace)", value)
+}
+
+// checkOutputExt rejects output paths that e-readers won't recognize as EPUB.
+// With force the mismatch is only reported.
+func checkOutputExt(out string, force bool) error {
+	if strings.EqualFold(filepath.Ext(out), ".epub") {
+		return nil
+	}
+	suggested := strings.TrimSuffix(out, filepath.Ext(out)) + ".epub"
+	if !force {
+		return fmt.Errorf("output %q does not end in .epub (did you mean %q? use -force to keep it)", out, suggested)
+	}
+	fmt.Fprintf(os.Stderr, "warning: output %q does not end in .epub; readers may not open it\n", out)
+	return nil
+}
+
+func runRewrite(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rewrite", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageRewrite) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+	find := fs.String("find", "", "")
+	replace := fs.String("replace", "", "")
+	regex := fs.Bool("regex", false, "")
+	ignoreCase := fs.Bool("ignore-case", false, "")
+	fs.BoolVar(ignoreCase, "i", false, "")
+	wholeWord := fs.Bool("whole-word", false, "")
+	fs.BoolVar(wholeWord, "w", false, "")
+	scopeStr := fs.String("scope", "body", "")
+
+	var selectors, ruleFlags multiValue
+	fs.Var(&selectors, "selector", "")
+	fs.Var(&ruleFlags, "rule", "")
+
+	rulesPath := fs.String("rules", "", "")
+	trimSpace := fs.Bool("trim-whitespace", false, "")
+	stripComments := fs.Bool("strip-comments", false, "")
+	dryRun := fs.Bool("dry-run", false, "")
+	verbose := fs.Bool("verbose", false, "")
+	threads := fs.Int("threads", 0, "")
+	batch := fs.Bool("batch", false, "")
+	reportDir := fs.String("report-dir", "", "")
+	jobs := fs.Int("jobs", 0, "")
+	resume := fs.Bool("resume", false, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkThreads(*threads); err != nil {
+		return err
+	}
+	if *jobs < 0 {
+		return fmt.Errorf("invalid -jobs %d (want 0 or more)", *jobs)
+	}
+
+	if fs.NArg() != 1 {
+		if *batch {
+			return fmt.Errorf("rewrite -batch requires exactly one directory")
+		}
+		return fmt.Errorf("rewrite requires exactly one EPUB path")
+	}
+	if !*batch && (*reportDir != "" || *resume || *jobs != 0) {
+		return fmt.Errorf("-report-dir, -jobs and -resume need -batch")
+	}
+	input := fs.Arg(0)
+
+	var rules []epub.RewriteRule
+	if *rulesPath != "" {
+		fileRules, err := epub.LoadRewriteRulesJSON(*rulesPath)
+		if err != nil {
+			return fmt.Errorf("read rules: %w", err)
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	for _, value := range ruleFlags {
+		f, r, err := parseRuleFlag(value)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, epub.RewriteRule{
+			Find:       f,
+			Replace:    r,
+			Regex:      *regex,
+			IgnoreCase: *ignoreCase,
+			WholeWord:  *wholeWord,
+			Selectors:  selectors,
+		})
+	}
+
+	if *find != "" {
+		rules = append(rules, epub.RewriteRule{
+			Find:       *find,
+			Replace:    *replace,
+			Regex:      *regex,
+			IgnoreCase: *ignoreCase,
+			WholeWord:  *wholeWord,
+			Selectors:  selectors,
+		})
+	}
+
+	var scope epub.RewriteScope
+	switch strings.ToLower(*scopeStr) {
+	case "body":
+		scope = epub.RewriteScopeBody
 	case "meta":
 		scope = epub.RewriteScopeMeta
 	case "all":
 		scope = epub.RewriteScopeAll
+	case "cover":
+		scope = epub.RewriteScopeCover
 	default:
-		return fmt.Errorf("invalid scope %q (want body, meta, all)", *scopeStr)
+		return fmt.Errorf("invalid scope %q (want body, meta, all, cover)", *scopeStr)
 	}
 
-	stats, err := epub.RewriteEPUB(ctx, input, epub.RewriteOptions{
+	ropts := epub.RewriteOptions{
 		OutPath: *out,
 		Scope:   scope,
 		Rules:   rules,
 		DryRun:  *dryRun,
-	})
+		Threads: *threads,
+
+		TrimWhitespace: *trimSpace,
+		StripComments:  *stripComments,
+	}
+	if *batch {
+		return runBatchRewrite(ctx, input, ropts, *reportDir, *jobs, *resume, *verbose)
+	}
+
+	stats, err := epub.RewriteEPUB(ctx, input, ropts)
 	if err != nil {
 		return err
 	}
 
+	for _, href := range stats.ScopeFiles {
+		fmt.Fprintf(os.Stderr, "rewrite: cover scope: %s\n", href)
+	}
+	if *verbose {
+		printFileMatches(os.Stdout, stats)
+		if stats.MetadataMatches > 0 {
+			fmt.Fprintf(os.Stderr, "rewrite: %d matches in the metadata\n", stats.MetadataMatches)
+		}
+	}
 	fmt.Fprintf(os.Stderr, "rewrite: %d matches across %d files\n", stats.MatchCount, stats.FilesChanged)
+	if *trimSpace {
+		fmt.Fprintf(os.Stderr, "rewrite: trimmed %s of whitespace\n", formatBytes(stats.WhitespaceBytes))
+	}
+	if *stripComments {
+		fmt.Fprintf(os.Stderr, "rewrite: removed %d comments\n", stats.CommentsRemoved)
+	}
 	return nil
 }
 
-func runEditMeta(ctx context.Context, args []string) error {
-	fs := flag.NewFlagSet("edit-meta", flag.ContinueOnError)
-	fs.SetOutput(os.Stderr)
-	fs.Usage = func() { fmt.Fprint(os.Stderr, usageEditMeta) }
+// printFileMatches lists the documents a rewrite changed (or would change)
+// with their match counts, most first and otherwise in manifest order;
+// documents only whitespace or comment changes touched come last, with 0.
+func printFileMatches(w io.Writer, stats epub.RewriteStats) {
+	byMatches := append([]epub.FileMatches(nil), stats.FileMatches...)
+	sort.SliceStable(byMatches, func(i, j int) bool { return byMatches[i].Matches > byMatches[j].Matches })
+	listed := make(map[string]bool, len(byMatches))
+	for _, fm := range byMatches {
+		fmt.Fprintf(w, "%d\t%s\n", fm.Matches, fm.Href)
+		listed[fm.Href] = true
+	}
+	for _, href := range stats.ChangedFiles {
+		if !listed[href] {
+			fmt.Fprintf(w, "0\t%s\n", href)
+		}
+	}
+}
 
-	out := fs.String("out", "", "")
-	fs.StringVar(out, "o", "", "")
-	title := fs.String("title", "", "")
-	lang := fs.String("lang", "", "")
-	identifier := fs.String("identifier", "", "")
-	description := fs.String("description", "", "")
+// runBatchRewrite is rewrite -batch: ropts applied to every book under dir,
+// with ropts.OutPath naming the output directory.
+func runBatchRewrite(ctx context.Context, dir string, ropts epub.RewriteOptions, reportDir string, jobs int, resume, verbose bool) error {
+	if reportDir == "" {
+		return fmt.Errorf("rewrite -batch requires a report directory (-report-dir <dir>)")
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("rewrite -batch: %s is not a directory", dir)
+	}
 
-	var creators multiValue
-	fs.Var(&creators, "creator", "")
+	res, err := epub.BatchRewrite(ctx, dir, epub.BatchRewriteOptions{
+		Rewrite:   ropts,
+		OutDir:    ropts.OutPath,
+		ReportDir: reportDir,
+		Jobs:      jobs,
+		Resume:    resume,
+		OnBook: func(rep epub.BookReport) {
+			switch {
+			case rep.Skipped:
+				if verbose {
+					fmt.Fprintf(os.Stderr, "rewrite: %s: already done\n", rep.Book)
+				}
+			case rep.Error != "":
+				fmt.Fprintf(os.Stderr, "warning: %s: %s\n", rep.Book, rep.Error)
+			default:
+				fmt.Fprintf(os.Stderr, "rewrite: %s: %d matches across %d files\n", rep.Book, rep.Matches, rep.FilesChanged)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	done, skipped, failed := res.Counts()
+	fmt.Fprintf(os.Stderr, "rewrite: %d books rewritten, %d skipped, %d failed\n", done, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("rewrite: %d of %d books failed (see %s)", failed, len(res.Reports), reportDir)
+	}
+	return nil
+}
 
-	metaPath := fs.String("meta", "", "")
-	dumpMeta := fs.String("dump-meta", "", "")
-	navPath := fs.String("nav", "", "")
-	dumpNav := fs.String("dump-nav", "", "")
-	noTouch := fs.Bool("no-touch-modified", false, "")
+func runFonts(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("fonts", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageFonts) }
 
-	if err := fs.Parse(args); err != nil {
+	if err := parseFlags(fs, args); err != nil {
 		return err
 	}
 
 	if fs.NArg() != 1 {
-		return fmt.Errorf("edit-meta requires exactly one EPUB path")
+		return fmt.Errorf("fonts requires exactly one EPUB path")
 	}
 
-	input := fs.Arg(0)
+	fonts, err := epub.ListFonts(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
 
-	var patch epub.MetadataPatch
-	if *metaPath != "" {
-		data, err := os.ReadFile(*metaPath)
-		if err != nil {
-			return fmt.Errorf("read meta: %w", err)
-		}
-		if err := json.Unmarshal(data, &patch); err != nil {
-			return fmt.Errorf("parse meta: %w", err)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSIZE\tOBFUSCATION\tHREF")
+	obfuscated := 0
+	for _, f := range fonts {
+		obf := f.Obfuscation
+		if obf == "" {
+			obf = "-"
+		} else {
+			obfuscated++
 		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", f.ID, f.Size, obf, f.Href)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
 	}
 
-	setFlags := map[string]bool{}
-	fs.Visit(func(f *flag.Flag) {
-		setFlags[f.Name] = true
-	})
+	fmt.Fprintf(os.Stderr, "fonts: %d fonts, %d obfuscated\n", len(fonts), obfuscated)
+	return nil
+}
 
-	if setFlags["title"] {
-		patch.Title = stringPtr(*title)
+func runFixMediaTypes(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("fix-mediatypes", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageFixMediaTypes) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+	dryRun := fs.Bool("dry-run", false, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
 	}
-	if setFlags["lang"] {
-		patch.Language = stringPtr(*lang)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("fix-mediatypes requires exactly one EPUB path")
 	}
-	if setFlags["identifier"] {
-		patch.Identifier = stringPtr(*identifier)
+
+	fixes, err := epub.FixMediaTypes(ctx, fs.Arg(0), epub.FixMediaTypesOptions{
+		OutPath: *out,
+		DryRun:  *dryRun,
+	})
+	if err != nil {
+		return err
 	}
-	if setFlags["description"] {
-		patch.Description = stringPtr(*description)
+
+	reportMediaTypeFixes("fix-mediatypes", fixes)
+	fixed := 0
+	for _, f := range fixes {
+		if f.MediaType != "" {
+			fixed++
+		}
 	}
-	if len(creators) > 0 {
-		list := make([]string, len(creators))
-		copy(list, creators)
-		patch.Creators = &list
+	fmt.Fprintf(os.Stderr, "fix-mediatypes: %d fixed, %d undetermined\n", fixed, len(fixes)-fixed)
+	return nil
+}
+
+func runFixMimetype(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("fix-mimetype", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageFixMimetype) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("fix-mimetype requires exactly one EPUB path")
+	}
+
+	problems, err := epub.FixMimetype(ctx, fs.Arg(0), epub.FixMimetypeOptions{OutPath: *out})
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		if *out != "" {
+			fmt.Fprintf(os.Stderr, "fix-mimetype: %s: already valid; copied to %s\n", fs.Arg(0), *out)
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "fix-mimetype: %s: already valid\n", fs.Arg(0))
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "fix-mimetype: fixed: %s\n", p)
+	}
+	return nil
+}
+
+func runMarkdown(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("md", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageMarkdown) }
+
+	href := fs.String("href", "", "")
+	all := fs.Bool("all", false, "")
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("md requires exactly one EPUB path")
+	}
+	if *all == (*href != "") {
+		return fmt.Errorf("md requires exactly one of -href or -all")
+	}
+
+	md, err := epub.ExportMarkdown(ctx, fs.Arg(0), epub.MarkdownOptions{Href: *href, All: *all})
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.WriteString(md)
+		return err
+	}
+	return os.WriteFile(*out, []byte(md), 0o644)
+}
+
+func runCheckChapters(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("check-chapters", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageCheckChapters) }
+
+	minWords := fs.Int("min-chapter-words", 50, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("check-chapters requires exactly one EPUB path")
+	}
+	if *minWords < 1 {
+		return fmt.Errorf("-min-chapter-words must be at least 1")
+	}
+
+	counts, err := epub.ChapterWordCounts(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORDS\tHREF")
+	flagged := 0
+	for _, c := range counts {
+		if c.Cover || c.Words >= *minWords {
+			continue
+		}
+		flagged++
+		fmt.Fprintf(tw, "%d\t%s\n", c.Words, c.Href)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "check-chapters: %d of %d documents under %d words\n", flagged, len(counts), *minWords)
+	return nil
+}
+
+func runProvenance(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("provenance", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageProvenance) }
+
+	asJSON := fs.Bool("json", false, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("provenance requires exactly one EPUB path")
+	}
+
+	origins, err := epub.SpineProvenance(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(origins)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tVOLUME\tSOURCE ID\tHREF")
+	for i, o := range origins {
+		volume, sourceID := "-", "-"
+		if o.Volume > 0 {
+			volume = strconv.Itoa(o.Volume)
+		}
+		if o.SourceID != "" {
+			sourceID = o.SourceID
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", i+1, volume, sourceID, o.Href)
+	}
+	return tw.Flush()
+}
+
+func runLinks(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("links", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageLinks) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+	strip := fs.Bool("strip-external", false, "")
+	dryRun := fs.Bool("dry-run", false, "")
+	asJSON := fs.Bool("json", false, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("links requires exactly one EPUB path")
+	}
+
+	var (
+		links []epub.ExternalLink
+		err   error
+	)
+	if *strip {
+		links, err = epub.StripExternalLinks(ctx, fs.Arg(0), epub.StripExternalLinksOptions{
+			OutPath: *out,
+			DryRun:  *dryRun,
+		})
+	} else {
+		links, err = epub.ListExternalLinks(ctx, fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if links == nil {
+			links = []epub.ExternalLink{}
+		}
+		if err := enc.Encode(links); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, l := range links {
+			fmt.Fprintf(tw, "%s\t%s\n", l.Document, l.URL)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if *strip {
+		verb := "removed"
+		if *dryRun {
+			verb = "would remove"
+		}
+		fmt.Fprintf(os.Stderr, "links: %s %d external links\n", verb, len(links))
+	}
+	return nil
+}
+
+// reportMediaTypeFixes logs each filled-in or canonicalized media-type and
+// warns about the items that are still missing one.
+func reportMediaTypeFixes(cmd string, fixes []epub.MediaTypeFix) {
+	for _, f := range fixes {
+		switch {
+		case f.MediaType == "":
+			fmt.Fprintf(os.Stderr, "warning: %s: manifest item %s (%s) has no media-type and none could be inferred\n", f.Source, f.ID, f.Href)
+		case strings.TrimSpace(f.From) == "":
+			fmt.Fprintf(os.Stderr, "%s: %s: set media-type of %s (%s) to %s\n", cmd, f.Source, f.ID, f.Href, f.MediaType)
+		default:
+			fmt.Fprintf(os.Stderr, "%s: %s: media-type of %s (%s): %q -> %s\n", cmd, f.Source, f.ID, f.Href, f.From, f.MediaType)
+		}
+	}
+}
+
+func runEditMeta(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("edit-meta", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageEditMeta) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+	title := fs.String("title", "", "")
+	lang := fs.String("lang", "", "")
+	identifier := fs.String("identifier", "", "")
+	description := fs.String("description", "", "")
+
+	var creators multiValue
+	fs.Var(&creators, "creator", "")
+
+	metaPath := fs.String("meta", "", "")
+	dumpMeta := fs.String("dump-meta", "", "")
+	navPath := fs.String("nav", "", "")
+	dumpNav := fs.String("dump-nav", "", "")
+	noTouch := fs.Bool("no-touch-modified", false, "")
+	force := fs.Bool("force", false, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("edit-meta requires exactly one EPUB path")
+	}
+
+	input := fs.Arg(0)
+
+	var patch epub.MetadataPatch
+	if *metaPath != "" {
+		data, err := os.ReadFile(*metaPath)
+		if err != nil {
+			return fmt.Errorf("read meta: %w", err)
+		}
+		if err := json.Unmarshal(data, &patch); err != nil {
+			return fmt.Errorf("parse meta: %w", err)
+		}
+	}
+
+	setFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	if setFlags["title"] {
+		patch.Title = stringPtr(*title)
+	}
+	if setFlags["lang"] {
+		patch.Language = stringPtr(*lang)
+	}
+	if setFlags["identifier"] {
+		patch.Identifier = stringPtr(*identifier)
+	}
+	if setFlags["description"] {
+		patch.Description = stringPtr(*description)
+	}
+	if len(creators) > 0 {
+		list := make([]string, len(creators))
+		copy(list, creators)
+		patch.Creators = &list
+	}
+	if patch.Language != nil {
+		language, err := checkLanguage(*patch.Language, *force)
+		if err != nil {
+			return err
+		}
+		patch.Language = &language
 	}
 
 	opts := epub.EditOptions{
@@ -452,3 +2134,521 @@ func runEditMeta(ctx context.Context, args []string) error {
 func stringPtr(s string) *string {
 	return &s
 }
+
+func runTOCDiff(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("toc-diff", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageTOCDiff) }
+
+	ignoreHrefs := fs.Bool("ignore-hrefs", false, "")
+	asJSON := fs.Bool("json", false, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("toc-diff requires exactly two EPUB paths")
+	}
+
+	changes, err := epub.DiffTOC(ctx, fs.Arg(0), fs.Arg(1), epub.TOCDiffOptions{IgnoreHrefs: *ignoreHrefs})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if changes == nil {
+			changes = []epub.TOCChange{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(changes)
+	}
+
+	if len(changes) == 0 {
+		fmt.Fprintln(os.Stderr, "toc-diff: TOCs match")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANGE\tPOSITION\tENTRY")
+	for _, c := range changes {
+		switch c.Kind {
+		case epub.TOCAdded:
+			fmt.Fprintf(tw, "%s\t%s\t%q %s\n", c.Kind, c.Position, c.Title, c.Href)
+		case epub.TOCRemoved:
+			fmt.Fprintf(tw, "%s\t%s\t%q %s\n", c.Kind, c.OldPosition, c.Title, c.OldHref)
+		case epub.TOCMoved:
+			fmt.Fprintf(tw, "%s\t%s -> %s\t%q\n", c.Kind, c.OldPosition, c.Position, c.Title)
+		case epub.TOCRetitled:
+			fmt.Fprintf(tw, "%s\t%s\t%q -> %q\n", c.Kind, c.Position, c.OldTitle, c.Title)
+		case epub.TOCRelinked:
+			fmt.Fprintf(tw, "%s\t%s\t%q: %s -> %s\n", c.Kind, c.Position, c.Title, c.OldHref, c.Href)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "toc-diff: %d changes\n", len(changes))
+	return nil
+}
+
+func runGrep(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("grep", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageGrep) }
+
+	ignoreCase := fs.Bool("i", false, "")
+	asJSON := fs.Bool("json", false, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 2 {
+		return fmt.Errorf("grep requires a pattern and at least one EPUB path or directory")
+	}
+	pattern := fs.Arg(0)
+	if *ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	var books []string
+	for _, arg := range fs.Args()[1:] {
+		if info, err := os.Stat(arg); err == nil && info.IsDir() {
+			found, err := expandDirectories([]string{arg}, dirOptions{})
+			if err != nil {
+				return err
+			}
+			books = append(books, found...)
+			continue
+		}
+		books = append(books, arg)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	matches, matched := 0, 0
+	for _, path := range books {
+		found, err := epub.GrepBook(ctx, path, re)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+			continue
+		}
+		if len(found) > 0 {
+			matched++
+		}
+		matches += len(found)
+		for _, m := range found {
+			if *asJSON {
+				if err := enc.Encode(m); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Printf("%s:%s: %s\n", m.Book, m.Href, m.Snippet)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "grep: %d matches in %d of %d books\n", matches, matched, len(books))
+	return nil
+}
+
+func runImages(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("images", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageImages) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+	var types multiValue
+	fs.Var(&types, "type", "")
+	minWidth := fs.Int("min-width", 0, "")
+	minHeight := fs.Int("min-height", 0, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("images requires exactly one EPUB path")
+	}
+	if *out == "" {
+		return fmt.Errorf("images requires an output directory (-o <dir>)")
+	}
+	if *minWidth < 0 || *minHeight < 0 {
+		return fmt.Errorf("-min-width and -min-height can't be negative")
+	}
+
+	images, err := epub.ExtractImages(ctx, fs.Arg(0), epub.ExtractImagesOptions{
+		OutDir:     *out,
+		MediaTypes: types,
+		MinWidth:   *minWidth,
+		MinHeight:  *minHeight,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "images: %d extracted to %s\n", len(images), *out)
+	return nil
+}
+
+func runMeta(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usageMeta)
+		return fmt.Errorf("meta requires a subcommand (export, import, clean)")
+	}
+	switch args[0] {
+	case "export":
+		return runMetaExport(ctx, args[1:])
+	case "import":
+		return runMetaImport(ctx, args[1:])
+	case "clean":
+		return runMetaClean(ctx, args[1:])
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stderr, usageMeta)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usageMeta)
+		return fmt.Errorf("unknown meta subcommand %q", args[0])
+	}
+}
+
+func runMetaExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("meta-export", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageMeta) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("meta export requires exactly one EPUB path")
+	}
+
+	rec, err := epub.ExportMetadata(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *out == "" || *out == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "meta export: wrote %s\n", *out)
+	return nil
+}
+
+func runMetaImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("meta-import", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageMeta) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("meta import requires an EPUB path and a metadata file")
+	}
+
+	rec, err := epub.LoadMetadataRecord(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if err := epub.ImportMetadata(ctx, fs.Arg(0), rec, epub.ImportMetadataOptions{OutPath: *out}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "meta import: applied %s\n", fs.Arg(1))
+	return nil
+}
+
+func runMetaClean(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("meta-clean", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageMeta) }
+
+	newID := fs.Bool("new-id", false, "")
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("meta clean requires exactly one EPUB path")
+	}
+
+	removed, err := epub.CleanMetadata(ctx, fs.Arg(0), epub.CleanMetadataOptions{
+		OutPath:       *out,
+		NewIdentifier: *newID,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "meta clean: removed %d metadata elements\n", removed)
+	return nil
+}
+
+func runNormalize(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("normalize", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageNormalize) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+	force := fs.Bool("force", false, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("normalize requires exactly one EPUB path")
+	}
+
+	res, err := epub.NormalizeEPUB(ctx, fs.Arg(0), epub.NormalizeOptions{OutPath: *out})
+	if err != nil {
+		return err
+	}
+	reportMediaTypeFixes("normalize", res.MediaTypes)
+	for _, name := range res.Removed {
+		fmt.Fprintf(os.Stderr, "normalize: removed %s\n", name)
+	}
+	dest := *out
+	if dest == "" {
+		dest = fs.Arg(0)
+	}
+	return reportValidation(dest, res.Validation, *force)
+}
+
+func runUnpack(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("unpack", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageUnpack) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("unpack requires exactly one EPUB path")
+	}
+	input := fs.Arg(0)
+	dir := *out
+	if dir == "" {
+		dir = strings.TrimSuffix(input, filepath.Ext(input))
+		if dir == input {
+			return fmt.Errorf("unpack: pass -o to name the folder for %s", input)
+		}
+	}
+
+	files, err := epub.Unpack(ctx, input, dir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "unpack: %d files in %s\n", files, dir)
+	return nil
+}
+
+func runPack(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("pack", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageUnpack) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("pack requires exactly one directory")
+	}
+	dir := fs.Arg(0)
+	dest := *out
+	if dest == "" {
+		dest = filepath.Clean(dir) + ".epub"
+	}
+
+	if err := epub.Pack(ctx, dir, dest); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "pack: wrote %s\n", dest)
+	return nil
+}
+
+func runSplit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("split", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageSplit) }
+
+	out := fs.String("out", "", "")
+	fs.StringVar(out, "o", "", "")
+	prefix := fs.String("prefix", "", "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("split requires exactly one EPUB path")
+	}
+	input := fs.Arg(0)
+	dir := *out
+	if dir == "" {
+		dir = filepath.Dir(input)
+	}
+
+	vols, err := epub.SplitEPUB(ctx, input, epub.SplitOptions{OutDir: dir, NameTemplate: *prefix})
+	if err != nil {
+		return err
+	}
+	for _, vol := range vols {
+		fmt.Fprintf(os.Stderr, "split: %s (%q, %d documents, %d files)\n", vol.Path, vol.Title, vol.Documents, vol.Files)
+	}
+	fmt.Fprintf(os.Stderr, "split: %d volumes written to %s\n", len(vols), dir)
+	return nil
+}
+
+func runInfo(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageInfo) }
+
+	format := fs.String("format", "text", "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("info requires exactly one EPUB path")
+	}
+	switch *format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("invalid -format %q (want text or json)", *format)
+	}
+
+	info, err := epub.ReadBookInfo(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Title:\t%s\n", info.Title)
+	fmt.Fprintf(tw, "Language:\t%s\n", info.Language)
+	fmt.Fprintf(tw, "Identifier:\t%s\n", info.Identifier)
+	fmt.Fprintf(tw, "Creators:\t%s\n", strings.Join(info.Creators, ", "))
+	fmt.Fprintf(tw, "Version:\t%s\n", info.Version)
+	fmt.Fprintf(tw, "Spine:\t%d documents\n", info.SpineLength)
+	fmt.Fprintf(tw, "Manifest:\t%d items\n", info.ManifestItems)
+	cover := info.CoverID
+	if cover == "" {
+		cover = "-"
+	}
+	fmt.Fprintf(tw, "Cover:\t%s\n", cover)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Println("TOC:")
+	printTOCInfo(info.TOC, 1)
+	return nil
+}
+
+func printTOCInfo(items []epub.TOCInfo, depth int) {
+	for _, item := range items {
+		line := strings.Repeat("  ", depth) + item.Title
+		if item.Href != "" {
+			line += " (" + item.Href + ")"
+		}
+		fmt.Println(line)
+		printTOCInfo(item.Children, depth+1)
+	}
+}
+
+func runValidate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usageValidate) }
+
+	asJSON := fs.Bool("json", false, "")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("validate requires at least one EPUB path")
+	}
+
+	type bookIssues struct {
+		Book   string                 `json:"book"`
+		Issues []epub.ValidationIssue `json:"issues"`
+	}
+	var enc *json.Encoder
+	if *asJSON {
+		enc = json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+	}
+	failed := 0
+	for _, input := range fs.Args() {
+		issues, err := epub.ValidateEPUB(ctx, input)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+		if errs, _ := epub.CountIssues(issues); errs > 0 {
+			failed++
+		}
+		if enc != nil {
+			if issues == nil {
+				issues = []epub.ValidationIssue{}
+			}
+			if err := enc.Encode(bookIssues{Book: input, Issues: issues}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := reportValidation(input, issues, true); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("validate: %d of %d books have errors", failed, fs.NArg())
+	}
+	return nil
+}
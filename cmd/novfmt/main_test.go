@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/kototok903/novfmt/internal/epub"
 )
 
 func TestExpandListFiles(t *testing.T) {
@@ -14,16 +20,20 @@ func TestExpandListFiles(t *testing.T) {
 /path/Vol 01.epub
 
    /path/Vol 02.epub
+arc: Second Arc
+/path/Vol 03.epub
+arc:
+/path/Vol 04.epub
 `
 	if err := os.WriteFile(list, []byte(content), 0o644); err != nil {
 		t.Fatalf("write list: %v", err)
 	}
 
-	out, err := expandListFiles([]string{list})
+	out, arcs, err := expandListFiles([]string{list})
 	if err != nil {
 		t.Fatalf("expand: %v", err)
 	}
-	want := []string{"/path/Vol 01.epub", "/path/Vol 02.epub"}
+	want := []string{"/path/Vol 01.epub", "/path/Vol 02.epub", "/path/Vol 03.epub", "/path/Vol 04.epub"}
 	if len(out) != len(want) {
 		t.Fatalf("got %d entries want %d", len(out), len(want))
 	}
@@ -32,10 +42,37 @@ func TestExpandListFiles(t *testing.T) {
 			t.Fatalf("entry %d = %q want %q", i, out[i], want[i])
 		}
 	}
+	if strings.Join(arcs, "|") != "||Second Arc|" {
+		t.Fatalf("arcs = %q", arcs)
+	}
+}
+
+func TestWriteListFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "Vol 2.epub"), filepath.Join(dir, "Vol 10.epub"), filepath.Join(dir, "Extra.epub")}
+	arcs := []string{"Arc One", "Arc One", ""}
+	list := filepath.Join(dir, "order.txt")
+	if err := writeListFile(list, files, arcs); err != nil {
+		t.Fatalf("writeListFile: %v", err)
+	}
+	data, err := os.ReadFile(list)
+	if err != nil {
+		t.Fatalf("read list: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "# ") {
+		t.Fatalf("list should start with a comment:\n%s", data)
+	}
+	out, gotArcs, err := expandListFiles([]string{list})
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	if strings.Join(out, "|") != strings.Join(files, "|") || strings.Join(gotArcs, "|") != strings.Join(arcs, "|") {
+		t.Fatalf("round trip = %q %q want %q %q", out, gotArcs, files, arcs)
+	}
 }
 
 func TestExpandListFilesMissing(t *testing.T) {
-	if _, err := expandListFiles([]string{"/no/such/file"}); err == nil {
+	if _, _, err := expandListFiles([]string{"/no/such/file"}); err == nil {
 		t.Fatalf("expected error for missing file")
 	}
 }
@@ -57,7 +94,7 @@ func TestExpandDirectoriesOrdering(t *testing.T) {
 		}
 	}
 
-	got, err := expandDirectories([]string{dir})
+	got, err := expandDirectories([]string{dir}, dirOptions{})
 	if err != nil {
 		t.Fatalf("expand: %v", err)
 	}
@@ -79,6 +116,96 @@ func TestExpandDirectoriesOrdering(t *testing.T) {
 	}
 }
 
+func TestExpandDirectoriesDecimalsAndRanges(t *testing.T) {
+	dir := t.TempDir()
+	want := []string{
+		"Vol 7.epub",
+		"Vol 7.5.epub",
+		"Vol 8.epub",
+		"Vol 10.epub",
+		"Vol 10-11.epub",
+		"Vol 10-12.epub",
+		"Vol 11.epub",
+		"Vol 2019-04 12.epub",
+		"Extra.epub",
+		"extras.epub",
+	}
+	for _, name := range want {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(""), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	got, err := expandDirectories([]string{dir}, dirOptions{})
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d files want %d", len(got), len(want))
+	}
+	for i := range want {
+		if filepath.Base(got[i]) != want[i] {
+			t.Fatalf("idx %d = %q want %q", i, filepath.Base(got[i]), want[i])
+		}
+	}
+}
+
+func TestExpandDirectoriesRecursive(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"Arc 10/Vol 2.epub",
+		"Arc 2/Vol 1.EPUB",
+		"Arc 2/Vol 10.epub",
+		"Arc 2/Vol 9.epub",
+		"Arc 2/Side/Vol 9.5.epub",
+		"Arc 2/notes.txt",
+		"Prologue 0.epub",
+		"Extras/Bonus.epub",
+	}
+	for _, name := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(""), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := os.Symlink(dir, filepath.Join(dir, "Arc 1 loop")); err != nil {
+		t.Logf("symlink: %v", err)
+	}
+
+	got, err := expandDirectories([]string{dir}, dirOptions{recursive: true})
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	want := []string{
+		"Prologue 0.epub",
+		"Arc 2/Vol 1.EPUB",
+		"Arc 2/Vol 9.epub",
+		"Arc 2/Vol 10.epub",
+		"Arc 2/Side/Vol 9.5.epub",
+		"Arc 10/Vol 2.epub",
+		"Extras/Bonus.epub",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	for i := range want {
+		if rel, _ := filepath.Rel(dir, got[i]); filepath.ToSlash(rel) != want[i] {
+			t.Fatalf("idx %d = %q want %q", i, rel, want[i])
+		}
+	}
+
+	got, err = expandDirectories([]string{dir}, dirOptions{})
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	if len(got) != 1 || filepath.Base(got[0]) != "Prologue 0.epub" {
+		t.Fatalf("without recursive: %v", got)
+	}
+}
+
 func TestExpandDirectoriesMultipleDirs(t *testing.T) {
 	dir1 := t.TempDir()
 	dir2 := t.TempDir()
@@ -92,7 +219,7 @@ func TestExpandDirectoriesMultipleDirs(t *testing.T) {
 	must(dir1, "Vol 01.epub")
 	must(dir2, "Vol 02.epub")
 
-	paths, err := expandDirectories([]string{dir1, dir2})
+	paths, err := expandDirectories([]string{dir1, dir2}, dirOptions{})
 	if err != nil {
 		t.Fatalf("expand: %v", err)
 	}
@@ -104,3 +231,240 @@ func TestExpandDirectoriesMultipleDirs(t *testing.T) {
 		t.Fatalf("unexpected order: %v", paths)
 	}
 }
+
+func TestExpandDirectoriesNoSort(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"Part B 1.epub", "Part A 9.epub", "Part C.epub"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(""), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	got, err := expandDirectories([]string{dir}, dirOptions{noSort: true})
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+
+	want := []string{"Part A 9.epub", "Part B 1.epub", "Part C.epub"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d files want %d", len(got), len(want))
+	}
+	for i := range want {
+		if filepath.Base(got[i]) != want[i] {
+			t.Fatalf("idx %d = %q want %q", i, filepath.Base(got[i]), want[i])
+		}
+	}
+}
+
+func TestCheckOutputExt(t *testing.T) {
+	for _, ok := range []string{"book.epub", "dir/Book.EPUB"} {
+		if err := checkOutputExt(ok, false); err != nil {
+			t.Fatalf("%s: unexpected error %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"book", "book.zip"} {
+		err := checkOutputExt(bad, false)
+		if err == nil {
+			t.Fatalf("%s: expected error", bad)
+		}
+		if !strings.Contains(err.Error(), "book.epub") {
+			t.Fatalf("%s: error should suggest book.epub, got %v", bad, err)
+		}
+		if err := checkOutputExt(bad, true); err != nil {
+			t.Fatalf("%s: -force should allow, got %v", bad, err)
+		}
+	}
+}
+
+func TestRunMergeOutDir(t *testing.T) {
+	dir := t.TempDir()
+	args := []string{"-out-dir", dir, "-o", "book", filepath.Join(dir, "a.epub"), filepath.Join(dir, "b.epub")}
+
+	err := runMerge(context.Background(), args)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%q", filepath.Join(dir, "book"))) {
+		t.Fatalf("expected the output to be placed in -out-dir, got %v", err)
+	}
+}
+
+func TestRunMergeDeadline(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.epub")
+	args := []string{"-deadline", "1h", "-o", out, filepath.Join(dir, "a.epub"), filepath.Join(dir, "b.epub")}
+
+	// A context already past its deadline stands in for a merge that ran over.
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	err := runMerge(ctx, args)
+	if err == nil || !strings.Contains(err.Error(), "-deadline 1h0m0s") {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if _, statErr := os.Stat(out); !os.IsNotExist(statErr) {
+		t.Fatalf("no output should be written after the deadline")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := runMerge(ctx, args); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("expected interruption error, got %v", err)
+	}
+}
+
+func TestCheckLanguage(t *testing.T) {
+	got, err := checkLanguage("en_US", false)
+	if err != nil || got != "en-US" {
+		t.Fatalf("en_US -> %q, %v", got, err)
+	}
+	if got, err := checkLanguage("", false); err != nil || got != "" {
+		t.Fatalf("empty -> %q, %v", got, err)
+	}
+	if _, err := checkLanguage("english", false); err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Fatalf("expected error suggesting -force, got %v", err)
+	}
+	if got, err := checkLanguage("english", true); err != nil || got != "english" {
+		t.Fatalf("-force: %q, %v", got, err)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[int64]string{
+		0:                 "0 B",
+		1023:              "1023 B",
+		1536:              "1.5 KiB",
+		300 * 1024 * 1024: "300.0 MiB",
+		5 << 30:           "5.0 GiB",
+	}
+	for n, want := range cases {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q want %q", n, got, want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"":          0,
+		"300MB":     300 << 20,
+		"300 mb":    300 << 20,
+		"1.5G":      3 << 29,
+		"512KiB":    512 << 10,
+		"524288000": 524288000,
+	}
+	for in, want := range cases {
+		got, err := parseSize(in)
+		if err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v want %d", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"MB", "-5MB", "lots", "nan", "inf", "-inf", "1e30", "8589934592GB", "0.0000001", "0.5B"} {
+		if _, err := parseSize(bad); err == nil {
+			t.Errorf("parseSize(%q): expected error", bad)
+		}
+	}
+}
+
+func TestParseVolumeSpec(t *testing.T) {
+	cases := map[string]string{
+		"5-10":         "5-10",
+		"5,7,9":        "5 7 9",
+		"1-3, 8":       "1-3 8",
+		"3,1-2,2":      "1-3",
+		"4-4":          "4",
+		"8-12,1,10-20": "1 8-20",
+		"1-2000000000": "1-2000000000",
+	}
+	for in, want := range cases {
+		got, err := parseVolumeSpec(in)
+		if err != nil {
+			t.Fatalf("parseVolumeSpec(%q): %v", in, err)
+		}
+		if s := strings.Trim(fmt.Sprint(got), "[]"); s != want {
+			t.Errorf("parseVolumeSpec(%q) = %s want %s", in, s, want)
+		}
+	}
+	for _, bad := range []string{"", ",", "10-5", "a-b", "-3", "1-"} {
+		if _, err := parseVolumeSpec(bad); err == nil {
+			t.Errorf("parseVolumeSpec(%q): expected error", bad)
+		}
+	}
+}
+
+func TestSelectVolumes(t *testing.T) {
+	files := []string{"dir/Vol 10.epub", "dir/Vol 2.epub", "dir/Vol 7.epub", "dir/Vol 5.epub"}
+	got, err := selectVolumes(files, []volumeRange{{5, 10}})
+	if err == nil {
+		t.Fatalf("expected missing volumes to be reported, got %v", got)
+	} else if !strings.Contains(err.Error(), "no volume 6, 8-9 among") {
+		t.Fatalf("error = %v", err)
+	}
+
+	got, err = selectVolumes(files, []volumeRange{{5, 5}, {7, 7}, {10, 10}})
+	if err != nil {
+		t.Fatalf("selectVolumes: %v", err)
+	}
+	if fmt.Sprint(got) != "[0 2 3]" {
+		t.Fatalf("got %v want [0 2 3]", got)
+	}
+
+	// One name without a number: everything is numbered by position.
+	mixed := []string{"prologue.epub", "book 4.epub", "book 9.epub"}
+	got, err = selectVolumes(mixed, []volumeRange{{2, 3}})
+	if err != nil {
+		t.Fatalf("selectVolumes positional: %v", err)
+	}
+	if fmt.Sprint(got) != "[1 2]" {
+		t.Fatalf("positional got %v", got)
+	}
+	if _, err := selectVolumes(mixed, []volumeRange{{4, 4}}); err == nil || !strings.Contains(err.Error(), "by position") {
+		t.Fatalf("expected positional error, got %v", err)
+	}
+	side := []string{"Vol 7.epub", "Vol 7.5.epub", "Vol 8.epub", "Vol 9-10.epub"}
+	if got, err := selectVolumes(side, []volumeRange{{7, 7}}); err != nil || fmt.Sprint(got) != "[0]" {
+		t.Fatalf("-volumes 7 = %v, %v", got, err)
+	}
+	if got, err := selectVolumes(side, []volumeRange{{7, 10}}); err != nil || fmt.Sprint(got) != "[0 1 2 3]" {
+		t.Fatalf("-volumes 7-10 = %v, %v", got, err)
+	}
+	double := []string{"Vol 09.epub", "Vol 10-11.epub", "Vol 12.epub"}
+	if got, err := selectVolumes(double, []volumeRange{{11, 12}}); err != nil || fmt.Sprint(got) != "[1 2]" {
+		t.Fatalf("-volumes 11-12 = %v, %v", got, err)
+	}
+	if got, err := selectVolumes(double, []volumeRange{{11, 11}}); err != nil || fmt.Sprint(got) != "[1]" {
+		t.Fatalf("-volumes 11 = %v, %v", got, err)
+	}
+	if _, err := selectVolumes(files, []volumeRange{{1, 2000000000}}); err == nil || !strings.Contains(err.Error(), "no volume 1, 3-4, 6, 8-9, 11-2000000000 among") {
+		t.Fatalf("huge range error = %v", err)
+	}
+}
+
+func TestParseRuleFlag(t *testing.T) {
+	cases := []struct{ in, find, replace string }{
+		{"Old Name=New Name", "Old Name", "New Name"},
+		{"typo=", "typo", ""},
+		{"a=b=c", "a", "b=c"},
+		{`x\=y=z`, "x=y", "z"},
+		{`Chapter\s+(\d+)=Chapter $1`, `Chapter\s+(\d+)`, "Chapter $1"},
+	}
+	for _, tc := range cases {
+		find, replace, err := parseRuleFlag(tc.in)
+		if err != nil || find != tc.find || replace != tc.replace {
+			t.Errorf("parseRuleFlag(%q) = %q, %q, %v; want %q, %q", tc.in, find, replace, err, tc.find, tc.replace)
+		}
+	}
+	for _, in := range []string{"no separator", "=only replace", `a\=b`} {
+		if _, _, err := parseRuleFlag(in); err == nil {
+			t.Errorf("parseRuleFlag(%q) should fail", in)
+		}
+	}
+}
+
+func TestPrintFileMatches(t *testing.T) {
+	var buf strings.Builder
+	printFileMatches(&buf, epub.RewriteStats{
+		FileMatches:  []epub.FileMatches{{Href: "a.xhtml", Matches: 2}, {Href: "b.xhtml", Matches: 5}, {Href: "c.xhtml", Matches: 2}},
+		ChangedFiles: []string{"a.xhtml", "b.xhtml", "trimmed.xhtml", "c.xhtml"},
+	})
+	want := "5\tb.xhtml\n2\ta.xhtml\n2\tc.xhtml\n0\ttrimmed.xhtml\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -1,3 +1,8 @@
 module github.com/kototok903/novfmt
 
 go 1.24.4
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	golang.org/x/text v0.26.0
+)
@@ -0,0 +1,324 @@
+package epub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type BatchRewriteOptions struct {
+	// Rewrite is applied to every book; its OutPath is ignored.
+	Rewrite RewriteOptions
+	// OutDir receives the rewritten books, at the same paths relative to it
+	// as under the input directory; books without matches are copied as
+	// they are. When empty the books are rewritten in place.
+	OutDir string
+	// ReportDir receives one JSON BookReport per book, named after the
+	// book's relative path plus ".json". Required.
+	ReportDir string
+	// Jobs caps how many books are rewritten concurrently (GOMAXPROCS when
+	// <= 0).
+	Jobs int
+	// Resume skips books whose report shows they were already rewritten
+	// with the same rules and haven't changed since (see resumable).
+	Resume bool
+	// OnBook, when set, is called with each book's report as it finishes,
+	// one call at a time.
+	OnBook func(BookReport)
+}
+
+// BookReport is what a batch rewrite did to one book, as written to its
+// report file. The hashes are hex SHA-256s of the book before and after
+// and of the rewrite settings, which is what Resume compares.
+type BookReport struct {
+	Book         string   `json:"book"`
+	Output       string   `json:"output,omitempty"`
+	Matches      int      `json:"matches"`
+	FilesChanged int      `json:"files_changed"`
+	ChangedFiles []string `json:"changed_files,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	DryRun       bool     `json:"dry_run,omitempty"`
+	SourceSHA256 string   `json:"source_sha256,omitempty"`
+	ResultSHA256 string   `json:"result_sha256,omitempty"`
+	RulesSHA256  string   `json:"rules_sha256"`
+	// Skipped is set on reports Resume reused; it isn't written.
+	Skipped bool `json:"-"`
+}
+
+// BatchRewriteResult holds a report per book, in path order. When the
+// batch stopped early, the books it didn't reach have zero reports.
+type BatchRewriteResult struct {
+	Reports []BookReport
+}
+
+// Counts returns how many books were rewritten (or checked, in a dry run),
+// skipped by Resume and failed. Books the batch didn't reach count as
+// none of these.
+func (r BatchRewriteResult) Counts() (done, skipped, failed int) {
+	for _, rep := range r.Reports {
+		switch {
+		case rep.Book == "":
+		case rep.Skipped:
+			skipped++
+		case rep.Error != "":
+			failed++
+		default:
+			done++
+		}
+	}
+	return done, skipped, failed
+}
+
+// BatchRewrite applies opts.Rewrite to every .epub under dir, up to
+// opts.Jobs books at a time, and writes a report per book. A book that
+// fails gets a report with its error and doesn't stop the others; the
+// error is for problems with the batch itself (listing dir, writing
+// reports) or cancellation. Reports are written atomically, so a batch
+// that was interrupted can be re-run with Resume.
+func BatchRewrite(ctx context.Context, dir string, opts BatchRewriteOptions) (BatchRewriteResult, error) {
+	var res BatchRewriteResult
+	if dir == "" {
+		return res, fmt.Errorf("input directory is required")
+	}
+	if opts.ReportDir == "" {
+		return res, fmt.Errorf("report directory is required")
+	}
+	ropts := opts.Rewrite
+	ropts.OutPath = ""
+	if len(ropts.Rules) == 0 && !ropts.TrimWhitespace && !ropts.StripComments {
+		return res, fmt.Errorf("no rewrite rules provided")
+	}
+	if _, err := compileRules(ropts.Rules); err != nil {
+		return res, err
+	}
+	rulesSum, err := rewriteSettingsSum(ropts)
+	if err != nil {
+		return res, err
+	}
+
+	books, err := batchBooks(dir, opts.OutDir, opts.ReportDir)
+	if err != nil {
+		return res, err
+	}
+	res.Reports = make([]BookReport, len(books))
+
+	var mu sync.Mutex
+	done := func(rep BookReport) {
+		if opts.OnBook == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		opts.OnBook(rep)
+	}
+	err = parallelFor(ctx, len(books), opts.Jobs, func(i int) error {
+		rel := books[i]
+		reportPath := filepath.Join(opts.ReportDir, filepath.FromSlash(rel)+".json")
+		rep := BookReport{Book: filepath.Join(dir, filepath.FromSlash(rel)), RulesSHA256: rulesSum, DryRun: ropts.DryRun}
+		if opts.OutDir != "" {
+			rep.Output = filepath.Join(opts.OutDir, filepath.FromSlash(rel))
+		}
+
+		if opts.Resume {
+			if prev, ok := resumable(reportPath, rep); ok {
+				prev.Skipped = true
+				res.Reports[i] = prev
+				done(prev)
+				return nil
+			}
+		}
+
+		if err := rewriteBatchBook(ctx, &rep, ropts); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			rep.Error = err.Error()
+		}
+		if err := writeBookReport(reportPath, rep); err != nil {
+			return err
+		}
+		res.Reports[i] = rep
+		done(rep)
+		return nil
+	})
+	return res, err
+}
+
+// rewriteBatchBook rewrites rep.Book into rep.Output (in place when empty)
+// and fills in the rest of rep.
+func rewriteBatchBook(ctx context.Context, rep *BookReport, ropts RewriteOptions) error {
+	var err error
+	if rep.SourceSHA256, err = fileSHA256(rep.Book); err != nil {
+		return err
+	}
+	if rep.Output != "" {
+		if err := ensureParentDir(rep.Output); err != nil {
+			return err
+		}
+		ropts.OutPath = rep.Output
+	}
+	stats, err := RewriteEPUB(ctx, rep.Book, ropts)
+	if err != nil {
+		return err
+	}
+	rep.Matches = stats.MatchCount
+	rep.FilesChanged = stats.FilesChanged
+	rep.ChangedFiles = stats.ChangedFiles
+
+	result := rep.Book
+	switch {
+	case ropts.DryRun:
+	case rep.Output != "" && stats.FilesChanged == 0:
+		info, err := os.Stat(rep.Book)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(rep.Book, rep.Output, info.Mode()); err != nil {
+			return err
+		}
+		result = rep.Output
+	case rep.Output != "":
+		result = rep.Output
+	}
+	rep.ResultSHA256, err = fileSHA256(result)
+	return err
+}
+
+// resumable returns the report at reportPath if it records a successful
+// rewrite of the book rep describes with the same settings, and the book
+// is unchanged since: in place it must still hash to the result, with
+// OutDir the source must hash as before and the output as written. A dry
+// run can't stand in for a real one.
+func resumable(reportPath string, rep BookReport) (BookReport, bool) {
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		return BookReport{}, false
+	}
+	var prev BookReport
+	if err := json.Unmarshal(data, &prev); err != nil {
+		return BookReport{}, false
+	}
+	if prev.Error != "" || prev.RulesSHA256 != rep.RulesSHA256 || prev.Output != rep.Output || prev.DryRun && !rep.DryRun {
+		return BookReport{}, false
+	}
+	sum, err := fileSHA256(rep.Book)
+	if err != nil {
+		return BookReport{}, false
+	}
+	if rep.Output == "" {
+		return prev, sum == prev.ResultSHA256
+	}
+	if sum != prev.SourceSHA256 {
+		return BookReport{}, false
+	}
+	if !prev.DryRun {
+		if out, err := fileSHA256(rep.Output); err != nil || out != prev.ResultSHA256 {
+			return BookReport{}, false
+		}
+	}
+	return prev, true
+}
+
+// batchBooks lists the .epub files under dir as slash-separated relative
+// paths in lexical order, leaving out outDir and reportDir if they are
+// inside it.
+func batchBooks(dir, outDir, reportDir string) ([]string, error) {
+	skip := make(map[string]bool)
+	for _, d := range []string{outDir, reportDir} {
+		if d == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(d); err == nil {
+			skip[abs] = true
+		}
+	}
+	var books []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if abs, err := filepath.Abs(p); err == nil && skip[abs] && p != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.EqualFold(filepath.Ext(p), ".epub") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		books = append(books, filepath.ToSlash(rel))
+		return nil
+	})
+	return books, err
+}
+
+// writeBookReport writes rep as indented JSON to p through a temporary
+// file, so an interrupted batch never leaves a truncated report behind.
+func writeBookReport(p string, rep BookReport) error {
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ensureParentDir(p); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".novfmt-report-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// rewriteSettingsSum hashes the settings that decide what a rewrite does
+// to a book, so Resume can tell when they changed.
+func rewriteSettingsSum(ropts RewriteOptions) (string, error) {
+	data, err := json.Marshal(struct {
+		Scope          RewriteScope  `json:"scope"`
+		Rules          []RewriteRule `json:"rules"`
+		TrimWhitespace bool          `json:"trim_whitespace"`
+		StripComments  bool          `json:"strip_comments"`
+	}{ropts.Scope, ropts.Rules, ropts.TrimWhitespace, ropts.StripComments})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,152 @@
+package epub
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchRewrite(t *testing.T) {
+	lib := t.TempDir()
+	place := func(src, rel string) {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := filepath.Join(lib, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	place(buildChaptersEPUB(t, "One", "Rin, Rin", "Ring"), "arc1/one.epub")
+	place(buildChaptersEPUB(t, "Two", "Nobody"), "arc1/two.EPUB")
+	if err := os.WriteFile(filepath.Join(lib, "broken.epub"), []byte("not a zip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reports := filepath.Join(lib, "reports")
+	opts := BatchRewriteOptions{
+		Rewrite:   RewriteOptions{Rules: []RewriteRule{{Find: "Rin,", Replace: "Len,"}}},
+		ReportDir: reports,
+		Jobs:      2,
+	}
+
+	res, err := BatchRewrite(context.Background(), lib, opts)
+	if err != nil {
+		t.Fatalf("BatchRewrite: %v", err)
+	}
+	if len(res.Reports) != 3 {
+		t.Fatalf("reports = %+v", res.Reports)
+	}
+	if done, skipped, failed := res.Counts(); done != 2 || skipped != 0 || failed != 1 {
+		t.Fatalf("counts = %d done, %d skipped, %d failed", done, skipped, failed)
+	}
+	one, two, broken := res.Reports[0], res.Reports[1], res.Reports[2]
+	if broken.Error == "" || one.Matches != 2 || one.FilesChanged != 2 || len(one.ChangedFiles) != 2 || two.Matches != 0 {
+		t.Fatalf("reports = %+v", res.Reports)
+	}
+	if one.SourceSHA256 == one.ResultSHA256 || two.SourceSHA256 != two.ResultSHA256 {
+		t.Fatalf("hashes: one %+v, two %+v", one, two)
+	}
+
+	data, err := os.ReadFile(filepath.Join(reports, "arc1", "one.epub.json"))
+	if err != nil {
+		t.Fatalf("read report: %v", err)
+	}
+	var written BookReport
+	if err := json.Unmarshal(data, &written); err != nil || written.Matches != 2 || written.ResultSHA256 != one.ResultSHA256 {
+		t.Fatalf("report file = %s, %v", data, err)
+	}
+
+	opts.Resume = true
+	var seen int
+	opts.OnBook = func(BookReport) { seen++ }
+	res, err = BatchRewrite(context.Background(), lib, opts)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if done, skipped, failed := res.Counts(); done != 0 || skipped != 2 || failed != 1 || seen != 3 {
+		t.Fatalf("resume counts = %d done, %d skipped, %d failed, %d callbacks", done, skipped, failed, seen)
+	}
+
+	opts.Rewrite.Rules = []RewriteRule{{Find: "Len", Replace: "Rin"}}
+	res, err = BatchRewrite(context.Background(), lib, opts)
+	if err != nil {
+		t.Fatalf("new rules: %v", err)
+	}
+	if done, skipped, _ := res.Counts(); done != 2 || skipped != 0 || res.Reports[0].Matches != 2 {
+		t.Fatalf("new rules: %d done, %d skipped, %+v", done, skipped, res.Reports)
+	}
+}
+
+func TestBatchRewriteOutDir(t *testing.T) {
+	lib := t.TempDir()
+	for rel, src := range map[string]string{
+		"a.epub": buildChaptersEPUB(t, "A", "Rin"),
+		"b.epub": buildChaptersEPUB(t, "B", "Other"),
+	} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(lib, rel), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, _ := fileSHA256(filepath.Join(lib, "a.epub"))
+	out := filepath.Join(lib, "fixed")
+	opts := BatchRewriteOptions{
+		Rewrite:   RewriteOptions{Rules: []RewriteRule{{Find: "Rin", Replace: "Len"}}},
+		OutDir:    out,
+		ReportDir: filepath.Join(t.TempDir(), "reports"),
+	}
+	if _, err := BatchRewrite(context.Background(), lib, opts); err != nil {
+		t.Fatalf("BatchRewrite: %v", err)
+	}
+	if after, _ := fileSHA256(filepath.Join(lib, "a.epub")); after != before {
+		t.Fatal("source rewritten despite OutDir")
+	}
+	for _, name := range []string{"a.epub", "b.epub"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+			t.Fatalf("output %s: %v", name, err)
+		}
+	}
+
+	// The output directory is inside the library but isn't a source.
+	opts.Resume = true
+	res, err := BatchRewrite(context.Background(), lib, opts)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if done, skipped, _ := res.Counts(); len(res.Reports) != 2 || done != 0 || skipped != 2 {
+		t.Fatalf("resume: %d reports, %d done, %d skipped", len(res.Reports), done, skipped)
+	}
+}
+
+func TestBatchRewriteCancelledCounts(t *testing.T) {
+	lib := t.TempDir()
+	data, err := os.ReadFile(buildChaptersEPUB(t, "One", "Rin, Rin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(lib, "one.epub"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	res, err := BatchRewrite(ctx, lib, BatchRewriteOptions{
+		Rewrite:   RewriteOptions{Rules: []RewriteRule{{Find: "Rin,", Replace: "Len,"}}},
+		ReportDir: filepath.Join(lib, "reports"),
+	})
+	if err == nil {
+		t.Fatalf("BatchRewrite with a cancelled context succeeded")
+	}
+	if done, skipped, failed := res.Counts(); done != 0 || skipped != 0 || failed != 0 {
+		t.Fatalf("counts = %d done, %d skipped, %d failed, want none", done, skipped, failed)
+	}
+}
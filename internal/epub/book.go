@@ -0,0 +1,108 @@
+package epub
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// maxBookEntrySize caps how much of one archive entry Book.ReadFile will
+// decompress, so a hostile archive can't exhaust memory.
+const maxBookEntrySize = 256 << 20
+
+// Book is a read-only view of an EPUB read straight from its archive. The
+// container, package document and nav are parsed when it is opened and other
+// entries are read on demand; unlike the loader used for edits, nothing is
+// extracted to disk. Close releases the archive.
+type Book struct {
+	SourcePath string
+	// PackagePath is the archive path of the package document; manifest
+	// hrefs are relative to its directory.
+	PackagePath string
+	Package     *PackageDocument
+	NavHref     string
+	NavItems    []NavItem
+	CoverID     string
+	// Warnings are problems with the source that opening worked around.
+	Warnings []string
+
+	zr    *zip.ReadCloser
+	files map[string]*zip.File
+}
+
+// OpenBook opens the EPUB at input for reading.
+func OpenBook(ctx context.Context, input string) (*Book, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	zr, err := zip.OpenReader(input)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", input, err)
+	}
+	b := &Book{SourcePath: input, zr: zr, files: make(map[string]*zip.File, len(zr.File))}
+	for _, f := range zr.File {
+		name := path.Clean(strings.TrimPrefix(strings.ReplaceAll(f.Name, "\\", "/"), "/"))
+		if _, dup := b.files[name]; !dup && !f.FileInfo().IsDir() {
+			b.files[name] = f
+		}
+	}
+
+	info, err := readPackageInfo(input, func(name string) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return b.readEntry(name)
+	})
+	if err != nil {
+		zr.Close()
+		return nil, err
+	}
+	b.PackagePath = info.PackagePath
+	b.Package = info.Package
+	b.NavHref = info.NavHref
+	b.NavItems = info.NavItems
+	b.CoverID = info.CoverID
+	b.Warnings = info.Warnings
+	return b, nil
+}
+
+func (b *Book) Close() error {
+	return b.zr.Close()
+}
+
+// ReadFile returns the contents of the entry at href, relative to the
+// package document's directory like a manifest href.
+func (b *Book) ReadFile(href string) ([]byte, error) {
+	return b.readEntry(normalizeEPUBPath(path.Join(path.Dir(b.PackagePath), href)))
+}
+
+// Exists reports whether the archive holds an entry at href, relative to the
+// package document's directory.
+func (b *Book) Exists(href string) bool {
+	_, ok := b.files[normalizeEPUBPath(path.Join(path.Dir(b.PackagePath), href))]
+	return ok
+}
+
+func (b *Book) readEntry(name string) ([]byte, error) {
+	f, ok := b.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxBookEntrySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	if len(data) > maxBookEntrySize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, maxBookEntrySize)
+	}
+	return data, nil
+}
@@ -0,0 +1,59 @@
+package epub
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestOpenBook(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title: "In Memory",
+			items: []ManifestItem{
+				testItem("nav", "Text/nav.xhtml", "nav"),
+				testItem("img", "Images/cover.png", "cover-image"),
+				testItem("chap", "Text/chapter.xhtml"),
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/Text/nav.xhtml":     `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="chapter.xhtml">Chapter</a></li></ol></nav></body></html>`,
+		"OEBPS/Text/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Text</p></body></html>`,
+		"OEBPS/Images/cover.png":   "png",
+	})
+
+	book, err := OpenBook(context.Background(), input)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+
+	if book.PackagePath != "OEBPS/content.opf" || book.NavHref != "Text/nav.xhtml" || book.CoverID != "img" {
+		t.Fatalf("book = %+v", book)
+	}
+	if len(book.NavItems) != 1 || book.NavItems[0].Title != "Chapter" {
+		t.Fatalf("nav items = %+v", book.NavItems)
+	}
+	data, err := book.ReadFile("Text/chapter.xhtml")
+	if err != nil || !strings.Contains(string(data), "<p>Text</p>") {
+		t.Fatalf("ReadFile: %q %v", data, err)
+	}
+	if !book.Exists("Images/../Images/cover.png") || book.Exists("Images/missing.png") {
+		t.Fatalf("Exists gave the wrong answer")
+	}
+	if _, err := book.ReadFile("Images/missing.png"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("missing entry error = %v", err)
+	}
+}
+
+func TestOpenBookMissingRootfile(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"META-INF/container.xml": `<container xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="OEBPS/missing.opf"/></rootfiles></container>`,
+	})
+	_, err := OpenBook(context.Background(), input)
+	if err == nil || !strings.Contains(err.Error(), "OEBPS/missing.opf") {
+		t.Fatalf("expected the missing rootfile to be named, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package epub
+
+import "strings"
+
+// collectionID is the id the merged belongs-to-collection meta gets, so its
+// collection-type can refine it.
+const collectionID = "collection"
+
+// volumeCollection returns the name of the first belongs-to-collection the
+// metadata declares, or "".
+func volumeCollection(meta Metadata) string {
+	for _, m := range meta.Meta {
+		if m.Property == "belongs-to-collection" && m.Refines == "" {
+			if name := strings.TrimSpace(m.Value); name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// mergedCollection picks the merged book's collection: opts.Collection when
+// set, else the name the volumes share. When they disagree it is
+// opts.Title if set, else the first volume's name.
+func mergedCollection(vols []*Volume, opts MergeOptions) string {
+	if name := strings.TrimSpace(opts.Collection); name != "" {
+		return name
+	}
+	var first string
+	for _, vol := range vols {
+		name := volumeCollection(vol.PackageDoc.Metadata)
+		switch {
+		case name == "":
+		case first == "":
+			first = name
+		case name != first:
+			if title := strings.TrimSpace(opts.Title); title != "" {
+				return title
+			}
+			return first
+		}
+	}
+	return first
+}
+
+// setCollection replaces meta's collections, with their refinements (such
+// as each volume's group-position), by a single belongs-to-collection of
+// type "set" named name.
+func setCollection(meta *Metadata, name string) {
+	dropped := make(map[string]bool)
+	kept := meta.Meta[:0]
+	for _, m := range meta.Meta {
+		if m.Property == "belongs-to-collection" && m.Refines == "" {
+			if m.ID != "" {
+				dropped["#"+m.ID] = true
+			}
+			continue
+		}
+		kept = append(kept, m)
+	}
+	meta.Meta = kept
+	if len(dropped) > 0 {
+		kept = meta.Meta[:0]
+		for _, m := range meta.Meta {
+			if !dropped[m.Refines] {
+				kept = append(kept, m)
+			}
+		}
+		meta.Meta = kept
+	}
+	meta.Meta = append(meta.Meta,
+		MetaNode{ID: collectionID, Property: "belongs-to-collection", Value: name},
+		MetaNode{Refines: "#" + collectionID, Property: "collection-type", Value: "set"},
+	)
+}
@@ -0,0 +1,78 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func buildCollectionEPUB(t *testing.T, collection string, position int) string {
+	t.Helper()
+	var meta []string
+	if collection != "" {
+		meta = []string{
+			`<meta property="belongs-to-collection" id="c01">` + collection + `</meta>`,
+			`<meta refines="#c01" property="collection-type">series</meta>`,
+			fmt.Sprintf(`<meta refines="#c01" property="group-position">%d</meta>`, position),
+		}
+	}
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Vol",
+			lang:       "en",
+			identifier: "urn:test:collection",
+			metadata:   meta,
+			items:      []ManifestItem{testItem("nav", "nav.xhtml", "nav"), testItem("c1", "c1.xhtml")},
+			spine:      []string{"c1"},
+		}),
+		"OEBPS/nav.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="c1.xhtml">One</a></li></ol></nav></body></html>`,
+		"OEBPS/c1.xhtml":  `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p></body></html>`,
+	})
+}
+
+func TestMergeEPUBsCollection(t *testing.T) {
+	saga1 := buildCollectionEPUB(t, "Saga", 1)
+	saga2 := buildCollectionEPUB(t, "Saga", 2)
+	other := buildCollectionEPUB(t, "Other Saga", 3)
+	none := buildCollectionEPUB(t, "", 0)
+
+	for _, tc := range []struct {
+		name   string
+		inputs []string
+		opts   MergeOptions
+		want   string
+	}{
+		{"shared", []string{saga1, none, saga2}, MergeOptions{}, "Saga"},
+		{"disagree with title", []string{saga1, other}, MergeOptions{Title: "Omnibus"}, "Omnibus"},
+		{"disagree", []string{saga1, other}, MergeOptions{}, "Saga"},
+		{"override", []string{saga1, saga2}, MergeOptions{Collection: "Saga Complete"}, "Saga Complete"},
+		{"none", []string{none, none}, MergeOptions{}, ""},
+	} {
+		opts := tc.opts
+		opts.OutPath = filepath.Join(t.TempDir(), "merged.epub")
+		if _, err := MergeEPUBs(context.Background(), tc.inputs, opts); err != nil {
+			t.Fatalf("%s: MergeEPUBs: %v", tc.name, err)
+		}
+		book, err := OpenBook(context.Background(), opts.OutPath)
+		if err != nil {
+			t.Fatalf("%s: OpenBook: %v", tc.name, err)
+		}
+		var got []string
+		for _, m := range book.Package.Metadata.Meta {
+			switch m.Property {
+			case "belongs-to-collection", "collection-type", "group-position":
+				got = append(got, m.Refines+m.Property+"="+m.Value)
+			}
+		}
+		book.Close()
+		want := ""
+		if tc.want != "" {
+			want = "belongs-to-collection=" + tc.want + ",#collectioncollection-type=set"
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%s: collection meta = %q, want %q", tc.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// stripCommentsDocument removes the <!-- ... --> comments of an XHTML
+// document, copying everything else byte for byte. Comments inside <script>
+// and <style> are kept, since HTML readers take their content as code, and
+// so are conditional comments (<!--[if IE]> ... <![endif]-->). It returns
+// the new document and the number of comments removed.
+func stripCommentsDocument(data []byte) ([]byte, int, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	var (
+		out     bytes.Buffer
+		keep    int
+		last    int64
+		removed int
+	)
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if keep > 0 || name == "script" || name == "style" {
+				keep++
+			}
+		case xml.EndElement:
+			if keep > 0 {
+				keep--
+			}
+		case xml.Comment:
+			if keep > 0 || isConditionalComment(t) {
+				continue
+			}
+			out.Write(data[last:start])
+			last = dec.InputOffset()
+			removed++
+		}
+	}
+	if removed == 0 {
+		return data, 0, nil
+	}
+	out.Write(data[last:])
+	return out.Bytes(), removed, nil
+}
+
+// isConditionalComment reports whether a comment is one half of an Internet
+// Explorer conditional comment.
+func isConditionalComment(c xml.Comment) bool {
+	s := strings.TrimSpace(string(c))
+	return strings.HasPrefix(s, "[if") || strings.HasSuffix(s, "<![endif]") || strings.HasPrefix(s, "<![endif]")
+}
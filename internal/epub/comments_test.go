@@ -0,0 +1,76 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStripCommentsDocument(t *testing.T) {
+	doc := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" +
+		"<!-- converted by sometool -->\n" +
+		"<html xmlns=\"http://www.w3.org/1999/xhtml\"><head>" +
+		"<style><!-- p { margin: 0 } --></style>" +
+		"<!--[if IE]><link rel=\"stylesheet\" href=\"ie.css\"/><![endif]-->" +
+		"</head><body>\n" +
+		"<p>One<!-- TODO: ask translator -->two</p>\n" +
+		"<p><![CDATA[<!-- not a comment -->]]></p>\n" +
+		"</body></html>"
+	want := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" +
+		"\n" +
+		"<html xmlns=\"http://www.w3.org/1999/xhtml\"><head>" +
+		"<style><!-- p { margin: 0 } --></style>" +
+		"<!--[if IE]><link rel=\"stylesheet\" href=\"ie.css\"/><![endif]-->" +
+		"</head><body>\n" +
+		"<p>Onetwo</p>\n" +
+		"<p><![CDATA[<!-- not a comment -->]]></p>\n" +
+		"</body></html>"
+
+	out, removed, err := stripCommentsDocument([]byte(doc))
+	if err != nil {
+		t.Fatalf("stripCommentsDocument: %v", err)
+	}
+	if string(out) != want || removed != 2 {
+		t.Fatalf("removed %d, got:\n%q\nwant:\n%q", removed, out, want)
+	}
+	if _, removed, _ := stripCommentsDocument(out); removed != 0 {
+		t.Fatalf("second pass removed %d comments", removed)
+	}
+}
+
+func TestRewriteAndMergeStripComments(t *testing.T) {
+	input := buildChaptersEPUB(t, "Notes", "One<!-- check this -->", "Two")
+
+	stats, err := RewriteEPUB(context.Background(), input, RewriteOptions{StripComments: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("RewriteEPUB: %v", err)
+	}
+	// The title is in the nav too.
+	if stats.CommentsRemoved != 2 || strings.Join(stats.ChangedFiles, " ") != "nav.xhtml c1.xhtml" {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	merged, err := MergeEPUBs(context.Background(), []string{input, buildTestEPUB(t, "Vol 2", "en")}, MergeOptions{OutPath: out, StripComments: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	// The volume's nav isn't kept, so only the chapter counts.
+	if merged.Rewrite.CommentsRemoved != 1 {
+		t.Fatalf("merge stats = %+v", merged.Rewrite)
+	}
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	data, err := vol.readFile("Volumes/v0001/c1.xhtml")
+	if err != nil {
+		t.Fatalf("read chapter: %v", err)
+	}
+	if doc := string(data); strings.Contains(doc, "<!--") || !strings.Contains(doc, "One") {
+		t.Fatalf("chapter = %q", doc)
+	}
+}
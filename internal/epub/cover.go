@@ -0,0 +1,463 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	CoverFirst = "first"
+	CoverLast  = "last"
+	CoverNone  = "none"
+	CoverGrid  = "grid"
+)
+
+const (
+	gridCoverID   = "cover-grid"
+	gridCoverHref = "cover-grid.jpg"
+	// An external MergeOptions.Cover image is copied to externalCoverName
+	// plus its extension.
+	externalCoverID   = "cover-image"
+	externalCoverName = "cover"
+	// defaultCoverEntryTitle titles the cover entry MergeOptions.CoverEntries
+	// adds under each volume.
+	defaultCoverEntryTitle = "Cover"
+	// A guessed cover must be at least this many pixels each way.
+	guessCoverMinWidth  = 200
+	guessCoverMinHeight = 300
+)
+
+// isCoverMode reports whether MergeOptions.Cover names a mode rather than
+// an image file.
+func isCoverMode(cover string) bool {
+	switch cover {
+	case "", CoverFirst, CoverLast, CoverNone, CoverGrid:
+		return true
+	}
+	return false
+}
+
+// externalCoverType returns the media type of the image file p given as
+// MergeOptions.Cover, which must be one EPUB readers are required to show.
+func externalCoverType(p string) (string, error) {
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("cover %q is neither first, last, none, grid nor an image file", p)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cover: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("cover %s is not a file", p)
+	}
+	switch mt := extMediaTypes[strings.ToLower(filepath.Ext(p))]; mt {
+	case "image/jpeg", "image/png", "image/gif", "image/webp", "image/svg+xml":
+		return mt, nil
+	}
+	return "", fmt.Errorf("cover %s is not a JPEG, PNG, GIF, WebP or SVG image", p)
+}
+
+// volumeCoverPath returns the extracted file backing the volume's cover image.
+func volumeCoverPath(vol *Volume) (string, bool) {
+	if vol.CoverID == "" {
+		return "", false
+	}
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		if item.ID == vol.CoverID {
+			return filepath.Join(vol.PackageDir, filepath.FromSlash(item.Href)), true
+		}
+	}
+	return "", false
+}
+
+// coverDocuments returns the package-relative hrefs of the volume's cover and
+// title page documents: the landmarks entries typed cover or titlepage, or
+// failing that the first spine document that displays the cover image.
+func coverDocuments(vol *Volume) ([]string, error) {
+	return findCoverDocuments(vol.PackageDoc, vol.NavHref, vol.CoverID, vol.readFile)
+}
+
+// findCoverDocuments is coverDocuments for a package whose files are read
+// through readFile (by package-relative href).
+func findCoverDocuments(pkg *PackageDocument, navHref, coverID string, readFile func(href string) ([]byte, error)) ([]string, error) {
+	hrefIDs := make(map[string]string)
+	idHrefs := make(map[string]string)
+	for _, item := range pkg.Manifest.Items {
+		hrefIDs[normalizeEPUBPath(item.Href)] = item.ID
+		idHrefs[item.ID] = item.Href
+	}
+
+	var out []string
+	if navHref != "" {
+		data, err := readFile(navHref)
+		if err != nil {
+			return nil, err
+		}
+		landmarks, err := parseLandmarks(data, "cover", "titlepage", "title-page")
+		if err != nil {
+			return nil, fmt.Errorf("parse landmarks: %w", err)
+		}
+		seen := map[string]bool{}
+		for _, href := range landmarks {
+			id, ok := navItemID(href, path.Dir(navHref), hrefIDs)
+			if !ok || seen[id] {
+				continue
+			}
+			seen[id] = true
+			out = append(out, idHrefs[id])
+		}
+	}
+	if len(out) > 0 {
+		return out, nil
+	}
+
+	coverHref, ok := idHrefs[coverID]
+	if !ok {
+		return nil, nil
+	}
+	coverHref = normalizeEPUBPath(coverHref)
+	for _, ref := range pkg.Spine.Itemrefs {
+		href, ok := idHrefs[ref.IDRef]
+		if !ok {
+			continue
+		}
+		data, err := readFile(href)
+		if err != nil {
+			return nil, err
+		}
+		if referencesResource(data, path.Dir(href), coverHref) {
+			return []string{href}, nil
+		}
+	}
+	return nil, nil
+}
+
+// volumeCoverPage returns the package-relative href of the volume's cover
+// page: the document its cover landmark points at, or else its first linear
+// spine document when that shows the cover image or is just an image. It
+// returns "" when the volume has no recognisable cover page.
+func volumeCoverPage(vol *Volume) (string, error) {
+	hrefIDs := make(map[string]string)
+	items := make(map[string]ManifestItem, len(vol.PackageDoc.Manifest.Items))
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		hrefIDs[normalizeEPUBPath(item.Href)] = item.ID
+		items[item.ID] = item
+	}
+
+	if vol.NavHref != "" {
+		data, err := vol.readFile(vol.NavHref)
+		if err != nil {
+			return "", err
+		}
+		landmarks, err := parseLandmarks(data, "cover")
+		if err != nil {
+			return "", fmt.Errorf("parse landmarks: %w", err)
+		}
+		for _, href := range landmarks {
+			if id, ok := navItemID(href, path.Dir(vol.NavHref), hrefIDs); ok {
+				return items[id].Href, nil
+			}
+		}
+	}
+
+	for _, ref := range vol.PackageDoc.Spine.Itemrefs {
+		item, ok := items[vol.canonicalID(ref.IDRef)]
+		if !ok || item.MediaType != "application/xhtml+xml" || hasProperty(item.Properties, "nav") || strings.EqualFold(ref.Linear, "no") {
+			continue
+		}
+		data, err := vol.readFile(item.Href)
+		if err != nil {
+			return "", err
+		}
+		if cover, ok := items[vol.CoverID]; ok && referencesResource(data, path.Dir(item.Href), normalizeEPUBPath(cover.Href)) {
+			return item.Href, nil
+		}
+		if ok, _, err := isIllustrationPage(data); err != nil || !ok {
+			return "", err
+		}
+		return item.Href, nil
+	}
+	return "", nil
+}
+
+// guessCoverID returns the manifest id of the image to treat as the cover of
+// a volume that declares none, or "". It only accepts the image of a first
+// linear spine document that shows exactly one image and little else, when
+// that image is a JPEG, PNG or GIF of at least guessCoverMinWidth by
+// guessCoverMinHeight pixels and no wider than it is tall.
+func guessCoverID(vol *Volume) (string, error) {
+	items := make(map[string]ManifestItem, len(vol.PackageDoc.Manifest.Items))
+	hrefIDs := make(map[string]string, len(vol.PackageDoc.Manifest.Items))
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		items[item.ID] = item
+		hrefIDs[normalizeEPUBPath(item.Href)] = item.ID
+	}
+	for _, ref := range vol.PackageDoc.Spine.Itemrefs {
+		item, ok := items[vol.canonicalID(ref.IDRef)]
+		if !ok || item.MediaType != "application/xhtml+xml" || hasProperty(item.Properties, "nav") || strings.EqualFold(ref.Linear, "no") {
+			continue
+		}
+		data, err := vol.readFile(item.Href)
+		if err != nil {
+			return "", err
+		}
+		if ok, _, err := isIllustrationPage(data); err != nil || !ok {
+			return "", err
+		}
+		refs := imageRefs(data)
+		if len(refs) != 1 {
+			return "", nil
+		}
+		target := resolveRef(path.Dir(normalizeEPUBPath(item.Href)), refs[0])
+		img, ok := items[hrefIDs[target]]
+		if !ok {
+			return "", nil
+		}
+		switch strings.ToLower(img.MediaType) {
+		case "image/jpeg", "image/png", "image/gif":
+		default:
+			return "", nil
+		}
+		f, err := os.Open(filepath.Join(vol.PackageDir, filepath.FromSlash(img.Href)))
+		if err != nil {
+			return "", nil
+		}
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil || cfg.Width < guessCoverMinWidth || cfg.Height < guessCoverMinHeight || cfg.Width > cfg.Height {
+			return "", nil
+		}
+		return img.ID, nil
+	}
+	return "", nil
+}
+
+// imageRefs returns the sources of the <img> and SVG <image> elements of an
+// XHTML document, as written.
+func imageRefs(data []byte) []string {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	var out []string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return out
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || (se.Name.Local != "img" && se.Name.Local != "image") {
+			continue
+		}
+		for _, attr := range se.Attr {
+			if attr.Name.Local == "src" || attr.Name.Local == "href" {
+				out = append(out, attr.Value)
+				break
+			}
+		}
+	}
+}
+
+// referencesResource reports whether an XHTML document shows target (a
+// package-relative href) through an img src or an SVG image href.
+func referencesResource(data []byte, docDir, target string) bool {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || (se.Name.Local != "img" && se.Name.Local != "image") {
+			continue
+		}
+		for _, attr := range se.Attr {
+			if attr.Name.Local != "src" && attr.Name.Local != "href" {
+				continue
+			}
+			ref := strings.TrimSpace(attr.Value)
+			if ref == "" || strings.Contains(ref, "://") {
+				continue
+			}
+			if normalizeEPUBPath(path.Join(docDir, ref)) == target {
+				return true
+			}
+		}
+	}
+}
+
+// writeGridCover tiles every volume cover it can decode into one JPEG at dest.
+// Volumes without a usable cover are skipped.
+func writeGridCover(vols []*Volume, columns int, background string, dest string) error {
+	var paths []string
+	for _, vol := range vols {
+		if p, ok := volumeCoverPath(vol); ok {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("grid cover: no volume covers found")
+	}
+
+	bg := color.Color(color.White)
+	if background != "" {
+		c, err := parseHexColor(background)
+		if err != nil {
+			return fmt.Errorf("grid cover: %w", err)
+		}
+		bg = c
+	}
+
+	img, err := buildGridCover(paths, columns, bg)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return jpeg.Encode(out, img, &jpeg.Options{Quality: 90})
+}
+
+// buildGridCover composites the images at paths into a grid. The canvas keeps
+// the first image's width and aspect ratio per cell, so the result stays close
+// to the size of a single cover. columns <= 0 picks a roughly square layout.
+func buildGridCover(paths []string, columns int, bg color.Color) (image.Image, error) {
+	covers := make([]image.Image, 0, len(paths))
+	for _, p := range paths {
+		img, err := decodeImageFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("grid cover: decode %s: %w", filepath.Base(p), err)
+		}
+		if img.Bounds().Empty() {
+			return nil, fmt.Errorf("grid cover: %s is an empty image", filepath.Base(p))
+		}
+		covers = append(covers, img)
+	}
+
+	if columns <= 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(len(covers)))))
+	}
+	if columns > len(covers) {
+		columns = len(covers)
+	}
+	rows := (len(covers) + columns - 1) / columns
+
+	first := covers[0].Bounds()
+	cellW := first.Dx() / columns
+	if cellW < 1 {
+		cellW = 1
+	}
+	cellH := cellW * first.Dy() / first.Dx()
+	if cellH < 1 {
+		cellH = 1
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, cellW*columns, cellH*rows))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	for i, img := range covers {
+		cell := image.Rect(0, 0, cellW, cellH).Add(image.Pt((i%columns)*cellW, (i/columns)*cellH))
+		target := fitRect(img.Bounds(), cell)
+		draw.Draw(canvas, target, scaleImage(img, target.Dx(), target.Dy()), image.Point{}, draw.Over)
+	}
+	return canvas, nil
+}
+
+func decodeImageFile(p string) (image.Image, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// fitRect returns the largest rectangle with src's aspect ratio centered in cell.
+func fitRect(src, cell image.Rectangle) image.Rectangle {
+	w, h := cell.Dx(), cell.Dy()
+	if src.Dx()*h > src.Dy()*w {
+		h = w * src.Dy() / src.Dx()
+	} else {
+		w = h * src.Dx() / src.Dy()
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	x := cell.Min.X + (cell.Dx()-w)/2
+	y := cell.Min.Y + (cell.Dy()-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// scaleImage resizes src to w×h by averaging the source pixels that fall
+// into each destination pixel.
+func scaleImage(src image.Image, w, h int) image.Image {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := b.Min.Y + (y+1)*b.Dy()/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := b.Min.X + (x+1)*b.Dx()/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+// parseHexColor parses "#rgb" or "#rrggbb" (the leading # is optional).
+func parseHexColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color %q (want #rrggbb)", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q (want #rrggbb)", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
@@ -0,0 +1,338 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildGridCover(t *testing.T) {
+	dir := t.TempDir()
+	colors := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+	var paths []string
+	for i, c := range colors {
+		p := filepath.Join(dir, string(rune('a'+i))+".png")
+		if err := os.WriteFile(p, []byte(solidPNG(t, 40, 60, c)), 0o644); err != nil {
+			t.Fatalf("write png: %v", err)
+		}
+		paths = append(paths, p)
+	}
+
+	bg := color.RGBA{10, 20, 30, 255}
+	img, err := buildGridCover(paths, 2, bg)
+	if err != nil {
+		t.Fatalf("buildGridCover: %v", err)
+	}
+	if got := img.Bounds(); got.Dx() != 40 || got.Dy() != 60 {
+		t.Fatalf("canvas = %v, want 40x60", got)
+	}
+
+	checks := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{10, 15, colors[0]},
+		{30, 15, colors[1]},
+		{10, 45, colors[2]},
+		{30, 45, bg},
+	}
+	for _, c := range checks {
+		r, g, b, _ := img.At(c.x, c.y).RGBA()
+		got := color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}
+		if got != c.want {
+			t.Fatalf("pixel (%d,%d) = %v want %v", c.x, c.y, got, c.want)
+		}
+	}
+}
+
+func TestBuildGridCoverRejectsEmptyImage(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 0, 0), color.Palette{color.Black}), nil); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+	p := filepath.Join(dir, "empty.gif")
+	if err := os.WriteFile(p, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write gif: %v", err)
+	}
+
+	if _, err := buildGridCover([]string{p}, 0, color.White); err == nil || !strings.Contains(err.Error(), "empty image") {
+		t.Fatalf("err = %v, want empty image error", err)
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	c, err := parseHexColor("#0f8")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if c != (color.RGBA{0x00, 0xff, 0x88, 0xff}) {
+		t.Fatalf("color = %v", c)
+	}
+	if _, err := parseHexColor("blue"); err == nil {
+		t.Fatalf("expected error for named color")
+	}
+}
+
+func TestMergeEPUBsGridCover(t *testing.T) {
+	a := buildCoverTestEPUB(t, "Vol 1", color.RGBA{255, 0, 0, 255})
+	b := buildCoverTestEPUB(t, "Vol 2", color.RGBA{0, 0, 255, 255})
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{
+		OutPath: out,
+		Cover:   CoverGrid,
+	}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	if vol.CoverID != gridCoverID {
+		t.Fatalf("cover id = %q want %q", vol.CoverID, gridCoverID)
+	}
+	covers := 0
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		if hasProperty(item.Properties, "cover-image") {
+			covers++
+		}
+	}
+	if covers != 1 {
+		t.Fatalf("expected exactly one cover-image item, got %d", covers)
+	}
+	if _, err := os.Stat(filepath.Join(vol.PackageDir, gridCoverHref)); err != nil {
+		t.Fatalf("grid cover missing: %v", err)
+	}
+}
+
+func TestMergeEPUBsCoverModes(t *testing.T) {
+	a := buildCoverTestEPUB(t, "Vol 1", color.RGBA{255, 0, 0, 255})
+	b := buildCoverTestEPUB(t, "Vol 2", color.RGBA{0, 0, 255, 255})
+	external := filepath.Join(t.TempDir(), "Omnibus Cover.PNG")
+	if err := os.WriteFile(external, []byte(solidPNG(t, 20, 30, color.White)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		cover, wantID, wantHref string
+	}{
+		{CoverFirst, "v0001_cover", "Volumes/v0001/cover.png"},
+		{CoverLast, "v0002_cover", "Volumes/v0002/cover.png"},
+		{CoverNone, "", ""},
+		{external, externalCoverID, "cover.png"},
+	}
+	for _, tc := range cases {
+		out := filepath.Join(t.TempDir(), "merged.epub")
+		if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Cover: tc.cover}); err != nil {
+			t.Fatalf("%s: MergeEPUBs: %v", tc.cover, err)
+		}
+		vol, err := loadVolume(context.Background(), 0, out)
+		if err != nil {
+			t.Fatalf("%s: reopen: %v", tc.cover, err)
+		}
+		defer os.RemoveAll(vol.TempDir)
+
+		if vol.CoverID != tc.wantID {
+			t.Errorf("%s: cover id = %q want %q", tc.cover, vol.CoverID, tc.wantID)
+		}
+		var hrefs []string
+		for _, item := range vol.PackageDoc.Manifest.Items {
+			if hasProperty(item.Properties, "cover-image") {
+				hrefs = append(hrefs, item.Href)
+			}
+		}
+		if tc.wantHref == "" && len(hrefs) != 0 || tc.wantHref != "" && (len(hrefs) != 1 || hrefs[0] != tc.wantHref) {
+			t.Errorf("%s: cover-image items = %q want %q", tc.cover, hrefs, tc.wantHref)
+		}
+		for _, m := range vol.PackageDoc.Metadata.Meta {
+			if m.Name == "cover" && m.Content != tc.wantID {
+				t.Errorf("%s: cover meta = %q", tc.cover, m.Content)
+			}
+		}
+	}
+
+	for _, bad := range []string{filepath.Join(t.TempDir(), "missing.jpg"), a} {
+		if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: filepath.Join(t.TempDir(), "x.epub"), Cover: bad}); err == nil {
+			t.Errorf("cover %s: expected an error", bad)
+		}
+	}
+	_, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: filepath.Join(t.TempDir(), "x.epub"), Cover: "frist"})
+	if err == nil || !strings.Contains(err.Error(), "neither first, last, none, grid nor an image file") {
+		t.Errorf("cover frist: err = %v", err)
+	}
+}
+
+func solidPNG(t *testing.T, w, h int, c color.Color) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.String()
+}
+
+func buildCoverTestEPUB(t *testing.T, title string, c color.Color) string {
+	t.Helper()
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      title,
+			lang:       "en",
+			identifier: "urn:test:cover",
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				testItem("cover", "cover.png", "cover-image"),
+				testItem("chap", "chapter.xhtml"),
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/nav.xhtml":     `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="chapter.xhtml">Chapter</a></li></ol></nav></body></html>`,
+		"OEBPS/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Chapter 1</p></body></html>`,
+		"OEBPS/cover.png":     solidPNG(t, 30, 45, c),
+	})
+}
+
+func TestMergeEPUBsCoverEntries(t *testing.T) {
+	opf := func(title string, chapters ...string) string {
+		items := []ManifestItem{testItem("nav", "nav.xhtml", "nav")}
+		for _, id := range chapters {
+			items = append(items, testItem(id, id+".xhtml"))
+		}
+		return testOPF(testPackage{title: title, lang: "en", identifier: "urn:test:cover-entries", items: items, spine: chapters})
+	}
+	withLandmark := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": opf("Vol 1", "front", "cov", "c1"),
+		"OEBPS/nav.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol><li><a href="cov.xhtml">Cover Art</a></li><li><a href="c1.xhtml">One</a></li></ol></nav>
+<nav epub:type="landmarks"><ol><li><a epub:type="cover" href="cov.xhtml">Cover</a></li></ol></nav>
+</body></html>`,
+		"OEBPS/front.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Front matter</p></body></html>`,
+		"OEBPS/cov.xhtml":   `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Cover text</p></body></html>`,
+		"OEBPS/c1.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p></body></html>`,
+	})
+	withImagePage := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": opf("Vol 2", "art", "c1"),
+		"OEBPS/nav.xhtml":   `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="c1.xhtml">One</a></li></ol></nav></body></html>`,
+		"OEBPS/art.xhtml":   `<html xmlns="http://www.w3.org/1999/xhtml"><body><img src="art.jpg" alt=""/></body></html>`,
+		"OEBPS/c1.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p></body></html>`,
+	})
+	plain := buildChaptersEPUB(t, "Vol 3", "One")
+
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{withLandmark, withImagePage, plain}, MergeOptions{OutPath: out, CoverEntries: true}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	var got []string
+	for _, entry := range vol.NavItems {
+		var children []string
+		for _, child := range entry.Children {
+			children = append(children, child.Title+"="+child.Href)
+		}
+		got = append(got, entry.Title+"["+strings.Join(children, ",")+"]")
+	}
+	want := []string{
+		"Vol 1[Cover=Volumes/v0001/cov.xhtml,One=Volumes/v0001/c1.xhtml]",
+		"Vol 2[Cover=Volumes/v0002/art.xhtml,One=Volumes/v0002/c1.xhtml]",
+		"Vol 3[One=Volumes/v0003/c1.xhtml]",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("nav =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func buildGuessCoverEPUB(t *testing.T, firstPage string, w, h int) string {
+	t.Helper()
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "No Cover",
+			lang:       "en",
+			identifier: "urn:test:guess-cover",
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				testItem("front", "Text/front.xhtml"),
+				testItem("art", "Images/art.png"),
+				testItem("chap", "Text/chapter.xhtml"),
+			},
+			spine: []string{"front", "chap"},
+		}),
+		"OEBPS/nav.xhtml":          `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="Text/chapter.xhtml">Chapter</a></li></ol></nav></body></html>`,
+		"OEBPS/Text/front.xhtml":   `<html xmlns="http://www.w3.org/1999/xhtml"><body>` + firstPage + `</body></html>`,
+		"OEBPS/Text/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Chapter 1</p></body></html>`,
+		"OEBPS/Images/art.png":     solidPNG(t, w, h, color.White),
+	})
+}
+
+func TestGuessCoverID(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		firstPage string
+		w, h      int
+		want      string
+	}{
+		{"full-page image", `<div><img src="../Images/art.png" alt="cover"/></div>`, 200, 300, "art"},
+		{"svg wrapper", `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><image xlink:href="../Images/art.png"/></svg>`, 600, 800, "art"},
+		{"landscape", `<img src="../Images/art.png"/>`, 400, 300, ""},
+		{"too small", `<img src="../Images/art.png"/>`, 50, 80, ""},
+		{"two images", `<img src="../Images/art.png"/><img src="../Images/art.png"/>`, 200, 300, ""},
+		{"text page", `<img src="../Images/art.png"/><p>` + strings.Repeat("Story text goes on. ", 10) + `</p>`, 200, 300, ""},
+	} {
+		vol, err := loadVolume(context.Background(), 0, buildGuessCoverEPUB(t, tc.firstPage, tc.w, tc.h))
+		if err != nil {
+			t.Fatalf("%s: loadVolume: %v", tc.name, err)
+		}
+		got, err := guessCoverID(vol)
+		os.RemoveAll(vol.TempDir)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: guessCoverID = %q, %v; want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestMergeEPUBsGuessCover(t *testing.T) {
+	a := buildGuessCoverEPUB(t, `<img src="../Images/art.png"/>`, 200, 300)
+	b := buildChaptersEPUB(t, "Vol 2", "One")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, GuessCover: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if len(stats.GuessedCovers) != 1 || stats.GuessedCovers[0] != a {
+		t.Fatalf("GuessedCovers = %q", stats.GuessedCovers)
+	}
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	for _, item := range book.Package.Manifest.Items {
+		if hasProperty(item.Properties, "cover-image") {
+			if item.Href != "Volumes/v0001/Images/art.png" {
+				t.Fatalf("cover-image = %s", item.Href)
+			}
+			return
+		}
+	}
+	t.Fatal("merged book has no cover-image")
+}
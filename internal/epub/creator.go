@@ -0,0 +1,42 @@
+package epub
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const defaultCreatorRole = "aut"
+
+// relatorPattern matches a MARC relator code (aut, ill, trl, edt, ...).
+var relatorPattern = regexp.MustCompile(`^[A-Za-z]{3}$`)
+
+// parseCreator splits a creator option such as "Some Name:trl" into the name
+// and its MARC relator code. A value without a trailing three-letter code is
+// an author, so names that merely contain a colon are kept whole.
+func parseCreator(s string) (name, role string) {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, ":"); i > 0 && relatorPattern.MatchString(strings.TrimSpace(s[i+1:])) {
+		return strings.TrimSpace(s[:i]), strings.ToLower(strings.TrimSpace(s[i+1:]))
+	}
+	return s, defaultCreatorRole
+}
+
+// creatorMetadata turns creator options into dc:creator elements and the
+// EPUB 3 role refinements that credit each one, in the order given.
+func creatorMetadata(specs []string) ([]DCMeta, []MetaNode) {
+	creators := make([]DCMeta, 0, len(specs))
+	roles := make([]MetaNode, 0, len(specs))
+	for i, spec := range specs {
+		name, role := parseCreator(spec)
+		id := fmt.Sprintf("creator%02d", i+1)
+		creators = append(creators, DCMeta{ID: id, Value: name})
+		roles = append(roles, MetaNode{
+			Refines:  "#" + id,
+			Property: "role",
+			Scheme:   "marc:relators",
+			Value:    role,
+		})
+	}
+	return creators, roles
+}
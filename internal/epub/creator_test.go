@@ -0,0 +1,58 @@
+package epub
+
+import "testing"
+
+func TestParseCreator(t *testing.T) {
+	cases := map[string][2]string{
+		"Some Writer":         {"Some Writer", "aut"},
+		"Some Translator:trl": {"Some Translator", "trl"},
+		" Artist : ILL ":      {"Artist", "ill"},
+		"Re:Zero Team":        {"Re:Zero Team", "aut"},
+		"Studio:Works:edt":    {"Studio:Works", "edt"},
+		":trl":                {":trl", "aut"},
+	}
+	for in, want := range cases {
+		name, role := parseCreator(in)
+		if name != want[0] || role != want[1] {
+			t.Errorf("parseCreator(%q) = %q, %q want %q, %q", in, name, role, want[0], want[1])
+		}
+	}
+}
+
+func TestBuildPackageCreatorRoles(t *testing.T) {
+	vols := []*Volume{{
+		DisplayName: "Vol 1",
+		PackageDoc:  &PackageDocument{Metadata: Metadata{Creators: []DCMeta{{Value: "Source Author"}}}},
+	}}
+	pkg := buildPackage(vols, Manifest{}, Spine{}, MergeOptions{Creators: []string{"Writer", "Translator:trl"}}, "")
+
+	creators := pkg.Metadata.Creators
+	if len(creators) != 2 || creators[0].Value != "Writer" || creators[1].Value != "Translator" {
+		t.Fatalf("creators = %+v", creators)
+	}
+	roles := map[string]string{}
+	for _, m := range pkg.Metadata.Meta {
+		if m.Property == "role" {
+			if m.Scheme != "marc:relators" {
+				t.Fatalf("role scheme = %q", m.Scheme)
+			}
+			roles[m.Refines] = m.Value
+		}
+	}
+	for _, c := range creators {
+		if c.ID == "" {
+			t.Fatalf("creator %q has no id", c.Value)
+		}
+	}
+	if roles["#"+creators[0].ID] != "aut" || roles["#"+creators[1].ID] != "trl" || len(roles) != 2 {
+		t.Fatalf("roles = %v", roles)
+	}
+
+	// Creators taken from the volumes are copied without inventing roles.
+	pkg = buildPackage(vols, Manifest{}, Spine{}, MergeOptions{}, "")
+	for _, m := range pkg.Metadata.Meta {
+		if m.Property == "role" {
+			t.Fatalf("unexpected role refinement %+v", m)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package epub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// dedupeResult summarizes one dedupe pass over the merged manifest.
+type dedupeResult struct {
+	items int
+	bytes int64
+}
+
+// sharedDir is where shareResources keeps the single copy of resources
+// several volumes ship, relative to the content directory.
+const sharedDir = "Shared"
+
+func isImageItem(item ManifestItem) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(item.MediaType)), "image/")
+}
+
+// isSharedResource reports whether shareResources considers item: its
+// stylesheets, fonts and images. Content documents never are.
+func isSharedResource(item ManifestItem) bool {
+	return strings.EqualFold(strings.TrimSpace(item.MediaType), "text/css") || isImageItem(item) || isFontItem(item)
+}
+
+// dedupeResources collapses byte-identical manifest items accepted by match
+// into their first occurrence: later copies are deleted from oebpsDir, dropped
+// from the manifest, and every reference to them in the remaining XHTML, SVG
+// and CSS documents is redirected. Items whose ids are in keep (such as each
+// volume's cover) are never merged. SVG documents that reference other files
+// are skipped, since their relative links only hold in their own directory.
+func dedupeResources(ctx context.Context, oebpsDir string, manifest *Manifest, keep map[string]bool, match func(ManifestItem) bool, threads int) (dedupeResult, error) {
+	var res dedupeResult
+
+	var candidates []int
+	for i, item := range manifest.Items {
+		if match(item) && !keep[item.ID] {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) < 2 {
+		return res, nil
+	}
+
+	type digest struct {
+		sum  string
+		size int64
+	}
+	digests := make([]digest, len(candidates))
+	err := parallelFor(ctx, len(candidates), threads, func(n int) error {
+		item := manifest.Items[candidates[n]]
+		data, err := os.ReadFile(filepath.Join(oebpsDir, filepath.FromSlash(item.Href)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if item.MediaType == "image/svg+xml" && hasRefs(data) {
+			return nil
+		}
+		sum := sha256.Sum256(data)
+		digests[n] = digest{sum: hex.EncodeToString(sum[:]), size: int64(len(data))}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	first := make(map[string]int)
+	removed := make(map[int]bool)
+	moved := make(map[string]string)
+	idMap := make(map[string]string)
+	for n, d := range digests {
+		if d.sum == "" {
+			continue
+		}
+		idx := candidates[n]
+		canon, ok := first[d.sum]
+		if !ok {
+			first[d.sum] = idx
+			continue
+		}
+		dup := manifest.Items[idx]
+		moved[normalizeEPUBPath(dup.Href)] = normalizeEPUBPath(manifest.Items[canon].Href)
+		idMap[dup.ID] = manifest.Items[canon].ID
+		removed[idx] = true
+		res.items++
+		res.bytes += d.size
+		if err := os.Remove(filepath.Join(oebpsDir, filepath.FromSlash(dup.Href))); err != nil {
+			return res, err
+		}
+	}
+	if len(removed) == 0 {
+		return res, nil
+	}
+
+	return res, redirectRefs(ctx, oebpsDir, manifest, removed, idMap, moved)
+}
+
+// redirectRefs drops the manifest items at the indexes in removed, points
+// fallbacks at the ids idMap gives for them, and rewrites every reference
+// in the remaining XHTML, SVG and CSS documents through moved.
+func redirectRefs(ctx context.Context, oebpsDir string, manifest *Manifest, removed map[int]bool, idMap, moved map[string]string) error {
+	items := manifest.Items[:0]
+	for i, item := range manifest.Items {
+		if removed[i] {
+			continue
+		}
+		if newID, ok := idMap[item.Fallback]; ok {
+			item.Fallback = newID
+		}
+		items = append(items, item)
+	}
+	manifest.Items = items
+
+	for _, item := range manifest.Items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var isCSS bool
+		switch item.MediaType {
+		case "application/xhtml+xml", "image/svg+xml":
+		case "text/css":
+			isCSS = true
+		default:
+			continue
+		}
+		p := filepath.Join(oebpsDir, filepath.FromSlash(item.Href))
+		data, err := os.ReadFile(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if out, changed := rewriteRefs(data, item.Href, isCSS, moved); changed {
+			if err := os.WriteFile(p, out, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// shareResources stores each set of byte-identical stylesheets, fonts and
+// images in the merged manifest once, under sharedDir: the first copy is
+// moved there, the others are deleted and dropped from the manifest, and
+// every reference to any of them is redirected to the shared file. Items
+// whose ids are in keep are never touched, and a resource only one volume
+// ships stays where it is.
+//
+// A stylesheet or SVG image that references other files only matches a copy
+// whose references resolve to the same files, so two volumes' identical CSS
+// pointing at their own, different fonts stays apart. The pass repeats until
+// nothing more is shared, so once the volumes' fonts have become one shared
+// file the stylesheets that use them can follow.
+func shareResources(ctx context.Context, oebpsDir string, manifest *Manifest, keep map[string]bool, threads int) (dedupeResult, error) {
+	var res dedupeResult
+	taken := make(map[string]bool)
+	for {
+		round, err := shareRound(ctx, oebpsDir, manifest, keep, taken, threads)
+		res.items += round.items
+		res.bytes += round.bytes
+		if err != nil || round.items == 0 {
+			return res, err
+		}
+	}
+}
+
+// shareRound is one pass of shareResources. taken holds the lowercased
+// hrefs already used under sharedDir.
+func shareRound(ctx context.Context, oebpsDir string, manifest *Manifest, keep, taken map[string]bool, threads int) (dedupeResult, error) {
+	var res dedupeResult
+
+	var candidates []int
+	for i, item := range manifest.Items {
+		if isSharedResource(item) && !keep[item.ID] {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) < 2 {
+		return res, nil
+	}
+
+	type digest struct {
+		key  string
+		size int64
+		refs []string
+	}
+	digests := make([]digest, len(candidates))
+	err := parallelFor(ctx, len(candidates), threads, func(n int) error {
+		item := manifest.Items[candidates[n]]
+		data, err := os.ReadFile(filepath.Join(oebpsDir, filepath.FromSlash(item.Href)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		sum := sha256.Sum256(data)
+		d := digest{key: hex.EncodeToString(sum[:]), size: int64(len(data))}
+		if isCSS := item.MediaType == "text/css"; isCSS || item.MediaType == "image/svg+xml" {
+			d.refs = localRefs(data, item.Href, isCSS)
+			if len(d.refs) > 0 {
+				d.key += "\x00" + strings.Join(d.refs, "\x00")
+			}
+		}
+		digests[n] = d
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	first := make(map[string]int)
+	shared := make(map[int]string)
+	removed := make(map[int]bool)
+	moved := make(map[string]string)
+	idMap := make(map[string]string)
+	for n, d := range digests {
+		if d.key == "" {
+			continue
+		}
+		idx := candidates[n]
+		canon, ok := first[d.key]
+		if !ok {
+			first[d.key] = n
+			continue
+		}
+		canonItem := manifest.Items[candidates[canon]]
+		target, ok := shared[candidates[canon]]
+		if !ok {
+			target = normalizeEPUBPath(canonItem.Href)
+			if !strings.HasPrefix(target, sharedDir+"/") {
+				target = sharedHref(path.Base(target), taken)
+				moved[normalizeEPUBPath(canonItem.Href)] = target
+			}
+			shared[candidates[canon]] = target
+		}
+		dup := manifest.Items[idx]
+		moved[normalizeEPUBPath(dup.Href)] = target
+		idMap[dup.ID] = canonItem.ID
+		removed[idx] = true
+		res.items++
+		res.bytes += d.size
+	}
+	if len(removed) == 0 {
+		return res, nil
+	}
+
+	for n, idx := range candidates {
+		target, ok := shared[idx]
+		item := &manifest.Items[idx]
+		if ok && target != normalizeEPUBPath(item.Href) {
+			if err := moveSharedResource(oebpsDir, item, target, digests[n].refs, moved); err != nil {
+				return res, err
+			}
+		}
+		if removed[idx] {
+			if err := os.Remove(filepath.Join(oebpsDir, filepath.FromSlash(item.Href))); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	return res, redirectRefs(ctx, oebpsDir, manifest, removed, idMap, moved)
+}
+
+// moveSharedResource moves item's file to target under oebpsDir and points
+// the item at it. refs are the files the item references, which are
+// rewritten relative to the new location (through moved, for those that are
+// being shared too).
+func moveSharedResource(oebpsDir string, item *ManifestItem, target string, refs []string, moved map[string]string) error {
+	src := filepath.Join(oebpsDir, filepath.FromSlash(item.Href))
+	dst := filepath.Join(oebpsDir, filepath.FromSlash(target))
+	if err := ensureParentDir(dst); err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+		item.Href = target
+		return nil
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	rebase := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		if to, ok := moved[ref]; ok {
+			rebase[ref] = to
+		} else {
+			rebase[ref] = ref
+		}
+	}
+	out, _ := rebaseRefs(data, item.Href, target, item.MediaType == "text/css", rebase)
+	if err := os.WriteFile(dst, out, 0o644); err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		return err
+	}
+	item.Href = target
+	return nil
+}
+
+// sharedHref returns a free path under sharedDir for a file named name,
+// numbering it ("font-2.ttf") when another shared file already has the
+// name, and marks it taken.
+func sharedHref(name string, taken map[string]bool) string {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := sharedDir + "/" + name
+	for n := 2; taken[strings.ToLower(candidate)]; n++ {
+		candidate = sharedDir + "/" + stem + "-" + strconv.Itoa(n) + ext
+	}
+	taken[strings.ToLower(candidate)] = true
+	return candidate
+}
@@ -0,0 +1,185 @@
+package epub
+
+import (
+	"context"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func buildDedupeTestEPUB(t *testing.T, title, cover, ornament string) string {
+	t.Helper()
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      title,
+			identifier: "urn:test:dedupe",
+			items: []ManifestItem{
+				testItem("cover", "Images/cover.png", "cover-image"),
+				testItem("orn", "Images/orn.png"),
+				testItem("css", "Styles/main.css"),
+				testItem("chap", "Text/chapter.xhtml"),
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/Images/cover.png": cover,
+		"OEBPS/Images/orn.png":   ornament,
+		"OEBPS/Styles/main.css":  `hr { background: url("../Images/orn.png") }`,
+		"OEBPS/Text/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:xlink="http://www.w3.org/1999/xlink"><body><img src="../Images/cover.png"/><img src="../Images/orn.png"/>
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 8 2"><image width="8" height="2" xlink:href="../Images/orn.png"/></svg></body></html>`,
+	})
+}
+
+func TestMergeEPUBsDedupeImages(t *testing.T) {
+	cover := solidPNG(t, 4, 6, color.White)
+	ornament := solidPNG(t, 8, 2, color.Black)
+	a := buildDedupeTestEPUB(t, "Vol 1", cover, ornament)
+	b := buildDedupeTestEPUB(t, "Vol 2", cover, ornament)
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, DedupeImages: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if stats.DedupedItems != 1 || stats.DedupedBytes != int64(len(ornament)) {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	ids := map[string]bool{}
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		ids[item.ID] = true
+	}
+	if !ids["v0001_orn"] || ids["v0002_orn"] {
+		t.Fatalf("ornament not collapsed: %v", ids)
+	}
+	if !ids["v0001_cover"] || !ids["v0002_cover"] {
+		t.Fatalf("covers must stay distinct: %v", ids)
+	}
+
+	read := func(rel string) string {
+		data, err := os.ReadFile(filepath.Join(vol.PackageDir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("read %s: %v", rel, err)
+		}
+		return string(data)
+	}
+	if _, err := os.Stat(filepath.Join(vol.PackageDir, "Volumes", "v0002", "Images", "orn.png")); !os.IsNotExist(err) {
+		t.Fatalf("duplicate file should be removed")
+	}
+	chap := read("Volumes/v0002/Text/chapter.xhtml")
+	if !strings.Contains(chap, `src="../../v0001/Images/orn.png"`) || !strings.Contains(chap, `src="../Images/cover.png"`) ||
+		!strings.Contains(chap, `xlink:href="../../v0001/Images/orn.png"`) {
+		t.Fatalf("chapter refs not rewritten: %s", chap)
+	}
+	if css := read("Volumes/v0002/Styles/main.css"); !strings.Contains(css, `url("../../v0001/Images/orn.png")`) {
+		t.Fatalf("css refs not rewritten: %s", css)
+	}
+	if chap := read("Volumes/v0001/Text/chapter.xhtml"); !strings.Contains(chap, `src="../Images/orn.png"`) {
+		t.Fatalf("first volume should be untouched: %s", chap)
+	}
+}
+
+func buildSharedTestEPUB(t *testing.T, title, cover, font string) string {
+	t.Helper()
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      title,
+			identifier: "urn:test:shared",
+			items: []ManifestItem{
+				testItem("cover", "Images/cover.png", "cover-image"),
+				testItem("orn", "Images/orn.png"),
+				testItem("font", "Fonts/serif.ttf"),
+				testItem("css", "Styles/main.css"),
+				testItem("chap", "Text/chapter.xhtml"),
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/Images/cover.png": cover,
+		"OEBPS/Images/orn.png":   "ornament",
+		"OEBPS/Fonts/serif.ttf":  font,
+		"OEBPS/Styles/main.css":  `@font-face { src: url(../Fonts/serif.ttf) } hr { background: url("../Images/orn.png") }`,
+		"OEBPS/Text/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><head><link rel="stylesheet" href="../Styles/main.css"/></head>` +
+			`<body><img src="../Images/cover.png"/><p>` + title + `</p></body></html>`,
+	})
+}
+
+func TestMergeEPUBsDedupeResources(t *testing.T) {
+	a := buildSharedTestEPUB(t, "Vol 1", "cover1", "font-one")
+	b := buildSharedTestEPUB(t, "Vol 2", "cover2", "font-one")
+	c := buildSharedTestEPUB(t, "Vol 3", "cover3", "font-two")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b, c}, MergeOptions{OutPath: out, DedupeResources: true, Verify: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	// Two ornaments, one font and one stylesheet.
+	if stats.DedupedItems != 4 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	for _, check := range stats.Verification {
+		if !check.OK() {
+			t.Fatalf("verify %s: %q", check.Prefix, check.Problems)
+		}
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	hrefs := map[string]string{}
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		hrefs[item.ID] = item.Href
+	}
+	want := map[string]string{
+		"v0001_orn":   "Shared/orn.png",
+		"v0001_font":  "Shared/serif.ttf",
+		"v0001_css":   "Shared/main.css",
+		"v0002_orn":   "",
+		"v0002_font":  "",
+		"v0002_css":   "",
+		"v0003_orn":   "",
+		"v0003_font":  "Volumes/v0003/Fonts/serif.ttf",
+		"v0003_css":   "Volumes/v0003/Styles/main.css",
+		"v0002_cover": "Volumes/v0002/Images/cover.png",
+	}
+	for id, href := range want {
+		if hrefs[id] != href {
+			t.Errorf("%s href = %q, want %q", id, hrefs[id], href)
+		}
+	}
+
+	read := func(rel string) string {
+		data, err := os.ReadFile(filepath.Join(vol.PackageDir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatalf("read %s: %v", rel, err)
+		}
+		return string(data)
+	}
+	if css := read("Shared/main.css"); !strings.Contains(css, "url(serif.ttf)") || !strings.Contains(css, `url("orn.png")`) {
+		t.Fatalf("shared css = %s", css)
+	}
+	if css := read("Volumes/v0003/Styles/main.css"); !strings.Contains(css, "url(../Fonts/serif.ttf)") || !strings.Contains(css, `url("../../../Shared/orn.png")`) {
+		t.Fatalf("volume 3 css = %s", css)
+	}
+	if chap := read("Volumes/v0002/Text/chapter.xhtml"); !strings.Contains(chap, `href="../../../Shared/main.css"`) || !strings.Contains(chap, `src="../Images/cover.png"`) {
+		t.Fatalf("volume 2 chapter = %s", chap)
+	}
+	if chap := read("Volumes/v0003/Text/chapter.xhtml"); !strings.Contains(chap, `href="../Styles/main.css"`) {
+		t.Fatalf("volume 3 chapter = %s", chap)
+	}
+	for _, gone := range []string{"Volumes/v0001/Styles/main.css", "Volumes/v0002/Fonts/serif.ttf"} {
+		if _, err := os.Stat(filepath.Join(vol.PackageDir, filepath.FromSlash(gone))); !os.IsNotExist(err) {
+			t.Errorf("%s should be gone: %v", gone, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package epub
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// dividerName is the file MergeOptions.VolumeDividers writes into each
+// volume's folder, numbered if the volume already has one by that name.
+const dividerName = "_divider"
+
+// addVolumeDivider writes a title page for vol into its folder volDir (a
+// path under oebpsDir) and returns its manifest item, with an id in the
+// volume's vNNNN_ namespace that ids doesn't hold yet.
+func addVolumeDivider(vol *Volume, oebpsDir, volDir string, ids map[string]string) (ManifestItem, error) {
+	href := path.Join(volDir, dividerName+".xhtml")
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(oebpsDir, filepath.FromSlash(href))); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return ManifestItem{}, err
+		}
+		href = path.Join(volDir, fmt.Sprintf("%s-%d.xhtml", dividerName, n))
+	}
+	id := fmt.Sprintf("v%04d_divider", vol.Index+1)
+	for n := 2; ids[id] != ""; n++ {
+		id = fmt.Sprintf("v%04d_divider-%d", vol.Index+1, n)
+	}
+
+	lang := ""
+	if len(vol.PackageDoc.Metadata.Languages) > 0 {
+		lang = strings.TrimSpace(vol.PackageDoc.Metadata.Languages[0].Value)
+	}
+	if err := os.WriteFile(filepath.Join(oebpsDir, filepath.FromSlash(href)), renderDivider(vol.DisplayName, lang), 0o644); err != nil {
+		return ManifestItem{}, err
+	}
+	return ManifestItem{ID: id, Href: href, MediaType: "application/xhtml+xml"}, nil
+}
+
+// renderDivider builds a page with title as its heading, styled inline so
+// it doesn't depend on any volume's stylesheets.
+func renderDivider(title, lang string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	buf.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"`)
+	if lang != "" {
+		l := html.EscapeString(lang)
+		buf.WriteString(` lang="` + l + `" xml:lang="` + l + `"`)
+	}
+	buf.WriteString(">\n")
+	buf.WriteString("<head><title>" + html.EscapeString(title) + "</title>\n<style>\n")
+	buf.WriteString("body { margin: 0; text-align: center; }\n")
+	buf.WriteString("h1 { margin: 35% 1em 0; font-size: 1.8em; font-weight: normal; }\n")
+	buf.WriteString("</style></head>\n<body>\n")
+	buf.WriteString(`<section epub:type="titlepage">` + "\n")
+	buf.WriteString("<h1>" + html.EscapeString(title) + "</h1>\n")
+	buf.WriteString("</section>\n</body>\n</html>\n")
+	return buf.Bytes()
+}
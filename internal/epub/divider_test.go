@@ -0,0 +1,58 @@
+package epub
+
+import (
+	"context"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeEPUBsVolumeDividers(t *testing.T) {
+	a := buildCoverTestEPUB(t, "Vol 1", color.White)
+	b := buildCoverTestEPUB(t, "Vol &lt;2&gt;", color.Black)
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, VolumeDividers: true}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	var spine []string
+	for _, ref := range vol.PackageDoc.Spine.Itemrefs {
+		spine = append(spine, ref.IDRef)
+	}
+	if got := strings.Join(spine, ","); got != "v0001_divider,v0001_chap,v0002_divider,v0002_chap" {
+		t.Fatalf("spine = %s", got)
+	}
+	hrefs := map[string]string{}
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		hrefs[item.ID] = item.Href
+	}
+	if hrefs["v0002_divider"] != "Volumes/v0002/_divider.xhtml" {
+		t.Fatalf("divider href = %q", hrefs["v0002_divider"])
+	}
+	if len(vol.NavItems) != 2 || vol.NavItems[1].Href != "Volumes/v0002/_divider.xhtml" || vol.NavItems[1].Children[0].Href != "Volumes/v0002/chapter.xhtml" {
+		t.Fatalf("nav = %+v", vol.NavItems)
+	}
+
+	data, err := os.ReadFile(filepath.Join(vol.PackageDir, "Volumes", "v0002", "_divider.xhtml"))
+	if err != nil {
+		t.Fatalf("read divider: %v", err)
+	}
+	page := string(data)
+	for _, want := range []string{`<h1>Vol &lt;2&gt;</h1>`, `xml:lang="en"`, "<style>"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("divider missing %s:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "<link") {
+		t.Errorf("divider links a stylesheet:\n%s", page)
+	}
+}
@@ -1,6 +1,7 @@
 package epub
 
 import (
+	"compress/flate"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -97,11 +98,28 @@ func EditEPUB(ctx context.Context, input string, opts EditOptions) error {
 		return err
 	}
 
-	outPath := opts.OutPath
+	return replaceArchive(vol.RootDir, input, opts.OutPath)
+}
+
+// replaceArchive zips the extracted tree at rootDir to outPath (input when
+// empty) through a temporary file in the same directory, so a failed write
+// never leaves a truncated book behind.
+func replaceArchive(rootDir, input, outPath string) error {
+	return replaceArchiveWith(rootDir, input, outPath, zipOptions{level: flate.DefaultCompression})
+}
+
+// replaceArchiveWith is replaceArchive with the archive written as zo says.
+func replaceArchiveWith(rootDir, input, outPath string, zo zipOptions) error {
 	if outPath == "" {
 		outPath = input
 	}
+	return writeArchive(rootDir, outPath, zo)
+}
 
+// writeArchive zips rootDir as zo says into a temporary file next to
+// outPath, then renames it over outPath, so a failed write leaves no
+// partial archive behind.
+func writeArchive(rootDir, outPath string, zo zipOptions) error {
 	tmpFile, err := os.CreateTemp(filepath.Dir(outPath), "novfmt-edit-*.epub")
 	if err != nil {
 		return err
@@ -114,14 +132,13 @@ func EditEPUB(ctx context.Context, input string, opts EditOptions) error {
 		}
 	}()
 
-	if err := writeZip(vol.RootDir, tmpPath); err != nil {
+	if _, err := writeZipWith(rootDir, tmpPath, zo); err != nil {
 		return err
 	}
 	if err := os.Rename(tmpPath, outPath); err != nil {
 		return err
 	}
 	tmpPath = ""
-
 	return nil
 }
 
@@ -197,7 +214,7 @@ func applyMetadataPatch(meta *Metadata, patch MetadataPatch) bool {
 		changed = true
 	}
 	if patch.Language != nil {
-		meta.Languages = []DCMeta{{Value: *patch.Language}}
+		meta.Languages = []DCMeta{{Value: canonicalLanguage(*patch.Language)}}
 		changed = true
 	}
 	if patch.Identifier != nil {
@@ -102,67 +102,135 @@ func TestEditEPUBReplaceNav(t *testing.T) {
 
 func buildTestEPUB(t *testing.T, title, lang string) string {
 	t.Helper()
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      title,
+			lang:       lang,
+			identifier: "urn:test:old",
+			metadata: []string{
+				`<dc:description>orig</dc:description>`,
+				`<meta property="dcterms:modified">2020-01-01T00:00:00Z</meta>`,
+			},
+			items: []ManifestItem{testItem("nav", "nav.xhtml", "nav"), testItem("chap", "chapter.xhtml")},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/nav.xhtml":     `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc" id="toc"><ol><li><a href="chapter.xhtml">Chapter</a></li></ol></nav></body></html>`,
+		"OEBPS/chapter.xhtml": "<html><body><p>Chapter 1</p></body></html>",
+	})
+}
 
-	root := t.TempDir()
-	if err := os.WriteFile(filepath.Join(root, "mimetype"), []byte("application/epub+zip"), 0o644); err != nil {
-		t.Fatalf("write mimetype: %v", err)
-	}
+// buildTestEPUBFiles zips files (paths relative to the archive root) into an
+// EPUB. A mimetype and a container.xml pointing at OEBPS/content.opf are added
+// unless files provides them.
+func buildTestEPUBFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
 
-	metaDir := filepath.Join(root, "META-INF")
-	if err := os.MkdirAll(metaDir, 0o755); err != nil {
-		t.Fatalf("mkdir meta: %v", err)
+	root := t.TempDir()
+	if _, ok := files["mimetype"]; !ok {
+		files["mimetype"] = "application/epub+zip"
 	}
-	container := `<?xml version="1.0" encoding="UTF-8"?>
+	if _, ok := files["META-INF/container.xml"]; !ok {
+		files["META-INF/container.xml"] = `<?xml version="1.0" encoding="UTF-8"?>
 <container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
   <rootfiles>
     <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
   </rootfiles>
 </container>
 `
-	if err := os.WriteFile(filepath.Join(metaDir, "container.xml"), []byte(container), 0o644); err != nil {
-		t.Fatalf("write container: %v", err)
 	}
-
-	oebps := filepath.Join(root, "OEBPS")
-	if err := os.MkdirAll(oebps, 0o755); err != nil {
-		t.Fatalf("mkdir oebps: %v", err)
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir %s: %v", name, err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
 	}
 
-	nav := `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc" id="toc"><ol><li><a href="chapter.xhtml">Chapter</a></li></ol></nav></body></html>`
-	if err := os.WriteFile(filepath.Join(oebps, "nav.xhtml"), []byte(nav), 0o644); err != nil {
-		t.Fatalf("write nav: %v", err)
+	outFile := filepath.Join(t.TempDir(), "test.epub")
+	if _, err := writeZip(root, outFile); err != nil {
+		t.Fatalf("write zip: %v", err)
 	}
+	return outFile
+}
 
-	content := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="BookId" version="3.0">
-  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
-    <dc:title>%s</dc:title>
-    <dc:language>%s</dc:language>
-    <dc:identifier id="BookId">urn:test:old</dc:identifier>
-    <dc:description>orig</dc:description>
-    <meta property="dcterms:modified">2020-01-01T00:00:00Z</meta>
-  </metadata>
-  <manifest>
-    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
-    <item id="chap" href="chapter.xhtml" media-type="application/xhtml+xml"/>
-  </manifest>
-  <spine>
-    <itemref idref="chap"/>
-  </spine>
-</package>
-`, title, lang)
+// testPackage describes the package document testOPF renders for a test
+// book. Metadata elements beyond the title, language and identifier go in
+// metadata as raw XML; spine lists idrefs.
+type testPackage struct {
+	version    string // "3.0" when empty
+	title      string
+	lang       string // no dc:language when empty
+	identifier string // "urn:test:book" when empty
+	metadata   []string
+	items      []ManifestItem
+	spine      []string
+	spineToc   string
+	// pageProgression is the spine's page-progression-direction.
+	pageProgression string
+}
 
-	if err := os.WriteFile(filepath.Join(oebps, "content.opf"), []byte(content), 0o644); err != nil {
-		t.Fatalf("write opf: %v", err)
+// testOPF renders pkg as a content.opf whose unique identifier is BookId.
+// Values are written as given, so they must already be escaped. An item
+// with no media type gets no media-type attribute.
+func testOPF(pkg testPackage) string {
+	version := pkg.version
+	if version == "" {
+		version = "3.0"
+	}
+	identifier := pkg.identifier
+	if identifier == "" {
+		identifier = "urn:test:book"
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&b, `<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="BookId" version="%s">`+"\n", version)
+	b.WriteString(`  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">` + "\n")
+	fmt.Fprintf(&b, "    <dc:title>%s</dc:title>\n", pkg.title)
+	if pkg.lang != "" {
+		fmt.Fprintf(&b, "    <dc:language>%s</dc:language>\n", pkg.lang)
+	}
+	fmt.Fprintf(&b, "    <dc:identifier id=\"BookId\">%s</dc:identifier>\n", identifier)
+	for _, m := range pkg.metadata {
+		b.WriteString("    " + m + "\n")
+	}
+	b.WriteString("  </metadata>\n  <manifest>\n")
+	for _, item := range pkg.items {
+		fmt.Fprintf(&b, `    <item id="%s" href="%s"`, item.ID, item.Href)
+		if item.MediaType != "" {
+			fmt.Fprintf(&b, ` media-type="%s"`, item.MediaType)
+		}
+		for _, attr := range []struct{ name, value string }{
+			{"properties", item.Properties},
+			{"fallback", item.Fallback},
+			{"media-overlay", item.MediaOverlay},
+		} {
+			if attr.value != "" {
+				fmt.Fprintf(&b, ` %s="%s"`, attr.name, attr.value)
+			}
+		}
+		b.WriteString("/>\n")
 	}
-
-	if err := os.WriteFile(filepath.Join(oebps, "chapter.xhtml"), []byte("<html><body><p>Chapter 1</p></body></html>"), 0o644); err != nil {
-		t.Fatalf("write chapter: %v", err)
+	b.WriteString("  </manifest>\n")
+	b.WriteString("  <spine")
+	if pkg.spineToc != "" {
+		fmt.Fprintf(&b, ` toc="%s"`, pkg.spineToc)
 	}
-
-	outFile := filepath.Join(t.TempDir(), "test.epub")
-	if err := writeZip(root, outFile); err != nil {
-		t.Fatalf("write zip: %v", err)
+	if pkg.pageProgression != "" {
+		fmt.Fprintf(&b, ` page-progression-direction="%s"`, pkg.pageProgression)
 	}
-	return outFile
+	b.WriteString(">\n")
+	for _, idref := range pkg.spine {
+		fmt.Fprintf(&b, "    <itemref idref=\"%s\"/>\n", idref)
+	}
+	b.WriteString("  </spine>\n</package>\n")
+	return b.String()
+}
+
+// testItem is a manifest item with the media type inferMediaType gives its
+// href and the given properties.
+func testItem(id, href string, properties ...string) ManifestItem {
+	return ManifestItem{ID: id, Href: href, MediaType: inferMediaType(href, nil), Properties: strings.Join(properties, " ")}
 }
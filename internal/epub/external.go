@@ -0,0 +1,198 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExternalLink is a reference from a content document to something outside
+// the book. Document is the document's manifest href.
+type ExternalLink struct {
+	Document string `json:"document"`
+	URL      string `json:"url"`
+}
+
+type StripExternalLinksOptions struct {
+	OutPath string
+	DryRun  bool
+}
+
+// isExternalRef reports whether ref points off the book: a web or mail link.
+func isExternalRef(ref string) bool {
+	scheme, _, ok := strings.Cut(strings.TrimSpace(ref), ":")
+	if !ok || !isAbsoluteURL(ref) {
+		return false
+	}
+	switch strings.ToLower(scheme) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// externalRefs returns the external targets of a document's href, src,
+// poster and data attributes, in document order.
+func externalRefs(data []byte) []string {
+	var out []string
+	for _, sub := range attrRefPattern.FindAllSubmatch(data, -1) {
+		ref := strings.TrimSpace(html.UnescapeString(strings.Trim(string(sub[2]), `"'`)))
+		if isExternalRef(ref) {
+			out = append(out, ref)
+		}
+	}
+	return out
+}
+
+// ListExternalLinks reports every external reference in the XHTML
+// documents of an EPUB, in manifest order. Read-only.
+func ListExternalLinks(ctx context.Context, input string) ([]ExternalLink, error) {
+	if input == "" {
+		return nil, fmt.Errorf("input EPUB path is required")
+	}
+	book, err := OpenBook(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	var out []ExternalLink
+	for _, item := range book.Package.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := book.ReadFile(item.Href)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range externalRefs(data) {
+			out = append(out, ExternalLink{Document: item.Href, URL: ref})
+		}
+	}
+	return out, nil
+}
+
+// StripExternalLinks unwraps every <a> whose href is external, keeping the
+// link text, and writes the result to opts.OutPath (the input when empty)
+// unless nothing changed or opts.DryRun is set. It returns the links it
+// removed. External images and media are reported by ListExternalLinks but
+// left in place.
+func StripExternalLinks(ctx context.Context, input string, opts StripExternalLinksOptions) ([]ExternalLink, error) {
+	if input == "" {
+		return nil, fmt.Errorf("input EPUB path is required")
+	}
+	vol, err := loadVolume(ctx, 0, input)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	var removed []ExternalLink
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p := filepath.Join(vol.PackageDir, filepath.FromSlash(item.Href))
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		out, urls, err := unwrapExternalAnchors(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", item.Href, err)
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		for _, u := range urls {
+			removed = append(removed, ExternalLink{Document: item.Href, URL: u})
+		}
+		if !opts.DryRun {
+			if err := os.WriteFile(p, out, 0o644); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(removed) == 0 || opts.DryRun {
+		return removed, nil
+	}
+	if err := replaceArchive(vol.RootDir, input, opts.OutPath); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// unwrapExternalAnchors cuts the start and end tags of each external <a>
+// out of an XHTML document, leaving everything else byte for byte. It
+// returns the new document and the removed links' URLs.
+func unwrapExternalAnchors(data []byte) ([]byte, []string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	type span struct{ start, end int64 }
+	var (
+		cuts  []span
+		urls  []string
+		stack []bool
+	)
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if !strings.EqualFold(t.Name.Local, "a") {
+				continue
+			}
+			external := false
+			for _, a := range t.Attr {
+				if a.Name.Local == "href" && isExternalRef(a.Value) {
+					external = true
+					urls = append(urls, strings.TrimSpace(a.Value))
+				}
+			}
+			if external {
+				cuts = append(cuts, span{start, dec.InputOffset()})
+			}
+			stack = append(stack, external)
+		case xml.EndElement:
+			if !strings.EqualFold(t.Name.Local, "a") || len(stack) == 0 {
+				continue
+			}
+			external := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			// A self-closing <a/> has no end tag of its own to cut.
+			if end := dec.InputOffset(); external && end > start {
+				cuts = append(cuts, span{start, end})
+			}
+		}
+	}
+	if len(cuts) == 0 {
+		return data, nil, nil
+	}
+	var out bytes.Buffer
+	last := int64(0)
+	for _, c := range cuts {
+		out.Write(data[last:c.start])
+		last = c.end
+	}
+	out.Write(data[last:])
+	return out.Bytes(), urls, nil
+}
@@ -0,0 +1,85 @@
+package epub
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const externalChapter = `<html xmlns="http://www.w3.org/1999/xhtml"><body>
+<p>Visit <a class="web" href="https://example.com/bonus?a=1&amp;b=2">our <em>site</em></a> or
+<a href="MAILTO:author@example.com">write</a>.</p>
+<p><a href="notes.xhtml#n1">Note</a> <a href="#top">top</a> <a href="http://example.com/x"/></p>
+<img src="http://example.com/banner.png" alt=""/>
+</body></html>`
+
+func TestExternalRefs(t *testing.T) {
+	got := externalRefs([]byte(externalChapter))
+	want := []string{"https://example.com/bonus?a=1&b=2", "MAILTO:author@example.com", "http://example.com/x", "http://example.com/banner.png"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("refs = %q want %q", got, want)
+	}
+}
+
+func TestUnwrapExternalAnchors(t *testing.T) {
+	out, urls, err := unwrapExternalAnchors([]byte(externalChapter))
+	if err != nil {
+		t.Fatalf("unwrapExternalAnchors: %v", err)
+	}
+	if len(urls) != 3 {
+		t.Fatalf("urls = %q", urls)
+	}
+	doc := string(out)
+	for _, want := range []string{
+		`<p>Visit our <em>site</em> or` + "\n" + `write.</p>`,
+		`<p><a href="notes.xhtml#n1">Note</a> <a href="#top">top</a> </p>`,
+		`<img src="http://example.com/banner.png" alt=""/>`,
+	} {
+		if !strings.Contains(doc, want) {
+			t.Fatalf("missing %q in:\n%s", want, doc)
+		}
+	}
+}
+
+func TestStripExternalLinks(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Links",
+			identifier: "urn:test:links",
+			items: []ManifestItem{
+				testItem("chap", "Text/chapter.xhtml"),
+				testItem("css", "style.css"),
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/Text/chapter.xhtml": externalChapter,
+		"OEBPS/style.css":          `body { background: url(http://example.com/bg.png) }`,
+	})
+
+	links, err := ListExternalLinks(context.Background(), input)
+	if err != nil {
+		t.Fatalf("ListExternalLinks: %v", err)
+	}
+	if len(links) != 4 || links[0].Document != "Text/chapter.xhtml" {
+		t.Fatalf("links = %+v", links)
+	}
+
+	removed, err := StripExternalLinks(context.Background(), input, StripExternalLinksOptions{DryRun: true})
+	if err != nil || len(removed) != 3 {
+		t.Fatalf("dry run = %+v, %v", removed, err)
+	}
+	if links, _ := ListExternalLinks(context.Background(), input); len(links) != 4 {
+		t.Fatalf("dry run changed the book: %+v", links)
+	}
+
+	if _, err := StripExternalLinks(context.Background(), input, StripExternalLinksOptions{}); err != nil {
+		t.Fatalf("StripExternalLinks: %v", err)
+	}
+	links, err = ListExternalLinks(context.Background(), input)
+	if err != nil {
+		t.Fatalf("ListExternalLinks after strip: %v", err)
+	}
+	if len(links) != 1 || links[0].URL != "http://example.com/banner.png" {
+		t.Fatalf("links after strip = %+v", links)
+	}
+}
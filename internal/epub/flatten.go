@@ -0,0 +1,153 @@
+package epub
+
+import (
+	"context"
+	"encoding/base64"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	imgSrcPattern = regexp.MustCompile(`(?i)(<img\b[^>]*?\ssrc\s*=\s*)("[^"]*"|'[^']*')`)
+	srcsetPattern = regexp.MustCompile(`(?i)\ssrcset\s*=\s*("[^"]*"|'[^']*')`)
+)
+
+// flattenResult summarizes a flattenImages pass: the images inlined and the
+// change in bytes (documents grown by their data URIs less the files
+// removed), which is negative when the book got smaller.
+type flattenResult struct {
+	images int
+	bytes  int64
+}
+
+// flattenImages inlines the raster images of at most maxBytes whose every
+// reference is an <img src> in an XHTML document: those attributes become
+// base64 data URIs and the image's file and manifest item are removed.
+// Images referenced any other way (CSS, SVG, srcset, links, fallbacks) and
+// items whose ids are in keep, such as the covers, are left alone.
+func flattenImages(ctx context.Context, oebpsDir string, manifest *Manifest, keep map[string]bool, maxBytes int64) (flattenResult, error) {
+	var res flattenResult
+
+	referenced := make(map[string]bool)
+	for _, item := range manifest.Items {
+		referenced[item.Fallback] = true
+		referenced[item.MediaOverlay] = true
+	}
+	candidates := make(map[string]ManifestItem)
+	for _, item := range manifest.Items {
+		if !isImageItem(item) || item.MediaType == "image/svg+xml" || keep[item.ID] || referenced[item.ID] {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(oebpsDir, filepath.FromSlash(item.Href)))
+		if err != nil || info.Size() > maxBytes {
+			continue
+		}
+		candidates[normalizeEPUBPath(item.Href)] = item
+	}
+	if len(candidates) == 0 {
+		return res, nil
+	}
+
+	// An image can go only if its img src references are all there are.
+	refs := make(map[string]int)
+	imgRefs := make(map[string]int)
+	docs := make(map[string][]byte)
+	for _, item := range manifest.Items {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		var isCSS bool
+		switch item.MediaType {
+		case "application/xhtml+xml", "image/svg+xml":
+		case "text/css":
+			isCSS = true
+		default:
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(oebpsDir, filepath.FromSlash(item.Href)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return res, err
+		}
+		for _, ref := range localRefs(data, item.Href, isCSS) {
+			refs[normalizeEPUBPath(ref)]++
+		}
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		docDir := path.Dir(normalizeEPUBPath(item.Href))
+		for _, sub := range srcsetPattern.FindAllSubmatch(data, -1) {
+			for _, candidate := range strings.Split(html.UnescapeString(strings.Trim(string(sub[1]), `"'`)), ",") {
+				if fields := strings.Fields(candidate); len(fields) > 0 {
+					refs[resolveRef(docDir, fields[0])]++
+				}
+			}
+		}
+		found := false
+		for _, sub := range imgSrcPattern.FindAllSubmatch(data, -1) {
+			target := resolveRef(docDir, html.UnescapeString(strings.Trim(string(sub[2]), `"'`)))
+			if _, ok := candidates[target]; ok {
+				imgRefs[target]++
+				found = true
+			}
+		}
+		if found {
+			docs[item.Href] = data
+		}
+	}
+	for href := range candidates {
+		if imgRefs[href] == 0 || refs[href] != imgRefs[href] {
+			delete(candidates, href)
+		}
+	}
+	if len(candidates) == 0 {
+		return res, nil
+	}
+
+	uris := make(map[string]string, len(candidates))
+	for href, item := range candidates {
+		p := filepath.Join(oebpsDir, filepath.FromSlash(href))
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return res, err
+		}
+		uris[href] = "data:" + strings.ToLower(strings.TrimSpace(item.MediaType)) + ";base64," + base64.StdEncoding.EncodeToString(data)
+		if err := os.Remove(p); err != nil {
+			return res, err
+		}
+		res.images++
+		res.bytes -= int64(len(data))
+	}
+
+	for docHref, data := range docs {
+		docDir := path.Dir(normalizeEPUBPath(docHref))
+		out := imgSrcPattern.ReplaceAllFunc(data, func(m []byte) []byte {
+			sub := imgSrcPattern.FindSubmatch(m)
+			uri, ok := uris[resolveRef(docDir, html.UnescapeString(strings.Trim(string(sub[2]), `"'`)))]
+			if !ok {
+				return m
+			}
+			return append(append([]byte(nil), sub[1]...), `"`+uri+`"`...)
+		})
+		if err := os.WriteFile(filepath.Join(oebpsDir, filepath.FromSlash(docHref)), out, 0o644); err != nil {
+			return res, err
+		}
+		res.bytes += int64(len(out) - len(data))
+	}
+
+	items := manifest.Items[:0]
+	for _, item := range manifest.Items {
+		if _, ok := candidates[normalizeEPUBPath(item.Href)]; ok {
+			continue
+		}
+		items = append(items, item)
+	}
+	manifest.Items = items
+	return res, nil
+}
@@ -0,0 +1,82 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFlattenImages(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Text/c1.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body>` +
+			`<p>One</p><img class="break" src="../Images/orn.png" alt=""/><p>Two</p><img src='../Images/orn.png'/>` +
+			`<img src="../Images/big.png"/><img src="../Images/bg.png"/><img src="../Images/cover.png"/>` +
+			`<img src="../Images/set.png" srcset="../Images/set.png 1x, ../Images/big.png 2x"/></body></html>`,
+		"Text/c2.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>No images</p></body></html>`,
+		"Styles/book.css":  `p { background: url("../Images/bg.png") }`,
+		"Images/orn.png":   "tiny",
+		"Images/big.png":   strings.Repeat("x", 100),
+		"Images/bg.png":    "bg",
+		"Images/cover.png": "cv",
+		"Images/set.png":   "st",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest := Manifest{Items: []ManifestItem{
+		{ID: "c1", Href: "Text/c1.xhtml", MediaType: "application/xhtml+xml"},
+		{ID: "c2", Href: "Text/c2.xhtml", MediaType: "application/xhtml+xml"},
+		{ID: "css", Href: "Styles/book.css", MediaType: "text/css"},
+		{ID: "orn", Href: "Images/orn.png", MediaType: "image/png"},
+		{ID: "big", Href: "Images/big.png", MediaType: "image/png"},
+		{ID: "bg", Href: "Images/bg.png", MediaType: "image/png"},
+		{ID: "cover", Href: "Images/cover.png", MediaType: "image/png"},
+		{ID: "set", Href: "Images/set.png", MediaType: "image/png"},
+	}}
+
+	res, err := flattenImages(context.Background(), dir, &manifest, map[string]bool{"cover": true}, 10)
+	if err != nil {
+		t.Fatalf("flattenImages: %v", err)
+	}
+	if res.images != 1 {
+		t.Fatalf("images = %d, want 1", res.images)
+	}
+
+	var ids []string
+	for _, item := range manifest.Items {
+		ids = append(ids, item.ID)
+	}
+	if strings.Join(ids, ",") != "c1,c2,css,big,bg,cover,set" {
+		t.Fatalf("manifest = %v", ids)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Images", "orn.png")); !os.IsNotExist(err) {
+		t.Fatalf("inlined image file still there: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "Text", "c1.xhtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := string(data)
+	uri := `src="data:image/png;base64,dGlueQ=="`
+	if strings.Count(doc, uri) != 2 || !strings.Contains(doc, `<img class="break" `+uri+` alt=""/>`) {
+		t.Fatalf("c1 = %s", doc)
+	}
+	for _, kept := range []string{"big.png", "bg.png", "cover.png", `src="../Images/set.png"`} {
+		if !strings.Contains(doc, kept) {
+			t.Fatalf("c1 lost its reference to %s: %s", kept, doc)
+		}
+	}
+	if want := int64(len(data) - len(files["Text/c1.xhtml"]) - len("tiny")); res.bytes != want {
+		t.Fatalf("bytes = %d, want %d", res.bytes, want)
+	}
+}
@@ -0,0 +1,274 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	algIDPFObfuscation  = "http://www.idpf.org/2008/embedding"
+	algAdobeObfuscation = "http://ns.adobe.com/pdf/enc#RC"
+)
+
+const (
+	ObfuscationIDPF  = "idpf"
+	ObfuscationAdobe = "adobe"
+)
+
+type FontInfo struct {
+	ID        string
+	Href      string
+	MediaType string
+	Size      int64
+	// Obfuscation is ObfuscationIDPF or ObfuscationAdobe when encryption.xml
+	// lists the font under a font-obfuscation algorithm, empty otherwise.
+	Obfuscation string
+}
+
+// ListFonts reports the font resources in the EPUB's manifest and whether
+// each one is obfuscated.
+func ListFonts(ctx context.Context, input string) ([]FontInfo, error) {
+	if input == "" {
+		return nil, fmt.Errorf("input EPUB path is required")
+	}
+
+	vol, err := loadVolume(ctx, 0, input)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	enc, err := readEncryption(vol.RootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var fonts []FontInfo
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		if !isFontItem(item) {
+			continue
+		}
+		info := FontInfo{
+			ID:        item.ID,
+			Href:      item.Href,
+			MediaType: item.MediaType,
+		}
+		p := filepath.Join(vol.PackageDir, filepath.FromSlash(item.Href))
+		if st, err := os.Stat(p); err == nil {
+			info.Size = st.Size()
+		}
+		info.Obfuscation = obfuscationName(enc[containerPath(vol, item.Href)])
+		fonts = append(fonts, info)
+	}
+	return fonts, nil
+}
+
+var fontMediaTypes = map[string]bool{
+	"application/vnd.ms-opentype": true,
+	"application/font-woff":       true,
+	"application/font-sfnt":       true,
+	"application/x-font-ttf":      true,
+	"application/x-font-otf":      true,
+	"application/x-font-truetype": true,
+	"application/x-font-opentype": true,
+}
+
+func isFontItem(item ManifestItem) bool {
+	mt := strings.ToLower(strings.TrimSpace(item.MediaType))
+	if strings.HasPrefix(mt, "font/") || fontMediaTypes[mt] {
+		return true
+	}
+	switch strings.ToLower(path.Ext(item.Href)) {
+	case ".ttf", ".otf", ".woff", ".woff2":
+		return true
+	}
+	return false
+}
+
+func obfuscationName(algorithm string) string {
+	switch algorithm {
+	case algIDPFObfuscation:
+		return ObfuscationIDPF
+	case algAdobeObfuscation:
+		return ObfuscationAdobe
+	}
+	return ""
+}
+
+// containerPath returns the archive path of a package-relative href, which is
+// how encryption.xml refers to resources.
+func containerPath(vol *Volume, href string) string {
+	p := filepath.Join(vol.PackageDir, filepath.FromSlash(href))
+	rel, err := filepath.Rel(vol.RootDir, p)
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// readEncryption parses META-INF/encryption.xml under root, mapping archive
+// paths to their encryption algorithm. A missing file yields an empty map.
+func readEncryption(root string) (map[string]string, error) {
+	data, err := os.ReadFile(filepath.Join(root, "META-INF", "encryption.xml"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("read encryption.xml: %w", err)
+	}
+	enc, err := parseEncryption(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse encryption.xml: %w", err)
+	}
+	return enc, nil
+}
+
+// parseEncryption walks EncryptedData entries by local name, pairing each
+// EncryptionMethod Algorithm with the CipherReference URIs that follow it.
+func parseEncryption(data []byte) (map[string]string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	out := map[string]string{}
+	var algorithm string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "EncryptedData":
+				algorithm = ""
+			case "EncryptionMethod":
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "Algorithm" {
+						algorithm = strings.TrimSpace(attr.Value)
+					}
+				}
+			case "CipherReference":
+				for _, attr := range t.Attr {
+					if attr.Name.Local != "URI" {
+						continue
+					}
+					uri := strings.TrimSpace(attr.Value)
+					if unescaped, err := url.PathUnescape(uri); err == nil {
+						uri = unescaped
+					}
+					out[path.Clean(strings.TrimPrefix(uri, "/"))] = algorithm
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// packageUniqueID returns the identifier named by the package's
+// unique-identifier attribute, falling back to the first dc:identifier.
+func packageUniqueID(pkg *PackageDocument) string {
+	for _, id := range pkg.Metadata.Identifiers {
+		if pkg.UniqueIdentifier != "" && id.ID == pkg.UniqueIdentifier {
+			return strings.TrimSpace(id.Value)
+		}
+	}
+	return strings.TrimSpace(firstDCValue(pkg.Metadata.Identifiers))
+}
+
+// obfuscationKey derives the XOR key for a font obfuscation algorithm and
+// returns how many leading bytes of the font it covers.
+//
+// IDPF: SHA-1 of the unique identifier with all whitespace removed, applied
+// to the first 1040 bytes. Adobe: the 16 bytes of the identifier's UUID,
+// applied to the first 1024 bytes.
+func obfuscationKey(algorithm, uid string) ([]byte, int, error) {
+	switch algorithm {
+	case algIDPFObfuscation:
+		clean := strings.Map(func(r rune) rune {
+			switch r {
+			case ' ', '\t', '\r', '\n':
+				return -1
+			}
+			return r
+		}, uid)
+		sum := sha1.Sum([]byte(clean))
+		return sum[:], 1040, nil
+	case algAdobeObfuscation:
+		raw := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(uid)), "urn:uuid:")
+		raw = strings.NewReplacer("-", "", ":", "").Replace(raw)
+		key, err := hex.DecodeString(raw)
+		if err != nil || len(key) != 16 {
+			return nil, 0, fmt.Errorf("identifier %q is not a UUID, can't derive Adobe font key", uid)
+		}
+		return key, 1024, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported font obfuscation algorithm %q", algorithm)
+}
+
+// xorFontHeader applies an obfuscation key to the start of data in place.
+// The operation is its own inverse, so it both obfuscates and restores.
+func xorFontHeader(data, key []byte, n int) {
+	if n > len(data) {
+		n = len(data)
+	}
+	for i := 0; i < n; i++ {
+		data[i] ^= key[i%len(key)]
+	}
+}
+
+// deobfuscateVolumeFonts restores every obfuscated font of vol that was copied
+// from baseDir into destDir, so the merged book can carry them without
+// encryption.xml.
+// Any other encrypted resource means DRM, which can't be merged.
+func deobfuscateVolumeFonts(vol *Volume, baseDir, destDir string) error {
+	enc, err := readEncryption(vol.RootDir)
+	if err != nil {
+		return err
+	}
+	if len(enc) == 0 {
+		return nil
+	}
+
+	uid := packageUniqueID(vol.PackageDoc)
+	for uri, algorithm := range enc {
+		if obfuscationName(algorithm) == "" {
+			return fmt.Errorf("%s is encrypted with %s; DRM-protected books can't be merged", uri, algorithm)
+		}
+		src := filepath.Join(vol.RootDir, filepath.FromSlash(uri))
+		rel, err := filepath.Rel(baseDir, src)
+		if err != nil || !isWithinDir(baseDir, src) {
+			continue
+		}
+		target := filepath.Join(destDir, rel)
+		data, err := os.ReadFile(target)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		key, n, err := obfuscationKey(algorithm, uid)
+		if err != nil {
+			return fmt.Errorf("%s: %w", uri, err)
+		}
+		xorFontHeader(data, key, n)
+		if err := os.WriteFile(target, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
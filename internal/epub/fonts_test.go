@@ -0,0 +1,184 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testEncryptionXML = `<?xml version="1.0" encoding="UTF-8"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">
+  <enc:EncryptedData>
+    <enc:EncryptionMethod Algorithm="http://www.idpf.org/2008/embedding"/>
+    <enc:CipherData><enc:CipherReference URI="OEBPS/Fonts/Serif%20Bold.otf"/></enc:CipherData>
+  </enc:EncryptedData>
+  <enc:EncryptedData>
+    <enc:EncryptionMethod Algorithm="http://ns.adobe.com/pdf/enc#RC"/>
+    <enc:CipherData><enc:CipherReference URI="OEBPS/Fonts/sans.ttf"/></enc:CipherData>
+  </enc:EncryptedData>
+</encryption>`
+
+func TestParseEncryption(t *testing.T) {
+	enc, err := parseEncryption([]byte(testEncryptionXML))
+	if err != nil {
+		t.Fatalf("parseEncryption: %v", err)
+	}
+	if enc["OEBPS/Fonts/Serif Bold.otf"] != algIDPFObfuscation {
+		t.Fatalf("idpf entry missing: %v", enc)
+	}
+	if enc["OEBPS/Fonts/sans.ttf"] != algAdobeObfuscation {
+		t.Fatalf("adobe entry missing: %v", enc)
+	}
+}
+
+func TestListFonts(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"META-INF/encryption.xml": testEncryptionXML,
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Fonts",
+			lang:       "en",
+			identifier: "urn:test:fonts",
+			items: []ManifestItem{
+				testItem("chap", "chapter.xhtml"),
+				testItem("serif", "Fonts/Serif Bold.otf"),
+				{ID: "sans", Href: "Fonts/sans.ttf", MediaType: "application/x-font-ttf"},
+				{ID: "mono", Href: "Fonts/mono.woff", MediaType: "application/octet-stream"},
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/chapter.xhtml":        `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Text</p></body></html>`,
+		"OEBPS/Fonts/Serif Bold.otf": "0123456789",
+		"OEBPS/Fonts/sans.ttf":       "01234",
+		"OEBPS/Fonts/mono.woff":      "012",
+	})
+
+	fonts, err := ListFonts(context.Background(), input)
+	if err != nil {
+		t.Fatalf("ListFonts: %v", err)
+	}
+	want := []FontInfo{
+		{ID: "serif", Href: "Fonts/Serif Bold.otf", MediaType: "font/otf", Size: 10, Obfuscation: ObfuscationIDPF},
+		{ID: "sans", Href: "Fonts/sans.ttf", MediaType: "application/x-font-ttf", Size: 5, Obfuscation: ObfuscationAdobe},
+		{ID: "mono", Href: "Fonts/mono.woff", MediaType: "application/octet-stream", Size: 3},
+	}
+	if len(fonts) != len(want) {
+		t.Fatalf("got %d fonts want %d: %+v", len(fonts), len(want), fonts)
+	}
+	for i := range want {
+		if fonts[i] != want[i] {
+			t.Fatalf("font[%d] = %+v want %+v", i, fonts[i], want[i])
+		}
+	}
+}
+
+func TestObfuscationKeyAdobe(t *testing.T) {
+	key, n, err := obfuscationKey(algAdobeObfuscation, "urn:uuid:0123abcd-4567-89ef-0123-456789abcdef")
+	if err != nil {
+		t.Fatalf("obfuscationKey: %v", err)
+	}
+	if n != 1024 || len(key) != 16 || key[0] != 0x01 || key[15] != 0xef {
+		t.Fatalf("key = %x n = %d", key, n)
+	}
+	if _, _, err := obfuscationKey(algAdobeObfuscation, "urn:isbn:9780000000000"); err == nil {
+		t.Fatalf("expected error for non-UUID identifier")
+	}
+}
+
+func TestMergeEPUBsDeobfuscatesFonts(t *testing.T) {
+	const uid = "urn:uuid:0123abcd-4567-89ef-0123-456789abcdef"
+	plain := bytes.Repeat([]byte("OTTO font data "), 100)
+
+	obfuscate := func(algorithm, id string) string {
+		key, n, err := obfuscationKey(algorithm, id)
+		if err != nil {
+			t.Fatalf("obfuscationKey: %v", err)
+		}
+		data := append([]byte(nil), plain...)
+		xorFontHeader(data, key, n)
+		return string(data)
+	}
+
+	build := func(title string) string {
+		return buildTestEPUBFiles(t, map[string]string{
+			"META-INF/encryption.xml": testEncryptionXML,
+			"OEBPS/content.opf": testOPF(testPackage{
+				title: title,
+				lang:  "en",
+				// Whitespace around the identifier must not change the key.
+				identifier: "\n      " + uid + "\n    ",
+				items: []ManifestItem{
+					testItem("chap", "chapter.xhtml"),
+					testItem("serif", "Fonts/Serif Bold.otf"),
+					testItem("sans", "Fonts/sans.ttf"),
+				},
+				spine: []string{"chap"},
+			}),
+			"OEBPS/chapter.xhtml":        `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Text</p></body></html>`,
+			"OEBPS/Fonts/Serif Bold.otf": obfuscate(algIDPFObfuscation, uid),
+			"OEBPS/Fonts/sans.ttf":       obfuscate(algAdobeObfuscation, uid),
+		})
+	}
+
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{build("Vol 1"), build("Vol 2")}, MergeOptions{OutPath: out}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	if _, err := os.Stat(filepath.Join(vol.RootDir, "META-INF", "encryption.xml")); !os.IsNotExist(err) {
+		t.Fatalf("merged book should not carry encryption.xml (stat err %v)", err)
+	}
+	fonts := 0
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		if !isFontItem(item) {
+			continue
+		}
+		fonts++
+		data, err := os.ReadFile(filepath.Join(vol.PackageDir, filepath.FromSlash(item.Href)))
+		if err != nil {
+			t.Fatalf("read %s: %v", item.Href, err)
+		}
+		if !bytes.Equal(data, plain) {
+			t.Fatalf("%s was not de-obfuscated", item.Href)
+		}
+	}
+	if fonts != 4 {
+		t.Fatalf("got %d fonts want 4", fonts)
+	}
+}
+
+func TestMergeEPUBsRejectsDRM(t *testing.T) {
+	build := func(title string) string {
+		return buildTestEPUBFiles(t, map[string]string{
+			"META-INF/encryption.xml": `<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">
+  <enc:EncryptedData>
+    <enc:EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes128-cbc"/>
+    <enc:CipherData><enc:CipherReference URI="OEBPS/chapter.xhtml"/></enc:CipherData>
+  </enc:EncryptedData>
+</encryption>`,
+			"OEBPS/content.opf": testOPF(testPackage{
+				title:      title,
+				identifier: "urn:test:drm",
+				items: []ManifestItem{
+					testItem("chap", "chapter.xhtml"),
+				},
+				spine: []string{"chap"},
+			}),
+			"OEBPS/chapter.xhtml": "encrypted",
+		})
+	}
+
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	_, err := MergeEPUBs(context.Background(), []string{build("Vol 1"), build("Vol 2")}, MergeOptions{OutPath: out})
+	if err == nil || !strings.Contains(err.Error(), "DRM") {
+		t.Fatalf("expected DRM error, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// grepContext is how many characters of text GrepBook keeps on each side of
+// a match in its snippet.
+const grepContext = 40
+
+// GrepMatch is one match of a text search. Href is the spine document's
+// manifest href.
+type GrepMatch struct {
+	Book    string `json:"book"`
+	Href    string `json:"href"`
+	Snippet string `json:"snippet"`
+}
+
+// textBlocks are the elements whose boundaries break text into separate
+// paragraphs for searching; everything else is inline.
+var textBlocks = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"body": true, "br": true, "caption": true, "dd": true, "div": true,
+	"dt": true, "figcaption": true, "figure": true, "footer": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "nav": true, "p": true,
+	"pre": true, "section": true, "td": true, "th": true, "tr": true,
+}
+
+// documentParagraphs returns the text of an XHTML document's body split at
+// block elements, with inline markup dropped (so "a<i>b</i>" reads "ab") and
+// whitespace collapsed. Scripts, styles and ruby annotations are left out.
+func documentParagraphs(data []byte) ([]string, error) {
+	var (
+		out []string
+		b   strings.Builder
+	)
+	flush := func() {
+		if text := normalizeSpace(b.String()); text != "" {
+			out = append(out, text)
+		}
+		b.Reset()
+	}
+	err := walkDocumentText(data, func(text []byte) { b.Write(text) }, flush)
+	if err != nil {
+		return nil, err
+	}
+	flush()
+	return out, nil
+}
+
+// walkDocumentText calls text with each run of character data in an XHTML
+// document's body and block at the start and end of every element in
+// textBlocks. The head, scripts, styles and ruby annotations are skipped.
+func walkDocumentText(data []byte, text func([]byte), block func()) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	skip := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			switch name {
+			case "head", "script", "style", "rt", "rp":
+				skip++
+			}
+			if textBlocks[name] {
+				block()
+			}
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			switch name {
+			case "head", "script", "style", "rt", "rp":
+				if skip > 0 {
+					skip--
+				}
+			}
+			if textBlocks[name] {
+				block()
+			}
+		case xml.CharData:
+			if skip == 0 {
+				text(t)
+			}
+		}
+	}
+}
+
+// matchSnippet cuts the text around text[start:end] down to grepContext
+// characters on each side, marking the cuts with an ellipsis.
+func matchSnippet(text string, start, end int) string {
+	before := []rune(text[:start])
+	after := []rune(text[end:])
+	prefix, suffix := "", ""
+	if len(before) > grepContext {
+		before = before[len(before)-grepContext:]
+		prefix = "…"
+	}
+	if len(after) > grepContext {
+		after = after[:grepContext]
+		suffix = "…"
+	}
+	return prefix + string(before) + text[start:end] + string(after) + suffix
+}
+
+// GrepBook searches the text of an EPUB's XHTML spine documents, in reading
+// order, and returns every match of re with a snippet of the paragraph it
+// is in. Matches don't span paragraphs. Read-only.
+func GrepBook(ctx context.Context, input string, re *regexp.Regexp) ([]GrepMatch, error) {
+	if input == "" {
+		return nil, fmt.Errorf("input EPUB path is required")
+	}
+
+	book, err := OpenBook(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	items := make(map[string]ManifestItem)
+	for _, item := range book.Package.Manifest.Items {
+		items[item.ID] = item
+	}
+
+	var out []GrepMatch
+	for _, ref := range book.Package.Spine.Itemrefs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		item, ok := items[ref.IDRef]
+		if !ok || item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		data, err := book.ReadFile(item.Href)
+		if err != nil {
+			return nil, err
+		}
+		paragraphs, err := documentParagraphs(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", item.Href, err)
+		}
+		for _, text := range paragraphs {
+			for _, m := range re.FindAllStringIndex(text, -1) {
+				if m[0] == m[1] {
+					continue
+				}
+				out = append(out, GrepMatch{Book: input, Href: item.Href, Snippet: matchSnippet(text, m[0], m[1])})
+			}
+		}
+	}
+	return out, nil
+}
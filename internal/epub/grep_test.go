@@ -0,0 +1,83 @@
+package epub
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDocumentParagraphs(t *testing.T) {
+	doc := `<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Skip</title><style>p{}</style></head><body>
+<h1>Chapter  One</h1>
+<p>The <i>dragon</i>'s
+   hoard.<br/>Next line</p>
+<p><ruby>漢<rt>かん</rt></ruby>字</p>
+</body></html>`
+	got, err := documentParagraphs([]byte(doc))
+	if err != nil {
+		t.Fatalf("documentParagraphs: %v", err)
+	}
+	want := "Chapter One|The dragon's hoard.|Next line|漢字"
+	if strings.Join(got, "|") != want {
+		t.Fatalf("got %q want %q", strings.Join(got, "|"), want)
+	}
+}
+
+func TestMatchSnippet(t *testing.T) {
+	text := strings.Repeat("a", 50) + "MATCH" + strings.Repeat("é", 50)
+	got := matchSnippet(text, 50, 55)
+	want := "…" + strings.Repeat("a", grepContext) + "MATCH" + strings.Repeat("é", grepContext) + "…"
+	if got != want {
+		t.Fatalf("got %q", got)
+	}
+	if got := matchSnippet("short MATCH here", 6, 11); got != "short MATCH here" {
+		t.Fatalf("short = %q", got)
+	}
+}
+
+func TestGrepBook(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Grep",
+			identifier: "urn:test:grep",
+			items: []ManifestItem{
+				testItem("c1", "Text/c1.xhtml"),
+				testItem("c2", "Text/c2.xhtml"),
+				testItem("notes", "Text/notes.xhtml"),
+			},
+			spine: []string{"c2", "c1"},
+		}),
+		"OEBPS/Text/c1.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>The Dragon slept.</p><p>No match.</p></body></html>`,
+		"OEBPS/Text/c2.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>A <b>dragon</b> and another dragon.</p></body></html>`,
+		"OEBPS/Text/notes.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>dragon outside the spine</p></body></html>`,
+	})
+
+	matches, err := GrepBook(context.Background(), input, regexp.MustCompile(`(?i)dragon`))
+	if err != nil {
+		t.Fatalf("GrepBook: %v", err)
+	}
+	var got []string
+	for _, m := range matches {
+		if m.Book != input {
+			t.Fatalf("book = %q", m.Book)
+		}
+		got = append(got, m.Href+": "+m.Snippet)
+	}
+	want := []string{
+		"Text/c2.xhtml: A dragon and another dragon.",
+		"Text/c2.xhtml: A dragon and another dragon.",
+		"Text/c1.xhtml: The Dragon slept.",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got:\n%s", strings.Join(got, "\n"))
+	}
+
+	matches, err = GrepBook(context.Background(), input, regexp.MustCompile(`Dragon`))
+	if err != nil {
+		t.Fatalf("GrepBook: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Href != "Text/c1.xhtml" {
+		t.Fatalf("case-sensitive matches = %+v", matches)
+	}
+}
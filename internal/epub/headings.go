@@ -0,0 +1,194 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// HeadingsAuto makes MergeOptions.NormalizeHeadings pick the chapter heading
+// level most of the volumes already use.
+const HeadingsAuto = "auto"
+
+// parseHeadingTarget validates a NormalizeHeadings value, returning the
+// wanted chapter level (1-6), 0 for HeadingsAuto, or -1 when it is off.
+func parseHeadingTarget(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return -1, nil
+	case HeadingsAuto:
+		return 0, nil
+	}
+	if level := headingLevel(s); level > 0 {
+		return level, nil
+	}
+	return 0, fmt.Errorf("invalid heading level %q (want auto or h1-h6)", s)
+}
+
+// headingLevel returns N for an hN element name, or 0 for anything else.
+func headingLevel(name string) int {
+	if len(name) == 2 && (name[0] == 'h' || name[0] == 'H') && name[1] >= '1' && name[1] <= '6' {
+		return int(name[1] - '0')
+	}
+	return 0
+}
+
+// volumeContentDocs returns the paths of a volume's XHTML spine documents,
+// leaving out its nav.
+func volumeContentDocs(vol *Volume) []string {
+	items := make(map[string]ManifestItem, len(vol.PackageDoc.Manifest.Items))
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		items[item.ID] = item
+	}
+	var out []string
+	for _, ref := range vol.PackageDoc.Spine.Itemrefs {
+		item, ok := items[ref.IDRef]
+		if !ok || item.MediaType != "application/xhtml+xml" || hasProperty(item.Properties, "nav") {
+			continue
+		}
+		out = append(out, filepath.Join(vol.PackageDir, filepath.FromSlash(item.Href)))
+	}
+	return out
+}
+
+// chapterHeadingLevel returns the highest heading level (the smallest N)
+// used in a volume's content documents, which is taken to be the one its
+// chapters start with, or 0 if it has no headings.
+func chapterHeadingLevel(vol *Volume) (int, error) {
+	best := 0
+	for _, p := range volumeContentDocs(vol) {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return 0, err
+		}
+		dec := xml.NewDecoder(bytes.NewReader(data))
+		dec.Strict = false
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return 0, fmt.Errorf("%s: %w", filepath.Base(p), err)
+			}
+			if start, ok := tok.(xml.StartElement); ok {
+				if level := headingLevel(start.Name.Local); level > 0 && (best == 0 || level < best) {
+					best = level
+				}
+			}
+		}
+	}
+	return best, nil
+}
+
+// commonHeadingLevel picks the chapter level most volumes use, the higher
+// level on a tie, or 1 when no volume has headings.
+func commonHeadingLevel(levels []int) int {
+	var counts [7]int
+	for _, l := range levels {
+		counts[l]++
+	}
+	best := 1
+	for l := 1; l <= 6; l++ {
+		if counts[l] > counts[best] {
+			best = l
+		}
+	}
+	return best
+}
+
+var headingTagPattern = regexp.MustCompile(`^<(/?)((?:[\w.-]+:)?)[hH]([1-6])`)
+
+// shiftHeadings moves every hN element in an XHTML document by delta levels,
+// clamped to h1-h6. Only the tag names change; the rest of the markup is
+// left byte for byte. It returns how many elements were retagged.
+func shiftHeadings(data []byte, delta int) ([]byte, int, error) {
+	if delta == 0 {
+		return data, 0, nil
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	type edit struct {
+		at    int64
+		level byte
+	}
+	var edits []edit
+	retagged := 0
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		var name string
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+		case xml.EndElement:
+			name = t.Name.Local
+		default:
+			continue
+		}
+		level := headingLevel(name)
+		if level == 0 {
+			continue
+		}
+		// A self-closing <hN/> yields an end element with no bytes of its own.
+		m := headingTagPattern.FindSubmatchIndex(data[start:dec.InputOffset()])
+		if m == nil {
+			continue
+		}
+		next := min(max(level+delta, 1), 6)
+		if next == level {
+			continue
+		}
+		edits = append(edits, edit{at: start + int64(m[6]), level: byte('0' + next)})
+		if _, ok := tok.(xml.StartElement); ok {
+			retagged++
+		}
+	}
+	if len(edits) == 0 {
+		return data, 0, nil
+	}
+	out := append([]byte(nil), data...)
+	for _, e := range edits {
+		out[e.at] = e.level
+	}
+	return out, retagged, nil
+}
+
+// normalizeVolumeHeadings shifts the headings of a volume's content
+// documents, in place, so its chapter level becomes target.
+func normalizeVolumeHeadings(vol *Volume, level, target int) (int, error) {
+	if level == 0 || level == target {
+		return 0, nil
+	}
+	total := 0
+	for _, p := range volumeContentDocs(vol) {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return total, err
+		}
+		out, n, err := shiftHeadings(data, target-level)
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", filepath.Base(p), err)
+		}
+		if n == 0 {
+			continue
+		}
+		if err := os.WriteFile(p, out, 0o644); err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
@@ -0,0 +1,134 @@
+package epub
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShiftHeadings(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><body>
+<!-- <h2>not a heading</h2> -->
+<h2 class="chapter">One</h2>
+<p>Text with <b>bold</b>.</p>
+<h3>Scene</h3><H5/>
+<h6>Deep</h6>
+</body></html>`
+	out, n, err := shiftHeadings([]byte(doc), -1)
+	if err != nil {
+		t.Fatalf("shiftHeadings: %v", err)
+	}
+	want := strings.NewReplacer(
+		`<h2 class="chapter">One</h2>`, `<h1 class="chapter">One</h1>`,
+		`<h3>Scene</h3><H5/>`, `<h2>Scene</h2><H4/>`,
+		`<h6>Deep</h6>`, `<h5>Deep</h5>`,
+	).Replace(doc)
+	if string(out) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+	if n != 4 {
+		t.Fatalf("retagged = %d", n)
+	}
+
+	out, n, err = shiftHeadings([]byte(`<body><h5>a</h5><h6>b</h6></body>`), 2)
+	if err != nil {
+		t.Fatalf("shiftHeadings: %v", err)
+	}
+	if string(out) != `<body><h6>a</h6><h6>b</h6></body>` || n != 1 {
+		t.Fatalf("clamped = %s (%d)", out, n)
+	}
+}
+
+func TestParseHeadingTarget(t *testing.T) {
+	cases := map[string]int{"": -1, "auto": 0, "AUTO": 0, "h1": 1, "H3": 3, "h6": 6}
+	for in, want := range cases {
+		if got, err := parseHeadingTarget(in); err != nil || got != want {
+			t.Errorf("parseHeadingTarget(%q) = %d, %v want %d", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"h0", "h7", "2", "header"} {
+		if _, err := parseHeadingTarget(bad); err == nil {
+			t.Errorf("parseHeadingTarget(%q): expected error", bad)
+		}
+	}
+}
+
+func TestCommonHeadingLevel(t *testing.T) {
+	cases := []struct {
+		levels []int
+		want   int
+	}{
+		{[]int{2, 1, 2}, 2},
+		{[]int{1, 2}, 1},
+		{[]int{0, 0, 3}, 3},
+		{[]int{0, 0}, 1},
+	}
+	for _, c := range cases {
+		if got := commonHeadingLevel(c.levels); got != c.want {
+			t.Errorf("commonHeadingLevel(%v) = %d want %d", c.levels, got, c.want)
+		}
+	}
+}
+
+func buildHeadingsEPUB(t *testing.T, title, body string) string {
+	t.Helper()
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      title,
+			identifier: "urn:test:headings",
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				testItem("c1", "c1.xhtml"),
+			},
+			spine: []string{"c1"},
+		}),
+		"OEBPS/nav.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><h2>Contents</h2><ol><li><a href="c1.xhtml">Chapter</a></li></ol></nav></body></html>`,
+		"OEBPS/c1.xhtml":  `<html xmlns="http://www.w3.org/1999/xhtml"><body>` + body + `</body></html>`,
+	})
+}
+
+func TestMergeEPUBsNormalizeHeadings(t *testing.T) {
+	a := buildHeadingsEPUB(t, "Vol 1", `<h1>One</h1><h2>Scene</h2>`)
+	b := buildHeadingsEPUB(t, "Vol 2", `<h2>Two</h2><h3>Scene</h3>`)
+	c := buildHeadingsEPUB(t, "Vol 3", `<h2>Three</h2>`)
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, NormalizeHeadings: "h7"}); err == nil {
+		t.Fatalf("expected an invalid level to be rejected")
+	}
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b, c}, MergeOptions{OutPath: out, NormalizeHeadings: HeadingsAuto})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if stats.HeadingsRetagged != 2 {
+		t.Fatalf("retagged = %d", stats.HeadingsRetagged)
+	}
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	for href, want := range map[string]string{
+		"Volumes/v0001/c1.xhtml": `<h2>One</h2><h3>Scene</h3>`,
+		"Volumes/v0002/c1.xhtml": `<h2>Two</h2><h3>Scene</h3>`,
+	} {
+		data, err := book.ReadFile(href)
+		if err != nil {
+			t.Fatalf("read %s: %v", href, err)
+		}
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("%s = %s", href, data)
+		}
+	}
+
+	stats, err = MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, NormalizeHeadings: "h1"})
+	if err != nil {
+		t.Fatalf("MergeEPUBs h1: %v", err)
+	}
+	if stats.HeadingsRetagged != 2 {
+		t.Fatalf("retagged to h1 = %d", stats.HeadingsRetagged)
+	}
+}
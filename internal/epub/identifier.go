@@ -0,0 +1,158 @@
+package epub
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// urnPattern is the shape RFC 8141 gives a URN: "urn:", a namespace id of
+// 2-32 letters, digits and hyphens, then a non-empty namespace-specific part.
+var urnPattern = regexp.MustCompile(`(?i)^urn:[a-z0-9][a-z0-9-]{0,30}[a-z0-9]:\S+$`)
+
+// checkIdentifier reports whether id is plausible as a book's dc:identifier:
+// a URN such as "urn:uuid:..." or "urn:isbn:...", or a bare ISBN-10/13 with
+// or without hyphens. URN ISBNs and UUIDs are checked further.
+func checkIdentifier(id string) error {
+	if strings.TrimSpace(id) != id || id == "" {
+		return fmt.Errorf("invalid identifier %q", id)
+	}
+	if isISBN(id) {
+		return nil
+	}
+	if !urnPattern.MatchString(id) {
+		return fmt.Errorf("invalid identifier %q (want a URN such as urn:uuid:..., or an ISBN)", id)
+	}
+	nid, nss, _ := strings.Cut(id[len("urn:"):], ":")
+	switch strings.ToLower(nid) {
+	case "isbn":
+		if !isISBN(nss) {
+			return fmt.Errorf("invalid identifier %q: %q is not a valid ISBN", id, nss)
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(nss) {
+			return fmt.Errorf("invalid identifier %q: %q is not a UUID", id, nss)
+		}
+	}
+	return nil
+}
+
+var uuidPattern = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// isISBN reports whether s is an ISBN-10 or ISBN-13 with a correct check
+// digit. Hyphens and spaces between digits are ignored.
+func isISBN(s string) bool {
+	digits := strings.NewReplacer("-", "", " ", "").Replace(s)
+	switch len(digits) {
+	case 10:
+		sum := 0
+		for i, r := range digits {
+			var d int
+			switch {
+			case r >= '0' && r <= '9':
+				d = int(r - '0')
+			case (r == 'X' || r == 'x') && i == 9:
+				d = 10
+			default:
+				return false
+			}
+			sum += (10 - i) * d
+		}
+		return sum%11 == 0
+	case 13:
+		sum := 0
+		for i, r := range digits {
+			if r < '0' || r > '9' {
+				return false
+			}
+			d := int(r - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		return sum%10 == 0
+	}
+	return false
+}
+
+// sourceISBNs returns the ISBNs the volumes' dc:identifiers declare, as
+// hyphen-free digits in volume order without repeats. An identifier counts
+// when it has opf:scheme="ISBN", an EPUB 3 identifier-type refinement naming
+// an ISBN, or a urn:isbn: value, and carries a valid ISBN.
+func sourceISBNs(vols []*Volume) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, vol := range vols {
+		meta := vol.PackageDoc.Metadata
+		for _, id := range meta.Identifiers {
+			isbn := identifierISBN(meta, id)
+			if isbn == "" || seen[isbn] {
+				continue
+			}
+			seen[isbn] = true
+			out = append(out, isbn)
+		}
+	}
+	return out
+}
+
+// identifierISBN returns the ISBN an ISBN-schemed identifier carries, as
+// hyphen-free digits, or "" when id isn't one.
+func identifierISBN(meta Metadata, id DCMeta) string {
+	isbn, urn := parseISBN(id.Value)
+	if isbn == "" || urn || strings.EqualFold(strings.TrimSpace(id.Scheme), "ISBN") {
+		return isbn
+	}
+	if id.ID != "" {
+		for _, m := range meta.Meta {
+			if m.Refines != "#"+id.ID || m.Property != "identifier-type" {
+				continue
+			}
+			v := strings.TrimSpace(m.Value)
+			if strings.EqualFold(v, "ISBN") || strings.EqualFold(m.Scheme, "onix:codelist5") && (v == "02" || v == "15") {
+				return isbn
+			}
+		}
+	}
+	return ""
+}
+
+// parseISBN returns the ISBN value holds, bare or as a urn:isbn:, as
+// hyphen-free digits ("" when it holds none), and whether it was a URN.
+func parseISBN(value string) (isbn string, urn bool) {
+	value = strings.TrimSpace(value)
+	if len(value) > len("urn:isbn:") && strings.EqualFold(value[:len("urn:isbn:")], "urn:isbn:") {
+		value, urn = value[len("urn:isbn:"):], true
+	}
+	if !isISBN(value) {
+		return "", urn
+	}
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(value)), urn
+}
+
+// addSourceISBNs appends isbns to meta as urn:isbn: identifiers with
+// opf:scheme="ISBN" and an ONIX identifier-type refinement (15 for an
+// ISBN-13, 02 for an ISBN-10), skipping any meta already carries.
+func addSourceISBNs(meta *Metadata, isbns []string) {
+	have := make(map[string]bool)
+	for _, id := range meta.Identifiers {
+		if isbn, _ := parseISBN(id.Value); isbn != "" {
+			have[isbn] = true
+		}
+	}
+	n := 0
+	for _, isbn := range isbns {
+		if have[isbn] {
+			continue
+		}
+		n++
+		id := fmt.Sprintf("isbn%02d", n)
+		meta.Identifiers = append(meta.Identifiers, DCMeta{ID: id, Scheme: "ISBN", Value: "urn:isbn:" + isbn})
+		code := "15"
+		if len(isbn) == 10 {
+			code = "02"
+		}
+		meta.Meta = append(meta.Meta, MetaNode{Refines: "#" + id, Property: "identifier-type", Scheme: "onix:codelist5", Value: code})
+	}
+}
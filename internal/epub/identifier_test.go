@@ -0,0 +1,113 @@
+package epub
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckIdentifier(t *testing.T) {
+	for _, tc := range []struct {
+		id string
+		ok bool
+	}{
+		{"urn:uuid:0f3c2b1e-8d4a-4c6b-9e2f-1a2b3c4d5e6f", true},
+		{"urn:isbn:978-0-306-40615-7", true},
+		{"9780306406157", true},
+		{"0-306-40615-2", true},
+		{"urn:example:my-book", true},
+		{"urn:uuid:not-a-uuid", false},
+		{"urn:isbn:9780306406158", false},
+		{"9780306406158", false},
+		{"my-book", false},
+		{" urn:example:x", false},
+		{"urn:x:y", false},
+	} {
+		if err := checkIdentifier(tc.id); (err == nil) != tc.ok {
+			t.Errorf("checkIdentifier(%q) = %v", tc.id, err)
+		}
+	}
+}
+
+func TestMergeEPUBsIdentifier(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	const id = "urn:uuid:0f3c2b1e-8d4a-4c6b-9e2f-1a2b3c4d5e6f"
+
+	for _, tmpl := range []*Metadata{nil, {Identifiers: []DCMeta{{ID: "pub-id", Value: "urn:test:template"}}}} {
+		out := filepath.Join(t.TempDir(), "merged.epub")
+		if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Identifier: id, MetadataTemplate: tmpl}); err != nil {
+			t.Fatalf("MergeEPUBs: %v", err)
+		}
+		book, err := OpenBook(context.Background(), out)
+		if err != nil {
+			t.Fatalf("OpenBook: %v", err)
+		}
+		pkg := book.Package
+		book.Close()
+		if len(pkg.Metadata.Identifiers) != 1 || pkg.Metadata.Identifiers[0].Value != id || pkg.Metadata.Identifiers[0].ID != pkg.UniqueIdentifier {
+			t.Fatalf("unique-identifier %q, identifiers %+v", pkg.UniqueIdentifier, pkg.Metadata.Identifiers)
+		}
+	}
+
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Identifier: "my-book"}); err == nil {
+		t.Fatal("expected an error for an implausible identifier")
+	}
+}
+
+func TestMergeEPUBsKeepSourceISBNs(t *testing.T) {
+	isbnEPUB := func(version, identifier string, metadata ...string) string {
+		return buildTestEPUBFiles(t, map[string]string{
+			"OEBPS/content.opf": testOPF(testPackage{
+				version:    version,
+				title:      "Vol",
+				lang:       "en",
+				identifier: identifier,
+				metadata:   metadata,
+				items:      []ManifestItem{testItem("c1", "c1.xhtml")},
+				spine:      []string{"c1"},
+			}),
+			"OEBPS/c1.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p></body></html>`,
+		})
+	}
+	a := isbnEPUB("2.0", "urn:uuid:0f3c2b1e-8d4a-4c6b-9e2f-1a2b3c4d5e6f",
+		`<dc:identifier opf:scheme="ISBN">978-0-306-40615-7</dc:identifier>`,
+		`<dc:identifier opf:scheme="ISBN">not an isbn</dc:identifier>`,
+		`<dc:identifier>0-306-40615-2</dc:identifier>`)
+	b := isbnEPUB("3.0", "9780306406157",
+		`<dc:identifier id="isbn10">0306406152</dc:identifier>`,
+		`<meta refines="#isbn10" property="identifier-type" scheme="onix:codelist5">02</meta>`)
+	c := isbnEPUB("3.0", "urn:isbn:9781861972712")
+
+	const id = "urn:uuid:11111111-2222-4333-8444-555555555555"
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{a, b, c}, MergeOptions{OutPath: out, Identifier: id, KeepSourceISBNs: true}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	pkg := book.Package
+
+	var got []string
+	for _, dc := range pkg.Metadata.Identifiers {
+		got = append(got, dc.ID+"|"+dc.Scheme+"|"+dc.Value)
+	}
+	want := "bookid||" + id + ",isbn01|ISBN|urn:isbn:9780306406157,isbn02|ISBN|urn:isbn:0306406152,isbn03|ISBN|urn:isbn:9781861972712"
+	if strings.Join(got, ",") != want || pkg.UniqueIdentifier != "bookid" {
+		t.Fatalf("unique-identifier %q, identifiers %q", pkg.UniqueIdentifier, got)
+	}
+	var types []string
+	for _, m := range pkg.Metadata.Meta {
+		if m.Property == "identifier-type" {
+			types = append(types, m.Refines+"="+m.Scheme+":"+m.Value)
+		}
+	}
+	if strings.Join(types, ",") != "#isbn01=onix:codelist5:15,#isbn02=onix:codelist5:02,#isbn03=onix:codelist5:15" {
+		t.Fatalf("identifier types = %q", types)
+	}
+}
@@ -0,0 +1,262 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Where MergeOptions.Illustrations looks for a volume's illustrations.
+// IllustrationsAuto tries all three in this order.
+const (
+	// IllustrationsLOI uses the volume's own list of illustrations: its
+	// epub:type="loi" nav, or the page its loi landmark points at.
+	IllustrationsLOI = "loi"
+	// IllustrationsTOC uses the TOC entries whose titles match
+	// MergeOptions.IllustrationPattern, such as "Color Illustrations".
+	IllustrationsTOC = "toc"
+	// IllustrationsPages uses the spine documents that hold little more
+	// than an image.
+	IllustrationsPages = "pages"
+	IllustrationsAuto  = "auto"
+)
+
+const (
+	illustrationsTitle = "Illustrations"
+	loiPageTitle       = "List of Illustrations"
+	// DefaultIllustrationPattern matches the TOC titles light novels
+	// commonly give their insert pages.
+	DefaultIllustrationPattern = `(?i)\billustrations?\b|\binserts?\b|\bcolou?r pages?\b|口絵|挿絵|插图|插圖`
+	// illustrationCaptionRunes is how much text a page can have besides its
+	// image and still count as an illustration page.
+	illustrationCaptionRunes = 60
+)
+
+// parseIllustrationSources validates a MergeOptions.Illustrations value: ""
+// for off, IllustrationsAuto, or a comma-separated list of sources.
+func parseIllustrationSources(spec string) ([]string, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	switch spec {
+	case "":
+		return nil, nil
+	case IllustrationsAuto:
+		return []string{IllustrationsLOI, IllustrationsTOC, IllustrationsPages}, nil
+	}
+	var out []string
+	seen := map[string]bool{}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		switch s {
+		case IllustrationsLOI, IllustrationsTOC, IllustrationsPages:
+		default:
+			return nil, fmt.Errorf("invalid illustration source %q (want auto, or a list of loi, toc, pages)", s)
+		}
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// volumeIllustrations lists a volume's illustrations with package-relative
+// hrefs, from the first of sources that finds any.
+func volumeIllustrations(vol *Volume, sources []string, pattern *regexp.Regexp) ([]NavItem, error) {
+	for _, source := range sources {
+		var (
+			items []NavItem
+			err   error
+		)
+		switch source {
+		case IllustrationsLOI:
+			items, err = loiEntries(vol)
+		case IllustrationsTOC:
+			items = matchingNavEntries(vol.NavItems, pattern)
+			if vol.NavHref != "" {
+				items = cloneNavItems(items, path.Dir(vol.NavHref))
+			}
+		case IllustrationsPages:
+			items, err = illustrationPages(vol)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(items) > 0 {
+			return items, nil
+		}
+	}
+	return nil, nil
+}
+
+// loiEntries returns the entries of the volume's loi nav, or else an entry
+// for the page its loi landmark points at.
+func loiEntries(vol *Volume) ([]NavItem, error) {
+	if vol.NavHref == "" {
+		return nil, nil
+	}
+	data, err := vol.readFile(vol.NavHref)
+	if err != nil {
+		return nil, err
+	}
+	navDir := path.Dir(vol.NavHref)
+	items, err := scanNav(data, "loi", false)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) > 0 {
+		return cloneNavItems(items, navDir), nil
+	}
+	hrefs, err := parseLandmarks(data, "loi")
+	if err != nil || len(hrefs) == 0 {
+		return nil, err
+	}
+	return []NavItem{{Title: loiPageTitle, Href: joinHref(navDir, hrefs[0])}}, nil
+}
+
+// matchingNavEntries returns the entries whose titles match pattern, with
+// their children, without looking inside the entries it returns.
+func matchingNavEntries(items []NavItem, pattern *regexp.Regexp) []NavItem {
+	var out []NavItem
+	for _, item := range items {
+		if pattern.MatchString(normalizeSpace(item.Title)) {
+			out = append(out, item)
+			continue
+		}
+		out = append(out, matchingNavEntries(item.Children, pattern)...)
+	}
+	return out
+}
+
+// illustrationPages returns an entry for each linear spine document, other
+// than the cover, that is an image with at most a short caption. Entries are
+// titled by the image's alt text or the caption, else numbered.
+func illustrationPages(vol *Volume) ([]NavItem, error) {
+	skip := make(map[string]bool)
+	covers, err := coverDocuments(vol)
+	if err != nil {
+		return nil, err
+	}
+	for _, href := range covers {
+		skip[normalizeEPUBPath(href)] = true
+	}
+	items := make(map[string]ManifestItem, len(vol.PackageDoc.Manifest.Items))
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		items[item.ID] = item
+	}
+	var out []NavItem
+	for _, ref := range vol.PackageDoc.Spine.Itemrefs {
+		item, ok := items[vol.canonicalID(ref.IDRef)]
+		if !ok || item.MediaType != "application/xhtml+xml" || strings.EqualFold(ref.Linear, "no") {
+			continue
+		}
+		if hasProperty(item.Properties, "nav") || skip[normalizeEPUBPath(item.Href)] {
+			continue
+		}
+		data, err := vol.readFile(item.Href)
+		if err != nil {
+			return nil, err
+		}
+		ok, title, err := isIllustrationPage(data)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if title == "" {
+			title = fmt.Sprintf("Illustration %d", len(out)+1)
+		}
+		out = append(out, NavItem{Title: title, Href: item.Href})
+	}
+	return out, nil
+}
+
+// isIllustrationPage reports whether an XHTML document's body is an image
+// (<img> or SVG <image>) with at most illustrationCaptionRunes of text, and
+// returns the first image's alt text or else the caption.
+func isIllustrationPage(data []byte) (bool, string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var (
+		text   strings.Builder
+		alt    string
+		images int
+		inBody bool
+		skip   int
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			switch {
+			case name == "body":
+				inBody = true
+			case skip > 0 || name == "script" || name == "style":
+				skip++
+			case name == "img" || name == "image":
+				images++
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "alt" && alt == "" {
+						alt = normalizeSpace(attr.Value)
+					}
+				}
+			}
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+			}
+		case xml.CharData:
+			if inBody && skip == 0 {
+				text.Write(t)
+			}
+		}
+	}
+	caption := normalizeSpace(text.String())
+	if images == 0 || utf8.RuneCountInString(caption) > illustrationCaptionRunes {
+		return false, "", nil
+	}
+	if alt != "" {
+		return true, alt, nil
+	}
+	return true, caption, nil
+}
+
+// illustrationsNav groups the volumes' illustrations (merged hrefs, keyed by
+// volume index) under an entry per volume.
+func illustrationsNav(vols []*Volume, byVolume map[int][]NavItem) []NavItem {
+	var out []NavItem
+	for _, vol := range vols {
+		items := byVolume[vol.Index]
+		if len(items) == 0 {
+			continue
+		}
+		out = append(out, NavItem{Title: vol.DisplayName, Href: items[0].Href, Children: items})
+	}
+	return out
+}
+
+// writeLOI writes the generated nav's list of illustrations.
+func writeLOI(buf *bytes.Buffer, items []NavItem) {
+	buf.WriteString(`<nav epub:type="loi" id="loi">` + "\n")
+	buf.WriteString("<h2>" + html.EscapeString(illustrationsTitle) + "</h2>\n<ol>\n")
+	for _, item := range items {
+		writeNavItem(buf, item)
+	}
+	buf.WriteString("</ol>\n</nav>\n")
+}
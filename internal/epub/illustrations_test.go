@@ -0,0 +1,115 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseIllustrationSources(t *testing.T) {
+	if got, err := parseIllustrationSources("Auto"); err != nil || strings.Join(got, ",") != "loi,toc,pages" {
+		t.Fatalf("auto = %q, %v", got, err)
+	}
+	if got, err := parseIllustrationSources("pages, toc,pages"); err != nil || strings.Join(got, ",") != "pages,toc" {
+		t.Fatalf("list = %q, %v", got, err)
+	}
+	if got, err := parseIllustrationSources(""); err != nil || got != nil {
+		t.Fatalf("empty = %q, %v", got, err)
+	}
+	if _, err := parseIllustrationSources("toc,landmarks"); err == nil {
+		t.Fatal("expected an error for an unknown source")
+	}
+}
+
+func TestIsIllustrationPage(t *testing.T) {
+	for _, tc := range []struct {
+		doc   string
+		ok    bool
+		title string
+	}{
+		{`<html><head><title>Long page title that is not a caption at all</title></head><body><div><img src="a.jpg" alt=" The  duel "/></div></body></html>`, true, "The duel"},
+		{`<html><body><svg><image href="a.jpg"/></svg><p>Plate 3</p></body></html>`, true, "Plate 3"},
+		{`<html><body><img src="a.jpg"/></body></html>`, true, ""},
+		{`<html><body><img src="a.jpg"/><p>` + strings.Repeat("Story text goes on. ", 10) + `</p></body></html>`, false, ""},
+		{`<html><body><p>No pictures here</p></body></html>`, false, ""},
+	} {
+		ok, title, err := isIllustrationPage([]byte(tc.doc))
+		if err != nil || ok != tc.ok || title != tc.title {
+			t.Errorf("isIllustrationPage(%.40q) = %v, %q, %v", tc.doc, ok, title, err)
+		}
+	}
+}
+
+func TestMergeEPUBsIllustrations(t *testing.T) {
+	withLOI := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Vol 1",
+			lang:       "en",
+			identifier: "urn:test:loi",
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				testItem("c1", "c1.xhtml"),
+			},
+			spine: []string{"c1"},
+		}),
+		"OEBPS/nav.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol><li><a href="c1.xhtml">One</a></li></ol></nav>
+<nav epub:type="loi"><ol><li><a href="c1.xhtml#fig1">The Map</a></li></ol></nav>
+</body></html>`,
+		"OEBPS/c1.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p><img id="fig1" src="map.jpg" alt="map"/></body></html>`,
+	})
+	withPages := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Vol 2",
+			lang:       "en",
+			identifier: "urn:test:pages",
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				testItem("art", "Text/art.xhtml"),
+				testItem("c1", "Text/c1.xhtml"),
+			},
+			spine: []string{"c1", "art"},
+		}),
+		"OEBPS/nav.xhtml":      `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="Text/c1.xhtml">One</a></li></ol></nav></body></html>`,
+		"OEBPS/Text/c1.xhtml":  `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>` + strings.Repeat("Story text goes on. ", 10) + `</p></body></html>`,
+		"OEBPS/Text/art.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><img src="../art.jpg" alt=""/></body></html>`,
+	})
+	plain := buildTestEPUB(t, "Vol 3", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{withLOI, withPages, plain}, MergeOptions{OutPath: out, Illustrations: IllustrationsAuto, Landmarks: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if stats.Illustrations != 2 {
+		t.Fatalf("Illustrations = %d", stats.Illustrations)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	data, err := vol.readFile(vol.NavHref)
+	if err != nil {
+		t.Fatalf("read nav: %v", err)
+	}
+	loi, err := scanNav(data, "loi", false)
+	if err != nil {
+		t.Fatalf("scanNav: %v", err)
+	}
+	if len(loi) != 2 || loi[0].Title != "Vol 1" || loi[1].Title != "Vol 2" {
+		t.Fatalf("loi = %+v", loi)
+	}
+	if got := loi[0].Children; len(got) != 1 || got[0].Title != "The Map" || got[0].Href != "Volumes/v0001/c1.xhtml#fig1" {
+		t.Fatalf("vol 1 = %+v", got)
+	}
+	if got := loi[1].Children; len(got) != 1 || got[0].Title != "Illustration 1" || got[0].Href != "Volumes/v0002/Text/art.xhtml" {
+		t.Fatalf("vol 2 = %+v", got)
+	}
+	if hrefs, err := parseLandmarks(data, "loi"); err != nil || len(hrefs) != 1 || hrefs[0] != "nav.xhtml#loi" {
+		t.Fatalf("loi landmark = %q, %v", hrefs, err)
+	}
+}
@@ -0,0 +1,127 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// imageIndexName is the index ExtractImages writes next to the images.
+const imageIndexName = "index.json"
+
+type ExtractImagesOptions struct {
+	OutDir string
+	// MediaTypes keeps only images of these types; "jpeg" is short for
+	// "image/jpeg". Empty keeps every image.
+	MediaTypes []string
+	// MinWidth and MinHeight skip smaller images, such as ornaments and
+	// icons. Images whose size can't be read (SVG, WebP) are kept.
+	MinWidth  int
+	MinHeight int
+}
+
+// ExtractedImage maps an image's manifest href to the file it was copied to,
+// relative to the output directory. Width and Height are 0 when unknown.
+type ExtractedImage struct {
+	Href      string `json:"href"`
+	File      string `json:"file"`
+	MediaType string `json:"media_type"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+}
+
+// ExtractImages copies the image manifest items of an EPUB into opts.OutDir
+// under their own file names, numbering repeats ("map.png", "map-2.png"),
+// and writes an index.json listing where each went. Files already in the
+// directory with the same names are overwritten. The book is not modified.
+func ExtractImages(ctx context.Context, input string, opts ExtractImagesOptions) ([]ExtractedImage, error) {
+	if input == "" {
+		return nil, fmt.Errorf("input EPUB path is required")
+	}
+	if opts.OutDir == "" {
+		return nil, fmt.Errorf("output directory is required")
+	}
+	wanted := make(map[string]bool, len(opts.MediaTypes))
+	for _, mt := range opts.MediaTypes {
+		mt = strings.ToLower(strings.TrimSpace(mt))
+		if !strings.Contains(mt, "/") {
+			mt = "image/" + mt
+		}
+		wanted[mt] = true
+	}
+
+	book, err := OpenBook(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	if err := os.MkdirAll(opts.OutDir, 0o755); err != nil {
+		return nil, err
+	}
+	taken := map[string]bool{imageIndexName: true}
+	out := []ExtractedImage{}
+	for _, item := range book.Package.Manifest.Items {
+		if !isImageItem(item) {
+			continue
+		}
+		mediaType := strings.ToLower(strings.TrimSpace(item.MediaType))
+		if len(wanted) > 0 && !wanted[mediaType] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		href := item.Href
+		if unescaped, err := url.PathUnescape(href); err == nil && !book.Exists(href) {
+			href = unescaped
+		}
+		data, err := book.ReadFile(href)
+		if err != nil {
+			return nil, err
+		}
+		img := ExtractedImage{Href: item.Href, MediaType: mediaType}
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			img.Width, img.Height = cfg.Width, cfg.Height
+			if img.Width < opts.MinWidth || img.Height < opts.MinHeight {
+				continue
+			}
+		}
+		img.File = uniqueFileName(path.Base(href), taken)
+		if err := os.WriteFile(filepath.Join(opts.OutDir, img.File), data, 0o644); err != nil {
+			return nil, err
+		}
+		out = append(out, img)
+	}
+
+	index, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(opts.OutDir, imageIndexName), append(index, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// uniqueFileName returns name, or name with "-2", "-3", ... before its
+// extension if that is taken, and marks the result taken. Names are compared
+// ignoring case, since some filesystems do.
+func uniqueFileName(name string, taken map[string]bool) string {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for n := 2; taken[strings.ToLower(candidate)]; n++ {
+		candidate = stem + "-" + strconv.Itoa(n) + ext
+	}
+	taken[strings.ToLower(candidate)] = true
+	return candidate
+}
@@ -0,0 +1,89 @@
+package epub
+
+import (
+	"context"
+	"encoding/json"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractImages(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Images",
+			identifier: "urn:test:images",
+			items: []ManifestItem{
+				testItem("c1", "Text/c1.xhtml"),
+				testItem("art1", "Images/art.png"),
+				testItem("art2", "Images/Part2/Art.png"),
+				testItem("dot", "Images/dot.png"),
+				testItem("logo", "Images/my%20logo.svg"),
+			},
+			spine: []string{"c1"},
+		}),
+		"OEBPS/Text/c1.xhtml":        `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p></body></html>`,
+		"OEBPS/Images/art.png":       solidPNG(t, 100, 50, color.Black),
+		"OEBPS/Images/Part2/Art.png": solidPNG(t, 80, 80, color.Black),
+		"OEBPS/Images/dot.png":       solidPNG(t, 2, 2, color.Black),
+		"OEBPS/Images/my logo.svg":   `<svg xmlns="http://www.w3.org/2000/svg"/>`,
+	})
+	dir := filepath.Join(t.TempDir(), "out")
+
+	got, err := ExtractImages(context.Background(), input, ExtractImagesOptions{OutDir: dir, MinWidth: 10, MinHeight: 10})
+	if err != nil {
+		t.Fatalf("ExtractImages: %v", err)
+	}
+	want := []ExtractedImage{
+		{Href: "Images/art.png", File: "art.png", MediaType: "image/png", Width: 100, Height: 50},
+		{Href: "Images/Part2/Art.png", File: "Art-2.png", MediaType: "image/png", Width: 80, Height: 80},
+		{Href: "Images/my%20logo.svg", File: "my logo.svg", MediaType: "image/svg+xml"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("image %d = %+v want %+v", i, got[i], want[i])
+		}
+		if _, err := os.Stat(filepath.Join(dir, want[i].File)); err != nil {
+			t.Fatalf("extracted file: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dot.png")); !os.IsNotExist(err) {
+		t.Fatalf("small image should be skipped")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, imageIndexName))
+	if err != nil {
+		t.Fatalf("read index: %v", err)
+	}
+	var index []ExtractedImage
+	if err := json.Unmarshal(data, &index); err != nil || len(index) != len(want) || index[1] != want[1] {
+		t.Fatalf("index = %s (%v)", data, err)
+	}
+
+	got, err = ExtractImages(context.Background(), input, ExtractImagesOptions{OutDir: t.TempDir(), MediaTypes: []string{"svg+xml"}})
+	if err != nil {
+		t.Fatalf("ExtractImages svg: %v", err)
+	}
+	if len(got) != 1 || got[0].File != "my logo.svg" {
+		t.Fatalf("svg only = %+v", got)
+	}
+}
+
+func TestUniqueFileName(t *testing.T) {
+	taken := map[string]bool{}
+	for _, c := range []struct{ in, want string }{
+		{"a.png", "a.png"},
+		{"A.png", "A-2.png"},
+		{"a.png", "a-3.png"},
+		{"noext", "noext"},
+		{"noext", "noext-2"},
+	} {
+		if got := uniqueFileName(c.in, taken); got != c.want {
+			t.Errorf("uniqueFileName(%q) = %q want %q", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package epub
+
+import (
+	"bytes"
+	"html"
+	"os"
+)
+
+const (
+	indexPageID    = "index-page"
+	indexPageHref  = "index.xhtml"
+	indexPageTitle = "Volumes"
+)
+
+// writeIndexPage writes a landing page listing every volume's title linked to
+// its first document. With coverHrefs (volume index -> merged cover href) each
+// entry also shows that volume's cover as a thumbnail.
+func writeIndexPage(vols []*Volume, coverHrefs map[int]string, dest string) error {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	buf.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">` + "\n")
+	buf.WriteString("<head><title>" + indexPageTitle + "</title>\n<style>\n")
+	buf.WriteString("ol.volumes { list-style: none; margin: 0; padding: 0; }\n")
+	buf.WriteString("ol.volumes li { margin: 0 0 1em; }\n")
+	buf.WriteString("ol.volumes a { text-decoration: none; }\n")
+	buf.WriteString("ol.volumes img { display: block; max-height: 8em; max-width: 40%; margin-bottom: 0.3em; }\n")
+	buf.WriteString("</style></head>\n<body>\n")
+	buf.WriteString(`<section epub:type="frontmatter">` + "\n")
+	buf.WriteString("<h1>" + indexPageTitle + "</h1>\n<ol class=\"volumes\">\n")
+
+	for _, vol := range vols {
+		if vol.FirstHref == "" {
+			continue
+		}
+		title := html.EscapeString(vol.DisplayName)
+		buf.WriteString(`<li><a href="` + html.EscapeString(vol.FirstHref) + `">`)
+		if href, ok := coverHrefs[vol.Index]; ok {
+			buf.WriteString(`<img src="` + html.EscapeString(href) + `" alt="` + title + `"/>`)
+		}
+		buf.WriteString(title + "</a></li>\n")
+	}
+
+	buf.WriteString("</ol>\n</section>\n</body>\n</html>\n")
+	return os.WriteFile(dest, buf.Bytes(), 0o644)
+}
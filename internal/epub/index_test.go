@@ -0,0 +1,54 @@
+package epub
+
+import (
+	"context"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeEPUBsIndexPage(t *testing.T) {
+	a := buildCoverTestEPUB(t, "Vol 1", color.White)
+	b := buildCoverTestEPUB(t, "Vol 2", color.Black)
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, IndexPage: true, IndexCovers: true}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	if first := vol.PackageDoc.Spine.Itemrefs[0].IDRef; first != indexPageID {
+		t.Fatalf("first spine item = %q", first)
+	}
+	found := false
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		found = found || (item.ID == indexPageID && item.Href == indexPageHref)
+	}
+	if !found {
+		t.Fatalf("index page missing from manifest")
+	}
+	if len(vol.NavItems) != 3 || vol.NavItems[0].Href != indexPageHref {
+		t.Fatalf("nav = %+v", vol.NavItems)
+	}
+
+	data, err := os.ReadFile(filepath.Join(vol.PackageDir, indexPageHref))
+	if err != nil {
+		t.Fatalf("read index: %v", err)
+	}
+	page := string(data)
+	for _, want := range []string{
+		`<a href="Volumes/v0001/chapter.xhtml"><img src="Volumes/v0001/cover.png" alt="Vol 1"/>Vol 1</a>`,
+		`<a href="Volumes/v0002/chapter.xhtml"><img src="Volumes/v0002/cover.png" alt="Vol 2"/>Vol 2</a>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Fatalf("index page missing %s:\n%s", want, page)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// BookInfo is the summary `novfmt info` prints, and its shape when printed
+// as JSON. Fields are only ever added, so tools can rely on the existing
+// names. Hrefs are relative to the package document's directory, like
+// manifest hrefs.
+type BookInfo struct {
+	Title         string   `json:"title"`
+	Language      string   `json:"language"`
+	Identifier    string   `json:"identifier"`
+	Creators      []string `json:"creators"`
+	Version       string   `json:"version"`
+	SpineLength   int      `json:"spine_length"`
+	ManifestItems int      `json:"manifest_items"`
+	// CoverID is the manifest id of the cover image, or "" when the book
+	// doesn't declare one.
+	CoverID string `json:"cover_id,omitempty"`
+	// TOC is the nav's table of contents, or the NCX's for EPUB 2 books
+	// without a nav.
+	TOC []TOCInfo `json:"toc"`
+}
+
+// TOCInfo is one entry of BookInfo.TOC.
+type TOCInfo struct {
+	Title    string    `json:"title"`
+	Href     string    `json:"href,omitempty"`
+	Children []TOCInfo `json:"children,omitempty"`
+}
+
+// ReadBookInfo summarizes the EPUB at input.
+func ReadBookInfo(ctx context.Context, input string) (BookInfo, error) {
+	if input == "" {
+		return BookInfo{}, fmt.Errorf("input EPUB path is required")
+	}
+	book, err := OpenBook(ctx, input)
+	if err != nil {
+		return BookInfo{}, err
+	}
+	defer book.Close()
+
+	pkg := book.Package
+	meta := pkg.Metadata
+	info := BookInfo{
+		Creators:      []string{},
+		Version:       pkg.Version,
+		SpineLength:   len(pkg.Spine.Itemrefs),
+		ManifestItems: len(pkg.Manifest.Items),
+		CoverID:       book.CoverID,
+		TOC:           tocInfo(book.NavItems, path.Dir(book.NavHref)),
+	}
+	if len(meta.Titles) > 0 {
+		info.Title = strings.TrimSpace(meta.Titles[0].Value)
+	}
+	if len(meta.Languages) > 0 {
+		info.Language = strings.TrimSpace(meta.Languages[0].Value)
+	}
+	if id := uniqueIdentifier(pkg); id != nil {
+		info.Identifier = strings.TrimSpace(id.Value)
+	}
+	for _, c := range meta.Creators {
+		if name := strings.TrimSpace(c.Value); name != "" {
+			info.Creators = append(info.Creators, name)
+		}
+	}
+	return info, nil
+}
+
+// tocInfo converts nav items, with hrefs relative to navDir, to TOCInfo.
+func tocInfo(items []NavItem, navDir string) []TOCInfo {
+	out := make([]TOCInfo, 0, len(items))
+	for _, item := range items {
+		entry := TOCInfo{Title: item.Title, Href: joinHref(navDir, item.Href)}
+		if len(item.Children) > 0 {
+			entry.Children = tocInfo(item.Children, navDir)
+		}
+		out = append(out, entry)
+	}
+	return out
+}
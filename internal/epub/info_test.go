@@ -0,0 +1,56 @@
+package epub
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadBookInfo(t *testing.T) {
+	a := buildChaptersEPUB(t, "Vol 1", "One", "Two")
+	b := buildChaptersEPUB(t, "Vol 2", "Three")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Title: "Saga", Identifier: "urn:uuid:11111111-2222-3333-4444-555555555555", Creators: []string{"Author"}}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	info, err := ReadBookInfo(context.Background(), out)
+	if err != nil {
+		t.Fatalf("ReadBookInfo: %v", err)
+	}
+	if info.Title != "Saga" || info.Language != "en" || info.Identifier != "urn:uuid:11111111-2222-3333-4444-555555555555" ||
+		!reflect.DeepEqual(info.Creators, []string{"Author"}) || info.Version != "3.0" || info.SpineLength != 3 || info.ManifestItems != 4 {
+		t.Fatalf("info = %+v", info)
+	}
+	want := []TOCInfo{
+		{Title: "Vol 1", Href: "Volumes/v0001/c1.xhtml", Children: []TOCInfo{
+			{Title: "One", Href: "Volumes/v0001/c1.xhtml"},
+			{Title: "Two", Href: "Volumes/v0001/c2.xhtml"},
+		}},
+		{Title: "Vol 2", Href: "Volumes/v0002/c1.xhtml", Children: []TOCInfo{
+			{Title: "Three", Href: "Volumes/v0002/c1.xhtml"},
+		}},
+	}
+	if !reflect.DeepEqual(info.TOC, want) {
+		t.Fatalf("toc = %+v", info.TOC)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"title", "language", "identifier", "creators", "version", "spine_length", "manifest_items", "toc"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("JSON lacks %q: %s", key, data)
+		}
+	}
+	if _, ok := fields["cover_id"]; ok {
+		t.Errorf("cover_id should be omitted without a cover: %s", data)
+	}
+}
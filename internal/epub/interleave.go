@@ -0,0 +1,79 @@
+package epub
+
+import (
+	"fmt"
+	"path"
+)
+
+// interleaveSpines alternates the staged spines of two volumes (first
+// volume's document, then the second's) and returns the merged spine along
+// with one nav entry per pair. refs holds each volume's spine with merged ids;
+// idMaps and idHref map source ids to merged ids and merged ids to hrefs.
+func interleaveSpines(vols []*Volume, refs [][]SpineItemRef, idMaps []map[string]string, idHref map[string]string) ([]SpineItemRef, []NavItem, error) {
+	if len(vols) != 2 {
+		return nil, nil, fmt.Errorf("interleave needs exactly two volumes, got %d", len(vols))
+	}
+	a, b := vols[0], vols[1]
+	if len(refs[a.Index]) != len(refs[b.Index]) {
+		return nil, nil, fmt.Errorf("interleave: %s has %d spine documents but %s has %d",
+			a.SourcePath, len(refs[a.Index]), b.SourcePath, len(refs[b.Index]))
+	}
+
+	titles := make([]map[string]string, len(vols))
+	for _, vol := range vols {
+		titles[vol.Index] = navTitles(vol, idMaps[vol.Index])
+	}
+
+	var (
+		spine []SpineItemRef
+		nav   []NavItem
+	)
+	for i := range refs[a.Index] {
+		pair := NavItem{}
+		for _, vol := range []*Volume{a, b} {
+			ref := refs[vol.Index][i]
+			spine = append(spine, ref)
+
+			title, ok := titles[vol.Index][ref.IDRef]
+			if !ok {
+				title = vol.DisplayName
+			} else if pair.Title == "" {
+				pair.Title = title
+			}
+			pair.Children = append(pair.Children, NavItem{Title: title, Href: idHref[ref.IDRef]})
+		}
+		if pair.Title == "" {
+			pair.Title = fmt.Sprintf("Part %d", i+1)
+		}
+		pair.Href = pair.Children[0].Href
+		nav = append(nav, pair)
+	}
+	return spine, nav, nil
+}
+
+// navTitles maps the merged id of each document vol's nav links to the title
+// of its first nav entry.
+func navTitles(vol *Volume, idMap map[string]string) map[string]string {
+	hrefIDs := make(map[string]string)
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		hrefIDs[normalizeEPUBPath(item.Href)] = item.ID
+	}
+
+	out := make(map[string]string)
+	navDir := path.Dir(vol.NavHref)
+	var walk func(items []NavItem)
+	walk = func(items []NavItem) {
+		for _, item := range items {
+			if id, ok := navItemID(item.Href, navDir, hrefIDs); ok && item.Title != "" {
+				if newID, ok := idMap[id]; ok {
+					if _, seen := out[newID]; !seen {
+						out[newID] = item.Title
+					}
+				}
+			}
+			walk(item.Children)
+		}
+	}
+	walk(vol.NavItems)
+	return out
+}
@@ -0,0 +1,83 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildChaptersEPUB builds a book with one spine document per title, listed
+// in the nav under those titles.
+func buildChaptersEPUB(t *testing.T, book string, chapters ...string) string {
+	t.Helper()
+	items := []ManifestItem{testItem("nav", "nav.xhtml", "nav")}
+	var spine []string
+	var links strings.Builder
+	files := map[string]string{}
+	for i, title := range chapters {
+		id := fmt.Sprintf("c%d", i+1)
+		href := id + ".xhtml"
+		items = append(items, testItem(id, href))
+		spine = append(spine, id)
+		fmt.Fprintf(&links, `<li><a href="%s">%s</a></li>`, href, title)
+		files["OEBPS/"+href] = `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>` + title + `</p></body></html>`
+	}
+	files["OEBPS/content.opf"] = testOPF(testPackage{title: book, identifier: "urn:test:interleave", items: items, spine: spine})
+	files["OEBPS/nav.xhtml"] = `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol>` + links.String() + `</ol></nav></body></html>`
+	return buildTestEPUBFiles(t, files)
+}
+
+func TestMergeEPUBsInterleave(t *testing.T) {
+	jp := buildChaptersEPUB(t, "JP", "第一章", "第二章")
+	en := buildChaptersEPUB(t, "EN", "Chapter 1", "Chapter 2")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{jp, en}, MergeOptions{OutPath: out, Interleave: true}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	var ids []string
+	for _, ref := range vol.PackageDoc.Spine.Itemrefs {
+		ids = append(ids, ref.IDRef)
+	}
+	want := []string{"v0001_c1", "v0002_c1", "v0001_c2", "v0002_c2"}
+	if strings.Join(ids, " ") != strings.Join(want, " ") {
+		t.Fatalf("spine = %v want %v", ids, want)
+	}
+
+	if len(vol.NavItems) != 2 {
+		t.Fatalf("nav = %+v", vol.NavItems)
+	}
+	pair := vol.NavItems[1]
+	if pair.Title != "第二章" || len(pair.Children) != 2 {
+		t.Fatalf("pair = %+v", pair)
+	}
+	if pair.Children[1].Title != "Chapter 2" || pair.Children[1].Href != "Volumes/v0002/c2.xhtml" {
+		t.Fatalf("second child = %+v", pair.Children[1])
+	}
+}
+
+func TestMergeEPUBsInterleaveErrors(t *testing.T) {
+	a := buildChaptersEPUB(t, "A", "One", "Two")
+	b := buildChaptersEPUB(t, "B", "One")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	_, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Interleave: true})
+	if err == nil || !strings.Contains(err.Error(), "has 2 spine documents but") {
+		t.Fatalf("mismatched counts: err = %v", err)
+	}
+
+	_, err = MergeEPUBs(context.Background(), []string{a, a, a}, MergeOptions{OutPath: out, Interleave: true})
+	if err == nil || !strings.Contains(err.Error(), "exactly two") {
+		t.Fatalf("three inputs: err = %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kobo support is experimental. Kobo's reader keeps its place, highlights
+// and reading statistics by sentence spans (<span class="koboSpan"
+// id="kobo.P.S">, P counting blocks and S sentences within one); this
+// produces the same spans the kepub conversion tools do, without the other
+// Kobo-specific rewrites they apply.
+
+const kepubExt = ".kepub.epub"
+
+// kepubPath renames an output path to end in .kepub.epub, which is how Kobo
+// devices tell kepubs from plain EPUBs.
+func kepubPath(out string) string {
+	lower := strings.ToLower(out)
+	if strings.HasSuffix(lower, kepubExt) {
+		return out
+	}
+	if strings.HasSuffix(lower, ".epub") {
+		out = out[:len(out)-len(".epub")]
+	}
+	return out + kepubExt
+}
+
+// koboBlocks start a new paragraph number.
+var koboBlocks = map[string]bool{
+	"p": true, "div": true, "li": true, "blockquote": true, "pre": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"dt": true, "dd": true, "td": true, "th": true, "figcaption": true, "caption": true,
+}
+
+// koboSkip holds elements whose text must not be wrapped.
+var koboSkip = map[string]bool{
+	"head": true, "script": true, "style": true, "svg": true, "math": true, "textarea": true,
+}
+
+// addKoboSpans wraps the sentences of every XHTML spine document under
+// pkgDir in kobo spans, leaving the nav alone. It returns how many documents
+// it rewrote.
+func addKoboSpans(pkgDir string, manifest Manifest, spine Spine) (int, error) {
+	items := make(map[string]ManifestItem, len(manifest.Items))
+	for _, item := range manifest.Items {
+		items[item.ID] = item
+	}
+	n := 0
+	for _, ref := range spine.Itemrefs {
+		item, ok := items[ref.IDRef]
+		if !ok || item.MediaType != "application/xhtml+xml" || hasProperty(item.Properties, "nav") {
+			continue
+		}
+		p := filepath.Join(pkgDir, filepath.FromSlash(item.Href))
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return n, err
+		}
+		out, changed, err := koboSpanDocument(data)
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", item.Href, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := os.WriteFile(p, out, 0o644); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// koboSpanDocument re-encodes an XHTML document the way rewriteXHTMLFile
+// does, wrapping each sentence of body text in a kobo span. Documents that
+// already carry kobo spans are left unchanged.
+func koboSpanDocument(data []byte) ([]byte, bool, error) {
+	if bytes.Contains(data, []byte("koboSpan")) {
+		return data, false, nil
+	}
+
+	prolog, body := splitProlog(data)
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.Strict = false
+	dec.Entity = prologEntities(prolog)
+
+	var out bytes.Buffer
+	out.Write(prolog)
+	enc := xml.NewEncoder(&out)
+
+	var (
+		inBody bool
+		skip   int
+		para   int
+		sent   int
+		spans  int
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			switch {
+			case name == "body":
+				inBody = true
+			case skip > 0 || koboSkip[name]:
+				skip++
+			case koboBlocks[name]:
+				para++
+				sent = 0
+			}
+			t.Attr = stripXMLNSAttrs(t.Attr)
+			if err := enc.EncodeToken(t); err != nil {
+				return nil, false, err
+			}
+
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			switch {
+			case skip > 0:
+				skip--
+			case name == "body":
+				inBody = false
+			}
+			if err := enc.EncodeToken(t); err != nil {
+				return nil, false, err
+			}
+
+		case xml.CharData:
+			if !inBody || skip > 0 || strings.TrimSpace(string(t)) == "" {
+				if err := enc.EncodeToken(t); err != nil {
+					return nil, false, err
+				}
+				continue
+			}
+			if para == 0 {
+				para = 1
+			}
+			for _, s := range splitSentences(string(t)) {
+				if strings.TrimSpace(s) == "" {
+					if err := enc.EncodeToken(xml.CharData(s)); err != nil {
+						return nil, false, err
+					}
+					continue
+				}
+				sent++
+				span := xml.StartElement{
+					Name: xml.Name{Local: "span"},
+					Attr: []xml.Attr{
+						{Name: xml.Name{Local: "class"}, Value: "koboSpan"},
+						{Name: xml.Name{Local: "id"}, Value: fmt.Sprintf("kobo.%d.%d", para, sent)},
+					},
+				}
+				for _, tok := range []xml.Token{span, xml.CharData(s), span.End()} {
+					if err := enc.EncodeToken(tok); err != nil {
+						return nil, false, err
+					}
+				}
+				spans++
+			}
+
+		default:
+			if err := enc.EncodeToken(t); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, false, err
+	}
+	if spans == 0 {
+		return data, false, nil
+	}
+	return out.Bytes(), true, nil
+}
+
+// splitSentences cuts s after each run of sentence-ending punctuation (and
+// any closing quotes or brackets after it), keeping the text between
+// sentences attached to the sentence before it. Leading spaces form their
+// own piece so they stay outside the first span. CJK full stops end a
+// sentence even without a following space.
+func splitSentences(s string) []string {
+	var out []string
+	trimmed := strings.TrimLeftFunc(s, unicode.IsSpace)
+	if lead := s[:len(s)-len(trimmed)]; lead != "" {
+		out = append(out, lead)
+	}
+	s = trimmed
+
+	start := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if !isSentenceEnd(r) {
+			continue
+		}
+		for i < len(s) {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if !isSentenceEnd(r) && !strings.ContainsRune(`"'”’」』)）]`, r) {
+				break
+			}
+			i += size
+		}
+		end := i
+		for end < len(s) {
+			r, size := utf8.DecodeRuneInString(s[end:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			end += size
+		}
+		if end == i && !isCJKStop(r) && end < len(s) {
+			// "3.14" or "e.g.x": not the end of a sentence.
+			continue
+		}
+		out = append(out, s[start:end])
+		start, i = end, end
+	}
+	if start < len(s) {
+		out = append(out, s[start:])
+	}
+	return out
+}
+
+func isSentenceEnd(r rune) bool {
+	return strings.ContainsRune(".!?…", r) || isCJKStop(r)
+}
+
+func isCJKStop(r rune) bool {
+	return strings.ContainsRune("。！？", r)
+}
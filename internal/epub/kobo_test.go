@@ -0,0 +1,112 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitSentences(t *testing.T) {
+	cases := map[string][]string{
+		"One. Two! Three":         {"One. ", "Two! ", "Three"},
+		"  Lead. Pi is 3.14 here": {"  ", "Lead. ", "Pi is 3.14 here"},
+		`He said "Go." Then left`: {`He said "Go." `, "Then left"},
+		"静かだ。雨が降る。":               {"静かだ。", "雨が降る。"},
+		"Wait...":                 {"Wait..."},
+	}
+	for in, want := range cases {
+		got := splitSentences(in)
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Fatalf("%q -> %q want %q", in, got, want)
+		}
+	}
+}
+
+func TestKoboSpanDocument(t *testing.T) {
+	doc := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Title.</title></head><body>
+<h1>Chapter</h1>
+<p>First one. Second <em>word</em> ends.</p>
+<svg xmlns="http://www.w3.org/2000/svg"><text>Label.</text></svg>
+</body></html>`)
+	out, changed, err := koboSpanDocument(doc)
+	if err != nil {
+		t.Fatalf("koboSpanDocument: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected spans")
+	}
+	got := string(out)
+	for _, want := range []string{
+		`<span class="koboSpan" id="kobo.1.1">Chapter</span>`,
+		`<span class="koboSpan" id="kobo.2.1">First one. </span><span class="koboSpan" id="kobo.2.2">Second </span>`,
+		`<span class="koboSpan" id="kobo.2.3">word</span>`,
+		`</em> <span class="koboSpan" id="kobo.2.4">ends.</span>`,
+		`<title xmlns="http://www.w3.org/1999/xhtml">Title.</title>`,
+		`<text xmlns="http://www.w3.org/2000/svg">Label.</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("missing %q in:\n%s", want, got)
+		}
+	}
+
+	if _, changed, _ := koboSpanDocument(out); changed {
+		t.Fatalf("already spanned document should be left alone")
+	}
+}
+
+func TestMergeEPUBsKobo(t *testing.T) {
+	a := buildChaptersEPUB(t, "Vol 1", "Opening.")
+	b := buildChaptersEPUB(t, "Vol 2", "Closing.")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Kobo: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if want := strings.TrimSuffix(out, ".epub") + ".kepub.epub"; stats.OutPath != want {
+		t.Fatalf("out path = %q want %q", stats.OutPath, want)
+	}
+	if stats.KoboDocuments != 2 {
+		t.Fatalf("kobo documents = %d", stats.KoboDocuments)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, stats.OutPath)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	data, err := os.ReadFile(filepath.Join(vol.PackageDir, "Volumes", "v0002", "c1.xhtml"))
+	if err != nil {
+		t.Fatalf("read chapter: %v", err)
+	}
+	if !strings.Contains(string(data), `id="kobo.1.1">Closing.</span>`) {
+		t.Fatalf("chapter not spanned: %s", data)
+	}
+	nav, err := os.ReadFile(filepath.Join(vol.PackageDir, "nav.xhtml"))
+	if err != nil {
+		t.Fatalf("read nav: %v", err)
+	}
+	if strings.Contains(string(nav), "koboSpan") {
+		t.Fatalf("nav should be left alone")
+	}
+}
+
+func TestKepubPath(t *testing.T) {
+	cases := map[string]string{
+		"book.epub":       "book.kepub.epub",
+		"book.kepub.epub": "book.kepub.epub",
+		"book.EPUB":       "book.kepub.epub",
+		"book":            "book.kepub.epub",
+	}
+	for in, want := range cases {
+		if got := kepubPath(in); got != want {
+			t.Fatalf("%q -> %q want %q", in, got, want)
+		}
+	}
+	if got := partPath("book.kepub.epub", 1, 2); got != "book.part01.kepub.epub" {
+		t.Fatalf("partPath = %q", got)
+	}
+}
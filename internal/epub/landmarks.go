@@ -0,0 +1,186 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"io"
+	"path"
+	"strings"
+)
+
+// bodymatterTitle labels the book-level bodymatter landmark.
+const bodymatterTitle = "Begin Reading"
+
+// landmark is one entry of the generated landmarks nav.
+type landmark struct {
+	Type  string
+	Title string
+	Href  string
+}
+
+// frontMatterTypes are epub:type values that mark a document as coming
+// before the story.
+var frontMatterTypes = []string{
+	"cover", "titlepage", "halftitlepage", "toc", "frontmatter",
+	"copyright-page", "dedication", "epigraph", "imprint", "landmarks",
+}
+
+// isFrontMatterPage reports whether an XHTML document looks like a cover,
+// title or contents page rather than a chapter: it has a nav, its <body> or
+// a <section> is typed as front matter, or its title or first heading is a
+// customary table of contents heading.
+func isFrontMatterPage(data []byte) (bool, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var (
+		heading  strings.Builder
+		capture  string
+		headings int
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			switch {
+			case name == "nav":
+				return true, nil
+			case name == "body" || name == "section":
+				if hasEPUBType(t.Attr, frontMatterTypes...) {
+					return true, nil
+				}
+			case capture == "" && (name == "title" || (headingLevel(name) > 0 && headings == 0)):
+				capture = name
+				heading.Reset()
+			}
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			if name != capture {
+				continue
+			}
+			if isContentsHeading(heading.String()) {
+				return true, nil
+			}
+			if headingLevel(name) > 0 {
+				headings++
+			}
+			capture = ""
+		case xml.CharData:
+			if capture != "" {
+				heading.Write(t)
+			}
+		}
+	}
+}
+
+// isContentsHeading reports whether s is a table of contents heading in any
+// language tocTitles knows.
+func isContentsHeading(s string) bool {
+	s = normalizeSpace(s)
+	if s == "" {
+		return false
+	}
+	if strings.EqualFold(s, defaultTOCTitle) || strings.EqualFold(s, "Contents") {
+		return true
+	}
+	for _, title := range tocTitles {
+		if strings.EqualFold(s, title) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodymatterHref returns the package-relative href where a volume's story
+// starts: its own bodymatter landmark if it has one, or else the first
+// linear spine document that isn't the cover, the nav or other front
+// matter. It is "" when no such document is found.
+func bodymatterHref(vol *Volume) (string, error) {
+	if vol.NavHref != "" {
+		data, err := vol.readFile(vol.NavHref)
+		if err != nil {
+			return "", err
+		}
+		hrefs, err := parseLandmarks(data, "bodymatter")
+		if err != nil {
+			return "", err
+		}
+		if len(hrefs) > 0 {
+			return joinHref(path.Dir(vol.NavHref), hrefs[0]), nil
+		}
+	}
+
+	skip := make(map[string]bool)
+	covers, err := coverDocuments(vol)
+	if err != nil {
+		return "", err
+	}
+	for _, href := range covers {
+		skip[normalizeEPUBPath(href)] = true
+	}
+	items := make(map[string]ManifestItem, len(vol.PackageDoc.Manifest.Items))
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		items[item.ID] = item
+	}
+	for _, ref := range vol.PackageDoc.Spine.Itemrefs {
+		item, ok := items[vol.canonicalID(ref.IDRef)]
+		if !ok || item.MediaType != "application/xhtml+xml" || strings.EqualFold(ref.Linear, "no") {
+			continue
+		}
+		if hasProperty(item.Properties, "nav") || skip[normalizeEPUBPath(item.Href)] {
+			continue
+		}
+		data, err := vol.readFile(item.Href)
+		if err != nil {
+			return "", err
+		}
+		front, err := isFrontMatterPage(data)
+		if err != nil {
+			return "", err
+		}
+		if !front {
+			return item.Href, nil
+		}
+	}
+	return "", nil
+}
+
+// volumeLandmarks lists the generated nav's landmarks: the table of
+// contents, then a bodymatter entry for each volume's first chapter
+// (hrefs maps volume index to merged href), with the first volume's
+// labelled as where the book starts.
+func volumeLandmarks(vols []*Volume, hrefs map[int]string, tocTitle string) []landmark {
+	out := []landmark{{Type: "toc", Title: tocTitle, Href: "nav.xhtml#toc"}}
+	for _, vol := range vols {
+		href := hrefs[vol.Index]
+		if href == "" {
+			continue
+		}
+		title := vol.DisplayName
+		if len(out) == 1 {
+			title = bodymatterTitle
+		}
+		out = append(out, landmark{Type: "bodymatter", Title: title, Href: href})
+	}
+	return out
+}
+
+// writeLandmarks writes a hidden landmarks nav for the generated nav.
+func writeLandmarks(buf *bytes.Buffer, landmarks []landmark) {
+	buf.WriteString(`<nav epub:type="landmarks" id="landmarks" hidden="hidden">` + "\n")
+	buf.WriteString("<h2>Landmarks</h2>\n<ol>\n")
+	for _, l := range landmarks {
+		buf.WriteString(`<li><a epub:type="` + l.Type + `" href="` + html.EscapeString(l.Href) + `">` + html.EscapeString(l.Title) + "</a></li>\n")
+	}
+	buf.WriteString("</ol>\n</nav>\n")
+}
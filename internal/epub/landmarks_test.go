@@ -0,0 +1,101 @@
+package epub
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsFrontMatterPage(t *testing.T) {
+	cases := map[string]bool{
+		`<html><body><h1>Chapter 1</h1><p>Text</p></body></html>`:                                                                    false,
+		`<html><head><title>Contents</title></head><body><p>1. Start</p></body></html>`:                                              true,
+		`<html><body><h2>目次</h2><p>第一章</p></body></html>`:                                                                            true,
+		`<html><body><h1>Chapter 1</h1><h2>Table of Contents</h2></body></html>`:                                                     false,
+		`<html><body><nav><ol><li>x</li></ol></nav></body></html>`:                                                                   true,
+		`<html xmlns:epub="http://www.idpf.org/2007/ops"><body epub:type="frontmatter"></body></html>`:                               true,
+		`<html xmlns:epub="http://www.idpf.org/2007/ops"><body><section epub:type="copyright-page"><p>©</p></section></body></html>`: true,
+		`<html xmlns:epub="http://www.idpf.org/2007/ops"><body><section epub:type="chapter"><p>x</p></section></body></html>`:        false,
+	}
+	for doc, want := range cases {
+		got, err := isFrontMatterPage([]byte(doc))
+		if err != nil {
+			t.Fatalf("isFrontMatterPage(%s): %v", doc, err)
+		}
+		if got != want {
+			t.Errorf("isFrontMatterPage(%s) = %v want %v", doc, got, want)
+		}
+	}
+}
+
+func buildLandmarksEPUB(t *testing.T, title, landmarks string) string {
+	t.Helper()
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      title,
+			identifier: "urn:test:landmarks",
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				testItem("img", "cover.jpg", "cover-image"),
+				testItem("cover", "cover.xhtml"),
+				testItem("toc", "toc.xhtml"),
+				testItem("c1", "c1.xhtml"),
+				testItem("c2", "c2.xhtml"),
+			},
+			spine: []string{"cover", "toc", "c1", "c2"},
+		}),
+		"OEBPS/nav.xhtml":   `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="c1.xhtml">One</a></li><li><a href="c2.xhtml">Two</a></li></ol></nav>` + landmarks + `</body></html>`,
+		"OEBPS/cover.jpg":   "jpeg",
+		"OEBPS/cover.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><img src="cover.jpg" alt=""/></body></html>`,
+		"OEBPS/toc.xhtml":   `<html xmlns="http://www.w3.org/1999/xhtml"><body><h1>Contents</h1><p>One</p><p>Two</p></body></html>`,
+		"OEBPS/c1.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><body><h1>One</h1></body></html>`,
+		"OEBPS/c2.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><body><h1>Two</h1></body></html>`,
+	})
+}
+
+func TestMergeEPUBsLandmarks(t *testing.T) {
+	a := buildLandmarksEPUB(t, "Vol 1", "")
+	b := buildLandmarksEPUB(t, "Vol 2", `<nav epub:type="landmarks"><ol><li><a epub:type="bodymatter" href="c2.xhtml">Start</a></li></ol></nav>`)
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Landmarks: true}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	nav, err := book.ReadFile(book.NavHref)
+	if err != nil {
+		t.Fatalf("read nav: %v", err)
+	}
+	hrefs, err := parseLandmarks(nav, "bodymatter")
+	if err != nil {
+		t.Fatalf("parseLandmarks: %v", err)
+	}
+	if strings.Join(hrefs, " ") != "Volumes/v0001/c1.xhtml Volumes/v0002/c2.xhtml" {
+		t.Fatalf("bodymatter = %v in\n%s", hrefs, nav)
+	}
+	for _, want := range []string{`<a epub:type="toc" href="nav.xhtml#toc">`, `>` + bodymatterTitle + `</a>`, `>Vol 2</a>`} {
+		if !strings.Contains(string(nav), want) {
+			t.Fatalf("nav missing %s:\n%s", want, nav)
+		}
+	}
+	if items, err := parseNavDocument(nav); err != nil || len(items) != 2 {
+		t.Fatalf("toc nav = %+v, %v", items, err)
+	}
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	book2, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book2.Close()
+	if nav, _ := book2.ReadFile(book2.NavHref); strings.Contains(string(nav), "landmarks") {
+		t.Fatalf("landmarks written without the option:\n%s", nav)
+	}
+}
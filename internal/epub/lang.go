@@ -0,0 +1,167 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// NormalizeLanguage returns the canonical BCP 47 form of a language code,
+// e.g. "EN" -> "en", "en_US" -> "en-US". Codes that aren't valid tags, such
+// as "english", are an error.
+func NormalizeLanguage(s string) (string, error) {
+	raw := strings.ReplaceAll(strings.TrimSpace(s), "_", "-")
+	if raw == "" {
+		return "", fmt.Errorf("empty language tag")
+	}
+	tag, err := language.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid language tag %q: %w", s, err)
+	}
+	return tag.String(), nil
+}
+
+// canonicalLanguage is NormalizeLanguage for values that were already
+// accepted: unparseable tags are kept as given.
+func canonicalLanguage(s string) string {
+	if norm, err := NormalizeLanguage(s); err == nil {
+		return norm
+	}
+	return strings.TrimSpace(s)
+}
+
+const defaultTOCTitle = "Table of Contents"
+
+// tocTitles holds the customary table of contents heading by base language.
+// Chinese is split by script below.
+var tocTitles = map[string]string{
+	"de": "Inhalt",
+	"es": "Índice",
+	"fr": "Table des matières",
+	"it": "Indice",
+	"ja": "目次",
+	"ko": "목차",
+	"pt": "Sumário",
+	"ru": "Содержание",
+	"zh": "目录",
+}
+
+// tocTitleFor returns the table of contents heading for a language code,
+// or the English one when the language is empty or not in tocTitles.
+func tocTitleFor(lang string) string {
+	if strings.TrimSpace(lang) == "" {
+		return defaultTOCTitle
+	}
+	tag, err := language.Parse(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+	if err != nil {
+		return defaultTOCTitle
+	}
+	base, _ := tag.Base()
+	if base.String() == "zh" {
+		if script, _ := tag.Script(); script.String() == "Hant" {
+			return "目錄"
+		}
+	}
+	if title, ok := tocTitles[base.String()]; ok {
+		return title
+	}
+	return defaultTOCTitle
+}
+
+// volumeLanguages returns each volume's dc:language by index when the
+// volumes don't all share a base language, as in a bilingual collection, and
+// nil otherwise. Volumes without a language are left out.
+func volumeLanguages(vols []*Volume) map[int]string {
+	langs := make(map[int]string, len(vols))
+	bases := make(map[string]bool)
+	for _, vol := range vols {
+		if len(vol.PackageDoc.Metadata.Languages) == 0 {
+			continue
+		}
+		lang := canonicalLanguage(vol.PackageDoc.Metadata.Languages[0].Value)
+		if lang == "" {
+			continue
+		}
+		langs[vol.Index] = lang
+		base := strings.ToLower(lang)
+		if tag, err := language.Parse(lang); err == nil {
+			b, _ := tag.Base()
+			base = b.String()
+		}
+		bases[base] = true
+	}
+	if len(bases) < 2 {
+		return nil
+	}
+	return langs
+}
+
+// tagVolumeLanguage gives the root element of each of a volume's content
+// documents lang and xml:lang attributes for lang, so readers hyphenate and
+// speak it in its own language inside a book of another. Documents that
+// already declare a language on their root are left alone. It returns how
+// many documents were tagged.
+func tagVolumeLanguage(vol *Volume, lang string) (int, error) {
+	total := 0
+	for _, p := range volumeContentDocs(vol) {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return total, err
+		}
+		out, changed, err := tagDocumentLanguage(data, lang)
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", filepath.Base(p), err)
+		}
+		if !changed {
+			continue
+		}
+		if err := os.WriteFile(p, out, 0o644); err != nil {
+			return total, err
+		}
+		total++
+	}
+	return total, nil
+}
+
+// tagDocumentLanguage adds lang and xml:lang attributes to the root element
+// of an XHTML document unless it has either, copying the rest byte for byte.
+func tagDocumentLanguage(data []byte, lang string) ([]byte, bool, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			return data, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		for _, attr := range el.Attr {
+			if attr.Name.Local == "lang" {
+				return data, false, nil
+			}
+		}
+		end := int(dec.InputOffset()) - 1
+		if end > int(start) && data[end-1] == '/' {
+			end--
+		}
+		value := html.EscapeString(lang)
+		var out bytes.Buffer
+		out.Write(data[:end])
+		out.WriteString(` lang="` + value + `" xml:lang="` + value + `"`)
+		out.Write(data[end:])
+		return out.Bytes(), true, nil
+	}
+}
@@ -0,0 +1,112 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeLanguage(t *testing.T) {
+	cases := map[string]string{
+		"en":         "en",
+		"EN":         "en",
+		"en_US":      "en-US",
+		" ja-jp ":    "ja-JP",
+		"zh-hant-tw": "zh-Hant-TW",
+	}
+	for in, want := range cases {
+		got, err := NormalizeLanguage(in)
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("%q -> %q want %q", in, got, want)
+		}
+	}
+	for _, bad := range []string{"", "english", "en--US", "12"} {
+		if _, err := NormalizeLanguage(bad); err == nil {
+			t.Fatalf("%q: expected error", bad)
+		}
+	}
+	if got := canonicalLanguage("english"); got != "english" {
+		t.Fatalf("canonicalLanguage kept %q", got)
+	}
+}
+
+func TestTOCTitleFor(t *testing.T) {
+	cases := map[string]string{
+		"":        "Table of Contents",
+		"en-GB":   "Table of Contents",
+		"ja":      "目次",
+		"zh-CN":   "目录",
+		"zh-TW":   "目錄",
+		"fr_CA":   "Table des matières",
+		"xx-nope": "Table of Contents",
+	}
+	for in, want := range cases {
+		if got := tocTitleFor(in); got != want {
+			t.Fatalf("%q -> %q want %q", in, got, want)
+		}
+	}
+}
+
+func TestTagDocumentLanguage(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{`<?xml version="1.0"?><html xmlns="http://www.w3.org/1999/xhtml"><body/></html>`,
+			`<?xml version="1.0"?><html xmlns="http://www.w3.org/1999/xhtml" lang="ja" xml:lang="ja"><body/></html>`},
+		{`<html/>`, `<html lang="ja" xml:lang="ja"/>`},
+		{`<html xml:lang="en"><body/></html>`, `<html xml:lang="en"><body/></html>`},
+	} {
+		out, _, err := tagDocumentLanguage([]byte(tc.in), "ja")
+		if err != nil || string(out) != tc.want {
+			t.Errorf("tagDocumentLanguage(%q) = %q, %v", tc.in, out, err)
+		}
+	}
+}
+
+func TestMergeEPUBsTagsVolumeLanguages(t *testing.T) {
+	en := buildTestEPUB(t, "Vol 1", "en")
+	enUS := buildTestEPUB(t, "Vol 2", "en_US")
+	ja := buildTestEPUB(t, "Vol 3", "ja")
+
+	vols := []*Volume{
+		{Index: 0, PackageDoc: &PackageDocument{Metadata: Metadata{Languages: []DCMeta{{Value: "en"}}}}},
+		{Index: 1, PackageDoc: &PackageDocument{Metadata: Metadata{Languages: []DCMeta{{Value: "en_US"}}}}},
+	}
+	if got := volumeLanguages(vols); got != nil {
+		t.Fatalf("same base language: %q", got)
+	}
+
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	stats, err := MergeEPUBs(context.Background(), []string{en, enUS, ja}, MergeOptions{OutPath: out})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if stats.LanguageTagged != 3 {
+		t.Fatalf("LanguageTagged = %d", stats.LanguageTagged)
+	}
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	for href, want := range map[string]string{
+		"Volumes/v0002/chapter.xhtml": `<html lang="en-US" xml:lang="en-US">`,
+		"Volumes/v0003/chapter.xhtml": `<html lang="ja" xml:lang="ja">`,
+	} {
+		data, err := vol.readFile(href)
+		if err != nil {
+			t.Fatalf("read %s: %v", href, err)
+		}
+		if !strings.HasPrefix(string(data), want) {
+			t.Errorf("%s = %q", href, data)
+		}
+	}
+
+	out = filepath.Join(t.TempDir(), "same.epub")
+	if stats, err := MergeEPUBs(context.Background(), []string{en, enUS}, MergeOptions{OutPath: out}); err != nil || stats.LanguageTagged != 0 {
+		t.Fatalf("same language: tagged %d, %v", stats.LanguageTagged, err)
+	}
+}
@@ -0,0 +1,168 @@
+package epub
+
+import (
+	"html"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Reference rewriting works on the raw text rather than an xml token stream:
+// re-encoding a document through encoding/xml rewrites namespace prefixes and
+// entity escapes, and only the attribute values need to change. Inline SVG
+// binds the XLink namespace to whatever prefix the document declares, so any
+// prefix on href is accepted.
+
+var (
+	attrRefPattern = regexp.MustCompile(`(?i)(\s(?:[a-z_][\w.-]*:)?(?:href|src|poster|data)\s*=\s*)("[^"]*"|'[^']*')`)
+	cssURLPattern  = regexp.MustCompile(`(?i)(url\(\s*)("[^"]*"|'[^']*'|[^)"'\s]+)(\s*\))`)
+	cssImportPat   = regexp.MustCompile(`(?i)(@import\s+)("[^"]*"|'[^']*')`)
+)
+
+// rewriteRefs rewrites the resource references in an XHTML, SVG or CSS
+// document at docHref. Each relative reference is resolved against the
+// document's directory and looked up in moved (old path -> new path, both
+// relative to the same root); matches are replaced with a path relative to
+// the document, keeping any fragment. It reports whether anything changed.
+func rewriteRefs(data []byte, docHref string, isCSS bool, moved map[string]string) ([]byte, bool) {
+	return rebaseRefs(data, docHref, docHref, isCSS, moved)
+}
+
+// rebaseRefs is rewriteRefs for a document being moved from fromHref to
+// toHref: references are resolved against the old location and rewritten
+// relative to the new one.
+func rebaseRefs(data []byte, fromHref, toHref string, isCSS bool, moved map[string]string) ([]byte, bool) {
+	fromDir := path.Dir(normalizeEPUBPath(fromHref))
+	toDir := path.Dir(normalizeEPUBPath(toHref))
+	changed := false
+
+	replace := func(pat *regexp.Regexp, src []byte, escape bool) []byte {
+		return pat.ReplaceAllFunc(src, func(m []byte) []byte {
+			sub := pat.FindSubmatch(m)
+			val := string(sub[2])
+			quote := ""
+			if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') {
+				quote = val[:1]
+				val = val[1 : len(val)-1]
+			}
+			ref := val
+			if escape {
+				ref = html.UnescapeString(ref)
+			}
+			newRef, ok := movedRef(ref, fromDir, toDir, moved)
+			if !ok {
+				return m
+			}
+			if escape {
+				newRef = html.EscapeString(newRef)
+			}
+			changed = true
+			out := append([]byte(nil), sub[1]...)
+			out = append(out, quote+newRef+quote...)
+			if len(sub) > 3 {
+				out = append(out, sub[3]...)
+			}
+			return out
+		})
+	}
+
+	if isCSS {
+		data = replace(cssURLPattern, data, false)
+		data = replace(cssImportPat, data, false)
+	} else {
+		data = replace(attrRefPattern, data, true)
+		data = replace(cssURLPattern, data, true)
+	}
+	return data, changed
+}
+
+// resolveRef resolves a relative reference in a document in docDir to a
+// normalized path from the same root, without its fragment. References that
+// aren't relative resolve to "".
+func resolveRef(docDir, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "/") || isAbsoluteURL(ref) {
+		return ""
+	}
+	if i := strings.IndexByte(ref, '#'); i >= 0 {
+		ref = ref[:i]
+	}
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		ref = unescaped
+	}
+	return normalizeEPUBPath(path.Join(docDir, ref))
+}
+
+// localRefs returns the targets of the relative references in an XHTML, SVG
+// or CSS document at docHref, resolved to paths relative to the same root as
+// docHref and without fragments.
+func localRefs(data []byte, docHref string, isCSS bool) []string {
+	docDir := path.Dir(normalizeEPUBPath(docHref))
+	var out []string
+	collect := func(pat *regexp.Regexp, escaped bool) {
+		for _, sub := range pat.FindAllSubmatch(data, -1) {
+			ref := string(sub[2])
+			if escaped {
+				ref = html.UnescapeString(ref)
+			}
+			if target := resolveRef(docDir, strings.Trim(ref, `"'`)); target != "" {
+				out = append(out, target)
+			}
+		}
+	}
+	if isCSS {
+		collect(cssURLPattern, false)
+		collect(cssImportPat, false)
+	} else {
+		collect(attrRefPattern, true)
+		collect(cssURLPattern, true)
+	}
+	return out
+}
+
+// movedRef maps one reference, resolved against fromDir, through moved,
+// returning the rewritten reference relative to toDir.
+func movedRef(ref, fromDir, toDir string, moved map[string]string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	resolved := resolveRef(fromDir, ref)
+	if resolved == "" {
+		return "", false
+	}
+	frag := ""
+	if i := strings.IndexByte(ref, '#'); i >= 0 {
+		frag = ref[i:]
+	}
+	target, ok := moved[resolved]
+	if !ok {
+		return "", false
+	}
+	rel, err := filepath.Rel(filepath.FromSlash(toDir), filepath.FromSlash(target))
+	if err != nil {
+		return "", false
+	}
+	return (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath() + frag, true
+}
+
+// isAbsoluteURL reports whether ref starts with a URL scheme such as
+// "https:" or "data:".
+func isAbsoluteURL(ref string) bool {
+	for i, r := range ref {
+		switch {
+		case r == ':':
+			return i > 0
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// hasRefs reports whether a document contains any resource reference the
+// rewriter would look at.
+func hasRefs(data []byte) bool {
+	return attrRefPattern.Match(data) || cssURLPattern.Match(data) || cssImportPat.Match(data)
+}
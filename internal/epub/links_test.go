@@ -0,0 +1,52 @@
+package epub
+
+import "testing"
+
+func TestRewriteRefs(t *testing.T) {
+	moved := map[string]string{
+		"Volumes/v0002/Images/orn 1.png": "Volumes/v0001/Images/orn 1.png",
+		"Volumes/v0002/Images/bg.jpg":    "Volumes/v0001/Images/bg.jpg",
+	}
+
+	doc := []byte(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:xlink="http://www.w3.org/1999/xlink"><body style="background: url('../Images/bg.jpg')">
+<img src="../Images/orn%201.png" alt="x"/><a href="../Images/bg.jpg#frag">bg</a>
+<svg><image xlink:href='../Images/bg.jpg'/></svg><img src="https://example.com/Images/bg.jpg"/><a href="#top">top</a>
+<svg xmlns:xl="http://www.w3.org/1999/xlink"><image xl:href="../Images/bg.jpg"/><image href="../Images/orn%201.png"/></svg>
+</body></html>`)
+	got, changed := rewriteRefs(doc, "Volumes/v0002/Text/ch1.xhtml", false, moved)
+	if !changed {
+		t.Fatalf("expected changes")
+	}
+	want := `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:xlink="http://www.w3.org/1999/xlink"><body style="background: url('../../v0001/Images/bg.jpg')">
+<img src="../../v0001/Images/orn%201.png" alt="x"/><a href="../../v0001/Images/bg.jpg#frag">bg</a>
+<svg><image xlink:href='../../v0001/Images/bg.jpg'/></svg><img src="https://example.com/Images/bg.jpg"/><a href="#top">top</a>
+<svg xmlns:xl="http://www.w3.org/1999/xlink"><image xl:href="../../v0001/Images/bg.jpg"/><image href="../../v0001/Images/orn%201.png"/></svg>
+</body></html>`
+	if string(got) != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+
+	css := []byte(`body { background: url(../Images/bg.jpg) } @import "other.css"; .x { background: url("data:image/png;base64,AAAA") }`)
+	got, changed = rewriteRefs(css, "Volumes/v0002/Styles/main.css", true, moved)
+	if !changed || string(got) != `body { background: url(../../v0001/Images/bg.jpg) } @import "other.css"; .x { background: url("data:image/png;base64,AAAA") }` {
+		t.Fatalf("css = %s", got)
+	}
+
+	if _, changed := rewriteRefs([]byte(`<img src="../Images/other.png"/>`), "Volumes/v0002/Text/ch1.xhtml", false, moved); changed {
+		t.Fatalf("unrelated reference should not change")
+	}
+}
+
+func TestLocalRefs(t *testing.T) {
+	doc := []byte(`<link href="../styles/a%20b.css"/><img src="img.png#x"/><a href="#top"/><a href="https://example.com/"/><div style="background: url(&quot;bg.png&quot;)"/>`)
+	got := localRefs(doc, "Text/page.xhtml", false)
+	want := []string{"styles/a b.css", "Text/img.png", "Text/bg.png"}
+	if len(got) != len(want) {
+		t.Fatalf("refs = %q want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("refs = %q want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type MarkdownOptions struct {
+	// Href selects one content document by manifest href. A bare file name
+	// matches when it identifies a single document.
+	Href string
+	// All converts every spine document in reading order instead.
+	All bool
+}
+
+// ExportMarkdown converts one content document (or with opts.All, every
+// spine document) of an EPUB to Markdown.
+func ExportMarkdown(ctx context.Context, input string, opts MarkdownOptions) (string, error) {
+	if input == "" {
+		return "", fmt.Errorf("input EPUB path is required")
+	}
+	if opts.All == (opts.Href != "") {
+		return "", fmt.Errorf("give either a document href or all")
+	}
+
+	book, err := OpenBook(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	defer book.Close()
+
+	var hrefs []string
+	if opts.All {
+		items := make(map[string]ManifestItem)
+		for _, item := range book.Package.Manifest.Items {
+			items[item.ID] = item
+		}
+		for _, ref := range book.Package.Spine.Itemrefs {
+			if item, ok := items[ref.IDRef]; ok && item.MediaType == "application/xhtml+xml" {
+				hrefs = append(hrefs, item.Href)
+			}
+		}
+	} else {
+		href, err := findDocumentHref(book.Package.Manifest, opts.Href)
+		if err != nil {
+			return "", err
+		}
+		hrefs = []string{href}
+	}
+
+	var parts []string
+	for _, href := range hrefs {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		data, err := book.ReadFile(href)
+		if err != nil {
+			return "", err
+		}
+		md, err := xhtmlToMarkdown(data)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", href, err)
+		}
+		if md != "" {
+			parts = append(parts, md)
+		}
+	}
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return strings.Join(parts, "\n\n") + "\n", nil
+}
+
+// findDocumentHref resolves want to the href of an XHTML manifest item,
+// trying an exact package-relative match first and then a unique match on
+// the trailing path.
+func findDocumentHref(manifest Manifest, want string) (string, error) {
+	want = normalizeEPUBPath(want)
+	var suffix []string
+	for _, item := range manifest.Items {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		href := normalizeEPUBPath(item.Href)
+		if href == want {
+			return item.Href, nil
+		}
+		if strings.HasSuffix(href, "/"+want) {
+			suffix = append(suffix, item.Href)
+		}
+	}
+	switch len(suffix) {
+	case 0:
+		return "", fmt.Errorf("no content document %q in the manifest", want)
+	case 1:
+		return suffix[0], nil
+	}
+	return "", fmt.Errorf("%q is ambiguous: %s", want, strings.Join(suffix, ", "))
+}
+
+type mdList struct {
+	ordered bool
+	n       int
+}
+
+// mdWriter accumulates Markdown blocks while walking an XHTML token stream.
+type mdWriter struct {
+	blocks []string
+	cur    strings.Builder
+	// prefix is written before the current block: heading marks or a list
+	// marker.
+	prefix string
+	lists  []mdList
+	links  []string
+	quote  int
+	pre    int
+}
+
+// xhtmlToMarkdown converts the body of an XHTML document to Markdown:
+// headings, paragraphs, emphasis, links, images (img and SVG image),
+// lists, block quotes, rules and preformatted text. Other elements
+// contribute only their text.
+func xhtmlToMarkdown(data []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	w := &mdWriter{}
+	skip := 0
+	inBody := !bytes.Contains(bytes.ToLower(data), []byte("<body"))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			switch {
+			case name == "body":
+				inBody = true
+			case skip > 0 || name == "head" || name == "script" || name == "style":
+				skip++
+			case inBody:
+				w.start(name, t.Attr)
+			}
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			switch {
+			case skip > 0:
+				skip--
+			case name == "body":
+				inBody = false
+			case inBody:
+				w.end(name)
+			}
+		case xml.CharData:
+			if inBody && skip == 0 {
+				w.text(string(t))
+			}
+		}
+	}
+	w.flush()
+	return strings.Join(w.blocks, "\n\n"), nil
+}
+
+func (w *mdWriter) start(name string, attrs []xml.Attr) {
+	switch name {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		w.block()
+		w.prefix = strings.Repeat("#", int(name[1]-'0')) + " "
+	case "p", "div", "section", "article", "figure", "figcaption", "dt", "dd":
+		w.block()
+	case "blockquote":
+		w.flush()
+		w.quote++
+	case "ul", "ol":
+		w.flush()
+		w.lists = append(w.lists, mdList{ordered: name == "ol"})
+	case "li":
+		w.flush()
+		marker := "- "
+		if n := len(w.lists); n > 0 {
+			l := &w.lists[n-1]
+			if l.ordered {
+				l.n++
+				marker = fmt.Sprintf("%d. ", l.n)
+			}
+			marker = strings.Repeat("  ", n-1) + marker
+		}
+		w.prefix = marker
+	case "pre":
+		w.flush()
+		w.pre++
+	case "hr":
+		w.flush()
+		w.blocks = append(w.blocks, w.quoted("---"))
+	case "br":
+		w.cur.WriteString("  \n")
+	case "em", "i", "cite":
+		w.cur.WriteString("*")
+	case "strong", "b":
+		w.cur.WriteString("**")
+	case "code":
+		if w.pre == 0 {
+			w.cur.WriteString("`")
+		}
+	case "a":
+		href := attrValue(attrs, "href")
+		w.links = append(w.links, href)
+		if href != "" {
+			w.cur.WriteString("[")
+		}
+	case "img":
+		src := attrValue(attrs, "src")
+		if src != "" {
+			fmt.Fprintf(&w.cur, "![%s](%s)", escapeMarkdown(attrValue(attrs, "alt")), src)
+		}
+	case "image":
+		// An SVG image, as in an inline <svg> illustration page. attrValue
+		// ignores the namespace, so href and xlink:href both match.
+		if href := attrValue(attrs, "href"); href != "" {
+			fmt.Fprintf(&w.cur, "![](%s)", href)
+		}
+	}
+}
+
+func (w *mdWriter) end(name string) {
+	switch name {
+	case "h1", "h2", "h3", "h4", "h5", "h6", "p", "div", "section", "article", "figure", "figcaption", "dt", "dd", "li":
+		w.flush()
+	case "blockquote":
+		w.flush()
+		if w.quote > 0 {
+			w.quote--
+		}
+	case "ul", "ol":
+		w.flush()
+		if n := len(w.lists); n > 0 {
+			w.lists = w.lists[:n-1]
+		}
+	case "pre":
+		w.flush()
+		if w.pre > 0 {
+			w.pre--
+		}
+	case "em", "i", "cite":
+		w.cur.WriteString("*")
+	case "strong", "b":
+		w.cur.WriteString("**")
+	case "code":
+		if w.pre == 0 {
+			w.cur.WriteString("`")
+		}
+	case "a":
+		if n := len(w.links); n > 0 {
+			if href := w.links[n-1]; href != "" {
+				fmt.Fprintf(&w.cur, "](%s)", href)
+			}
+			w.links = w.links[:n-1]
+		}
+	}
+}
+
+func (w *mdWriter) text(s string) {
+	if w.pre > 0 {
+		w.cur.WriteString(s)
+		return
+	}
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if s != "" && w.cur.Len() > 0 {
+			w.space()
+		}
+		return
+	}
+	if isSpaceByte(s[0]) {
+		w.space()
+	}
+	w.cur.WriteString(escapeMarkdown(strings.Join(words, " ")))
+	if isSpaceByte(s[len(s)-1]) {
+		w.space()
+	}
+}
+
+func (w *mdWriter) space() {
+	cur := w.cur.String()
+	if cur != "" && !strings.HasSuffix(cur, " ") && !strings.HasSuffix(cur, "\n") {
+		w.cur.WriteByte(' ')
+	}
+}
+
+// block starts a new block, keeping a pending list marker when nothing has
+// been written since it (as in <li><p>...).
+func (w *mdWriter) block() {
+	if strings.TrimSpace(w.cur.String()) != "" {
+		w.flush()
+	}
+}
+
+func (w *mdWriter) flush() {
+	text := w.cur.String()
+	w.cur.Reset()
+	prefix := w.prefix
+	w.prefix = ""
+	if w.pre > 0 {
+		text = strings.Trim(text, "\n")
+		if strings.TrimSpace(text) != "" {
+			w.blocks = append(w.blocks, w.quoted("```\n"+text+"\n```"))
+		}
+		return
+	}
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	text = strings.Join(lines, "  \n")
+	w.blocks = append(w.blocks, w.quoted(prefix+text))
+}
+
+// quoted prefixes every line of s with the current block quote depth.
+func (w *mdWriter) quoted(s string) string {
+	if w.quote == 0 {
+		return s
+	}
+	marks := strings.Repeat("> ", w.quote)
+	return marks + strings.ReplaceAll(s, "\n", "\n"+marks)
+}
+
+func isSpaceByte(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`")
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, attr := range attrs {
+		if attr.Name.Local == name {
+			return strings.TrimSpace(attr.Value)
+		}
+	}
+	return ""
+}
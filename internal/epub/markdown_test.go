@@ -0,0 +1,97 @@
+package epub
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestXHTMLToMarkdown(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>Ignored</title><style>p { margin: 0 }</style></head>
+<body>
+  <h2>Chapter <b>One</b></h2>
+  <p>She said <em>hello</em> and left&nbsp;— see <a href="notes.xhtml#n1">note 1</a>.</p>
+  <p>Line one<br/>line two</p>
+  <div><img src="../Images/map.png" alt="A map"/></div>
+  <svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><image xlink:href="../Images/plate.jpg"/></svg>
+  <ul>
+    <li>first</li>
+    <li><p>second</p>
+      <ol><li>nested</li></ol>
+    </li>
+  </ul>
+  <blockquote><p>quoted *text*</p></blockquote>
+  <hr/>
+  <pre>  keep
+    spacing</pre>
+</body>
+</html>`
+
+	got, err := xhtmlToMarkdown([]byte(doc))
+	if err != nil {
+		t.Fatalf("xhtmlToMarkdown: %v", err)
+	}
+	want := strings.Join([]string{
+		"## Chapter **One**",
+		"She said *hello* and left — see [note 1](notes.xhtml#n1).",
+		"Line one  \nline two",
+		"![A map](../Images/map.png)",
+		"![](../Images/plate.jpg)",
+		"- first",
+		"- second",
+		"  1. nested",
+		`> quoted \*text\*`,
+		"---",
+		"```\n  keep\n    spacing\n```",
+	}, "\n\n")
+	if got != want {
+		t.Fatalf("got:\n%s\n\nwant:\n%s", got, want)
+	}
+}
+
+func TestExportMarkdown(t *testing.T) {
+	input := buildChaptersEPUB(t, "Book", "One", "Two")
+
+	got, err := ExportMarkdown(context.Background(), input, MarkdownOptions{Href: "c2.xhtml"})
+	if err != nil {
+		t.Fatalf("ExportMarkdown: %v", err)
+	}
+	if got != "Two\n" {
+		t.Fatalf("single document = %q", got)
+	}
+
+	got, err = ExportMarkdown(context.Background(), input, MarkdownOptions{All: true})
+	if err != nil {
+		t.Fatalf("ExportMarkdown all: %v", err)
+	}
+	if got != "One\n\nTwo\n" {
+		t.Fatalf("all documents = %q", got)
+	}
+
+	if _, err := ExportMarkdown(context.Background(), input, MarkdownOptions{Href: "missing.xhtml"}); err == nil {
+		t.Fatalf("expected error for unknown href")
+	}
+}
+
+func TestFindDocumentHref(t *testing.T) {
+	manifest := Manifest{Items: []ManifestItem{
+		{Href: "Text/a/ch1.xhtml", MediaType: "application/xhtml+xml"},
+		{Href: "Text/b/ch1.xhtml", MediaType: "application/xhtml+xml"},
+		{Href: "Text/ch2.xhtml", MediaType: "application/xhtml+xml"},
+		{Href: "Images/ch3.xhtml", MediaType: "image/png"},
+	}}
+	if got, err := findDocumentHref(manifest, "ch2.xhtml"); err != nil || got != "Text/ch2.xhtml" {
+		t.Fatalf("ch2: %q, %v", got, err)
+	}
+	if got, err := findDocumentHref(manifest, "Text/a/ch1.xhtml"); err != nil || got != "Text/a/ch1.xhtml" {
+		t.Fatalf("exact: %q, %v", got, err)
+	}
+	if _, err := findDocumentHref(manifest, "ch1.xhtml"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Fatalf("ambiguous: %v", err)
+	}
+	if _, err := findDocumentHref(manifest, "ch3.xhtml"); err == nil {
+		t.Fatalf("non-XHTML item should not match")
+	}
+}
@@ -0,0 +1,194 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MediaTypeFix records a manifest item whose media-type was missing or not
+// in its canonical form. From is what the item had; MediaType is the
+// canonical or inferred type, or empty when none could be determined and
+// the item was left as it was.
+type MediaTypeFix struct {
+	Source    string
+	ID        string
+	Href      string
+	From      string
+	MediaType string
+}
+
+type FixMediaTypesOptions struct {
+	OutPath string
+	DryRun  bool
+}
+
+var extMediaTypes = map[string]string{
+	".xhtml": "application/xhtml+xml",
+	".xht":   "application/xhtml+xml",
+	".html":  "application/xhtml+xml",
+	".htm":   "application/xhtml+xml",
+	".css":   "text/css",
+	".js":    "text/javascript",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".png":   "image/png",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".svg":   "image/svg+xml",
+	".ncx":   "application/x-dtbncx+xml",
+	".smil":  "application/smil+xml",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".mp3":   "audio/mpeg",
+	".m4a":   "audio/mp4",
+	".aac":   "audio/aac",
+	".ogg":   "audio/ogg",
+	".oga":   "audio/ogg",
+	".opus":  "audio/opus",
+	".mp4":   "video/mp4",
+	".m4v":   "video/mp4",
+	".webm":  "video/webm",
+	".pls":   "application/pls+xml",
+}
+
+// mediaTypeAliases maps media types seen in the wild to the ones EPUB
+// names for the same formats.
+var mediaTypeAliases = map[string]string{
+	"image/jpg":                   "image/jpeg",
+	"image/pjpeg":                 "image/jpeg",
+	"image/x-png":                 "image/png",
+	"text/html":                   "application/xhtml+xml",
+	"application/xhtml":           "application/xhtml+xml",
+	"application/javascript":      "text/javascript",
+	"application/x-javascript":    "text/javascript",
+	"application/x-font-ttf":      "font/ttf",
+	"application/x-font-truetype": "font/ttf",
+	"application/font-sfnt":       "font/ttf",
+	"application/x-font-otf":      "font/otf",
+	"application/x-font-opentype": "font/otf",
+	"application/font-woff":       "font/woff",
+	"application/x-font-woff":     "font/woff",
+	"application/font-woff2":      "font/woff2",
+}
+
+// inferMediaType guesses a resource's media type from its extension, then
+// from its leading bytes. It returns "" when neither is conclusive.
+func inferMediaType(href string, data []byte) string {
+	if mt, ok := extMediaTypes[strings.ToLower(path.Ext(href))]; ok {
+		return mt
+	}
+	if len(data) == 0 {
+		return ""
+	}
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	lower := bytes.ToLower(head)
+	switch {
+	case bytes.Contains(lower, []byte("<html")):
+		return "application/xhtml+xml"
+	case bytes.Contains(lower, []byte("<svg")):
+		return "image/svg+xml"
+	}
+	mt, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	switch {
+	case strings.HasPrefix(mt, "image/"), strings.HasPrefix(mt, "font/"),
+		strings.HasPrefix(mt, "audio/"), strings.HasPrefix(mt, "video/"):
+		return mt
+	}
+	return ""
+}
+
+// fixManifestMediaTypes trims and lowercases the manifest's media-types,
+// replaces the aliases in mediaTypeAliases with the EPUB names and fills in
+// missing ones, reading the files under pkgDir when the extension isn't
+// enough. Every item it changed is reported, and so is every item still
+// missing a media-type.
+func fixManifestMediaTypes(pkgDir string, manifest *Manifest) []MediaTypeFix {
+	var fixes []MediaTypeFix
+	for i := range manifest.Items {
+		item := &manifest.Items[i]
+		mt := strings.ToLower(strings.TrimSpace(item.MediaType))
+		if alias, ok := mediaTypeAliases[mt]; ok {
+			mt = alias
+		}
+		if mt == "" {
+			data, _ := os.ReadFile(filepath.Join(pkgDir, filepath.FromSlash(item.Href)))
+			mt = inferMediaType(item.Href, data)
+		}
+		if mt == item.MediaType && mt != "" {
+			continue
+		}
+		fixes = append(fixes, MediaTypeFix{ID: item.ID, Href: item.Href, From: item.MediaType, MediaType: mt})
+		item.MediaType = mt
+	}
+	return fixes
+}
+
+// FixMediaTypes fills in missing manifest media-types in an EPUB and puts
+// the others in canonical form (see fixManifestMediaTypes), writing the
+// result to opts.OutPath (the input when empty) unless opts.DryRun is set.
+// When nothing needs fixing the input is left alone, or copied as it is to
+// opts.OutPath.
+func FixMediaTypes(ctx context.Context, input string, opts FixMediaTypesOptions) ([]MediaTypeFix, error) {
+	if input == "" {
+		return nil, fmt.Errorf("input EPUB path is required")
+	}
+
+	vol, err := loadVolume(ctx, 0, input)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	fixes := fixManifestMediaTypes(vol.PackageDir, &vol.PackageDoc.Manifest)
+	fixed := 0
+	for i := range fixes {
+		fixes[i].Source = input
+		if fixes[i].MediaType != "" {
+			fixed++
+		}
+	}
+	if opts.DryRun {
+		return fixes, nil
+	}
+	if fixed == 0 {
+		return fixes, copyUnchanged(input, opts.OutPath)
+	}
+
+	if err := writePackage(vol.PackageDoc, vol.PackagePath); err != nil {
+		return nil, err
+	}
+	if err := replaceArchive(vol.RootDir, input, opts.OutPath); err != nil {
+		return nil, err
+	}
+	return fixes, nil
+}
+
+// copyUnchanged copies input to out, when set and not the input itself, for
+// commands that found nothing to change but were asked for an output file.
+func copyUnchanged(input, out string) error {
+	if out == "" {
+		return nil
+	}
+	info, err := os.Stat(input)
+	if err != nil {
+		return err
+	}
+	if outInfo, err := os.Stat(out); err == nil && os.SameFile(info, outInfo) {
+		return nil
+	}
+	if err := ensureParentDir(out); err != nil {
+		return err
+	}
+	return copyFile(input, out, info.Mode())
+}
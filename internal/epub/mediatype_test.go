@@ -0,0 +1,168 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildMissingMediaTypeEPUB builds a book whose manifest has three items
+// without a media-type: one identifiable by extension, one only by content,
+// and one not at all.
+func buildMissingMediaTypeEPUB(t *testing.T) string {
+	t.Helper()
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Broken",
+			lang:       "en",
+			identifier: "urn:test:broken",
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				{ID: "chap", Href: "chapter.xhtml"},
+				{ID: "pic", Href: "images/pic"},
+				{ID: "blob", Href: "data.bin"},
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/nav.xhtml":     `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="chapter.xhtml">Chapter</a></li></ol></nav></body></html>`,
+		"OEBPS/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Text</p></body></html>`,
+		"OEBPS/images/pic":    "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"OEBPS/data.bin":      "\x00\x01\x02\x03",
+	})
+}
+
+func TestInferMediaType(t *testing.T) {
+	cases := []struct {
+		href string
+		data string
+		want string
+	}{
+		{"Text/ch1.XHTML", "", "application/xhtml+xml"},
+		{"style.css", "", "text/css"},
+		{"img/cover", "\xff\xd8\xff\xe0\x00\x10JFIF", "image/jpeg"},
+		{"page", `<?xml version="1.0"?><html xmlns="http://www.w3.org/1999/xhtml"/>`, "application/xhtml+xml"},
+		{"drawing", `<svg xmlns="http://www.w3.org/2000/svg"/>`, "image/svg+xml"},
+		{"notes", "plain text", ""},
+		{"empty", "", ""},
+	}
+	for _, tc := range cases {
+		if got := inferMediaType(tc.href, []byte(tc.data)); got != tc.want {
+			t.Errorf("inferMediaType(%q) = %q want %q", tc.href, got, tc.want)
+		}
+	}
+}
+
+func TestFixManifestMediaTypesAliases(t *testing.T) {
+	manifest := Manifest{Items: []ManifestItem{
+		{ID: "photo", Href: "photo.jpg", MediaType: " Image/JPG"},
+		{ID: "font", Href: "font.ttf", MediaType: "application/x-font-ttf"},
+		{ID: "css", Href: "book.css", MediaType: "text/css"},
+		{ID: "odd", Href: "data.bin", MediaType: "application/x-custom"},
+	}}
+	fixes := fixManifestMediaTypes(t.TempDir(), &manifest)
+	if len(fixes) != 2 || fixes[0].From != " Image/JPG" || fixes[0].MediaType != "image/jpeg" || fixes[1].MediaType != "font/ttf" {
+		t.Fatalf("fixes = %+v", fixes)
+	}
+	if got := manifest.Items[0].MediaType; got != "image/jpeg" {
+		t.Fatalf("photo media-type = %q", got)
+	}
+	if got := manifest.Items[3].MediaType; got != "application/x-custom" {
+		t.Fatalf("unknown media-type changed to %q", got)
+	}
+}
+
+func TestFixMediaTypes(t *testing.T) {
+	input := buildMissingMediaTypeEPUB(t)
+	out := filepath.Join(t.TempDir(), "fixed.epub")
+
+	fixes, err := FixMediaTypes(context.Background(), input, FixMediaTypesOptions{OutPath: out})
+	if err != nil {
+		t.Fatalf("FixMediaTypes: %v", err)
+	}
+	want := map[string]string{"chap": "application/xhtml+xml", "pic": "image/png", "blob": ""}
+	if len(fixes) != len(want) {
+		t.Fatalf("fixes = %+v", fixes)
+	}
+	for _, f := range fixes {
+		if f.MediaType != want[f.ID] {
+			t.Fatalf("%s: media-type %q want %q", f.ID, f.MediaType, want[f.ID])
+		}
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("loadVolume: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		if w, ok := want[item.ID]; ok && item.MediaType != w {
+			t.Fatalf("written %s media-type %q want %q", item.ID, item.MediaType, w)
+		}
+	}
+}
+
+func TestFixMediaTypesDryRun(t *testing.T) {
+	input := buildMissingMediaTypeEPUB(t)
+	before, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatalf("read input: %v", err)
+	}
+
+	if _, err := FixMediaTypes(context.Background(), input, FixMediaTypesOptions{DryRun: true}); err != nil {
+		t.Fatalf("FixMediaTypes: %v", err)
+	}
+	after, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatalf("read input: %v", err)
+	}
+	if string(before) != string(after) {
+		t.Fatalf("dry run modified the input")
+	}
+}
+
+func TestFixMediaTypesNothingToFixOut(t *testing.T) {
+	input := buildTestEPUB(t, "Vol 1", "en")
+	out := filepath.Join(t.TempDir(), "fixed.epub")
+
+	fixes, err := FixMediaTypes(context.Background(), input, FixMediaTypesOptions{OutPath: out})
+	if err != nil || len(fixes) != 0 {
+		t.Fatalf("FixMediaTypes = %+v, %v", fixes, err)
+	}
+	want, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatalf("read input: %v", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("output not written: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("output differs from the unchanged input")
+	}
+}
+
+func TestMergeEPUBsFillsMissingMediaTypes(t *testing.T) {
+	a := buildMissingMediaTypeEPUB(t)
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, TempDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if len(stats.MediaTypeFixes) != 3 || stats.MediaTypeFixes[0].Source != a {
+		t.Fatalf("fixes = %+v", stats.MediaTypeFixes)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("loadVolume: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		if item.ID == "v0001_pic" && item.MediaType != "image/png" {
+			t.Fatalf("merged pic media-type = %q", item.MediaType)
+		}
+	}
+}
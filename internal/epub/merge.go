@@ -3,9 +3,12 @@ package epub
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
 	"html"
@@ -13,75 +16,303 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
 )
 
-func MergeEPUBs(ctx context.Context, sources []string, opts MergeOptions) error {
-	if len(sources) < 2 {
-		return fmt.Errorf("need at least two input EPUB files")
+func MergeEPUBs(ctx context.Context, sources []string, opts MergeOptions) (MergeStats, error) {
+	if len(sources) == 0 || len(sources) == 1 && !opts.PreserveNav {
+		return MergeStats{}, fmt.Errorf("need at least two input EPUB files")
 	}
+	if opts.Kobo && opts.OutPath != "" {
+		opts.OutPath = kepubPath(opts.OutPath)
+	}
+	if opts.MaxSize > 0 {
+		return mergeParts(ctx, sources, opts)
+	}
+	return mergeSources(ctx, sources, opts)
+}
 
+// mergeSources merges sources (possibly just one, for a part of a split
+// merge) into opts.OutPath.
+func mergeSources(ctx context.Context, sources []string, opts MergeOptions) (MergeStats, error) {
+	var stats MergeStats
 	if opts.OutPath == "" {
-		return fmt.Errorf("output path is required")
+		return stats, fmt.Errorf("output path is required")
 	}
 
-	volumes := make([]*Volume, len(sources))
-	for i, src := range sources {
-		if ctx.Err() != nil {
-			return ctx.Err()
+	if opts.Interleave && len(sources) != 2 {
+		return stats, fmt.Errorf("interleave needs exactly two input EPUB files, got %d", len(sources))
+	}
+	if len(opts.Arcs) > 0 && len(opts.Arcs) != len(sources) {
+		return stats, fmt.Errorf("got %d arc names for %d input EPUB files", len(opts.Arcs), len(sources))
+	}
+
+	stripTitle, err := titlePrefixStripper(opts.StripTitlePrefix, opts.StripTitleRegex)
+	if err != nil {
+		return stats, err
+	}
+
+	if opts.Compression < CompressionStore || opts.Compression > flate.BestCompression {
+		return stats, fmt.Errorf("invalid compression level %d (want 1-9)", opts.Compression)
+	}
+
+	var externalCover string
+	if !isCoverMode(opts.Cover) {
+		if externalCover, err = externalCoverType(opts.Cover); err != nil {
+			return stats, err
+		}
+	}
+
+	switch opts.RightsFrom {
+	case "", RightsFirst, RightsLast, RightsAll:
+	default:
+		return stats, fmt.Errorf("invalid rights source %q (want first, last, all)", opts.RightsFrom)
+	}
+
+	var rewriteRules []compiledRule
+	if len(opts.RewriteRules) > 0 {
+		if rewriteRules, err = compileRules(opts.RewriteRules); err != nil {
+			return stats, err
+		}
+	}
+
+	switch opts.PageProgression {
+	case "", "ltr", "rtl", "default":
+	default:
+		return stats, fmt.Errorf("invalid page progression direction %q (want ltr, rtl, default)", opts.PageProgression)
+	}
+
+	headingTarget, err := parseHeadingTarget(opts.NormalizeHeadings)
+	if err != nil {
+		return stats, err
+	}
+
+	if err := checkVolumeDirTemplate(opts.VolumeDirTemplate); err != nil {
+		return stats, err
+	}
+
+	illustrationSources, err := parseIllustrationSources(opts.Illustrations)
+	if err != nil {
+		return stats, err
+	}
+	var illustrationPattern *regexp.Regexp
+	if illustrationSources != nil {
+		pattern := opts.IllustrationPattern
+		if pattern == "" {
+			pattern = DefaultIllustrationPattern
+		}
+		if illustrationPattern, err = regexp.Compile(pattern); err != nil {
+			return stats, fmt.Errorf("invalid illustration pattern: %w", err)
+		}
+	}
+
+	if opts.FlattenImages < 0 {
+		return stats, fmt.Errorf("flatten image size limit can't be negative")
+	}
+
+	if opts.ProgressAnchors < 0 {
+		return stats, fmt.Errorf("progress anchor interval can't be negative")
+	}
+
+	if opts.Identifier != "" {
+		if err := checkIdentifier(opts.Identifier); err != nil {
+			return stats, err
 		}
-		vol, err := loadVolume(ctx, i, src)
+	}
+
+	contentDir, pkgName, err := packageLayout(opts.ContentDir, opts.PackageName)
+	if err != nil {
+		return stats, err
+	}
+
+	switch opts.ReadingOrder {
+	case ReadingOrderSpine, ReadingOrderNav:
+	default:
+		return stats, fmt.Errorf("invalid reading order %d", opts.ReadingOrder)
+	}
+
+	switch opts.ManifestOrder {
+	case ManifestOrderSource, ManifestOrderHref, ManifestOrderType:
+	default:
+		return stats, fmt.Errorf("invalid manifest order %d", opts.ManifestOrder)
+	}
+
+	progress := opts.Progress
+	progress.update(func(s *MergeProgressState) {
+		*s = MergeProgressState{Phase: PhaseLoading, Total: len(sources)}
+	})
+
+	volumes := make([]*Volume, len(sources))
+	err = parallelFor(ctx, len(sources), opts.Threads, func(i int) error {
+		vol, err := loadVolumeIn(ctx, i, sources[i], opts.TempDir)
 		if err != nil {
-			for _, v := range volumes {
-				if v != nil {
-					os.RemoveAll(v.TempDir)
-				}
-			}
 			return err
 		}
 		volumes[i] = vol
-	}
+		progress.update(func(s *MergeProgressState) { s.Loaded++ })
+		return nil
+	})
 	defer func() {
 		for _, v := range volumes {
-			os.RemoveAll(v.TempDir)
+			if v != nil {
+				os.RemoveAll(v.TempDir)
+			}
 		}
 	}()
+	if err != nil {
+		return stats, err
+	}
+	for i, vol := range volumes {
+		checkSourceVolume(vol, &stats)
+		stats.Warnings = append(stats.Warnings, vol.Warnings...)
+		stats.Warnings = append(stats.Warnings, collapseDuplicateHrefs(vol)...)
+		if len(opts.Arcs) > 0 {
+			vol.Arc = strings.TrimSpace(opts.Arcs[i])
+		}
+		if opts.GuessCover && vol.CoverID == "" {
+			id, err := guessCoverID(vol)
+			if err != nil {
+				return stats, fmt.Errorf("%s: %w", vol.SourcePath, err)
+			}
+			if id != "" {
+				vol.CoverID = id
+				stats.GuessedCovers = append(stats.GuessedCovers, vol.SourcePath)
+			}
+		}
+	}
+
+	if stripTitle != nil {
+		for _, vol := range volumes {
+			vol.DisplayName = stripTitle(vol.DisplayName)
+		}
+	}
+	if opts.RenameTitleConflicts {
+		disambiguateTitles(volumes)
+	}
+
+	var headingLevels []int
+	if headingTarget >= 0 {
+		headingLevels = make([]int, len(volumes))
+		for i, vol := range volumes {
+			if headingLevels[i], err = chapterHeadingLevel(vol); err != nil {
+				return stats, fmt.Errorf("%s: %w", vol.SourcePath, err)
+			}
+		}
+		if headingTarget == 0 {
+			headingTarget = commonHeadingLevel(headingLevels)
+		}
+	}
+
+	// Volumes in different languages keep their own inside the merged book.
+	volLangs := volumeLanguages(volumes)
 
-	stageDir, err := os.MkdirTemp("", "novfmt-stage-*")
+	volDirs, err := volumeDirNames(opts.VolumeDirTemplate, volumes)
 	if err != nil {
-		return err
+		return stats, err
+	}
+
+	keepNav := opts.PreserveNav && len(volumes) == 1 && volumes[0].NavHref != "" && !opts.IndexPage && illustrationSources == nil && opts.ProgressAnchors == 0
+	if opts.PreserveNav && !keepNav {
+		stats.Warnings = append(stats.Warnings, "nav regenerated: "+navRegenReason(volumes, opts))
+	}
+
+	progress.setPhase(PhaseStaging)
+	stageDir, err := os.MkdirTemp(opts.TempDir, "novfmt-stage-*")
+	if err != nil {
+		return stats, err
 	}
 	defer os.RemoveAll(stageDir)
 
-	oebpsDir := filepath.Join(stageDir, "OEBPS")
+	oebpsDir := filepath.Join(stageDir, filepath.FromSlash(contentDir))
 	if err := os.MkdirAll(oebpsDir, 0o755); err != nil {
-		return err
+		return stats, err
 	}
 
 	manifest := Manifest{}
 	spine := Spine{}
 	idHref := make(map[string]string)
+	idMaps := make([]map[string]string, len(volumes))
+	volRefs := make([][]SpineItemRef, len(volumes))
+	covers := make(map[string]bool)
+	coverHrefs := make(map[int]string)
+	bodyHrefs := make(map[int]string)
+	illustrations := make(map[int][]NavItem)
+	anchors := make(map[string][]string)
 	var coverItemID string
+	adoptCover := opts.Cover == "" || opts.Cover == CoverFirst || opts.Cover == CoverLast
+	// coverVol and coverIndex are the volume that supplied coverItemID and
+	// its position in manifest.Items, for CoverLast to swap it out.
+	coverVol, coverIndex := -1, -1
 
 	for _, vol := range volumes {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return stats, ctx.Err()
 		default:
 		}
 
-		vol.Prefix = path.Join("Volumes", fmt.Sprintf("v%04d", vol.Index+1))
-		destDir := filepath.Join(oebpsDir, filepath.FromSlash(vol.Prefix))
-		if err := copyVolumePayload(vol, destDir); err != nil {
-			return fmt.Errorf("%s: %w", vol.SourcePath, err)
+		// Resources outside the package directory (href="../styles/...")
+		// come along by copying from the directory that holds them all,
+		// which keeps every relative reference between them intact.
+		baseDir := volumeBaseDir(vol)
+		pkgSub, err := filepath.Rel(baseDir, vol.PackageDir)
+		if err != nil {
+			return stats, err
+		}
+		volDir := path.Join("Volumes", volDirs[vol.Index])
+		vol.Prefix = path.Join(volDir, filepath.ToSlash(pkgSub))
+		destDir := filepath.Join(oebpsDir, filepath.FromSlash(volDir))
+		if rewriteRules != nil || opts.StripComments {
+			rw, err := rewriteVolume(ctx, vol, rewriteRules, RewriteOptions{Scope: RewriteScopeBody, StripComments: opts.StripComments, Threads: opts.Threads}, !keepNav)
+			if err != nil {
+				return stats, fmt.Errorf("%s: rewrite: %w", vol.SourcePath, err)
+			}
+			addRewriteStats(&stats.Rewrite, rw, vol.Prefix)
+		}
+		if headingLevels != nil {
+			n, err := normalizeVolumeHeadings(vol, headingLevels[vol.Index], headingTarget)
+			if err != nil {
+				return stats, fmt.Errorf("%s: %w", vol.SourcePath, err)
+			}
+			stats.HeadingsRetagged += n
+		}
+		if lang := volLangs[vol.Index]; lang != "" {
+			n, err := tagVolumeLanguage(vol, lang)
+			if err != nil {
+				return stats, fmt.Errorf("%s: %w", vol.SourcePath, err)
+			}
+			stats.LanguageTagged += n
+		}
+		if opts.ProgressAnchors > 0 {
+			docs, n, err := volumeProgressAnchors(vol, opts.ProgressAnchors)
+			if err != nil {
+				return stats, fmt.Errorf("%s: %w", vol.SourcePath, err)
+			}
+			for href, ids := range docs {
+				anchors[normalizeEPUBPath(path.Join(vol.Prefix, href))] = ids
+			}
+			stats.ProgressAnchorsAdded += n
+		}
+		if err := copyVolumePayload(vol, baseDir, destDir, keepNav); err != nil {
+			return stats, fmt.Errorf("%s: %w", vol.SourcePath, err)
+		}
+		if err := deobfuscateVolumeFonts(vol, baseDir, destDir); err != nil {
+			return stats, fmt.Errorf("%s: %w", vol.SourcePath, err)
+		}
+
+		for _, fix := range fixManifestMediaTypes(vol.PackageDir, &vol.PackageDoc.Manifest) {
+			fix.Source = vol.SourcePath
+			stats.MediaTypeFixes = append(stats.MediaTypeFixes, fix)
 		}
 
 		idMap := make(map[string]string)
+		idMaps[vol.Index] = idMap
 
-		for _, item := range vol.PackageDoc.Manifest.Items {
-			if hasProperty(item.Properties, "nav") {
+		for _, item := range orderedManifestItems(vol.PackageDoc.Manifest.Items, opts.ManifestOrder) {
+			if hasProperty(item.Properties, "nav") && !keepNav {
 				continue
 			}
 			newID := fmt.Sprintf("v%04d_%s", vol.Index+1, item.ID)
@@ -91,35 +322,62 @@ func MergeEPUBs(ctx context.Context, sources []string, opts MergeOptions) error
 				ID:         newID,
 				Href:       href,
 				MediaType:  item.MediaType,
-				Properties: item.Properties,
+				Properties: removeProperty(item.Properties, "cover-image"),
 			}
 			if item.Fallback != "" {
-				entry.Fallback = fmt.Sprintf("v%04d_%s", vol.Index+1, item.Fallback)
+				entry.Fallback = fmt.Sprintf("v%04d_%s", vol.Index+1, vol.canonicalID(item.Fallback))
 			}
-			if coverItemID == "" {
-				switch {
-				case vol.CoverID != "" && item.ID == vol.CoverID:
-					entry.Properties = addProperty(entry.Properties, "cover-image")
-					coverItemID = newID
-				case vol.CoverID == "" && hasProperty(item.Properties, "cover-image"):
+			if item.MediaOverlay != "" {
+				entry.MediaOverlay = fmt.Sprintf("v%04d_%s", vol.Index+1, vol.canonicalID(item.MediaOverlay))
+			}
+			if item.ID == vol.CoverID || hasProperty(item.Properties, "cover-image") {
+				covers[newID] = true
+				if _, ok := coverHrefs[vol.Index]; !ok {
+					coverHrefs[vol.Index] = href
+				}
+			}
+			// With CoverLast each volume's cover replaces the one before.
+			if adoptCover && (coverItemID == "" || opts.Cover == CoverLast && coverVol != vol.Index) {
+				if isImageItem(item) && (vol.CoverID != "" && item.ID == vol.CoverID || vol.CoverID == "" && hasProperty(item.Properties, "cover-image")) {
+					if coverIndex >= 0 {
+						manifest.Items[coverIndex].Properties = removeProperty(manifest.Items[coverIndex].Properties, "cover-image")
+					}
 					entry.Properties = addProperty(entry.Properties, "cover-image")
 					coverItemID = newID
+					coverVol, coverIndex = vol.Index, len(manifest.Items)
 				}
 			}
 			manifest.Items = append(manifest.Items, entry)
 			idHref[newID] = href
 		}
+		for alias, id := range vol.IDAliases {
+			if newID, ok := idMap[id]; ok {
+				idMap[alias] = newID
+			}
+		}
 
+		// A kept nav keeps its book intact, NCX included. Otherwise each
+		// volume's NCX only covers that volume, so none is made the book's.
+		if keepNav && vol.PackageDoc.Spine.Toc != "" {
+			spine.Toc = idMap[vol.PackageDoc.Spine.Toc]
+		}
 		if spine.PageProgressionDirection == "" && vol.PackageDoc.Spine.PageProgressionDirection != "" {
 			spine.PageProgressionDirection = vol.PackageDoc.Spine.PageProgressionDirection
 		}
 
-		for _, ref := range vol.PackageDoc.Spine.Itemrefs {
+		refs := vol.PackageDoc.Spine.Itemrefs
+		if opts.ReadingOrder == ReadingOrderNav {
+			refs = navSpineOrder(vol)
+		}
+		inSpine := make(map[string]bool, len(refs))
+		for _, ref := range refs {
 			newID, ok := idMap[ref.IDRef]
-			if !ok {
+			// Aliased ids listed alongside their item would repeat it.
+			if !ok || inSpine[newID] {
 				continue
 			}
-			spine.Itemrefs = append(spine.Itemrefs, SpineItemRef{
+			inSpine[newID] = true
+			volRefs[vol.Index] = append(volRefs[vol.Index], SpineItemRef{
 				IDRef:  newID,
 				Linear: ref.Linear,
 			})
@@ -128,37 +386,324 @@ func MergeEPUBs(ctx context.Context, sources []string, opts MergeOptions) error
 				vol.FirstHref = idHref[newID]
 			}
 		}
+		if opts.VolumeDividers {
+			item, err := addVolumeDivider(vol, oebpsDir, volDir, idHref)
+			if err != nil {
+				return stats, fmt.Errorf("%s: divider: %w", vol.SourcePath, err)
+			}
+			manifest.Items = append(manifest.Items, item)
+			idHref[item.ID] = item.Href
+			volRefs[vol.Index] = append([]SpineItemRef{{IDRef: item.ID}}, volRefs[vol.Index]...)
+			vol.FirstHref = item.Href
+		}
+		if opts.Landmarks {
+			href, err := bodymatterHref(vol)
+			if err != nil {
+				return stats, fmt.Errorf("%s: %w", vol.SourcePath, err)
+			}
+			if href != "" {
+				bodyHrefs[vol.Index] = joinHref(vol.Prefix, href)
+			}
+		}
+		if opts.CoverEntries {
+			href, err := volumeCoverPage(vol)
+			if err != nil {
+				return stats, fmt.Errorf("%s: %w", vol.SourcePath, err)
+			}
+			if href != "" {
+				vol.CoverHref = joinHref(vol.Prefix, href)
+			}
+		}
+		if illustrationSources != nil {
+			items, err := volumeIllustrations(vol, illustrationSources, illustrationPattern)
+			if err != nil {
+				return stats, fmt.Errorf("%s: %w", vol.SourcePath, err)
+			}
+			illustrations[vol.Index] = cloneNavItems(items, vol.Prefix)
+			stats.Illustrations += len(items)
+		}
+		progress.update(func(s *MergeProgressState) { s.Staged++ })
 	}
 
-	manifest.Items = append(manifest.Items, ManifestItem{
-		ID:         "nav",
-		Href:       "nav.xhtml",
-		MediaType:  "application/xhtml+xml",
-		Properties: "nav",
-	})
+	if opts.PageProgression != "" {
+		spine.PageProgressionDirection = opts.PageProgression
+	} else if w := mixedDirections(volumes, spine.PageProgressionDirection); w != "" {
+		stats.Warnings = append(stats.Warnings, w)
+	}
 
-	if err := writeNav(volumes, filepath.Join(oebpsDir, "nav.xhtml")); err != nil {
-		return err
+	var navEntries []NavItem
+	if opts.Interleave {
+		refs, pairs, err := interleaveSpines(volumes, volRefs, idMaps, idHref)
+		if err != nil {
+			return stats, err
+		}
+		spine.Itemrefs = refs
+		navEntries = pairs
+	} else {
+		for _, refs := range volRefs {
+			spine.Itemrefs = append(spine.Itemrefs, refs...)
+		}
+		navEntries = volumeNavEntries(volumes, coverEntryTitle(opts))
+	}
+
+	if opts.DedupeNav {
+		navEntries, stats.NavDuplicatesRemoved = dedupeNavItems(navEntries)
+	}
+	if opts.CollapseTOC {
+		navEntries = collapseNavItems(navEntries)
+	}
+
+	progress.setPhase(PhaseWriting)
+
+	if opts.DedupeResources {
+		res, err := shareResources(ctx, oebpsDir, &manifest, covers, opts.Threads)
+		if err != nil {
+			return stats, err
+		}
+		stats.DedupedItems += res.items
+		stats.DedupedBytes += res.bytes
+	} else if opts.DedupeImages {
+		res, err := dedupeResources(ctx, oebpsDir, &manifest, covers, isImageItem, opts.Threads)
+		if err != nil {
+			return stats, err
+		}
+		stats.DedupedItems += res.items
+		stats.DedupedBytes += res.bytes
+	}
+
+	if opts.FlattenImages > 0 {
+		res, err := flattenImages(ctx, oebpsDir, &manifest, covers, opts.FlattenImages)
+		if err != nil {
+			return stats, err
+		}
+		stats.FlattenedImages = res.images
+		stats.FlattenedBytes = res.bytes
+	}
+
+	if opts.Cover == CoverGrid {
+		if err := writeGridCover(volumes, opts.CoverColumns, opts.CoverBackground, filepath.Join(oebpsDir, gridCoverHref)); err != nil {
+			return stats, err
+		}
+		manifest.Items = append(manifest.Items, ManifestItem{
+			ID:         gridCoverID,
+			Href:       gridCoverHref,
+			MediaType:  "image/jpeg",
+			Properties: "cover-image",
+		})
+		coverItemID = gridCoverID
+	}
+	if externalCover != "" {
+		href := externalCoverName + strings.ToLower(filepath.Ext(opts.Cover))
+		if err := copyFile(opts.Cover, filepath.Join(oebpsDir, href), 0o644); err != nil {
+			return stats, fmt.Errorf("copy cover: %w", err)
+		}
+		manifest.Items = append(manifest.Items, ManifestItem{
+			ID:         externalCoverID,
+			Href:       href,
+			MediaType:  externalCover,
+			Properties: "cover-image",
+		})
+		coverItemID = externalCoverID
+	}
+
+	var leadNav []NavItem
+	if opts.IndexPage {
+		if !opts.IndexCovers {
+			coverHrefs = nil
+		}
+		if err := writeIndexPage(volumes, coverHrefs, filepath.Join(oebpsDir, indexPageHref)); err != nil {
+			return stats, err
+		}
+		manifest.Items = append(manifest.Items, ManifestItem{
+			ID:        indexPageID,
+			Href:      indexPageHref,
+			MediaType: "application/xhtml+xml",
+		})
+		spine.Itemrefs = append([]SpineItemRef{{IDRef: indexPageID}}, spine.Itemrefs...)
+		leadNav = append(leadNav, NavItem{Title: indexPageTitle, Href: indexPageHref})
+	}
+
+	// A kept source nav was staged with the rest of its volume and is
+	// already in the manifest.
+	if !keepNav {
+		manifest.Items = append(manifest.Items, ManifestItem{
+			ID:         "nav",
+			Href:       "nav.xhtml",
+			MediaType:  "application/xhtml+xml",
+			Properties: "nav",
+		})
+
+		loi := illustrationsNav(volumes, illustrations)
+		var landmarks []landmark
+		if opts.Landmarks {
+			landmarks = volumeLandmarks(volumes, bodyHrefs, navHeading(opts))
+			if len(loi) > 0 {
+				landmarks = append(landmarks, landmark{Type: "loi", Title: illustrationsTitle, Href: "nav.xhtml#loi"})
+			}
+		}
+		pages := progressPageList(spine.Itemrefs, idHref, anchors)
+		stats.ProgressAnchors = len(pages)
+		nav := renderNav(append(leadNav, navEntries...), navHeading(opts), landmarks, loi, pages)
+		if err := os.WriteFile(filepath.Join(oebpsDir, "nav.xhtml"), nav, 0o644); err != nil {
+			return stats, err
+		}
+		if opts.DryRun && opts.PrintOPF {
+			stats.Generated = append(stats.Generated, GeneratedFile{Path: path.Join(contentDir, "nav.xhtml"), Data: nav})
+		}
+	}
+
+	if opts.TraceIDs != nil {
+		if err := writeIDTrace(opts.TraceIDs, volumes, idMaps, manifest); err != nil {
+			return stats, err
+		}
 	}
 
 	pkg := buildPackage(volumes, manifest, spine, opts, coverItemID)
-	if err := writePackage(pkg, filepath.Join(oebpsDir, "content.opf")); err != nil {
-		return err
+	// The NCX needs the identifier buildPackage settles on.
+	if opts.EmitNCX && !keepNav {
+		uid := ""
+		if id := uniqueIdentifier(pkg); id != nil {
+			uid = id.Value
+		}
+		ncx := renderNCX(append(leadNav, navEntries...), uid, firstDCValue(pkg.Metadata.Titles))
+		if err := os.WriteFile(filepath.Join(oebpsDir, ncxHref), ncx, 0o644); err != nil {
+			return stats, err
+		}
+		if opts.DryRun && opts.PrintOPF {
+			stats.Generated = append(stats.Generated, GeneratedFile{Path: path.Join(contentDir, ncxHref), Data: ncx})
+		}
+		pkg.Manifest.Items = append(pkg.Manifest.Items, ManifestItem{ID: ncxID, Href: ncxHref, MediaType: ncxMediaType})
+		pkg.Spine.Toc = ncxID
+	}
+	if hasMediaOverlays(volumes) {
+		pkg.Metadata.Meta = append(pkg.Metadata.Meta, overlayMetadata(volumes, idMaps)...)
+	}
+	opf, err := marshalPackage(pkg)
+	if err != nil {
+		return stats, err
+	}
+	if err := os.WriteFile(filepath.Join(oebpsDir, pkgName), opf, 0o644); err != nil {
+		return stats, err
+	}
+	if opts.DryRun && opts.PrintOPF {
+		stats.Generated = append([]GeneratedFile{{Path: path.Join(contentDir, pkgName), Data: opf}}, stats.Generated...)
 	}
 
-	if err := writeContainer(filepath.Join(stageDir, "META-INF")); err != nil {
-		return err
+	if opts.Kobo {
+		n, err := addKoboSpans(oebpsDir, manifest, spine)
+		if err != nil {
+			return stats, fmt.Errorf("kobo spans: %w", err)
+		}
+		stats.KoboDocuments = n
 	}
 
-	if err := os.WriteFile(filepath.Join(stageDir, "mimetype"), []byte("application/epub+zip"), 0o644); err != nil {
-		return err
+	if err := writeContainer(filepath.Join(stageDir, "META-INF"), path.Join(contentDir, pkgName)); err != nil {
+		return stats, err
 	}
 
-	if err := writeZip(stageDir, opts.OutPath); err != nil {
-		return err
+	if err := os.WriteFile(filepath.Join(stageDir, "mimetype"), []byte(epubMimetype), 0o644); err != nil {
+		return stats, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+	if opts.DryRun {
+		raw, est, err := estimateArchiveSize(stageDir, deflateLevel(opts.Compression))
+		if err != nil {
+			return stats, err
+		}
+		progress.setPhase(PhaseDone)
+		stats.Volumes = len(volumes)
+		stats.StagedBytes = raw
+		stats.EstimatedBytes = est
+		return stats, nil
+	}
+	progress.setPhase(PhaseZipping)
+	sum, err := writeZipWith(stageDir, opts.OutPath, zipOptions{level: deflateLevel(opts.Compression), preserveTimes: opts.PreserveTimes})
+	if err != nil {
+		return stats, err
+	}
+	progress.setPhase(PhaseDone)
+	stats.OutPath = opts.OutPath
+	stats.Volumes = len(volumes)
+	stats.SHA256 = sum
+
+	if opts.WriteChecksum {
+		if err := writeChecksumFile(opts.OutPath, sum); err != nil {
+			return stats, err
+		}
+	}
+
+	if opts.Verify {
+		checks, err := VerifyMerged(ctx, opts.OutPath)
+		if err != nil {
+			return stats, fmt.Errorf("verify: %w", err)
+		}
+		stats.Verification = checks
+	}
+
+	if opts.ValidateAfter {
+		issues, err := ValidateEPUB(ctx, opts.OutPath)
+		if err != nil {
+			return stats, fmt.Errorf("validate: %w", err)
+		}
+		stats.Validation = issues
 	}
 
-	return nil
+	return stats, nil
+}
+
+// titlePrefixStripper returns a function that removes prefix from the start
+// of a volume title, or nil when prefix is empty. With isRegex the prefix is
+// a regular expression anchored at the start of the title. Titles that would
+// become empty are left unchanged.
+func titlePrefixStripper(prefix string, isRegex bool) (func(string) string, error) {
+	if prefix == "" {
+		return nil, nil
+	}
+	if !isRegex {
+		return func(title string) string {
+			out := strings.TrimSpace(strings.TrimPrefix(title, prefix))
+			if out == "" {
+				return title
+			}
+			return out
+		}, nil
+	}
+	re, err := regexp.Compile("^(?:" + prefix + ")")
+	if err != nil {
+		return nil, fmt.Errorf("compile title prefix %q: %w", prefix, err)
+	}
+	return func(title string) string {
+		loc := re.FindStringIndex(title)
+		if loc == nil {
+			return title
+		}
+		out := strings.TrimSpace(title[loc[1]:])
+		if out == "" {
+			return title
+		}
+		return out
+	}, nil
+}
+
+// disambiguateTitles appends " (1)", " (2)", ... to volume titles shared by
+// more than one volume, numbering each group in volume order.
+func disambiguateTitles(vols []*Volume) {
+	counts := make(map[string]int)
+	for _, vol := range vols {
+		counts[vol.DisplayName]++
+	}
+	seen := make(map[string]int)
+	for _, vol := range vols {
+		name := vol.DisplayName
+		if counts[name] < 2 {
+			continue
+		}
+		seen[name]++
+		vol.DisplayName = fmt.Sprintf("%s (%d)", name, seen[name])
+	}
 }
 
 func buildPackage(vols []*Volume, manifest Manifest, spine Spine, opts MergeOptions, coverID string) *PackageDocument {
@@ -185,6 +730,7 @@ func buildPackage(vols []*Volume, manifest Manifest, spine Spine, opts MergeOpti
 	if lang == "" {
 		lang = "en"
 	}
+	lang = canonicalLanguage(lang)
 
 	creators := make([]string, 0, len(opts.Creators))
 	if len(opts.Creators) > 0 {
@@ -208,30 +754,67 @@ func buildPackage(vols []*Volume, manifest Manifest, spine Spine, opts MergeOpti
 	if len(creators) == 0 {
 		creators = []string{"Unknown"}
 	}
-	sort.Strings(creators)
+	if opts.SortCreators {
+		sort.SliceStable(creators, func(i, j int) bool {
+			a, _ := parseCreator(creators[i])
+			b, _ := parseCreator(creators[j])
+			return a < b
+		})
+	}
 
-	identifier := randomURN()
+	var meta Metadata
+	uniqueID := "bookid"
+	if opts.MetadataTemplate != nil {
+		meta, uniqueID = metadataFromTemplate(opts.MetadataTemplate, opts)
+		if len(meta.Languages) > 0 {
+			lang = meta.Languages[0].Value
+		} else {
+			meta.Languages = []DCMeta{{Value: lang}}
+		}
+	} else {
+		meta = Metadata{
+			Titles: []DCMeta{
+				{Value: title},
+			},
+			Languages: []DCMeta{
+				{Value: lang},
+			},
+			Identifiers: []DCMeta{
+				{ID: uniqueID, Value: bookIdentifier(opts)},
+			},
+		}
 
-	meta := Metadata{
-		Titles: []DCMeta{
-			{Value: title},
-		},
-		Languages: []DCMeta{
-			{Value: lang},
-		},
-		Identifiers: []DCMeta{
-			{ID: "bookid", Value: identifier},
-		},
+		if len(opts.Creators) > 0 {
+			meta.Creators, meta.Meta = creatorMetadata(creators)
+		} else {
+			for _, creator := range creators {
+				meta.Creators = append(meta.Creators, DCMeta{Value: creator})
+			}
+		}
 	}
 
-	for _, creator := range creators {
-		meta.Creators = append(meta.Creators, DCMeta{Value: creator})
+	if name := mergedCollection(vols, opts); name != "" && (opts.Collection != "" || volumeCollection(meta) == "") {
+		setCollection(&meta, name)
 	}
 
-	meta.Meta = append(meta.Meta, MetaNode{
-		Property: "novfmt:source-count",
-		Value:    fmt.Sprintf("%d", len(vols)),
-	})
+	if opts.KeepSourceISBNs {
+		addSourceISBNs(&meta, sourceISBNs(vols))
+	}
+
+	mergeDublinCore(&meta, vols, opts)
+
+	if opts.Rights != "" {
+		meta.Rights = []DCMeta{{Value: opts.Rights}}
+	} else if len(meta.Rights) == 0 {
+		meta.Rights = volumeRights(vols, opts.RightsFrom)
+	}
+
+	if !opts.NoToolMeta {
+		meta.Meta = append(meta.Meta, MetaNode{
+			Property: "novfmt:source-count",
+			Value:    fmt.Sprintf("%d", len(vols)),
+		})
+	}
 	meta.Meta = append(meta.Meta, MetaNode{
 		Property: "dcterms:modified",
 		Value:    time.Now().UTC().Format(time.RFC3339),
@@ -248,79 +831,401 @@ func buildPackage(vols []*Volume, manifest Manifest, spine Spine, opts MergeOpti
 		XMLNSDC:          nsDC,
 		XMLNSOPF:         nsOPF,
 		Version:          "3.0",
-		UniqueIdentifier: "bookid",
+		UniqueIdentifier: uniqueID,
 		Lang:             lang,
 		Metadata:         meta,
 		Manifest:         manifest,
 		Spine:            spine,
-		Prefix:           "novfmt: https://novfmt.local/vocab#",
+	}
+	if !opts.NoToolMeta {
+		pkg.Prefix = "novfmt: https://novfmt.local/vocab#"
 	}
 
 	return pkg
 }
 
+// mergeDublinCore fills in the merged book's publisher, date, description
+// and subjects from the volumes, or from opts.Publisher and
+// opts.Description. Fields meta already has (from a metadata template) are
+// left alone, except for those overrides.
+func mergeDublinCore(meta *Metadata, vols []*Volume, opts MergeOptions) {
+	for _, local := range []string{"publisher", "date"} {
+		if len(extraDCValues(*meta, local)) > 0 {
+			continue
+		}
+		for _, v := range vols {
+			els := extraDCElements(v.PackageDoc.Metadata, local)
+			if len(els) == 0 {
+				continue
+			}
+			if local == "date" {
+				els = []RawElement{publicationDate(v.PackageDoc.Metadata, els)}
+			}
+			meta.Extra = append(meta.Extra, els...)
+			break
+		}
+	}
+	if p := strings.TrimSpace(opts.Publisher); p != "" {
+		setExtraDC(meta, "publisher", []string{p})
+	}
+
+	if d := strings.TrimSpace(opts.Description); d != "" {
+		meta.Descriptions = []DCMeta{{Value: d}}
+	} else if len(meta.Descriptions) == 0 {
+		for _, v := range vols {
+			if d := strings.TrimSpace(firstDCValue(v.PackageDoc.Metadata.Descriptions)); d != "" {
+				meta.Descriptions = []DCMeta{{Value: d}}
+				break
+			}
+		}
+	}
+
+	subjects := extraDCValues(*meta, "subject")
+	seen := make(map[string]bool)
+	for _, s := range subjects {
+		seen[strings.ToLower(normalizeSpace(s))] = true
+	}
+	added := false
+	for _, v := range vols {
+		for _, s := range extraDCValues(v.PackageDoc.Metadata, "subject") {
+			key := strings.ToLower(normalizeSpace(s))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			subjects = append(subjects, s)
+			added = true
+		}
+	}
+	if added {
+		setExtraDC(meta, "subject", subjects)
+	}
+}
+
+// extraDCElements returns copies of the Dublin Core elements named local in
+// meta.Extra, keeping only their xml:lang and dir: ids anchor refinements
+// that aren't carried over, and EPUB 2 attributes such as opf:event aren't
+// allowed in the EPUB 3 output.
+func extraDCElements(meta Metadata, local string) []RawElement {
+	var out []RawElement
+	for _, raw := range meta.Extra {
+		if raw.XMLName.Local != local || !isDCSpace(raw.XMLName.Space) || strings.TrimSpace(raw.Inner) == "" {
+			continue
+		}
+		el := RawElement{XMLName: xml.Name{Space: nsDC, Local: local}, Inner: raw.Inner}
+		for _, attr := range raw.Attrs {
+			if attr.Name.Local == "lang" || attr.Name.Local == "dir" {
+				el.Attrs = append(el.Attrs, attr)
+			}
+		}
+		out = append(out, el)
+	}
+	return out
+}
+
+// publicationDate picks the one dc:date EPUB 3 allows out of a volume's
+// dates (els, as extraDCElements returned them): the EPUB 2
+// opf:event="publication" one, else the first without an event, else the
+// first.
+func publicationDate(meta Metadata, els []RawElement) RawElement {
+	var plain = -1
+	n := 0
+	for _, raw := range meta.Extra {
+		if raw.XMLName.Local != "date" || !isDCSpace(raw.XMLName.Space) || strings.TrimSpace(raw.Inner) == "" {
+			continue
+		}
+		event := ""
+		for _, attr := range raw.Attrs {
+			if attr.Name.Local == "event" {
+				event = strings.ToLower(strings.TrimSpace(attr.Value))
+			}
+		}
+		if event == "publication" {
+			return els[n]
+		}
+		if event == "" && plain < 0 {
+			plain = n
+		}
+		n++
+	}
+	if plain >= 0 {
+		return els[plain]
+	}
+	return els[0]
+}
+
+const (
+	RightsFirst = "first"
+	RightsLast  = "last"
+	RightsAll   = "all"
+)
+
+// volumeRights picks the merged dc:rights from the volumes' statements: the
+// first or last volume that has any, or with RightsAll every distinct
+// statement in volume order.
+func volumeRights(vols []*Volume, from string) []DCMeta {
+	var out []DCMeta
+	seen := map[string]bool{}
+	for _, v := range vols {
+		var stmts []DCMeta
+		for _, r := range v.PackageDoc.Metadata.Rights {
+			if value := strings.TrimSpace(r.Value); value != "" {
+				stmts = append(stmts, DCMeta{Value: value})
+			}
+		}
+		if len(stmts) == 0 {
+			continue
+		}
+		switch from {
+		case RightsAll:
+			for _, r := range stmts {
+				if !seen[r.Value] {
+					seen[r.Value] = true
+					out = append(out, r)
+				}
+			}
+		case RightsLast:
+			out = stmts
+		default:
+			return stmts
+		}
+	}
+	return out
+}
+
 func writePackage(pkg *PackageDocument, dest string) error {
-	data, err := xml.MarshalIndent(pkg, "", "  ")
+	data, err := marshalPackage(pkg)
 	if err != nil {
 		return err
 	}
+	return os.WriteFile(dest, data, 0o644)
+}
+
+// marshalPackage serializes a package document as writePackage writes it.
+func marshalPackage(pkg *PackageDocument) ([]byte, error) {
+	// Prefixed sources (<o:package xmlns:o=...>) leave these empty, and the
+	// output always writes unprefixed OPF elements and opf:* attributes.
+	out := *pkg
+	if out.XMLNS == "" {
+		out.XMLNS = nsOPF
+	}
+	if out.XMLNSDC == "" {
+		out.XMLNSDC = nsDC
+	}
+	if out.XMLNSOPF == "" {
+		out.XMLNSOPF = nsOPF
+	}
+	data, err := xml.MarshalIndent(&out, "", "  ")
+	if err != nil {
+		return nil, err
+	}
 	var buf bytes.Buffer
 	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
 	buf.Write(data)
 	buf.WriteByte('\n')
-	return os.WriteFile(dest, buf.Bytes(), 0o644)
+	return buf.Bytes(), nil
+}
+
+const (
+	DefaultContentDir  = "OEBPS"
+	DefaultPackageName = "content.opf"
+)
+
+// packageLayout validates MergeOptions.ContentDir and PackageName, applying
+// the defaults for empty values.
+func packageLayout(dir, name string) (string, string, error) {
+	if dir == "" {
+		dir = DefaultContentDir
+	}
+	if name == "" {
+		name = DefaultPackageName
+	}
+	clean := path.Clean(strings.ReplaceAll(dir, "\\", "/"))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") || path.IsAbs(clean) ||
+		strings.EqualFold(strings.SplitN(clean, "/", 2)[0], "META-INF") {
+		return "", "", fmt.Errorf("invalid content directory %q", dir)
+	}
+	if strings.ContainsAny(name, "/\\") || name == "." || name == ".." || !strings.EqualFold(path.Ext(name), ".opf") {
+		return "", "", fmt.Errorf("invalid package file name %q (want a file name ending in .opf)", name)
+	}
+	return clean, name, nil
 }
 
-func writeContainer(metaDir string) error {
+// writeContainer writes META-INF/container.xml pointing at pkgPath, the
+// package document's path from the archive root.
+func writeContainer(metaDir, pkgPath string) error {
 	if err := os.MkdirAll(metaDir, 0o755); err != nil {
 		return err
 	}
 	container := `<?xml version="1.0" encoding="UTF-8"?>
 <container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
   <rootfiles>
-    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+    <rootfile full-path="` + html.EscapeString(pkgPath) + `" media-type="application/oebps-package+xml"/>
   </rootfiles>
 </container>
 `
 	return os.WriteFile(filepath.Join(metaDir, "container.xml"), []byte(container), 0o644)
 }
 
-func writeNav(vols []*Volume, dest string) error {
+// mixedDirections describes the problem when some volumes read right to left
+// and others don't, since the merged spine (read in direction dir) can have
+// only one direction. It returns "" when the volumes agree.
+func mixedDirections(vols []*Volume, dir string) string {
+	var rtl, other []string
+	for _, vol := range vols {
+		if vol.PackageDoc.Spine.PageProgressionDirection == "rtl" {
+			rtl = append(rtl, vol.DisplayName)
+		} else {
+			other = append(other, vol.DisplayName)
+		}
+	}
+	if len(rtl) == 0 || len(other) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("volumes mix page progression directions (rtl: %s; ltr or unset: %s); the whole book will read %s, so some volumes may page the wrong way; pick the direction with -ppd",
+		strings.Join(rtl, ", "), strings.Join(other, ", "), dir)
+}
+
+// navRegenReason says why MergeOptions.PreserveNav couldn't keep a source
+// nav document.
+func navRegenReason(vols []*Volume, opts MergeOptions) string {
+	switch {
+	case len(vols) > 1:
+		return fmt.Sprintf("a source nav is only kept when the output holds a single volume, not %d", len(vols))
+	case vols[0].NavHref == "":
+		return fmt.Sprintf("%s has no nav document", vols[0].SourcePath)
+	case opts.IndexPage:
+		return "the index page needs an entry in the nav"
+	case opts.ProgressAnchors > 0:
+		return "the progress page list is written into the nav"
+	default:
+		return "the list of illustrations is written into the nav"
+	}
+}
+
+// addRewriteStats adds one volume's rewrite stats to total, with its changed
+// files given package-relative hrefs in the merged book.
+func addRewriteStats(total *RewriteStats, rw RewriteStats, prefix string) {
+	total.FilesChanged += rw.FilesChanged
+	total.MatchCount += rw.MatchCount
+	total.WhitespaceBytes += rw.WhitespaceBytes
+	total.CommentsRemoved += rw.CommentsRemoved
+	total.MetadataMatches += rw.MetadataMatches
+	for _, href := range rw.ChangedFiles {
+		total.ChangedFiles = append(total.ChangedFiles, normalizeEPUBPath(path.Join(prefix, href)))
+	}
+	for _, fm := range rw.FileMatches {
+		fm.Href = normalizeEPUBPath(path.Join(prefix, fm.Href))
+		total.FileMatches = append(total.FileMatches, fm)
+	}
+}
+
+// navHeading is the merged nav's title: opts.TOCTitle, or the usual
+// heading for opts.Language, falling back to English.
+func navHeading(opts MergeOptions) string {
+	if title := strings.TrimSpace(opts.TOCTitle); title != "" {
+		return title
+	}
+	return tocTitleFor(opts.Language)
+}
+
+// renderNav builds the merged nav document with items as its top-level
+// entries and title as both its <title> and heading, followed by the list of
+// illustrations, a landmarks nav and a hidden progress page list when there
+// are any.
+func renderNav(items []NavItem, title string, landmarks []landmark, loi, pages []NavItem) []byte {
+	title = html.EscapeString(title)
 	var buf bytes.Buffer
 	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
 	buf.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">` + "\n")
-	buf.WriteString("<head><title>Table of Contents</title></head>\n<body>\n")
+	buf.WriteString("<head><title>" + title + "</title></head>\n<body>\n")
 	buf.WriteString(`<nav epub:type="toc" id="toc">` + "\n")
-	buf.WriteString("<h1>Table of Contents</h1>\n<ol>\n")
+	buf.WriteString("<h1>" + title + "</h1>\n<ol>\n")
 
-	for _, vol := range vols {
-		entry := buildVolumeNav(vol)
-		if entry == nil {
-			continue
-		}
-		writeNavItem(&buf, *entry)
+	for _, item := range items {
+		writeNavItem(&buf, item)
 	}
 
-	buf.WriteString("</ol>\n</nav>\n</body>\n</html>\n")
-	return os.WriteFile(dest, buf.Bytes(), 0o644)
+	buf.WriteString("</ol>\n</nav>\n")
+	if len(loi) > 0 {
+		writeLOI(&buf, loi)
+	}
+	if len(landmarks) > 0 {
+		writeLandmarks(&buf, landmarks)
+	}
+	if len(pages) > 0 {
+		writePageList(&buf, pages)
+	}
+	buf.WriteString("</body>\n</html>\n")
+	return buf.Bytes()
 }
 
-func writeZip(srcDir, outPath string) error {
+const (
+	CompressionDefault = 0
+	CompressionStore   = -1
+)
+
+// deflateLevel maps a MergeOptions.Compression value to a compress/flate level.
+func deflateLevel(compression int) int {
+	switch compression {
+	case CompressionDefault:
+		return flate.DefaultCompression
+	case CompressionStore:
+		return flate.NoCompression
+	}
+	return compression
+}
+
+type zipOptions struct {
+	// level is the deflate level for content entries: -1 for the default,
+	// 0 to store entries uncompressed, 1-9 as in compress/flate.
+	level int
+	// preserveTimes stamps each content entry with its file's mtime
+	// instead of leaving the modified time unset.
+	preserveTimes bool
+	// canonical writes a correct mimetype whatever the tree holds, puts
+	// META-INF/container.xml right after it and gives every entry mode 0644,
+	// so the archive doesn't depend on how the files were extracted.
+	canonical bool
+}
+
+// writeZip packs srcDir into an EPUB at outPath and returns the hex SHA-256
+// of the bytes written, hashed as they stream out.
+func writeZip(srcDir, outPath string) (string, error) {
+	return writeZipWith(srcDir, outPath, zipOptions{level: flate.DefaultCompression})
+}
+
+func writeZipWith(srcDir, outPath string, zo zipOptions) (string, error) {
 	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
-		return err
+		return "", err
 	}
 
 	out, err := os.Create(outPath)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer out.Close()
 
-	w := zipWriter{w: out}
+	h := sha256.New()
+	w := zipWriter{w: io.MultiWriter(out, h), level: zo.level, preserveTimes: zo.preserveTimes, canonical: zo.canonical}
 	if err := w.addEPUBTree(srcDir); err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksumFile writes a sha256sum-compatible sidecar next to outPath.
+func writeChecksumFile(outPath, sum string) error {
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(outPath))
+	return os.WriteFile(outPath+".sha256", []byte(line), 0o644)
+}
+
+// bookIdentifier is MergeOptions.Identifier, or a fresh random URN.
+func bookIdentifier(opts MergeOptions) string {
+	if opts.Identifier != "" {
+		return opts.Identifier
+	}
+	return randomURN()
 }
 
 func randomURN() string {
@@ -342,7 +1247,35 @@ func normalizeEPUBPath(p string) string {
 	return path.Clean(strings.ReplaceAll(p, "\\", "/"))
 }
 
-func buildVolumeNav(vol *Volume) *NavItem {
+// volumeNavEntries returns one nav entry per volume, nesting its own TOC.
+// Runs of volumes that share an arc are grouped under an entry for the arc,
+// which links to the first of them.
+func volumeNavEntries(vols []*Volume, coverTitle string) []NavItem {
+	var out []NavItem
+	var arc *NavItem
+	for _, vol := range vols {
+		entry := buildVolumeNav(vol, coverTitle)
+		if entry == nil {
+			continue
+		}
+		if vol.Arc == "" {
+			arc = nil
+			out = append(out, *entry)
+			continue
+		}
+		if arc == nil || arc.Title != vol.Arc {
+			out = append(out, NavItem{Title: vol.Arc, Href: entry.Href})
+			arc = &out[len(out)-1]
+		}
+		arc.Children = append(arc.Children, *entry)
+	}
+	return out
+}
+
+// buildVolumeNav returns the volume's merged nav entry, with its own nav
+// entries as children. When coverTitle is set and the volume has a cover
+// page, that page comes first under that title.
+func buildVolumeNav(vol *Volume, coverTitle string) *NavItem {
 	if vol == nil {
 		return nil
 	}
@@ -359,9 +1292,34 @@ func buildVolumeNav(vol *Volume) *NavItem {
 			entry.Href = entry.Children[0].Href
 		}
 	}
+	if coverTitle != "" && vol.CoverHref != "" {
+		children := []NavItem{{Title: coverTitle, Href: vol.CoverHref}}
+		for _, child := range entry.Children {
+			if len(child.Children) == 0 && child.Href == vol.CoverHref {
+				continue
+			}
+			children = append(children, child)
+		}
+		entry.Children = children
+		if entry.Href == "" {
+			entry.Href = vol.CoverHref
+		}
+	}
 	return entry
 }
 
+// coverEntryTitle is the title of each volume's cover entry, or "" when
+// MergeOptions.CoverEntries is off.
+func coverEntryTitle(opts MergeOptions) string {
+	if !opts.CoverEntries {
+		return ""
+	}
+	if title := strings.TrimSpace(opts.CoverEntryTitle); title != "" {
+		return title
+	}
+	return defaultCoverEntryTitle
+}
+
 func cloneNavItems(items []NavItem, prefix string) []NavItem {
 	out := make([]NavItem, 0, len(items))
 	for _, item := range items {
@@ -405,27 +1363,118 @@ func writeNavItem(buf *bytes.Buffer, item NavItem) {
 	buf.WriteString("</li>\n")
 }
 
-func copyVolumePayload(vol *Volume, dst string) error {
-	pkgRel := filepath.Base(vol.PackagePath)
-	navRel := path.Clean(filepath.ToSlash(vol.NavHref))
-	return filepath.Walk(vol.PackageDir, func(p string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// collapseDuplicateHrefs drops manifest items that repeat an earlier item's
+// href, which would otherwise become two entries for one merged file. The
+// dropped items' properties are folded into the one kept, and their ids are
+// recorded in vol.IDAliases so spine and fallback references still resolve.
+// It returns a warning for each item dropped.
+func collapseDuplicateHrefs(vol *Volume) []string {
+	var warnings []string
+	items := vol.PackageDoc.Manifest.Items
+	kept := items[:0]
+	first := make(map[string]int, len(items))
+	for _, item := range items {
+		href := normalizeEPUBPath(item.Href)
+		i, dup := first[href]
+		if !dup {
+			first[href] = len(kept)
+			kept = append(kept, item)
+			continue
 		}
-		if info.IsDir() {
-			return nil
+		keep := &kept[i]
+		for _, p := range strings.Fields(item.Properties) {
+			keep.Properties = addProperty(keep.Properties, p)
+		}
+		if keep.Fallback == "" {
+			keep.Fallback = item.Fallback
+		}
+		if keep.MediaOverlay == "" {
+			keep.MediaOverlay = item.MediaOverlay
+		}
+		if vol.IDAliases == nil {
+			vol.IDAliases = make(map[string]string)
+		}
+		vol.IDAliases[item.ID] = keep.ID
+		if vol.CoverID == item.ID {
+			vol.CoverID = keep.ID
 		}
-		rel, err := filepath.Rel(vol.PackageDir, p)
+		warnings = append(warnings, fmt.Sprintf("%s: manifest items %q and %q both point at %s; keeping %q", vol.SourcePath, keep.ID, item.ID, item.Href, keep.ID))
+	}
+	vol.PackageDoc.Manifest.Items = kept
+	return warnings
+}
+
+// canonicalID returns the id of the manifest item that stands for id after
+// collapseDuplicateHrefs.
+func (v *Volume) canonicalID(id string) string {
+	if keep, ok := v.IDAliases[id]; ok {
+		return keep
+	}
+	return id
+}
+
+// volumeBaseDir returns the deepest directory holding the volume's package
+// document, every manifest resource, and whatever the cover and title pages
+// link to (their stylesheets are often left out of the manifest). It is the
+// package directory unless some of these climb out of it; nothing above the
+// archive root counts.
+func volumeBaseDir(vol *Volume) string {
+	var hrefs []string
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		hrefs = append(hrefs, item.Href)
+	}
+	if covers, err := coverDocuments(vol); err == nil {
+		for _, href := range covers {
+			if data, err := os.ReadFile(filepath.Join(vol.PackageDir, filepath.FromSlash(href))); err == nil {
+				hrefs = append(hrefs, localRefs(data, href, false)...)
+			}
+		}
+	}
+
+	base := vol.PackageDir
+	for _, href := range hrefs {
+		dir := filepath.Dir(filepath.Join(vol.PackageDir, filepath.FromSlash(href)))
+		for !isWithinDir(base, dir) {
+			if base == vol.RootDir || filepath.Dir(base) == base {
+				break
+			}
+			base = filepath.Dir(base)
+		}
+	}
+	return base
+}
+
+// isWithinDir reports whether p is dir or lies below it.
+func isWithinDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// copyVolumePayload copies the files under baseDir to dst, leaving out the
+// package document, the nav unless withNav (the merge writes its own) and,
+// when baseDir is the archive root, the container files.
+func copyVolumePayload(vol *Volume, baseDir, dst string, withNav bool) error {
+	skip := map[string]bool{filepath.Clean(vol.PackagePath): true}
+	if vol.NavHref != "" && !withNav {
+		skip[filepath.Join(vol.PackageDir, filepath.FromSlash(vol.NavHref))] = true
+	}
+	return filepath.Walk(baseDir, func(p string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if rel == pkgRel {
+		if info.IsDir() {
+			if p == filepath.Join(vol.RootDir, "META-INF") {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		relSlash := path.Clean(filepath.ToSlash(rel))
-		if navRel != "" && relSlash == navRel {
+		if skip[p] || p == filepath.Join(vol.RootDir, "mimetype") {
 			return nil
 		}
+		rel, err := filepath.Rel(baseDir, p)
+		if err != nil {
+			return err
+		}
 		target := filepath.Join(dst, rel)
 		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
 			return err
@@ -445,26 +1494,55 @@ func copyFile(src, dst string, mode os.FileMode) error {
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-
 	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// Keep the source's mtime for MergeOptions.PreserveTimes.
+	info, err := in.Stat()
+	if err != nil {
 		return err
 	}
-	return nil
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// storedExts are audio and video formats that are already compressed; they
+// are stored as-is, since deflating large media only costs time.
+var storedExts = map[string]bool{
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".oga": true, ".opus": true,
+	".mp4": true, ".m4v": true, ".webm": true,
 }
 
 type zipWriter struct {
-	w io.Writer
+	w             io.Writer
+	level         int
+	preserveTimes bool
+	canonical     bool
 }
 
 func (zw *zipWriter) addEPUBTree(root string) error {
 	writer := zip.NewWriter(zw.w)
+	method := zip.Deflate
+	switch {
+	case zw.level == flate.NoCompression:
+		method = zip.Store
+	case zw.level != flate.DefaultCompression:
+		level := zw.level
+		writer.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
 
-	mimePath := filepath.Join(root, "mimetype")
-	mimeData, err := os.ReadFile(mimePath)
-	if err != nil {
-		writer.Close()
-		return err
+	mimeData := []byte(epubMimetype)
+	if !zw.canonical {
+		var err error
+		if mimeData, err = os.ReadFile(filepath.Join(root, "mimetype")); err != nil {
+			writer.Close()
+			return err
+		}
 	}
 
 	mimeHeader := &zip.FileHeader{
@@ -482,25 +1560,22 @@ func (zw *zipWriter) addEPUBTree(root string) error {
 		return err
 	}
 
-	if err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
+	addFile := func(p, rel string, info os.FileInfo) error {
+		header := &zip.FileHeader{
+			Name:   filepath.ToSlash(rel),
+			Method: method,
 		}
-		rel, err := filepath.Rel(root, p)
-		if err != nil {
-			return err
+		if storedExts[strings.ToLower(filepath.Ext(p))] {
+			header.Method = zip.Store
 		}
-		if rel == "mimetype" {
-			return nil
+		if zw.canonical {
+			header.SetMode(0o644)
+		} else {
+			header.SetMode(info.Mode())
 		}
-		header := &zip.FileHeader{
-			Name:   filepath.ToSlash(rel),
-			Method: zip.Deflate,
+		if zw.preserveTimes {
+			header.Modified = info.ModTime()
 		}
-		header.SetMode(info.Mode())
 		w, err := writer.CreateHeader(header)
 		if err != nil {
 			return err
@@ -515,6 +1590,34 @@ func (zw *zipWriter) addEPUBTree(root string) error {
 		}
 		f.Close()
 		return nil
+	}
+
+	containerRel := filepath.Join("META-INF", "container.xml")
+	if zw.canonical {
+		p := filepath.Join(root, containerRel)
+		if info, err := os.Stat(p); err == nil {
+			if err := addFile(p, containerRel, info); err != nil {
+				writer.Close()
+				return err
+			}
+		}
+	}
+
+	if err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "mimetype" || zw.canonical && rel == containerRel {
+			return nil
+		}
+		return addFile(p, rel, info)
 	}); err != nil {
 		writer.Close()
 		return err
@@ -1,6 +1,18 @@
 package epub
 
-import "testing"
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestBuildPackageDefaults(t *testing.T) {
 	vols := []*Volume{
@@ -32,7 +44,8 @@ func TestBuildPackageDefaults(t *testing.T) {
 	if got := pkg.Metadata.Languages[0].Value; got != "ja" {
 		t.Fatalf("language mismatch: %q", got)
 	}
-	wantCreators := []string{"Author A", "Author B"}
+	// Creators keep the order they first appear in; SortCreators sorts them.
+	wantCreators := []string{"Author B", "Author A"}
 	if len(pkg.Metadata.Creators) != len(wantCreators) {
 		t.Fatalf("creator count mismatch: %d", len(pkg.Metadata.Creators))
 	}
@@ -41,6 +54,10 @@ func TestBuildPackageDefaults(t *testing.T) {
 			t.Fatalf("creator[%d]=%q want %q", i, pkg.Metadata.Creators[i].Value, want)
 		}
 	}
+	sorted := buildPackage(vols, Manifest{}, Spine{}, MergeOptions{SortCreators: true}, "")
+	if c := sorted.Metadata.Creators; len(c) != 2 || c[0].Value != "Author A" || c[1].Value != "Author B" {
+		t.Fatalf("sorted creators = %+v", c)
+	}
 	if pkg.Metadata.Identifiers[0].ID != "bookid" {
 		t.Fatalf("identifier id mismatch: %s", pkg.Metadata.Identifiers[0].ID)
 	}
@@ -81,3 +98,841 @@ func TestHasProperty(t *testing.T) {
 		t.Fatalf("unexpected partial match")
 	}
 }
+
+func TestMergeEPUBsChecksum(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{
+		OutPath:       out,
+		WriteChecksum: true,
+	})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if stats.Volumes != 2 {
+		t.Fatalf("volumes = %d", stats.Volumes)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if want := hex.EncodeToString(sum[:]); stats.SHA256 != want {
+		t.Fatalf("sha256 = %s want %s", stats.SHA256, want)
+	}
+
+	sidecar, err := os.ReadFile(out + ".sha256")
+	if err != nil {
+		t.Fatalf("read sidecar: %v", err)
+	}
+	if got := strings.TrimSpace(string(sidecar)); got != stats.SHA256+"  merged.epub" {
+		t.Fatalf("sidecar = %q", got)
+	}
+}
+
+func TestTitlePrefixStripper(t *testing.T) {
+	cases := []struct {
+		name   string
+		prefix string
+		regex  bool
+		in     string
+		want   string
+	}{
+		{"literal", "My Series ", false, "My Series Vol. 3", "Vol. 3"},
+		{"literal-miss", "Other ", false, "My Series Vol. 3", "My Series Vol. 3"},
+		{"literal-whole", "My Series", false, "My Series", "My Series"},
+		{"regex", `My Series[:,]?\s*`, true, "My Series: Vol. 3", "Vol. 3"},
+		{"regex-anchored", `Vol`, true, "My Series Vol. 3", "My Series Vol. 3"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			strip, err := titlePrefixStripper(tc.prefix, tc.regex)
+			if err != nil {
+				t.Fatalf("stripper: %v", err)
+			}
+			if got := strip(tc.in); got != tc.want {
+				t.Fatalf("strip(%q) = %q want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+
+	if _, err := titlePrefixStripper("(", true); err == nil {
+		t.Fatalf("expected error for invalid regex")
+	}
+}
+
+func TestMergeEPUBsCompressionStore(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{
+		OutPath:     out,
+		Compression: CompressionStore,
+		TempDir:     t.TempDir(),
+	}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	r, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Method != zip.Store {
+			t.Fatalf("%s stored with method %d, want store", f.Name, f.Method)
+		}
+	}
+}
+
+func TestMergeEPUBsMedia(t *testing.T) {
+	enhanced := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Enhanced",
+			identifier: "urn:test:media",
+			items: []ManifestItem{
+				testItem("chap", "Text/chapter.xhtml"),
+				testItem("clip", "Audio/clip.mp3"),
+				testItem("film", "Video/film.mp4"),
+				testItem("still", "Images/still.png"),
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/Text/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body>
+<audio controls="controls" src="../Audio/clip.mp3"><source src="../Audio/clip.mp3" type="audio/mpeg"/></audio>
+<video controls="controls" poster="../Images/still.png"><source src="../Video/film.mp4" type="video/mp4"/></video>
+</body></html>`,
+		"OEBPS/Audio/clip.mp3":   "ID3" + strings.Repeat("a", 4096),
+		"OEBPS/Video/film.mp4":   strings.Repeat("v", 4096),
+		"OEBPS/Images/still.png": "png",
+	})
+	plain := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{enhanced, plain}, MergeOptions{OutPath: out, Verify: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	for _, c := range stats.Verification {
+		if !c.OK() {
+			t.Fatalf("verify %s: %q", c.Prefix, c.Problems)
+		}
+	}
+
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	types := map[string]string{}
+	for _, item := range book.Package.Manifest.Items {
+		types[item.ID] = item.Href + " " + item.MediaType
+	}
+	if types["v0001_clip"] != "Volumes/v0001/Audio/clip.mp3 audio/mpeg" || types["v0001_film"] != "Volumes/v0001/Video/film.mp4 video/mp4" {
+		t.Fatalf("media items = %v", types)
+	}
+	chapter, err := book.ReadFile("Volumes/v0001/Text/chapter.xhtml")
+	if err != nil {
+		t.Fatalf("read chapter: %v", err)
+	}
+	for _, ref := range []string{`src="../Audio/clip.mp3"`, `src="../Video/film.mp4"`, `poster="../Images/still.png"`} {
+		if !strings.Contains(string(chapter), ref) {
+			t.Fatalf("missing %s in:\n%s", ref, chapter)
+		}
+	}
+
+	r, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		switch f.Name {
+		case "OEBPS/Volumes/v0001/Audio/clip.mp3", "OEBPS/Volumes/v0001/Video/film.mp4":
+			if f.Method != zip.Store {
+				t.Fatalf("%s deflated, want stored", f.Name)
+			}
+		case "OEBPS/Volumes/v0001/Text/chapter.xhtml":
+			if f.Method != zip.Deflate {
+				t.Fatalf("%s stored, want deflated", f.Name)
+			}
+		}
+	}
+}
+
+func TestBuildPackageNoToolMeta(t *testing.T) {
+	vols := []*Volume{{PackageDoc: &PackageDocument{}}}
+
+	pkg := buildPackage(vols, Manifest{}, Spine{}, MergeOptions{}, "")
+	if pkg.Prefix == "" {
+		t.Fatalf("default package should declare the novfmt prefix")
+	}
+
+	pkg = buildPackage(vols, Manifest{}, Spine{}, MergeOptions{NoToolMeta: true}, "")
+	if pkg.Prefix != "" {
+		t.Fatalf("prefix = %q", pkg.Prefix)
+	}
+	for _, meta := range pkg.Metadata.Meta {
+		if strings.HasPrefix(meta.Property, "novfmt:") {
+			t.Fatalf("unexpected tool meta %+v", meta)
+		}
+	}
+}
+
+func TestDisambiguateTitles(t *testing.T) {
+	names := []string{"Saga", "Side Story", "Saga", "Saga"}
+	vols := make([]*Volume, len(names))
+	for i, n := range names {
+		vols[i] = &Volume{DisplayName: n}
+	}
+	disambiguateTitles(vols)
+
+	want := []string{"Saga (1)", "Side Story", "Saga (2)", "Saga (3)"}
+	for i, w := range want {
+		if vols[i].DisplayName != w {
+			t.Fatalf("vol %d = %q want %q", i, vols[i].DisplayName, w)
+		}
+	}
+}
+
+func TestMergeEPUBsPackageLayout(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{
+		OutPath:     out,
+		ContentDir:  "EPUB",
+		PackageName: "package.opf",
+		TempDir:     t.TempDir(),
+	}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	r, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	var hasPkg bool
+	for _, f := range r.File {
+		if strings.HasPrefix(f.Name, "OEBPS/") {
+			t.Fatalf("unexpected entry %s", f.Name)
+		}
+		hasPkg = hasPkg || f.Name == "EPUB/package.opf"
+	}
+	r.Close()
+	if !hasPkg {
+		t.Fatalf("EPUB/package.opf missing")
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("loadVolume: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	if filepath.Base(vol.PackageDir) != "EPUB" {
+		t.Fatalf("package dir = %s", vol.PackageDir)
+	}
+	if len(vol.PackageDoc.Spine.Itemrefs) == 0 {
+		t.Fatalf("merged spine is empty")
+	}
+
+	for _, opts := range []MergeOptions{
+		{ContentDir: "../out"},
+		{ContentDir: "META-INF"},
+		{PackageName: "sub/package.opf"},
+		{PackageName: "package.xml"},
+	} {
+		opts.OutPath = out
+		if _, err := MergeEPUBs(context.Background(), []string{a, b}, opts); err == nil {
+			t.Fatalf("expected error for %+v", opts)
+		}
+	}
+}
+
+func TestMergeEPUBsArcs(t *testing.T) {
+	var sources []string
+	for _, name := range []string{"Prologue", "Vol 1", "Vol 2", "Vol 3"} {
+		sources = append(sources, buildChaptersEPUB(t, name, "Chapter"))
+	}
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), sources, MergeOptions{OutPath: out, Arcs: []string{"A"}}); err == nil {
+		t.Fatalf("expected an error for too few arc names")
+	}
+	if _, err := MergeEPUBs(context.Background(), sources, MergeOptions{OutPath: out, Arcs: []string{"", "First Arc", "First Arc", "Second Arc"}}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	var got []string
+	for _, item := range book.NavItems {
+		var children []string
+		for _, c := range item.Children {
+			children = append(children, c.Title)
+		}
+		got = append(got, item.Title+"["+strings.Join(children, ",")+"]")
+	}
+	want := "Prologue[Chapter] First Arc[Vol 1,Vol 2] Second Arc[Vol 3]"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("nav = %q want %q", strings.Join(got, " "), want)
+	}
+	if first := book.NavItems[1]; first.Href != first.Children[0].Href {
+		t.Fatalf("arc entry should link to its first volume: %+v", first)
+	}
+	if len(book.Package.Spine.Itemrefs) != 4 {
+		t.Fatalf("spine = %+v", book.Package.Spine.Itemrefs)
+	}
+}
+
+func TestBuildPackageRights(t *testing.T) {
+	withRights := func(stmts ...string) *Volume {
+		meta := Metadata{}
+		for _, s := range stmts {
+			meta.Rights = append(meta.Rights, DCMeta{Value: s})
+		}
+		return &Volume{PackageDoc: &PackageDocument{Metadata: meta}}
+	}
+	vols := []*Volume{withRights(), withRights("© Author"), withRights("CC BY 4.0"), withRights("© Author")}
+
+	cases := []struct {
+		opts MergeOptions
+		want []string
+	}{
+		{MergeOptions{}, []string{"© Author"}},
+		{MergeOptions{RightsFrom: RightsLast}, []string{"© Author"}},
+		{MergeOptions{RightsFrom: RightsAll}, []string{"© Author", "CC BY 4.0"}},
+		{MergeOptions{Rights: "All rights reserved", RightsFrom: RightsAll}, []string{"All rights reserved"}},
+	}
+	for _, tc := range cases {
+		pkg := buildPackage(vols, Manifest{}, Spine{}, tc.opts, "")
+		var got []string
+		for _, r := range pkg.Metadata.Rights {
+			got = append(got, r.Value)
+		}
+		if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Fatalf("%+v: rights = %q want %q", tc.opts, got, tc.want)
+		}
+	}
+
+	if got := volumeRights(vols[:3], RightsLast); len(got) != 1 || got[0].Value != "CC BY 4.0" {
+		t.Fatalf("last = %+v", got)
+	}
+}
+
+func TestBuildPackageDublinCore(t *testing.T) {
+	withMeta := func(inner string) *Volume {
+		var pkg PackageDocument
+		data := `<package xmlns="http://www.idpf.org/2007/opf" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf" version="2.0"><metadata>` + inner + `</metadata></package>`
+		if err := xml.Unmarshal([]byte(data), &pkg); err != nil {
+			t.Fatal(err)
+		}
+		return &Volume{PackageDoc: &pkg}
+	}
+	vols := []*Volume{
+		withMeta(`<dc:title>One</dc:title><dc:date opf:event="modification">2020-02-02</dc:date><dc:date opf:event="publication">2019-04-01</dc:date><dc:subject>Fantasy</dc:subject><dc:subject>Light novel</dc:subject>`),
+		withMeta(`<dc:title>Two</dc:title><dc:publisher id="pub">Yen Press</dc:publisher><dc:description>Second volume.</dc:description><dc:subject>fantasy</dc:subject><dc:subject>Isekai</dc:subject>`),
+		withMeta(`<dc:title>Three</dc:title><dc:publisher>Other House</dc:publisher><dc:date>2021-01-01</dc:date>`),
+	}
+
+	reparse := func(pkg *PackageDocument) Metadata {
+		data, err := marshalPackage(pkg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back PackageDocument
+		if err := xml.Unmarshal(data, &back); err != nil {
+			t.Fatalf("%v\n%s", err, data)
+		}
+		return back.Metadata
+	}
+
+	meta := reparse(buildPackage(vols, Manifest{}, Spine{}, MergeOptions{}, ""))
+	if got := extraDCValues(meta, "publisher"); len(got) != 1 || got[0] != "Yen Press" {
+		t.Errorf("publisher = %q", got)
+	}
+	if got := extraDCValues(meta, "date"); len(got) != 1 || got[0] != "2019-04-01" {
+		t.Errorf("date = %q", got)
+	}
+	if got := firstDCValue(meta.Descriptions); got != "Second volume." {
+		t.Errorf("description = %q", got)
+	}
+	if got := extraDCValues(meta, "subject"); strings.Join(got, "|") != "Fantasy|Light novel|Isekai" {
+		t.Errorf("subjects = %q", got)
+	}
+	for _, raw := range meta.Extra {
+		for _, attr := range raw.Attrs {
+			if attr.Name.Local == "id" || attr.Name.Local == "event" {
+				t.Errorf("%s kept its %s", raw.XMLName.Local, attr.Name.Local)
+			}
+		}
+	}
+
+	meta = reparse(buildPackage(vols, Manifest{}, Spine{}, MergeOptions{Publisher: "Omnibus Press", Description: "All three."}, ""))
+	if got := extraDCValues(meta, "publisher"); len(got) != 1 || got[0] != "Omnibus Press" {
+		t.Errorf("publisher override = %q", got)
+	}
+	if got := firstDCValue(meta.Descriptions); got != "All three." {
+		t.Errorf("description override = %q", got)
+	}
+}
+
+func TestMergeEPUBsKeepsRights(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Rights: "CC BY-SA 4.0"}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	if r := vol.PackageDoc.Metadata.Rights; len(r) != 1 || r[0].Value != "CC BY-SA 4.0" {
+		t.Fatalf("rights = %+v", r)
+	}
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, RightsFrom: "middle"}); err == nil {
+		t.Fatalf("expected error for invalid rights source")
+	}
+}
+
+func TestMergeEPUBsTOCTitle(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "ja")
+	b := buildTestEPUB(t, "Vol 2", "ja")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	for _, tc := range []struct {
+		opts MergeOptions
+		want string
+	}{
+		{MergeOptions{}, "<h1>Table of Contents</h1>"},
+		{MergeOptions{Language: "ja"}, "<h1>目次</h1>"},
+		{MergeOptions{Language: "ja", TOCTitle: "Contents & Notes"}, "<title>Contents &amp; Notes</title>"},
+	} {
+		tc.opts.OutPath = out
+		if _, err := MergeEPUBs(context.Background(), []string{a, b}, tc.opts); err != nil {
+			t.Fatalf("MergeEPUBs: %v", err)
+		}
+		vol, err := loadVolume(context.Background(), 0, out)
+		if err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		nav, err := os.ReadFile(filepath.Join(vol.PackageDir, "nav.xhtml"))
+		os.RemoveAll(vol.TempDir)
+		if err != nil {
+			t.Fatalf("read nav: %v", err)
+		}
+		if !strings.Contains(string(nav), tc.want) {
+			t.Fatalf("%+v: nav lacks %q:\n%s", tc.opts, tc.want, nav)
+		}
+	}
+}
+
+func TestMergeEPUBsPreserveNav(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Single",
+			identifier: "urn:test:preserve-nav",
+			items: []ManifestItem{
+				testItem("nav", "Text/nav.xhtml", "nav"),
+				testItem("chap", "Text/chapter.xhtml"),
+				testItem("ncx", "toc.ncx"),
+			},
+			spine:    []string{"chap"},
+			spineToc: "ncx",
+		}),
+		"OEBPS/toc.ncx": `<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1"><navMap/></ncx>`,
+		"OEBPS/Text/nav.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol><li><a href="chapter.xhtml">Chapter</a></li></ol></nav>
+<nav epub:type="page-list"><ol><li><a href="chapter.xhtml#p1">1</a></li></ol></nav>
+</body></html>`,
+		"OEBPS/Text/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p id="p1">Text</p></body></html>`,
+	})
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{input}, MergeOptions{OutPath: out}); err == nil {
+		t.Fatalf("expected error for a single volume without PreserveNav")
+	}
+
+	stats, err := MergeEPUBs(context.Background(), []string{input}, MergeOptions{OutPath: out, PreserveNav: true, Verify: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if len(stats.Warnings) != 0 {
+		t.Fatalf("warnings = %q", stats.Warnings)
+	}
+	for _, c := range stats.Verification {
+		if !c.OK() {
+			t.Fatalf("verify %s: %q", c.Prefix, c.Problems)
+		}
+	}
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	if vol.NavHref != "Volumes/v0001/Text/nav.xhtml" {
+		t.Fatalf("nav href = %q", vol.NavHref)
+	}
+	nav, err := os.ReadFile(filepath.Join(vol.PackageDir, filepath.FromSlash(vol.NavHref)))
+	if err != nil {
+		t.Fatalf("read nav: %v", err)
+	}
+	if !strings.Contains(string(nav), `epub:type="page-list"`) {
+		t.Fatalf("page list lost: %s", nav)
+	}
+	if _, err := os.Stat(filepath.Join(vol.PackageDir, "nav.xhtml")); !os.IsNotExist(err) {
+		t.Fatalf("no nav should be generated")
+	}
+	if vol.PackageDoc.Spine.Toc != "v0001_ncx" {
+		t.Fatalf("spine toc = %q", vol.PackageDoc.Spine.Toc)
+	}
+
+	stats, err = MergeEPUBs(context.Background(), []string{input, input}, MergeOptions{OutPath: out, PreserveNav: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs two volumes: %v", err)
+	}
+	merged, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(merged.TempDir)
+	if merged.PackageDoc.Spine.Toc != "" {
+		t.Fatalf("a volume's NCX should not become the book's, toc = %q", merged.PackageDoc.Spine.Toc)
+	}
+	if len(stats.Warnings) != 1 || !strings.Contains(stats.Warnings[0], "nav regenerated") {
+		t.Fatalf("warnings = %q", stats.Warnings)
+	}
+}
+
+func TestMergeEPUBsMixedDirections(t *testing.T) {
+	book := func(title, ppd string) string {
+		return buildTestEPUBFiles(t, map[string]string{
+			"OEBPS/content.opf": testOPF(testPackage{
+				title:           title,
+				identifier:      "urn:test:ppd",
+				items:           []ManifestItem{testItem("chap", "chapter.xhtml")},
+				spine:           []string{"chap"},
+				pageProgression: ppd,
+			}),
+			"OEBPS/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Text</p></body></html>`,
+		})
+	}
+	jp := book("JP", "rtl")
+	jp2 := book("JP 2", "rtl")
+	en := book("EN", "")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{jp, en}, MergeOptions{OutPath: out})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if len(stats.Warnings) != 1 || !strings.Contains(stats.Warnings[0], "rtl: JP; ltr or unset: EN") || !strings.Contains(stats.Warnings[0], "-ppd") {
+		t.Fatalf("warnings = %q", stats.Warnings)
+	}
+
+	if stats, err = MergeEPUBs(context.Background(), []string{jp, jp2}, MergeOptions{OutPath: out}); err != nil || len(stats.Warnings) != 0 {
+		t.Fatalf("agreeing volumes: %v %q", err, stats.Warnings)
+	}
+
+	stats, err = MergeEPUBs(context.Background(), []string{jp, en}, MergeOptions{OutPath: out, PageProgression: "ltr"})
+	if err != nil || len(stats.Warnings) != 0 {
+		t.Fatalf("explicit direction: %v %q", err, stats.Warnings)
+	}
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	if got := vol.PackageDoc.Spine.PageProgressionDirection; got != "ltr" {
+		t.Fatalf("ppd = %q", got)
+	}
+
+	if _, err := MergeEPUBs(context.Background(), []string{jp, en}, MergeOptions{OutPath: out, PageProgression: "up"}); err == nil {
+		t.Fatalf("expected error for invalid direction")
+	}
+}
+
+func TestMergeEPUBsCoverOutsidePackageDir(t *testing.T) {
+	// The cover page links a stylesheet in a sibling of the package
+	// directory that the manifest doesn't list, and its image is listed
+	// with an href climbing out of the package directory.
+	a := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Vol 1",
+			identifier: "urn:test:cover-paths",
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				testItem("cover", "cover.xhtml"),
+				testItem("cover-img", "../images/cover.jpg", "cover-image"),
+				testItem("chap", "chapter.xhtml"),
+			},
+			spine: []string{"cover", "chap"},
+		}),
+		"OEBPS/nav.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol><li><a href="chapter.xhtml">Chapter</a></li></ol></nav>
+<nav epub:type="landmarks"><ol><li><a epub:type="cover" href="cover.xhtml">Cover</a></li></ol></nav>
+</body></html>`,
+		"OEBPS/cover.xhtml":   `<html xmlns="http://www.w3.org/1999/xhtml"><head><link rel="stylesheet" href="../styles/cover.css"/></head><body><img src="../images/cover.jpg" alt=""/></body></html>`,
+		"OEBPS/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Text</p></body></html>`,
+		"styles/cover.css":    `img { width: 100% }`,
+		"images/cover.jpg":    "jpeg",
+	})
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Verify: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	for _, c := range stats.Verification {
+		if !c.OK() {
+			t.Fatalf("%s: %v", c.Prefix, c.Problems)
+		}
+	}
+
+	r, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer r.Close()
+	entries := map[string]bool{}
+	for _, f := range r.File {
+		entries[f.Name] = true
+	}
+	for _, want := range []string{
+		"OEBPS/Volumes/v0001/OEBPS/cover.xhtml",
+		"OEBPS/Volumes/v0001/styles/cover.css",
+		"OEBPS/Volumes/v0001/images/cover.jpg",
+		"OEBPS/Volumes/v0002/chapter.xhtml",
+	} {
+		if !entries[want] {
+			t.Fatalf("missing %s in %v", want, entries)
+		}
+	}
+	for name := range entries {
+		if strings.HasPrefix(name, "OEBPS/Volumes/v0001/META-INF") || name == "OEBPS/Volumes/v0001/mimetype" {
+			t.Fatalf("container file copied into the volume: %s", name)
+		}
+	}
+}
+
+func TestMergeEPUBsDryRun(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, DryRun: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("dry run wrote %s (stat err %v)", out, err)
+	}
+	if stats.Volumes != 2 || stats.StagedBytes == 0 || stats.EstimatedBytes == 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	written, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	info, err := os.Stat(written.OutPath)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	// The estimate should land in the right neighbourhood of the real size.
+	if est, got := stats.EstimatedBytes, info.Size(); est < got/2 || est > got*2 {
+		t.Fatalf("estimated %d bytes, wrote %d", est, got)
+	}
+}
+
+func TestMergeEPUBsDryRunPrintOPF(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Title: "Saga", DryRun: true, PrintOPF: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("dry run wrote %s (stat err %v)", out, err)
+	}
+	if len(stats.Generated) != 2 || stats.Generated[0].Path != "OEBPS/content.opf" || stats.Generated[1].Path != "OEBPS/nav.xhtml" {
+		t.Fatalf("generated = %+v", stats.Generated)
+	}
+	opf, nav := string(stats.Generated[0].Data), string(stats.Generated[1].Data)
+	if !strings.Contains(opf, ">Saga</title>") || !strings.Contains(opf, `href="Volumes/v0002/chapter.xhtml"`) {
+		t.Fatalf("opf = %s", opf)
+	}
+	if !strings.Contains(nav, `<a href="Volumes/v0001/chapter.xhtml">Vol 1</a>`) {
+		t.Fatalf("nav = %s", nav)
+	}
+
+	if stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, DryRun: true}); err != nil || stats.Generated != nil {
+		t.Fatalf("without PrintOPF: %+v, %v", stats.Generated, err)
+	}
+}
+
+// stampEPUB rewrites an EPUB so every entry carries the modified time stamp.
+func stampEPUB(t *testing.T, path string, stamp time.Time) {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer r.Close()
+	stamped := path + ".stamped"
+	out, err := os.Create(stamped)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	w := zip.NewWriter(out)
+	for _, f := range r.File {
+		header := f.FileHeader
+		header.Modified = stamp
+		dst, err := w.CreateHeader(&header)
+		if err != nil {
+			t.Fatalf("create %s: %v", f.Name, err)
+		}
+		src, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			t.Fatalf("copy %s: %v", f.Name, err)
+		}
+		src.Close()
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	out.Close()
+	if err := os.Rename(stamped, path); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+}
+
+func TestMergeEPUBsPreserveTimes(t *testing.T) {
+	stamp := time.Date(2019, 4, 1, 12, 30, 0, 0, time.UTC)
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	stampEPUB(t, a, stamp)
+	stampEPUB(t, b, stamp)
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	entryTimes := func() map[string]time.Time {
+		r, err := zip.OpenReader(out)
+		if err != nil {
+			t.Fatalf("open zip: %v", err)
+		}
+		defer r.Close()
+		times := map[string]time.Time{}
+		for _, f := range r.File {
+			times[f.Name] = f.Modified
+		}
+		return times
+	}
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	for name, mt := range entryTimes() {
+		if mt.Equal(stamp) {
+			t.Fatalf("%s kept its source time without -preserve-times", name)
+		}
+	}
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, PreserveTimes: true}); err != nil {
+		t.Fatalf("MergeEPUBs preserve: %v", err)
+	}
+	times := entryTimes()
+	for _, name := range []string{"OEBPS/Volumes/v0001/chapter.xhtml", "OEBPS/Volumes/v0002/chapter.xhtml"} {
+		mt, ok := times[name]
+		if !ok {
+			t.Fatalf("missing %s in %v", name, times)
+		}
+		if !mt.Equal(stamp) {
+			t.Fatalf("%s modified = %v want %v", name, mt, stamp)
+		}
+	}
+}
+
+func TestMergeEPUBsDuplicateHrefs(t *testing.T) {
+	dup := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Duplicates",
+			identifier: "urn:test:dup",
+			items: []ManifestItem{
+				testItem("img", "Images/cover.jpg"),
+				testItem("c1", "Text/c1.xhtml"),
+				testItem("c1-again", "Text/./c1.xhtml"),
+				testItem("cover", "Images/cover.jpg", "cover-image"),
+				testItem("c2", "Text/c2.xhtml"),
+			},
+			spine: []string{"c1-again", "c1", "c2"},
+		}),
+		"OEBPS/Images/cover.jpg": "jpeg",
+		"OEBPS/Text/c1.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p></body></html>`,
+		"OEBPS/Text/c2.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Two</p></body></html>`,
+	})
+	plain := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{dup, plain}, MergeOptions{OutPath: out, Verify: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	for _, c := range stats.Verification {
+		if !c.OK() {
+			t.Fatalf("verify %s: %q", c.Prefix, c.Problems)
+		}
+	}
+	warned := 0
+	for _, w := range stats.Warnings {
+		if strings.Contains(w, "both point at") {
+			warned++
+		}
+	}
+	if warned != 2 {
+		t.Fatalf("warnings = %q", stats.Warnings)
+	}
+
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	hrefs := map[string][]string{}
+	for _, item := range book.Package.Manifest.Items {
+		hrefs[item.Href] = append(hrefs[item.Href], item.ID)
+		if item.ID == "v0001_img" && !hasProperty(item.Properties, "cover-image") {
+			t.Fatalf("kept cover item lost cover-image: %+v", item)
+		}
+	}
+	for _, href := range []string{"Volumes/v0001/Images/cover.jpg", "Volumes/v0001/Text/c1.xhtml"} {
+		if len(hrefs[href]) != 1 {
+			t.Fatalf("%s listed as %v", href, hrefs[href])
+		}
+	}
+	var spine []string
+	for _, ref := range book.Package.Spine.Itemrefs {
+		spine = append(spine, ref.IDRef)
+	}
+	if got := strings.Join(spine, " "); !strings.HasPrefix(got, "v0001_c1 v0001_c2 v0002_") {
+		t.Fatalf("spine = %s", got)
+	}
+}
@@ -0,0 +1,121 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type CleanMetadataOptions struct {
+	OutPath string
+	// NewIdentifier replaces the book's unique identifier with a fresh
+	// urn:uuid instead of keeping it.
+	NewIdentifier bool
+}
+
+// CleanMetadata resets an EPUB's metadata to a minimal set: its first title,
+// the creators credited as authors, its language, its unique identifier,
+// the cover reference and, for EPUB 3, a fresh dcterms:modified. Contributors,
+// publishers, dates, descriptions, subjects, rights, refinements and tool
+// or custom meta (and the prefixes they declare) are dropped. It returns how
+// many metadata elements were removed. The input is rewritten in place
+// unless opts.OutPath is set.
+func CleanMetadata(ctx context.Context, input string, opts CleanMetadataOptions) (int, error) {
+	if input == "" {
+		return 0, fmt.Errorf("input EPUB path is required")
+	}
+
+	vol, err := loadVolume(ctx, 0, input)
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	pkg := vol.PackageDoc
+	before := countMetadata(pkg.Metadata)
+	pkg.Metadata, pkg.UniqueIdentifier = minimalMetadata(pkg.Metadata, pkg.UniqueIdentifier, opts.NewIdentifier)
+	pkg.Prefix = ""
+	if strings.HasPrefix(pkg.Version, "3") {
+		updateModifiedTimestamp(&pkg.Metadata)
+	}
+	removed := max(before-countMetadata(pkg.Metadata), 0)
+
+	if err := writePackage(pkg, vol.PackagePath); err != nil {
+		return 0, err
+	}
+	if err := replaceArchive(vol.RootDir, input, opts.OutPath); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// minimalMetadata builds the metadata CleanMetadata keeps, returning it with
+// the id of its unique identifier. A missing language becomes "und"
+// (undetermined) and a missing identifier a new urn:uuid, since both are
+// required.
+func minimalMetadata(meta Metadata, uniqueID string, newIdentifier bool) (Metadata, string) {
+	var clean Metadata
+	if title := firstDCValue(meta.Titles); strings.TrimSpace(title) != "" {
+		clean.Titles = []DCMeta{{Value: title}}
+	}
+	for _, c := range meta.Creators {
+		if role := creatorRole(meta, c); (role == "" || role == "aut") && strings.TrimSpace(c.Value) != "" {
+			clean.Creators = append(clean.Creators, DCMeta{Value: c.Value})
+		}
+	}
+	lang := strings.TrimSpace(firstDCValue(meta.Languages))
+	if lang == "" {
+		lang = "und"
+	}
+	clean.Languages = []DCMeta{{Value: lang}}
+
+	var id DCMeta
+	for _, ident := range meta.Identifiers {
+		if uniqueID != "" && ident.ID == uniqueID {
+			id = ident
+			break
+		}
+	}
+	if id.ID == "" && len(meta.Identifiers) > 0 {
+		id = meta.Identifiers[0]
+	}
+	if id.ID == "" {
+		id.ID = "bookid"
+	}
+	if newIdentifier || strings.TrimSpace(id.Value) == "" {
+		id.Value = randomURN()
+	}
+	clean.Identifiers = []DCMeta{{ID: id.ID, Value: strings.TrimSpace(id.Value)}}
+
+	for _, m := range meta.Meta {
+		if strings.EqualFold(m.Name, "cover") && strings.TrimSpace(m.Content) != "" {
+			clean.Meta = append(clean.Meta, MetaNode{Name: "cover", Content: strings.TrimSpace(m.Content)})
+			break
+		}
+	}
+	return clean, id.ID
+}
+
+// creatorRole returns a creator's MARC relator code, from its opf:role
+// attribute or an EPUB 3 role refinement, or "" when it has none.
+func creatorRole(meta Metadata, c DCMeta) string {
+	if c.Role != "" {
+		return strings.ToLower(strings.TrimSpace(c.Role))
+	}
+	if c.ID == "" {
+		return ""
+	}
+	for _, m := range meta.Meta {
+		if m.Refines == "#"+c.ID && m.Property == "role" {
+			return strings.ToLower(strings.TrimSpace(m.Value))
+		}
+	}
+	return ""
+}
+
+// countMetadata counts the elements of a metadata block.
+func countMetadata(meta Metadata) int {
+	return len(meta.Titles) + len(meta.Creators) + len(meta.Languages) + len(meta.Identifiers) +
+		len(meta.Descriptions) + len(meta.Rights) + len(meta.Meta) + len(meta.Extra)
+}
@@ -0,0 +1,147 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const cleanTestOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid" prefix="novfmt: https://novfmt.local/vocab#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="isbn">9780306406157</dc:identifier>
+    <dc:identifier id="uid">urn:uuid:11111111-2222-4333-8444-555555555555</dc:identifier>
+    <dc:title id="t1">Saga</dc:title>
+    <dc:title>Saga: Subtitle</dc:title>
+    <dc:creator id="c1">Some Writer</dc:creator>
+    <meta refines="#c1" property="role" scheme="marc:relators">aut</meta>
+    <dc:creator id="c2">Some Translator</dc:creator>
+    <meta refines="#c2" property="role" scheme="marc:relators">trl</meta>
+    <dc:contributor>Some Editor</dc:contributor>
+    <dc:language>ja</dc:language>
+    <dc:publisher>Press</dc:publisher>
+    <dc:date>2020-01-01</dc:date>
+    <dc:description>Blurb</dc:description>
+    <meta property="dcterms:modified">2020-01-01T00:00:00Z</meta>
+    <meta property="novfmt:source">vol1.epub</meta>
+    <meta name="calibre:series" content="Saga"/>
+    <meta name="cover" content="cover-img"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="chapter" href="chapter.xhtml" media-type="application/xhtml+xml"/>
+    <item id="cover-img" href="cover.jpg" media-type="image/jpeg" properties="cover-image"/>
+  </manifest>
+  <spine>
+    <itemref idref="chapter"/>
+  </spine>
+</package>
+`
+
+func buildCleanTestEPUB(t *testing.T) string {
+	t.Helper()
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf":   cleanTestOPF,
+		"OEBPS/nav.xhtml":     `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="chapter.xhtml">One</a></li></ol></nav></body></html>`,
+		"OEBPS/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Hi</p></body></html>`,
+		"OEBPS/cover.jpg":     "jpeg",
+	})
+}
+
+func TestCleanMetadata(t *testing.T) {
+	input := buildCleanTestEPUB(t)
+	out := filepath.Join(t.TempDir(), "clean.epub")
+	removed, err := CleanMetadata(context.Background(), input, CleanMetadataOptions{OutPath: out})
+	if err != nil {
+		t.Fatalf("CleanMetadata: %v", err)
+	}
+	if removed != 11 {
+		t.Fatalf("removed = %d, want 11", removed)
+	}
+
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	pkg := book.Package
+	meta := pkg.Metadata
+
+	if pkg.Prefix != "" {
+		t.Fatalf("prefix = %q, want none", pkg.Prefix)
+	}
+	if len(meta.Titles) != 1 || meta.Titles[0].Value != "Saga" {
+		t.Fatalf("titles = %+v", meta.Titles)
+	}
+	if len(meta.Creators) != 1 || meta.Creators[0].Value != "Some Writer" {
+		t.Fatalf("creators = %+v", meta.Creators)
+	}
+	if len(meta.Languages) != 1 || meta.Languages[0].Value != "ja" {
+		t.Fatalf("languages = %+v", meta.Languages)
+	}
+	if pkg.UniqueIdentifier != "uid" || len(meta.Identifiers) != 1 ||
+		meta.Identifiers[0].ID != "uid" || meta.Identifiers[0].Value != "urn:uuid:11111111-2222-4333-8444-555555555555" {
+		t.Fatalf("identifiers = %+v (unique %q)", meta.Identifiers, pkg.UniqueIdentifier)
+	}
+	if len(meta.Descriptions) != 0 || len(meta.Rights) != 0 || len(meta.Extra) != 0 {
+		t.Fatalf("left descriptions %+v, rights %+v, extra %+v", meta.Descriptions, meta.Rights, meta.Extra)
+	}
+
+	var cover, modified string
+	for _, m := range meta.Meta {
+		switch {
+		case m.Name == "cover":
+			cover = m.Content
+		case m.Property == "dcterms:modified":
+			modified = m.Value
+		default:
+			t.Fatalf("unexpected meta %+v", m)
+		}
+	}
+	if cover != "cover-img" {
+		t.Fatalf("cover = %q, want cover-img", cover)
+	}
+	if modified == "" || strings.HasPrefix(modified, "2020") {
+		t.Fatalf("modified = %q, want a fresh timestamp", modified)
+	}
+}
+
+func TestCleanMetadataNewIdentifierInPlace(t *testing.T) {
+	input := buildCleanTestEPUB(t)
+	if _, err := CleanMetadata(context.Background(), input, CleanMetadataOptions{NewIdentifier: true}); err != nil {
+		t.Fatalf("CleanMetadata: %v", err)
+	}
+	if _, err := os.Stat(input); err != nil {
+		t.Fatalf("input missing: %v", err)
+	}
+
+	book, err := OpenBook(context.Background(), input)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	ids := book.Package.Metadata.Identifiers
+	if len(ids) != 1 || ids[0].ID != "uid" || !strings.HasPrefix(ids[0].Value, "urn:uuid:") ||
+		ids[0].Value == "urn:uuid:11111111-2222-4333-8444-555555555555" {
+		t.Fatalf("identifiers = %+v, want a new urn:uuid", ids)
+	}
+}
+
+func TestMinimalMetadataDefaults(t *testing.T) {
+	meta := Metadata{
+		Titles:   []DCMeta{{Value: "Book"}},
+		Creators: []DCMeta{{Value: "Illustrator", Role: "ill"}, {Value: "Writer", Role: "aut"}},
+	}
+	clean, uid := minimalMetadata(meta, "", false)
+	if uid != "bookid" || len(clean.Identifiers) != 1 || !strings.HasPrefix(clean.Identifiers[0].Value, "urn:uuid:") {
+		t.Fatalf("identifier = %+v (unique %q)", clean.Identifiers, uid)
+	}
+	if firstDCValue(clean.Languages) != "und" {
+		t.Fatalf("language = %q, want und", firstDCValue(clean.Languages))
+	}
+	if len(clean.Creators) != 1 || clean.Creators[0].Value != "Writer" {
+		t.Fatalf("creators = %+v", clean.Creators)
+	}
+}
@@ -0,0 +1,373 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// MetadataRecord is the editable form of a book's metadata that meta export
+// writes and meta import applies, as JSON.
+type MetadataRecord struct {
+	Title       string          `json:"title"`
+	Creators    []CreatorRecord `json:"creators,omitempty"`
+	Language    string          `json:"language"`
+	Identifier  string          `json:"identifier"`
+	Description string          `json:"description,omitempty"`
+	Subjects    []string        `json:"subjects,omitempty"`
+	// Dates are the book's dc:date elements, in order.
+	Dates []DateRecord `json:"dates,omitempty"`
+}
+
+// DateRecord is a dc:date (YYYY, YYYY-MM, YYYY-MM-DD or a timestamp) and
+// the EPUB 2 opf:event it marks, such as publication or modification.
+// EPUB 3 has no events, so importing into an EPUB 3 book drops them.
+type DateRecord struct {
+	Value string `json:"value"`
+	Event string `json:"event,omitempty"`
+}
+
+// CreatorRecord is a creator with its MARC relator code ("aut" when empty)
+// and sort name.
+type CreatorRecord struct {
+	Name   string `json:"name"`
+	Role   string `json:"role,omitempty"`
+	FileAs string `json:"file_as,omitempty"`
+}
+
+type ImportMetadataOptions struct {
+	OutPath string
+}
+
+// datePattern matches the W3CDTF dates EPUB allows for dc:date, and the
+// timestamps without a zone that many EPUB 2 books carry.
+var datePattern = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2}(T\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:\d{2})?)?)?)?$`)
+
+// ExportMetadata reads the metadata MetadataRecord models from an EPUB. The
+// book is not modified.
+func ExportMetadata(ctx context.Context, input string) (MetadataRecord, error) {
+	if input == "" {
+		return MetadataRecord{}, fmt.Errorf("input EPUB path is required")
+	}
+	book, err := OpenBook(ctx, input)
+	if err != nil {
+		return MetadataRecord{}, err
+	}
+	defer book.Close()
+	return metadataRecord(book.Package), nil
+}
+
+// metadataRecord extracts a MetadataRecord from a package document. The
+// identifier is the package's unique identifier, else its first.
+func metadataRecord(pkg *PackageDocument) MetadataRecord {
+	meta := pkg.Metadata
+	rec := MetadataRecord{
+		Title:       strings.TrimSpace(firstDCValue(meta.Titles)),
+		Language:    strings.TrimSpace(firstDCValue(meta.Languages)),
+		Description: strings.TrimSpace(firstDCValue(meta.Descriptions)),
+		Subjects:    extraDCValues(meta, "subject"),
+	}
+	for _, raw := range meta.Extra {
+		if raw.XMLName.Local != "date" || !isDCSpace(raw.XMLName.Space) {
+			continue
+		}
+		d := DateRecord{Value: rawText(raw)}
+		if d.Value == "" {
+			continue
+		}
+		for _, attr := range raw.Attrs {
+			if attr.Name.Local == "event" {
+				d.Event = strings.TrimSpace(attr.Value)
+			}
+		}
+		rec.Dates = append(rec.Dates, d)
+	}
+	if id := uniqueIdentifier(pkg); id != nil {
+		rec.Identifier = strings.TrimSpace(id.Value)
+	}
+	for _, c := range meta.Creators {
+		name := strings.TrimSpace(c.Value)
+		if name == "" {
+			continue
+		}
+		rec.Creators = append(rec.Creators, CreatorRecord{
+			Name:   name,
+			Role:   creatorRole(meta, c),
+			FileAs: creatorFileAs(meta, c),
+		})
+	}
+	return rec
+}
+
+// LoadMetadataRecord reads a MetadataRecord written by ExportMetadata (or
+// by hand) and checks it with checkMetadataRecord. Unknown keys are an
+// error, so a misspelt field isn't silently ignored.
+func LoadMetadataRecord(p string) (MetadataRecord, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return MetadataRecord{}, fmt.Errorf("read metadata: %w", err)
+	}
+	var rec MetadataRecord
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rec); err != nil {
+		return MetadataRecord{}, fmt.Errorf("parse metadata %s: %w", p, err)
+	}
+	if err := checkMetadataRecord(&rec); err != nil {
+		return MetadataRecord{}, fmt.Errorf("metadata %s: %w", p, err)
+	}
+	return rec, nil
+}
+
+// checkMetadataRecord trims rec and requires a title, a valid language and
+// an identifier, named creators with three-letter role codes and W3CDTF
+// dates. The language is normalized.
+func checkMetadataRecord(rec *MetadataRecord) error {
+	rec.Title = strings.TrimSpace(rec.Title)
+	rec.Identifier = strings.TrimSpace(rec.Identifier)
+	rec.Description = strings.TrimSpace(rec.Description)
+	if rec.Title == "" {
+		return fmt.Errorf("missing title")
+	}
+	if rec.Identifier == "" {
+		return fmt.Errorf("missing identifier")
+	}
+	lang, err := NormalizeLanguage(rec.Language)
+	if err != nil {
+		return fmt.Errorf("language: %w", err)
+	}
+	rec.Language = lang
+	for i := range rec.Creators {
+		c := &rec.Creators[i]
+		c.Name = strings.TrimSpace(c.Name)
+		c.Role = strings.ToLower(strings.TrimSpace(c.Role))
+		c.FileAs = strings.TrimSpace(c.FileAs)
+		if c.Name == "" {
+			return fmt.Errorf("creator %d: missing name", i+1)
+		}
+		if c.Role != "" && !relatorPattern.MatchString(c.Role) {
+			return fmt.Errorf("creator %q: invalid role %q (want a MARC relator code such as aut, ill, trl)", c.Name, c.Role)
+		}
+	}
+	var subjects []string
+	for _, s := range rec.Subjects {
+		if s = strings.TrimSpace(s); s != "" {
+			subjects = append(subjects, s)
+		}
+	}
+	rec.Subjects = subjects
+	for i := range rec.Dates {
+		d := &rec.Dates[i]
+		d.Value = strings.TrimSpace(d.Value)
+		d.Event = strings.TrimSpace(d.Event)
+		if d.Value == "" {
+			return fmt.Errorf("date %d: missing value", i+1)
+		}
+		if !datePattern.MatchString(d.Value) {
+			return fmt.Errorf("invalid date %q (want YYYY, YYYY-MM, YYYY-MM-DD or a timestamp)", d.Value)
+		}
+	}
+	return nil
+}
+
+// ImportMetadata replaces the metadata MetadataRecord models with rec,
+// keeping everything else (other titles, publisher, rights, custom meta, the
+// cover reference, manifest and spine). The unique identifier keeps its id
+// and takes rec's value. The input is rewritten in place unless
+// opts.OutPath is set.
+func ImportMetadata(ctx context.Context, input string, rec MetadataRecord, opts ImportMetadataOptions) error {
+	if input == "" {
+		return fmt.Errorf("input EPUB path is required")
+	}
+	if err := checkMetadataRecord(&rec); err != nil {
+		return err
+	}
+
+	vol, err := loadVolume(ctx, 0, input)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	pkg := vol.PackageDoc
+	applyMetadataRecord(pkg, rec)
+	if strings.HasPrefix(pkg.Version, "3") {
+		updateModifiedTimestamp(&pkg.Metadata)
+	}
+	if err := writePackage(pkg, vol.PackagePath); err != nil {
+		return err
+	}
+	return replaceArchive(vol.RootDir, input, opts.OutPath)
+}
+
+// applyMetadataRecord writes a checked rec into pkg. Creators are replaced
+// with their refinements: EPUB 3 packages credit roles and sort names with
+// refining meta, older ones with opf:role and opf:file-as.
+func applyMetadataRecord(pkg *PackageDocument, rec MetadataRecord) {
+	meta := &pkg.Metadata
+	if len(meta.Titles) == 0 {
+		meta.Titles = []DCMeta{{}}
+	}
+	meta.Titles[0].Value = rec.Title
+	if len(meta.Languages) == 0 {
+		meta.Languages = []DCMeta{{}}
+	}
+	meta.Languages[0].Value = rec.Language
+	meta.Descriptions = nil
+	if rec.Description != "" {
+		meta.Descriptions = []DCMeta{{Value: rec.Description}}
+	}
+
+	if id := uniqueIdentifier(pkg); id != nil {
+		id.Value = rec.Identifier
+	} else {
+		if pkg.UniqueIdentifier == "" {
+			pkg.UniqueIdentifier = "bookid"
+		}
+		meta.Identifiers = append([]DCMeta{{ID: pkg.UniqueIdentifier, Value: rec.Identifier}}, meta.Identifiers...)
+	}
+
+	dropped := make(map[string]bool)
+	for _, c := range meta.Creators {
+		if c.ID != "" {
+			dropped["#"+c.ID] = true
+		}
+	}
+	if len(dropped) > 0 {
+		kept := meta.Meta[:0]
+		for _, m := range meta.Meta {
+			if !dropped[m.Refines] {
+				kept = append(kept, m)
+			}
+		}
+		meta.Meta = kept
+	}
+	epub3 := strings.HasPrefix(pkg.Version, "3")
+	meta.Creators = nil
+	for i, c := range rec.Creators {
+		role := c.Role
+		if role == "" {
+			role = defaultCreatorRole
+		}
+		if !epub3 {
+			meta.Creators = append(meta.Creators, DCMeta{Value: c.Name, Role: role, FileAs: c.FileAs})
+			continue
+		}
+		id := fmt.Sprintf("creator%02d", i+1)
+		meta.Creators = append(meta.Creators, DCMeta{ID: id, Value: c.Name})
+		meta.Meta = append(meta.Meta, MetaNode{Refines: "#" + id, Property: "role", Scheme: "marc:relators", Value: role})
+		if c.FileAs != "" {
+			meta.Meta = append(meta.Meta, MetaNode{Refines: "#" + id, Property: "file-as", Value: c.FileAs})
+		}
+	}
+
+	setExtraDC(meta, "subject", rec.Subjects)
+	dates := make([]RawElement, 0, len(rec.Dates))
+	for _, d := range rec.Dates {
+		el := dcElement("date", d.Value)
+		if d.Event != "" && !epub3 {
+			el.Attrs = []xml.Attr{{Name: xml.Name{Local: "opf:event"}, Value: d.Event}}
+		}
+		dates = append(dates, el)
+	}
+	replaceExtraDC(meta, "date", dates)
+}
+
+// uniqueIdentifier returns the dc:identifier the package's
+// unique-identifier names, else its first, or nil when it has none.
+func uniqueIdentifier(pkg *PackageDocument) *DCMeta {
+	ids := pkg.Metadata.Identifiers
+	for i := range ids {
+		if pkg.UniqueIdentifier != "" && ids[i].ID == pkg.UniqueIdentifier {
+			return &ids[i]
+		}
+	}
+	if len(ids) > 0 {
+		return &ids[0]
+	}
+	return nil
+}
+
+// creatorFileAs returns a creator's sort name, from its opf:file-as
+// attribute or an EPUB 3 file-as refinement.
+func creatorFileAs(meta Metadata, c DCMeta) string {
+	if c.FileAs != "" {
+		return strings.TrimSpace(c.FileAs)
+	}
+	if c.ID == "" {
+		return ""
+	}
+	for _, m := range meta.Meta {
+		if m.Refines == "#"+c.ID && m.Property == "file-as" {
+			return strings.TrimSpace(m.Value)
+		}
+	}
+	return ""
+}
+
+// extraDCValues returns the text of the Dublin Core elements named local
+// that Metadata keeps in Extra, such as dc:subject.
+func extraDCValues(meta Metadata, local string) []string {
+	var out []string
+	for _, raw := range meta.Extra {
+		if raw.XMLName.Local != local || !isDCSpace(raw.XMLName.Space) {
+			continue
+		}
+		if text := rawText(raw); text != "" {
+			out = append(out, text)
+		}
+	}
+	return out
+}
+
+// rawText returns the trimmed text of an element kept in Extra.
+func rawText(raw RawElement) string {
+	text, err := unescapeXMLText([]byte(raw.Inner))
+	if err != nil {
+		text = raw.Inner
+	}
+	return strings.TrimSpace(text)
+}
+
+// setExtraDC replaces the Dublin Core elements named local in Extra with
+// one per value, placed where the first of them was (or at the end).
+func setExtraDC(meta *Metadata, local string, values []string) {
+	els := make([]RawElement, 0, len(values))
+	for _, v := range values {
+		els = append(els, dcElement(local, v))
+	}
+	replaceExtraDC(meta, local, els)
+}
+
+// dcElement returns a Dublin Core element named local holding value.
+func dcElement(local, value string) RawElement {
+	return RawElement{XMLName: xml.Name{Space: nsDC, Local: local}, Inner: html.EscapeString(value)}
+}
+
+// replaceExtraDC is setExtraDC for elements built by the caller.
+func replaceExtraDC(meta *Metadata, local string, els []RawElement) {
+	at := -1
+	var kept []RawElement
+	for _, raw := range meta.Extra {
+		if raw.XMLName.Local == local && isDCSpace(raw.XMLName.Space) {
+			if at < 0 {
+				at = len(kept)
+			}
+			continue
+		}
+		kept = append(kept, raw)
+	}
+	if at < 0 {
+		at = len(kept)
+	}
+	out := make([]RawElement, 0, len(kept)+len(els))
+	out = append(out, kept[:at]...)
+	out = append(out, els...)
+	meta.Extra = append(out, kept[at:]...)
+}
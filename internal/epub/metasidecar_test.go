@@ -0,0 +1,196 @@
+package epub
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImportMetadata(t *testing.T) {
+	input := buildCleanTestEPUB(t)
+	rec, err := ExportMetadata(context.Background(), input)
+	if err != nil {
+		t.Fatalf("ExportMetadata: %v", err)
+	}
+	want := MetadataRecord{
+		Title:       "Saga",
+		Creators:    []CreatorRecord{{Name: "Some Writer", Role: "aut"}, {Name: "Some Translator", Role: "trl"}},
+		Language:    "ja",
+		Identifier:  "urn:uuid:11111111-2222-4333-8444-555555555555",
+		Description: "Blurb",
+		Dates:       []DateRecord{{Value: "2020-01-01"}},
+	}
+	if !reflect.DeepEqual(rec, want) {
+		t.Fatalf("export = %+v\nwant %+v", rec, want)
+	}
+
+	rec.Title = "Saga & Sequel"
+	rec.Creators = []CreatorRecord{{Name: "Some Writer", FileAs: "Writer, Some"}, {Name: "Some Artist", Role: "ILL"}}
+	rec.Description = ""
+	rec.Subjects = []string{"Fantasy", " ", "Adventure"}
+	rec.Dates = []DateRecord{{Value: "2021-03"}}
+	out := filepath.Join(t.TempDir(), "out.epub")
+	if err := ImportMetadata(context.Background(), input, rec, ImportMetadataOptions{OutPath: out}); err != nil {
+		t.Fatalf("ImportMetadata: %v", err)
+	}
+
+	got, err := ExportMetadata(context.Background(), out)
+	if err != nil {
+		t.Fatalf("ExportMetadata after import: %v", err)
+	}
+	want = MetadataRecord{
+		Title:      "Saga & Sequel",
+		Creators:   []CreatorRecord{{Name: "Some Writer", Role: "aut", FileAs: "Writer, Some"}, {Name: "Some Artist", Role: "ill"}},
+		Language:   "ja",
+		Identifier: "urn:uuid:11111111-2222-4333-8444-555555555555",
+		Subjects:   []string{"Fantasy", "Adventure"},
+		Dates:      []DateRecord{{Value: "2021-03"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("round trip = %+v\nwant %+v", got, want)
+	}
+
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	meta := book.Package.Metadata
+	var cover, publisher bool
+	for _, m := range meta.Meta {
+		cover = cover || m.Name == "cover" && m.Content == "cover-img"
+		if m.Refines == "#c2" {
+			t.Fatalf("refinement of a replaced creator kept: %+v", m)
+		}
+	}
+	for _, raw := range meta.Extra {
+		publisher = publisher || raw.XMLName.Local == "publisher"
+	}
+	if !cover || !publisher || len(meta.Identifiers) != 2 || book.Package.UniqueIdentifier != "uid" {
+		t.Fatalf("import dropped structure: cover %v, publisher %v, identifiers %+v", cover, publisher, meta.Identifiers)
+	}
+}
+
+func TestImportMetadataEPUB2Roles(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			version:    "2.0",
+			title:      "Old",
+			lang:       "en",
+			identifier: "urn:test:epub2",
+			metadata: []string{
+				`<dc:creator opf:role="aut">Someone</dc:creator>`,
+			},
+			items: []ManifestItem{
+				testItem("c1", "c1.xhtml"),
+			},
+			spine: []string{"c1"},
+		}),
+		"OEBPS/c1.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p></body></html>`,
+	})
+	rec := MetadataRecord{
+		Title:      "New",
+		Creators:   []CreatorRecord{{Name: "Translator", Role: "trl", FileAs: "T"}},
+		Language:   "en",
+		Identifier: "urn:test:epub2",
+	}
+	if err := ImportMetadata(context.Background(), input, rec, ImportMetadataOptions{}); err != nil {
+		t.Fatalf("ImportMetadata: %v", err)
+	}
+	book, err := OpenBook(context.Background(), input)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	if got := book.Package.Metadata.Creators; len(got) != 1 || got[0].Role != "trl" || got[0].FileAs != "T" || got[0].ID != "" {
+		t.Fatalf("creators = %+v", got)
+	}
+	for _, m := range book.Package.Metadata.Meta {
+		if m.Property == "dcterms:modified" || m.Refines != "" {
+			t.Fatalf("EPUB 2 package got EPUB 3 meta %+v", m)
+		}
+	}
+}
+
+func TestExportImportMetadataEPUB2Dates(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			version: "2.0",
+			title:   "Old",
+			lang:    "en",
+			metadata: []string{
+				`<dc:date opf:event="publication">2012-03-04T00:00:00</dc:date>`,
+				`<dc:date opf:event="modification">2013-05-06</dc:date>`,
+			},
+			items: []ManifestItem{testItem("c1", "c1.xhtml")},
+			spine: []string{"c1"},
+		}),
+		"OEBPS/c1.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p></body></html>`,
+	})
+	rec, err := ExportMetadata(context.Background(), input)
+	if err != nil {
+		t.Fatalf("ExportMetadata: %v", err)
+	}
+	want := []DateRecord{{Value: "2012-03-04T00:00:00", Event: "publication"}, {Value: "2013-05-06", Event: "modification"}}
+	if !reflect.DeepEqual(rec.Dates, want) {
+		t.Fatalf("dates = %+v\nwant %+v", rec.Dates, want)
+	}
+
+	p := filepath.Join(t.TempDir(), "meta.json")
+	data, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadMetadataRecord(p)
+	if err != nil {
+		t.Fatalf("LoadMetadataRecord: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.epub")
+	if err := ImportMetadata(context.Background(), input, loaded, ImportMetadataOptions{OutPath: out}); err != nil {
+		t.Fatalf("ImportMetadata: %v", err)
+	}
+	got, err := ExportMetadata(context.Background(), out)
+	if err != nil {
+		t.Fatalf("ExportMetadata after import: %v", err)
+	}
+	if !reflect.DeepEqual(got, rec) {
+		t.Fatalf("round trip = %+v\nwant %+v", got, rec)
+	}
+}
+
+func TestLoadMetadataRecord(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		json, err string
+	}{
+		{`{"title": "T", "language": "en_US", "identifier": "x"}`, ""},
+		{`{"language": "en", "identifier": "x"}`, "missing title"},
+		{`{"title": "T", "language": "en"}`, "missing identifier"},
+		{`{"title": "T", "language": "not a tag!", "identifier": "x"}`, "language"},
+		{`{"title": "T", "language": "en", "identifier": "x", "creators": [{"name": "A", "role": "author"}]}`, "invalid role"},
+		{`{"title": "T", "language": "en", "identifier": "x", "dates": [{"value": "March 2021"}]}`, "invalid date"},
+		{`{"title": "T", "language": "en", "identifier": "x", "dates": [{"event": "publication"}]}`, "missing value"},
+		{`{"title": "T", "language": "en", "identifier": "x", "publisher": "P"}`, "unknown field"},
+	} {
+		p := filepath.Join(dir, "meta.json")
+		if err := os.WriteFile(p, []byte(tc.json), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rec, err := LoadMetadataRecord(p)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: %v", tc.json, err)
+		case tc.err == "" && rec.Language != "en-US":
+			t.Errorf("%s: language = %q, want en-US", tc.json, rec.Language)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: err = %v, want %q", tc.json, err, tc.err)
+		}
+	}
+}
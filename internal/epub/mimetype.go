@@ -0,0 +1,92 @@
+package epub
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const epubMimetype = "application/epub+zip"
+
+type FixMimetypeOptions struct {
+	OutPath string
+}
+
+// checkMimetype reports what is wrong with the mimetype entry of the archive
+// at src: OCF requires it to be the first entry, stored uncompressed, and to
+// hold exactly "application/epub+zip".
+func checkMimetype(src string) ([]string, error) {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for i, f := range r.File {
+		if f.Name != "mimetype" {
+			continue
+		}
+		var problems []string
+		if i != 0 {
+			problems = append(problems, fmt.Sprintf("mimetype is entry %d, not the first", i+1))
+		}
+		if f.Method != zip.Store {
+			problems = append(problems, "mimetype is compressed")
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, 256))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if string(data) != epubMimetype {
+			problems = append(problems, fmt.Sprintf("mimetype contains %q", data))
+		}
+		return problems, nil
+	}
+	return []string{"mimetype entry is missing"}, nil
+}
+
+// FixMimetype rewrites an EPUB whose mimetype entry is missing, misplaced,
+// compressed or wrong, writing to opts.OutPath (the input when empty). It
+// returns the problems found; none means the book was already valid, and it
+// is only copied to opts.OutPath if that is set.
+func FixMimetype(ctx context.Context, input string, opts FixMimetypeOptions) ([]string, error) {
+	if input == "" {
+		return nil, fmt.Errorf("input EPUB path is required")
+	}
+
+	problems, err := checkMimetype(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) == 0 {
+		return nil, copyUnchanged(input, opts.OutPath)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "novfmt-mimetype-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := unzip(input, dir); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "mimetype"), []byte(epubMimetype), 0o644); err != nil {
+		return nil, err
+	}
+	if err := replaceArchive(dir, input, opts.OutPath); err != nil {
+		return nil, err
+	}
+	return problems, nil
+}
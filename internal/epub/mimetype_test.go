@@ -0,0 +1,120 @@
+package epub
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeRawZip writes entries in order, deflating all of them, the way a
+// careless tool might.
+func writeRawZip(t *testing.T, entries [][2]string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "bad.epub")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		w, err := zw.Create(e[0])
+		if err != nil {
+			t.Fatalf("create %s: %v", e[0], err)
+		}
+		if _, err := w.Write([]byte(e[1])); err != nil {
+			t.Fatalf("write %s: %v", e[0], err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	f.Close()
+	return p
+}
+
+func TestFixMimetype(t *testing.T) {
+	input := writeRawZip(t, [][2]string{
+		{"META-INF/container.xml", "<container/>"},
+		{"mimetype", epubMimetype},
+	})
+	out := filepath.Join(t.TempDir(), "fixed.epub")
+
+	problems, err := FixMimetype(context.Background(), input, FixMimetypeOptions{OutPath: out})
+	if err != nil {
+		t.Fatalf("FixMimetype: %v", err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("problems = %v, want misplaced and compressed", problems)
+	}
+
+	if problems, err := checkMimetype(out); err != nil || len(problems) != 0 {
+		t.Fatalf("fixed archive: problems %v, err %v", problems, err)
+	}
+	r, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+	if len(r.File) != 2 || r.File[1].Name != "META-INF/container.xml" {
+		t.Fatalf("entries not preserved: %d", len(r.File))
+	}
+}
+
+func TestFixMimetypeAlreadyValid(t *testing.T) {
+	input := buildTestEPUB(t, "Vol 1", "en")
+	before, err := os.Stat(input)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	problems, err := FixMimetype(context.Background(), input, FixMimetypeOptions{})
+	if err != nil {
+		t.Fatalf("FixMimetype: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("problems = %v", problems)
+	}
+	after, err := os.Stat(input)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Fatalf("valid book was rewritten")
+	}
+}
+
+func TestFixMimetypeAlreadyValidOut(t *testing.T) {
+	input := buildTestEPUB(t, "Vol 1", "en")
+	out := filepath.Join(t.TempDir(), "fixed.epub")
+
+	problems, err := FixMimetype(context.Background(), input, FixMimetypeOptions{OutPath: out})
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("FixMimetype = %v, %v", problems, err)
+	}
+	want, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatalf("read input: %v", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("output not written: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("output differs from the valid input")
+	}
+}
+
+func TestCheckMimetypeMissingAndWrong(t *testing.T) {
+	missing := writeRawZip(t, [][2]string{{"META-INF/container.xml", "<container/>"}})
+	if problems, _ := checkMimetype(missing); len(problems) != 1 {
+		t.Fatalf("missing: problems = %v", problems)
+	}
+
+	wrong := writeRawZip(t, [][2]string{{"mimetype", "application/zip\n"}})
+	problems, _ := checkMimetype(wrong)
+	if len(problems) != 2 {
+		t.Fatalf("wrong content: problems = %v", problems)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"os"
 	"path"
 	"strings"
 )
@@ -21,15 +20,43 @@ type navItemState struct {
 	text strings.Builder
 }
 
-func parseNavFile(path string) ([]NavItem, error) {
-	data, err := os.ReadFile(path)
+func parseNavDocument(data []byte) ([]NavItem, error) {
+	items, _, err := parseNavTOC(data)
+	return items, err
+}
+
+// parseNavTOC parses the epub:type="toc" nav, falling back to the first
+// <nav> without any epub:type whose list has entries. Such navs are out of
+// spec but common in hand-made books; landmarks and page lists are always
+// typed, so they are never picked up by mistake.
+func parseNavTOC(data []byte) ([]NavItem, bool, error) {
+	items, err := scanTOCNav(data, false)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	return parseNavDocument(data)
+	if len(items) > 0 {
+		return items, false, nil
+	}
+	items, err = scanTOCNav(data, true)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(items) == 0 {
+		return nil, false, fmt.Errorf("toc nav not found")
+	}
+	return items, true, nil
 }
 
-func parseNavDocument(data []byte) ([]NavItem, error) {
+// scanTOCNav collects the entries of the first toc nav, or with untyped the
+// first nav without an epub:type that has any entries.
+func scanTOCNav(data []byte, untyped bool) ([]NavItem, error) {
+	return scanNav(data, "toc", untyped)
+}
+
+// scanNav collects the entries of the first nav whose epub:type lists
+// navType ("toc", "loi", ...), or with untyped the first nav without an
+// epub:type that has any entries.
+func scanNav(data []byte, navType string, untyped bool) ([]NavItem, error) {
 	dec := xml.NewDecoder(bytes.NewReader(data))
 	dec.Strict = false
 
@@ -53,7 +80,7 @@ func parseNavDocument(data []byte) ([]NavItem, error) {
 		switch t := tok.(type) {
 		case xml.StartElement:
 			if t.Name.Local == "nav" {
-				if !inTOC && hasTOCTypeAttr(t.Attr) {
+				if !inTOC && isNavOfType(t.Attr, navType, untyped) {
 					inTOC = true
 					navDepth = 1
 					continue
@@ -98,7 +125,10 @@ func parseNavDocument(data []byte) ([]NavItem, error) {
 				navDepth--
 				if navDepth == 0 {
 					inTOC = false
-					return items, nil
+					if len(items) > 0 || !untyped {
+						return items, nil
+					}
+					listStack, liStack = nil, nil
 				}
 				continue
 			}
@@ -133,14 +163,30 @@ func parseNavDocument(data []byte) ([]NavItem, error) {
 		}
 	}
 
-	if len(items) == 0 {
-		return nil, fmt.Errorf("toc nav not found")
+	return items, nil
+}
+
+func isNavOfType(attrs []xml.Attr, navType string, untyped bool) bool {
+	if untyped {
+		return !hasTypeAttr(attrs)
 	}
+	return hasEPUBType(attrs, navType)
+}
 
-	return items, nil
+// hasTypeAttr reports whether attrs carry a non-empty epub:type (or
+// unprefixed type).
+func hasTypeAttr(attrs []xml.Attr) bool {
+	for _, attr := range attrs {
+		if attr.Name.Local == "type" && strings.TrimSpace(attr.Value) != "" {
+			return true
+		}
+	}
+	return false
 }
 
-func hasTOCTypeAttr(attrs []xml.Attr) bool {
+// hasEPUBType reports whether attrs carry an epub:type (or unprefixed type)
+// listing one of want.
+func hasEPUBType(attrs []xml.Attr, want ...string) bool {
 	const navNS = "http://www.idpf.org/2007/ops"
 	for _, attr := range attrs {
 		if attr.Name.Local != "type" {
@@ -150,14 +196,120 @@ func hasTOCTypeAttr(attrs []xml.Attr) bool {
 			continue
 		}
 		for _, token := range strings.Fields(attr.Value) {
-			if token == "toc" {
-				return true
+			for _, w := range want {
+				if token == w {
+					return true
+				}
 			}
 		}
 	}
 	return false
 }
 
+// parseLandmarks returns the hrefs of the landmarks nav entries whose
+// epub:type is one of types, in document order.
+func parseLandmarks(data []byte, types ...string) ([]string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	var (
+		hrefs    []string
+		navDepth int
+	)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "nav" {
+				if navDepth > 0 || hasEPUBType(t.Attr, "landmarks") {
+					navDepth++
+				}
+				continue
+			}
+			if navDepth == 0 || t.Name.Local != "a" || !hasEPUBType(t.Attr, types...) {
+				continue
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "href" && strings.TrimSpace(attr.Value) != "" {
+					hrefs = append(hrefs, strings.TrimSpace(attr.Value))
+					break
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "nav" && navDepth > 0 {
+				navDepth--
+			}
+		}
+	}
+	return hrefs, nil
+}
+
+// collapseNavItems folds each chain of entries that have exactly one child
+// into a single entry, so "Part 1 > Book 1 > chapters" lists the chapters one
+// level up under "Part 1: Book 1". A repeated or empty title along the chain
+// is dropped rather than joined, and the outermost link wins.
+func collapseNavItems(items []NavItem) []NavItem {
+	out := make([]NavItem, 0, len(items))
+	for _, item := range items {
+		last := item.Title
+		for len(item.Children) == 1 {
+			child := item.Children[0]
+			switch {
+			case item.Title == "":
+				item.Title = child.Title
+			case child.Title != "" && !strings.EqualFold(normalizeSpace(child.Title), normalizeSpace(last)):
+				item.Title += ": " + child.Title
+			}
+			if child.Title != "" {
+				last = child.Title
+			}
+			if item.Href == "" {
+				item.Href = child.Href
+			}
+			item.Children = child.Children
+		}
+		if len(item.Children) > 0 {
+			item.Children = collapseNavItems(item.Children)
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+// dedupeNavItems drops entries whose title (ignoring case and spacing) and
+// href match an earlier entry anywhere in the tree, keeping the first. A
+// dropped entry's children take its place, so nothing under it is lost. It
+// returns the new tree and how many entries were dropped.
+func dedupeNavItems(items []NavItem) ([]NavItem, int) {
+	seen := make(map[[2]string]bool)
+	removed := 0
+	var walk func(items []NavItem) []NavItem
+	walk = func(items []NavItem) []NavItem {
+		out := make([]NavItem, 0, len(items))
+		for _, item := range items {
+			key := [2]string{strings.ToLower(normalizeSpace(item.Title)), item.Href}
+			if seen[key] {
+				removed++
+				out = append(out, walk(item.Children)...)
+				continue
+			}
+			seen[key] = true
+			if len(item.Children) > 0 {
+				item.Children = walk(item.Children)
+			}
+			out = append(out, item)
+		}
+		return out
+	}
+	return walk(items), removed
+}
+
 func normalizeSpace(s string) string {
 	s = strings.TrimSpace(s)
 	if s == "" {
@@ -1,6 +1,12 @@
 package epub
 
-import "testing"
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
 
 func TestParseNavDocument(t *testing.T) {
 	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
@@ -41,6 +47,39 @@ func TestParseNavDocument(t *testing.T) {
 	}
 }
 
+func TestParseNavTOCUntypedFallback(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<body>
+  <nav epub:type="landmarks"><ol><li><a epub:type="cover" href="cover.xhtml">Cover</a></li></ol></nav>
+  <nav id="empty"><p>Contents</p></nav>
+  <nav id="toc">
+    <ol>
+      <li><a href="chapter1.xhtml">Chapter 1</a></li>
+      <li><a href="chapter2.xhtml">Chapter 2</a></li>
+    </ol>
+  </nav>
+</body>
+</html>`)
+	items, untyped, err := parseNavTOC(data)
+	if err != nil {
+		t.Fatalf("parse nav: %v", err)
+	}
+	if !untyped {
+		t.Fatalf("expected the untyped fallback to be reported")
+	}
+	if len(items) != 2 || items[0].Href != "chapter1.xhtml" || items[1].Title != "Chapter 2" {
+		t.Fatalf("items = %+v", items)
+	}
+
+	landmarksOnly := []byte(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="landmarks"><ol><li><a epub:type="cover" href="cover.xhtml">Cover</a></li></ol></nav>
+</body></html>`)
+	if _, _, err := parseNavTOC(landmarksOnly); err == nil {
+		t.Fatalf("landmarks must not stand in for the toc")
+	}
+}
+
 func TestJoinHref(t *testing.T) {
 	cases := map[string]struct {
 		prefix string
@@ -62,3 +101,129 @@ func TestJoinHref(t *testing.T) {
 		})
 	}
 }
+
+func TestParseLandmarks(t *testing.T) {
+	doc := []byte(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol><li><a epub:type="cover" href="not-a-landmark.xhtml">X</a></li></ol></nav>
+<nav epub:type="landmarks"><ol>
+  <li><a epub:type="cover" href="Text/cover.xhtml">Cover</a></li>
+  <li><a epub:type="bodymatter" href="Text/ch1.xhtml">Start</a></li>
+  <li><a epub:type="titlepage" href="Text/title.xhtml#top">Title Page</a></li>
+</ol></nav>
+</body></html>`)
+
+	got, err := parseLandmarks(doc, "cover", "titlepage")
+	if err != nil {
+		t.Fatalf("parseLandmarks: %v", err)
+	}
+	if len(got) != 2 || got[0] != "Text/cover.xhtml" || got[1] != "Text/title.xhtml#top" {
+		t.Fatalf("landmarks = %v", got)
+	}
+}
+
+func TestCollapseNavItems(t *testing.T) {
+	items := []NavItem{
+		{Title: "Vol 1", Href: "v1/start.xhtml", Children: []NavItem{
+			{Title: "Part One", Href: "v1/part.xhtml", Children: []NavItem{
+				{Title: "part one", Href: "v1/part.xhtml#t", Children: []NavItem{
+					{Title: "Chapter 1", Href: "v1/c1.xhtml"},
+					{Title: "Chapter 2", Href: "v1/c2.xhtml"},
+				}},
+			}},
+		}},
+		{Title: "Vol 2", Children: []NavItem{
+			{Title: "", Href: "v2/c1.xhtml"},
+		}},
+		{Title: "Vol 3", Href: "v3/c1.xhtml", Children: []NavItem{
+			{Title: "Wrapper", Children: []NavItem{{Title: "Only", Href: "v3/c1.xhtml"}}},
+			{Title: "Other", Href: "v3/c2.xhtml"},
+		}},
+	}
+	got := collapseNavItems(items)
+
+	if len(got) != 3 {
+		t.Fatalf("got %d entries", len(got))
+	}
+	if got[0].Title != "Vol 1: Part One" || got[0].Href != "v1/start.xhtml" || len(got[0].Children) != 2 {
+		t.Fatalf("first = %+v", got[0])
+	}
+	if got[1].Title != "Vol 2" || got[1].Href != "v2/c1.xhtml" || len(got[1].Children) != 0 {
+		t.Fatalf("second = %+v", got[1])
+	}
+	if len(got[2].Children) != 2 || got[2].Children[0].Title != "Wrapper: Only" || got[2].Children[0].Href != "v3/c1.xhtml" {
+		t.Fatalf("third = %+v", got[2])
+	}
+	if items[0].Title != "Vol 1" || len(items[0].Children) != 1 {
+		t.Fatalf("input was modified: %+v", items[0])
+	}
+}
+
+func TestDedupeNavItems(t *testing.T) {
+	items := []NavItem{
+		{Title: "Cover", Href: "v1/cover.xhtml"},
+		{Title: " cover ", Href: "v1/cover.xhtml"},
+		{Title: "Part 1", Href: "v1/p1.xhtml", Children: []NavItem{
+			{Title: "Chapter 1", Href: "v1/c1.xhtml"},
+			{Title: "Chapter 1", Href: "v1/c1.xhtml#start"},
+		}},
+		{Title: "Part 1", Href: "v1/p1.xhtml", Children: []NavItem{
+			{Title: "Chapter 1", Href: "v1/c1.xhtml"},
+			{Title: "Chapter 2", Href: "v1/c2.xhtml"},
+		}},
+	}
+	got, removed := dedupeNavItems(items)
+
+	if removed != 3 {
+		t.Fatalf("removed = %d", removed)
+	}
+	var titles []string
+	for _, item := range got {
+		titles = append(titles, item.Title+"("+strconv.Itoa(len(item.Children))+")")
+	}
+	if want := "Cover(0) Part 1(2) Chapter 2(0)"; strings.Join(titles, " ") != want {
+		t.Fatalf("got %s, want %s", strings.Join(titles, " "), want)
+	}
+	if got[1].Children[1].Href != "v1/c1.xhtml#start" {
+		t.Fatalf("entries with another fragment are kept: %+v", got[1].Children)
+	}
+	if len(items) != 4 || len(items[3].Children) != 2 {
+		t.Fatalf("input was modified: %+v", items)
+	}
+}
+
+func TestMergeEPUBsDedupeNav(t *testing.T) {
+	a := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Vol 1",
+			lang:       "en",
+			identifier: "urn:test:dedupe",
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				testItem("cover", "cover.xhtml"),
+				testItem("c1", "c1.xhtml"),
+			},
+			spine: []string{"cover", "c1"},
+		}),
+		"OEBPS/nav.xhtml":   `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="cover.xhtml">Cover</a></li><li><a href="cover.xhtml">Cover</a></li><li><a href="c1.xhtml">One</a></li></ol></nav></body></html>`,
+		"OEBPS/cover.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Cover</p></body></html>`,
+		"OEBPS/c1.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p></body></html>`,
+	})
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, DedupeNav: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if stats.NavDuplicatesRemoved != 1 {
+		t.Fatalf("NavDuplicatesRemoved = %d", stats.NavDuplicatesRemoved)
+	}
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	if len(book.NavItems) != 2 || len(book.NavItems[0].Children) != 2 {
+		t.Fatalf("nav = %+v", book.NavItems)
+	}
+}
@@ -0,0 +1,188 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+const (
+	ncxID        = "ncx"
+	ncxHref      = "toc.ncx"
+	ncxMediaType = "application/x-dtbncx+xml"
+)
+
+// renderNCX builds an NCX of the merged TOC for readers (and Kindle
+// conversion) that predate the EPUB 3 nav. items is the tree the nav lists,
+// built from buildVolumeNav; uid must be the package's unique identifier.
+// Nav points are numbered with playOrder in document order. A titled entry
+// without a link of its own points at its first descendant's; one with
+// neither title nor link is left out and its children moved up.
+func renderNCX(items []NavItem, uid, title string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	buf.WriteString(`<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">` + "\n")
+	buf.WriteString("<head>\n")
+	fmt.Fprintf(&buf, `<meta name="dtb:uid" content="%s"/>`+"\n", html.EscapeString(uid))
+	fmt.Fprintf(&buf, `<meta name="dtb:depth" content="%d"/>`+"\n", max(navDepth(items), 1))
+	buf.WriteString(`<meta name="dtb:totalPageCount" content="0"/>` + "\n")
+	buf.WriteString(`<meta name="dtb:maxPageNumber" content="0"/>` + "\n")
+	buf.WriteString("</head>\n")
+	buf.WriteString("<docTitle><text>" + html.EscapeString(title) + "</text></docTitle>\n")
+	buf.WriteString("<navMap>\n")
+	order := 0
+	for _, item := range items {
+		writeNavPoint(&buf, item, &order)
+	}
+	buf.WriteString("</navMap>\n</ncx>\n")
+	return buf.Bytes()
+}
+
+func writeNavPoint(buf *bytes.Buffer, item NavItem, order *int) {
+	src := item.Href
+	if src == "" && item.Title != "" {
+		src = firstNavHref(item.Children)
+	}
+	if src == "" {
+		for _, child := range item.Children {
+			writeNavPoint(buf, child, order)
+		}
+		return
+	}
+	*order++
+	label := item.Title
+	if label == "" {
+		label = src
+	}
+	fmt.Fprintf(buf, `<navPoint id="navPoint-%d" playOrder="%d">`, *order, *order)
+	buf.WriteString("<navLabel><text>" + html.EscapeString(label) + "</text></navLabel>")
+	buf.WriteString(`<content src="` + html.EscapeString(src) + `"/>`)
+	if len(item.Children) > 0 {
+		buf.WriteString("\n")
+		for _, child := range item.Children {
+			writeNavPoint(buf, child, order)
+		}
+	}
+	buf.WriteString("</navPoint>\n")
+}
+
+// firstNavHref returns the first link in items, depth first, or "".
+func firstNavHref(items []NavItem) string {
+	for _, item := range items {
+		if item.Href != "" {
+			return item.Href
+		}
+		if href := firstNavHref(item.Children); href != "" {
+			return href
+		}
+	}
+	return ""
+}
+
+// navDepth is how many levels the tree has.
+func navDepth(items []NavItem) int {
+	depth := 0
+	for _, item := range items {
+		depth = max(depth, 1+navDepth(item.Children))
+	}
+	return depth
+}
+
+// parseNCX reads the navMap of an EPUB 2 NCX into nav items, for volumes
+// that have no nav document. Nested navPoints become children; each title
+// is the navLabel text and each href the content src, resolved against
+// ncxDir so that, like the rest of the book, it is relative to the package
+// directory. Fragments are kept. pageList and navList are ignored.
+func parseNCX(data []byte, ncxDir string) ([]NavItem, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	var (
+		items   []NavItem
+		stack   []*navItemState
+		inMap   bool
+		inLabel bool
+	)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "navMap":
+				inMap = true
+			case "navPoint":
+				if inMap {
+					stack = append(stack, &navItemState{})
+				}
+			case "navLabel":
+				inLabel = len(stack) > 0
+			case "content":
+				if len(stack) == 0 {
+					continue
+				}
+				curr := stack[len(stack)-1]
+				if curr.item.Href != "" {
+					continue
+				}
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "src" {
+						curr.item.Href = joinHref(ncxDir, attr.Value)
+						break
+					}
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "navMap":
+				inMap = false
+			case "navLabel":
+				inLabel = false
+			case "navPoint":
+				if len(stack) == 0 {
+					continue
+				}
+				state := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				state.item.Title = normalizeSpace(state.text.String())
+				if len(stack) > 0 {
+					parent := &stack[len(stack)-1].item
+					parent.Children = append(parent.Children, state.item)
+				} else {
+					items = append(items, state.item)
+				}
+			}
+		case xml.CharData:
+			if inLabel && len(stack) > 0 {
+				curr := stack[len(stack)-1]
+				if curr.text.Len() > 0 {
+					curr.text.WriteByte(' ')
+				}
+				curr.text.WriteString(string(t))
+			}
+		}
+	}
+	return items, nil
+}
+
+// ncxItem returns the manifest item the spine's toc attribute names, or nil.
+func ncxItem(pkg *PackageDocument) *ManifestItem {
+	id := strings.TrimSpace(pkg.Spine.Toc)
+	if id == "" {
+		return nil
+	}
+	for i := range pkg.Manifest.Items {
+		if pkg.Manifest.Items[i].ID == id {
+			return &pkg.Manifest.Items[i]
+		}
+	}
+	return nil
+}
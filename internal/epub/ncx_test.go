@@ -0,0 +1,186 @@
+package epub
+
+import (
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderNCX(t *testing.T) {
+	items := []NavItem{
+		{Title: "Vol 1", Href: "v1/c1.xhtml", Children: []NavItem{
+			{Title: "One", Href: "v1/c1.xhtml"},
+			{Title: "Two & Three", Href: "v1/c2.xhtml#s2"},
+		}},
+		{Title: "Arc", Children: []NavItem{{Title: "Four", Href: "v2/c1.xhtml"}}},
+		{Children: []NavItem{{Title: "Five", Href: "v3/c1.xhtml"}}},
+	}
+	data := renderNCX(items, "urn:uuid:x", "Saga")
+
+	var ncx struct {
+		Meta []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"head>meta"`
+		Title  string     `xml:"docTitle>text"`
+		Points []ncxPoint `xml:"navMap>navPoint"`
+	}
+	if err := xml.Unmarshal(data, &ncx); err != nil {
+		t.Fatalf("NCX doesn't parse: %v\n%s", err, data)
+	}
+	if ncx.Meta[0].Name != "dtb:uid" || ncx.Meta[0].Content != "urn:uuid:x" || ncx.Meta[1].Content != "2" || ncx.Title != "Saga" {
+		t.Fatalf("head = %+v, title %q", ncx.Meta, ncx.Title)
+	}
+	var got []string
+	var walk func(points []ncxPoint, depth string)
+	walk = func(points []ncxPoint, depth string) {
+		for _, p := range points {
+			got = append(got, depth+p.Order+":"+p.Label+"="+p.Content.Src)
+			walk(p.Children, depth+"-")
+		}
+	}
+	walk(ncx.Points, "")
+	want := "1:Vol 1=v1/c1.xhtml,-2:One=v1/c1.xhtml,-3:Two & Three=v1/c2.xhtml#s2,4:Arc=v2/c1.xhtml,-5:Four=v2/c1.xhtml,6:Five=v3/c1.xhtml"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("nav points = %q", got)
+	}
+}
+
+type ncxPoint struct {
+	Order   string `xml:"playOrder,attr"`
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	Children []ncxPoint `xml:"navPoint"`
+}
+
+func TestMergeEPUBsEmitNCX(t *testing.T) {
+	a := buildChaptersEPUB(t, "Vol 1", "One", "Two")
+	b := buildChaptersEPUB(t, "Vol 2", "Three")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	const id = "urn:uuid:0f3c2b1e-8d4a-4c6b-9e2f-1a2b3c4d5e6f"
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Identifier: id, EmitNCX: true}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	pkg := vol.PackageDoc
+	var item *ManifestItem
+	for i := range pkg.Manifest.Items {
+		if pkg.Manifest.Items[i].ID == pkg.Spine.Toc {
+			item = &pkg.Manifest.Items[i]
+		}
+	}
+	if pkg.Spine.Toc != "ncx" || item == nil || item.Href != "toc.ncx" || item.MediaType != "application/x-dtbncx+xml" {
+		t.Fatalf("spine toc %q, item %+v", pkg.Spine.Toc, item)
+	}
+	data, err := vol.readFile("toc.ncx")
+	if err != nil {
+		t.Fatalf("read NCX: %v", err)
+	}
+	ncx := string(data)
+	for _, want := range []string{`<meta name="dtb:uid" content="` + id + `"/>`, `playOrder="5"`, `<content src="Volumes/v0002/c1.xhtml"/>`} {
+		if !strings.Contains(ncx, want) {
+			t.Fatalf("NCX lacks %s:\n%s", want, ncx)
+		}
+	}
+	if issues, err := ValidateEPUB(context.Background(), out); err != nil {
+		t.Fatalf("ValidateEPUB: %v", err)
+	} else if errs, _ := CountIssues(issues); errs != 0 {
+		t.Fatalf("merged book has errors: %+v", issues)
+	}
+}
+
+func TestParseNCX(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+<docTitle><text>Old Book</text></docTitle>
+<navMap>
+  <navPoint id="p1" playOrder="1">
+    <navLabel><text>Part
+      One</text></navLabel>
+    <content src="Text/part1.xhtml"/>
+    <navPoint id="p2" playOrder="2">
+      <navLabel><text>Chapter 1</text></navLabel>
+      <content src="Text/part1.xhtml#ch1"/>
+    </navPoint>
+  </navPoint>
+  <navPoint id="p3" playOrder="3">
+    <navLabel><text>Afterword</text></navLabel>
+    <content src="../Text/after.xhtml"/>
+  </navPoint>
+</navMap>
+<pageList><pageTarget type="normal" value="1"><navLabel><text>1</text></navLabel><content src="Text/part1.xhtml#p1"/></pageTarget></pageList>
+</ncx>`)
+	items, err := parseNCX(data, "Misc")
+	if err != nil {
+		t.Fatalf("parseNCX: %v", err)
+	}
+	want := []NavItem{
+		{Title: "Part One", Href: "Misc/Text/part1.xhtml", Children: []NavItem{
+			{Title: "Chapter 1", Href: "Misc/Text/part1.xhtml#ch1"},
+		}},
+		{Title: "Afterword", Href: "Text/after.xhtml"},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("items = %+v", items)
+	}
+}
+
+func TestMergeEPUBsNCXOnlyVolume(t *testing.T) {
+	old := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			version:    "2.0",
+			title:      "Old",
+			identifier: "urn:test:epub2",
+			items: []ManifestItem{
+				testItem("ncx", "toc.ncx"),
+				testItem("c1", "Text/c1.xhtml"),
+			},
+			spine:    []string{"c1"},
+			spineToc: "ncx",
+		}),
+		"OEBPS/toc.ncx": `<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1"><navMap>
+<navPoint id="a" playOrder="1"><navLabel><text>Opening</text></navLabel><content src="Text/c1.xhtml"/>
+<navPoint id="b" playOrder="2"><navLabel><text>Second scene</text></navLabel><content src="Text/c1.xhtml#s2"/></navPoint>
+</navPoint></navMap></ncx>`,
+		"OEBPS/Text/c1.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p><p id="s2">Two</p></body></html>`,
+	})
+	vol, err := loadVolume(context.Background(), 0, old)
+	if err != nil {
+		t.Fatalf("loadVolume: %v", err)
+	}
+	os.RemoveAll(vol.TempDir)
+	if vol.NavHref != "" || len(vol.NavItems) != 1 || len(vol.NavItems[0].Children) != 1 || len(vol.Warnings) != 0 {
+		t.Fatalf("nav %q, items %+v, warnings %q", vol.NavHref, vol.NavItems, vol.Warnings)
+	}
+
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{old, buildTestEPUB(t, "New", "en")}, MergeOptions{OutPath: out}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	merged, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(merged.TempDir)
+	data, err := merged.readFile(merged.NavHref)
+	if err != nil {
+		t.Fatalf("read nav: %v", err)
+	}
+	nav := string(data)
+	for _, want := range []string{`href="Volumes/v0001/Text/c1.xhtml">Opening</a>`, `href="Volumes/v0001/Text/c1.xhtml#s2">Second scene</a>`} {
+		if !strings.Contains(nav, want) {
+			t.Fatalf("nav lacks %s:\n%s", want, nav)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package epub
+
+import (
+	"compress/flate"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type NormalizeOptions struct {
+	OutPath string
+}
+
+// NormalizeResult reports what NormalizeEPUB changed and how the output
+// validated.
+type NormalizeResult struct {
+	// MediaTypes are the manifest items whose media-type was filled in or
+	// rewritten to its canonical form.
+	MediaTypes []MediaTypeFix
+	// Removed are the archive entries dropped as operating-system clutter
+	// (__MACOSX, .DS_Store, Thumbs.db).
+	Removed []string
+	// Validation is what ValidateEPUB found in the normalized book.
+	Validation []ValidationIssue
+}
+
+// clutterNames are files archivers and file managers leave in a book.
+var clutterNames = map[string]bool{
+	".DS_Store":   true,
+	"Thumbs.db":   true,
+	"desktop.ini": true,
+}
+
+// NormalizeEPUB repacks an EPUB in a canonical form without changing its
+// content: a stored mimetype first, then META-INF/container.xml, then the
+// other entries in sorted order with fixed modes and no timestamps; canonical
+// manifest media-types; the package document re-serialized; and OS clutter
+// left out. The result is validated with ValidateEPUB. The input is
+// rewritten in place unless opts.OutPath is set.
+func NormalizeEPUB(ctx context.Context, input string, opts NormalizeOptions) (NormalizeResult, error) {
+	var res NormalizeResult
+	if input == "" {
+		return res, fmt.Errorf("input EPUB path is required")
+	}
+
+	vol, err := loadVolume(ctx, 0, input)
+	if err != nil {
+		return res, err
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	if err := os.WriteFile(filepath.Join(vol.RootDir, "mimetype"), []byte(epubMimetype), 0o644); err != nil {
+		return res, err
+	}
+	res.Removed, err = removeClutter(vol.RootDir)
+	if err != nil {
+		return res, err
+	}
+	res.MediaTypes = fixManifestMediaTypes(vol.PackageDir, &vol.PackageDoc.Manifest)
+	for i := range res.MediaTypes {
+		res.MediaTypes[i].Source = input
+	}
+
+	if err := writePackage(vol.PackageDoc, vol.PackagePath); err != nil {
+		return res, err
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	if err := replaceArchiveWith(vol.RootDir, input, opts.OutPath, zipOptions{level: flate.DefaultCompression, canonical: true}); err != nil {
+		return res, err
+	}
+
+	out := opts.OutPath
+	if out == "" {
+		out = input
+	}
+	res.Validation, err = ValidateEPUB(ctx, out)
+	if err != nil {
+		return res, fmt.Errorf("validate: %w", err)
+	}
+	return res, nil
+}
+
+// removeClutter deletes __MACOSX folders and the files in clutterNames
+// under root, returning their archive paths.
+func removeClutter(root string) ([]string, error) {
+	var removed []string
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if clutter := info.IsDir() && info.Name() == "__MACOSX" || !info.IsDir() && clutterNames[info.Name()]; !clutter {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		removed = append(removed, filepath.ToSlash(rel))
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,150 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEPUB(t *testing.T) {
+	opf := `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid"><metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="uid">urn:uuid:11111111-2222-4333-8444-555555555555</dc:identifier><dc:title>Book</dc:title><dc:language>en</dc:language>
+<meta property="dcterms:modified">2020-01-01T00:00:00Z</meta></metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="c1" href="c1.xhtml" media-type=" Text/HTML "/>
+<item id="img" href="pic.jpg" media-type="image/jpg"/>
+<item id="css" href="book.css"/>
+</manifest>
+<spine><itemref idref="c1"/></spine></package>`
+	chapter := `<html xmlns="http://www.w3.org/1999/xhtml"><head><title>One</title><link rel="stylesheet" href="book.css"/></head><body><p>One</p><img src="pic.jpg" alt=""/></body></html>`
+	input := writeRawZip(t, [][2]string{
+		{"OEBPS/content.opf", opf},
+		{"OEBPS/c1.xhtml", chapter},
+		{"OEBPS/nav.xhtml", `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><head><title>TOC</title></head><body><nav epub:type="toc"><ol><li><a href="c1.xhtml">One</a></li></ol></nav></body></html>`},
+		{"OEBPS/pic.jpg", "\xff\xd8\xff"},
+		{"OEBPS/book.css", "p { margin: 0 }"},
+		{"OEBPS/.DS_Store", "junk"},
+		{"__MACOSX/OEBPS/._c1.xhtml", "junk"},
+		{"META-INF/container.xml", `<?xml version="1.0"?><container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`},
+		{"mimetype", epubMimetype},
+	})
+	out := filepath.Join(t.TempDir(), "normalized.epub")
+
+	res, err := NormalizeEPUB(context.Background(), input, NormalizeOptions{OutPath: out})
+	if err != nil {
+		t.Fatalf("NormalizeEPUB: %v", err)
+	}
+	if errs, _ := CountIssues(res.Validation); errs != 0 {
+		t.Fatalf("normalized book has errors: %+v", res.Validation)
+	}
+	var changes []string
+	for _, c := range res.MediaTypes {
+		changes = append(changes, c.ID+"="+c.MediaType)
+	}
+	if strings.Join(changes, ",") != "c1=application/xhtml+xml,img=image/jpeg,css=text/css" {
+		t.Fatalf("media types = %q", changes)
+	}
+	if strings.Join(res.Removed, ",") != "OEBPS/.DS_Store,__MACOSX" {
+		t.Fatalf("removed = %q", res.Removed)
+	}
+
+	r, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		if f.Mode().Perm() != 0o644 {
+			t.Errorf("%s: mode %v", f.Name, f.Mode())
+		}
+	}
+	want := "mimetype,META-INF/container.xml,OEBPS/book.css,OEBPS/c1.xhtml,OEBPS/content.opf,OEBPS/nav.xhtml,OEBPS/pic.jpg"
+	if strings.Join(names, ",") != want || r.File[0].Method != zip.Store {
+		t.Fatalf("entries = %q, mimetype method %d", names, r.File[0].Method)
+	}
+
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	if data, err := book.ReadFile("c1.xhtml"); err != nil || string(data) != chapter {
+		t.Fatalf("chapter changed: %s, %v", data, err)
+	}
+
+	again := filepath.Join(t.TempDir(), "again.epub")
+	res, err = NormalizeEPUB(context.Background(), out, NormalizeOptions{OutPath: again})
+	if err != nil || len(res.MediaTypes) != 0 || len(res.Removed) != 0 {
+		t.Fatalf("second pass: %+v, %v", res, err)
+	}
+	first, _ := os.ReadFile(out)
+	second, _ := os.ReadFile(again)
+	if !bytes.Equal(first, second) {
+		t.Fatal("normalizing a normalized book changed it")
+	}
+}
+
+func TestNormalizeEPUBKeepsEPUB2Package(t *testing.T) {
+	opf := testOPF(testPackage{
+		version: "2.0",
+		title:   "Book",
+		lang:    "ja",
+		metadata: []string{
+			`<dc:title xml:lang="ja" dir="ltr">本</dc:title>`,
+			`<dc:date opf:event="publication">2012-03-04</dc:date>`,
+			`<dc:date opf:event="modification">2013-05-06</dc:date>`,
+		},
+		items:    []ManifestItem{testItem("c1", "c1.xhtml"), testItem("ncx", "toc.ncx")},
+		spine:    []string{"c1"},
+		spineToc: "ncx",
+	})
+	opf = strings.Replace(opf, "</package>", `<guide><reference type="text" title="Start" href="c1.xhtml"/></guide></package>`, 1)
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": opf,
+		"OEBPS/c1.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><head><title>One</title></head><body><p>One</p></body></html>`,
+		"OEBPS/toc.ncx":     `<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1"><head/><docTitle><text>本</text></docTitle><navMap><navPoint id="p1"><navLabel><text>One</text></navLabel><content src="c1.xhtml"/></navPoint></navMap></ncx>`,
+	})
+
+	once := filepath.Join(t.TempDir(), "once.epub")
+	twice := filepath.Join(t.TempDir(), "twice.epub")
+	if _, err := NormalizeEPUB(context.Background(), input, NormalizeOptions{OutPath: once}); err != nil {
+		t.Fatalf("NormalizeEPUB: %v", err)
+	}
+	if _, err := NormalizeEPUB(context.Background(), once, NormalizeOptions{OutPath: twice}); err != nil {
+		t.Fatalf("NormalizeEPUB again: %v", err)
+	}
+	first, _ := os.ReadFile(once)
+	second, _ := os.ReadFile(twice)
+	if !bytes.Equal(first, second) {
+		t.Fatal("normalizing a normalized book changed it")
+	}
+
+	vol, err := loadVolume(context.Background(), 0, twice)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	pkg := vol.PackageDoc
+	if g := pkg.Guide; g == nil || len(g.References) != 1 || g.References[0] != (GuideReference{Type: "text", Title: "Start", Href: "c1.xhtml"}) {
+		t.Fatalf("guide = %+v", g)
+	}
+	if ti := pkg.Metadata.Titles; len(ti) != 2 || ti[1].Lang != "ja" || ti[1].Dir != "ltr" {
+		t.Fatalf("titles = %+v", ti)
+	}
+	if dates := extraDCElements(pkg.Metadata, "date"); len(dates) != 2 {
+		t.Fatalf("dates = %+v", dates)
+	}
+	data, err := os.ReadFile(vol.PackagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUniqueAttrs(t, data)
+}
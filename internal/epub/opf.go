@@ -0,0 +1,150 @@
+package epub
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// Package documents in the wild bind Dublin Core to whatever prefix the
+// producer liked, sometimes without declaring it, sometimes under the old
+// 1.0 namespace, and OEB-era files wrap it in <dc-metadata>. The struct tags
+// alone only match one exact namespace URI, so metadata is decoded by hand,
+// by local name within the Dublin Core namespace family.
+
+const nsDCLegacy = "http://purl.org/dc/elements/1.0/"
+
+// isDCSpace reports whether space is a Dublin Core namespace, including the
+// bare prefix that encoding/xml leaves behind when dc: was never declared.
+func isDCSpace(space string) bool {
+	switch strings.TrimRight(strings.ToLower(space), "/") {
+	case strings.TrimRight(nsDC, "/"), strings.TrimRight(nsDCLegacy, "/"), "dc":
+		return true
+	}
+	return false
+}
+
+func (m *Metadata) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	m.XMLName = start.Name
+	return m.decodeChildren(d)
+}
+
+func (m *Metadata) decodeChildren(d *xml.Decoder) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			if err := m.decodeChild(d, t); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (m *Metadata) decodeChild(d *xml.Decoder, se xml.StartElement) error {
+	local := se.Name.Local
+	if isDCSpace(se.Name.Space) {
+		var target *[]DCMeta
+		switch local {
+		case "title":
+			target = &m.Titles
+		case "creator":
+			target = &m.Creators
+		case "language":
+			target = &m.Languages
+		case "identifier":
+			target = &m.Identifiers
+		case "description":
+			target = &m.Descriptions
+		case "rights":
+			target = &m.Rights
+		}
+		if target != nil {
+			var v DCMeta
+			if err := d.DecodeElement(&v, &se); err != nil {
+				return err
+			}
+			*target = append(*target, v)
+			return nil
+		}
+	} else {
+		switch local {
+		case "meta":
+			var v MetaNode
+			if err := d.DecodeElement(&v, &se); err != nil {
+				return err
+			}
+			m.Meta = append(m.Meta, v)
+			return nil
+		case "dc-metadata", "x-metadata":
+			return m.decodeChildren(d)
+		}
+	}
+
+	var raw RawElement
+	if err := d.DecodeElement(&raw, &se); err != nil {
+		return err
+	}
+	m.Extra = append(m.Extra, raw)
+	return nil
+}
+
+// UnmarshalXML reads a Dublin Core element, taking id, role, file-as,
+// scheme, xml:lang and dir by local name whatever prefix (opf:, none, or
+// another) they were written with.
+func (dc *DCMeta) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		switch attr.Name.Local {
+		case "id":
+			dc.ID = attr.Value
+		case "role":
+			dc.Role = attr.Value
+		case "file-as":
+			dc.FileAs = attr.Value
+		case "scheme":
+			dc.Scheme = attr.Value
+		case "lang":
+			dc.Lang = attr.Value
+		case "dir":
+			dc.Dir = attr.Value
+		}
+	}
+	var text strings.Builder
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				dc.Value = text.String()
+				return nil
+			}
+			depth--
+		case xml.CharData:
+			text.Write(t)
+		}
+	}
+}
+
+// UnmarshalXML reads an element Metadata doesn't model, dropping its
+// namespace declarations: the encoder writes its own from the element and
+// attribute names, so kept ones would pile up on every round trip.
+func (r *RawElement) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type rawElement RawElement
+	var v rawElement
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	v.Attrs = stripXMLNSAttrs(v.Attrs)
+	*r = RawElement(v)
+	return nil
+}
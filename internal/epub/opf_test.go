@@ -0,0 +1,151 @@
+package epub
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const unusualPrefixOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<o:package xmlns:o="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="uid">
+  <o:metadata xmlns:purl="http://purl.org/dc/elements/1.1/">
+    <purl:title>Prefixed Title</purl:title>
+    <purl:creator o:role="aut" o:file-as="Writer, Some">Some Writer</purl:creator>
+    <purl:language>ja</purl:language>
+    <purl:identifier id="uid">urn:test:prefixed</purl:identifier>
+    <purl:publisher>Kept Press</purl:publisher>
+    <o:meta name="cover" content="img"/>
+    <o:dc-metadata xmlns:old="http://purl.org/dc/elements/1.0/">
+      <old:description>Legacy description</old:description>
+      <dc:creator role="ill">Undeclared Prefix</dc:creator>
+    </o:dc-metadata>
+  </o:metadata>
+  <o:manifest>
+    <o:item id="img" href="cover.jpg" media-type="image/jpeg"/>
+    <o:item id="chap" href="chapter.xhtml" media-type="application/xhtml+xml"/>
+  </o:manifest>
+  <o:spine page-progression-direction="rtl" toc="ncx">
+    <o:itemref idref="chap"/>
+  </o:spine>
+</o:package>
+`
+
+func TestParsePackageUnusualPrefixes(t *testing.T) {
+	var pkg PackageDocument
+	if err := xml.Unmarshal([]byte(unusualPrefixOPF), &pkg); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	checkUnusualPackage(t, &pkg)
+
+	// Written back out and re-read, nothing should be lost either.
+	out := filepath.Join(t.TempDir(), "content.opf")
+	if err := writePackage(&pkg, out); err != nil {
+		t.Fatalf("writePackage: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !strings.Contains(string(data), `xmlns="http://www.idpf.org/2007/opf"`) || !strings.Contains(string(data), `xmlns:opf="http://www.idpf.org/2007/opf"`) {
+		t.Fatalf("written package should declare the OPF namespaces:\n%s", data)
+	}
+	var again PackageDocument
+	if err := xml.Unmarshal(data, &again); err != nil {
+		t.Fatalf("re-read: %v\n%s", err, data)
+	}
+	checkUnusualPackage(t, &again)
+}
+
+func checkUnusualPackage(t *testing.T, pkg *PackageDocument) {
+	t.Helper()
+	meta := pkg.Metadata
+	if firstDCValue(meta.Titles) != "Prefixed Title" || firstDCValue(meta.Languages) != "ja" {
+		t.Fatalf("titles/languages = %+v %+v", meta.Titles, meta.Languages)
+	}
+	if len(meta.Identifiers) != 1 || meta.Identifiers[0].ID != "uid" {
+		t.Fatalf("identifiers = %+v", meta.Identifiers)
+	}
+	if len(meta.Creators) != 2 {
+		t.Fatalf("creators = %+v", meta.Creators)
+	}
+	if c := meta.Creators[0]; c.Role != "aut" || c.FileAs != "Writer, Some" || c.Value != "Some Writer" {
+		t.Fatalf("creator[0] = %+v", c)
+	}
+	if c := meta.Creators[1]; c.Role != "ill" || c.Value != "Undeclared Prefix" {
+		t.Fatalf("creator[1] = %+v", c)
+	}
+	if firstDCValue(meta.Descriptions) != "Legacy description" {
+		t.Fatalf("descriptions = %+v", meta.Descriptions)
+	}
+	if len(meta.Meta) != 1 || meta.Meta[0].Name != "cover" || meta.Meta[0].Content != "img" {
+		t.Fatalf("meta = %+v", meta.Meta)
+	}
+	if len(meta.Extra) != 1 || meta.Extra[0].XMLName.Local != "publisher" {
+		t.Fatalf("extra = %+v", meta.Extra)
+	}
+	if len(pkg.Manifest.Items) != 2 || pkg.Manifest.Items[1].Href != "chapter.xhtml" {
+		t.Fatalf("manifest = %+v", pkg.Manifest.Items)
+	}
+	if len(pkg.Spine.Itemrefs) != 1 || pkg.Spine.PageProgressionDirection != "rtl" || pkg.Spine.Toc != "ncx" {
+		t.Fatalf("spine = %+v", pkg.Spine)
+	}
+}
+
+func TestPackageExtraRoundTrip(t *testing.T) {
+	data := []byte(testOPF(testPackage{
+		version:  "2.0",
+		title:    "Dated",
+		metadata: []string{`<dc:date opf:event="publication">2012-03-04</dc:date>`, `<dc:subject xml:lang="ja">Fantasy</dc:subject>`},
+		items:    []ManifestItem{testItem("chap", "chapter.xhtml")},
+		spine:    []string{"chap"},
+	}))
+	var outputs [][]byte
+	for range 2 {
+		var pkg PackageDocument
+		if err := xml.Unmarshal(data, &pkg); err != nil {
+			t.Fatalf("unmarshal: %v\n%s", err, data)
+		}
+		if len(pkg.Metadata.Extra) != 2 {
+			t.Fatalf("extra = %+v", pkg.Metadata.Extra)
+		}
+		out, err := marshalPackage(&pkg)
+		if err != nil {
+			t.Fatalf("marshalPackage: %v", err)
+		}
+		checkUniqueAttrs(t, out)
+		outputs = append(outputs, out)
+		data = out
+	}
+	if string(outputs[0]) != string(outputs[1]) {
+		t.Fatalf("second round trip changed the package:\n%s\n---\n%s", outputs[0], outputs[1])
+	}
+	if !strings.Contains(string(data), `opf:event="publication"`) || !strings.Contains(string(data), `xml:lang="ja"`) {
+		t.Fatalf("extra attributes lost:\n%s", data)
+	}
+}
+
+// checkUniqueAttrs fails if any element of data repeats an attribute, which
+// encoding/xml accepts but XML forbids.
+func checkUniqueAttrs(t *testing.T, data []byte) {
+	t.Helper()
+	dec := xml.NewDecoder(strings.NewReader(string(data)))
+	for {
+		tok, err := dec.RawToken()
+		if err != nil {
+			return
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		seen := map[xml.Name]bool{}
+		for _, a := range se.Attr {
+			if seen[a.Name] {
+				t.Fatalf("<%s> repeats %s:%s:\n%s", se.Name.Local, a.Name.Space, a.Name.Local, data)
+			}
+			seen[a.Name] = true
+		}
+	}
+}
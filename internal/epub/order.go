@@ -0,0 +1,116 @@
+package epub
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// ReadingOrder selects what the merged spine follows within each volume.
+type ReadingOrder int
+
+const (
+	// ReadingOrderSpine keeps each volume's spine order.
+	ReadingOrderSpine ReadingOrder = iota
+	// ReadingOrderNav orders each volume's spine documents by their first
+	// appearance in its nav, appending the ones the nav never links.
+	ReadingOrderNav
+)
+
+// ManifestOrder selects how each volume's items are ordered in the merged
+// manifest. Volumes always come in merge order.
+type ManifestOrder int
+
+const (
+	// ManifestOrderSource keeps each volume's manifest order.
+	ManifestOrderSource ManifestOrder = iota
+	// ManifestOrderHref sorts each volume's items by href.
+	ManifestOrderHref
+	// ManifestOrderType sorts each volume's items by media type, then href.
+	ManifestOrderType
+)
+
+// orderedManifestItems returns a copy of items in the given order. Sorting is
+// stable, so items sharing an href keep their source order.
+func orderedManifestItems(items []ManifestItem, order ManifestOrder) []ManifestItem {
+	out := append([]ManifestItem(nil), items...)
+	switch order {
+	case ManifestOrderHref:
+		sort.SliceStable(out, func(i, j int) bool {
+			return normalizeEPUBPath(out[i].Href) < normalizeEPUBPath(out[j].Href)
+		})
+	case ManifestOrderType:
+		sort.SliceStable(out, func(i, j int) bool {
+			ti, tj := strings.ToLower(out[i].MediaType), strings.ToLower(out[j].MediaType)
+			if ti != tj {
+				return ti < tj
+			}
+			return normalizeEPUBPath(out[i].Href) < normalizeEPUBPath(out[j].Href)
+		})
+	}
+	return out
+}
+
+// navSpineOrder returns vol's spine itemrefs sorted by nav order. Nav entries
+// are visited depth-first; those pointing outside the spine are ignored.
+func navSpineOrder(vol *Volume) []SpineItemRef {
+	refs := vol.PackageDoc.Spine.Itemrefs
+	if len(vol.NavItems) == 0 {
+		return refs
+	}
+
+	hrefIDs := make(map[string]string)
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		hrefIDs[normalizeEPUBPath(item.Href)] = item.ID
+	}
+	byID := make(map[string]SpineItemRef)
+	for _, ref := range refs {
+		if _, ok := byID[ref.IDRef]; !ok {
+			byID[ref.IDRef] = ref
+		}
+	}
+
+	navDir := path.Dir(vol.NavHref)
+	var (
+		out  []SpineItemRef
+		seen = map[string]bool{}
+		walk func(items []NavItem)
+	)
+	walk = func(items []NavItem) {
+		for _, item := range items {
+			if id, ok := navItemID(item.Href, navDir, hrefIDs); ok && !seen[id] {
+				if ref, ok := byID[id]; ok {
+					seen[id] = true
+					out = append(out, ref)
+				}
+			}
+			walk(item.Children)
+		}
+	}
+	walk(vol.NavItems)
+
+	for _, ref := range refs {
+		if !seen[ref.IDRef] {
+			seen[ref.IDRef] = true
+			out = append(out, ref)
+		}
+	}
+	return out
+}
+
+// navItemID resolves a nav href (relative to the nav document) to a manifest
+// id, falling back to treating it as package-relative.
+func navItemID(href, navDir string, hrefIDs map[string]string) (string, bool) {
+	base := strings.TrimSpace(href)
+	if i := strings.IndexByte(base, '#'); i >= 0 {
+		base = base[:i]
+	}
+	if base == "" || strings.Contains(base, "://") {
+		return "", false
+	}
+	if id, ok := hrefIDs[normalizeEPUBPath(path.Join(navDir, base))]; ok {
+		return id, true
+	}
+	id, ok := hrefIDs[normalizeEPUBPath(base)]
+	return id, ok
+}
@@ -0,0 +1,163 @@
+package epub
+
+import (
+	"context"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNavSpineOrder(t *testing.T) {
+	vol := &Volume{
+		NavHref: "Text/nav.xhtml",
+		NavItems: []NavItem{
+			{Title: "Two", Href: "c2.xhtml", Children: []NavItem{
+				{Title: "Two, part 2", Href: "c2.xhtml#p2"},
+				{Title: "One", Href: "c1.xhtml"},
+			}},
+			{Title: "Site", Href: "https://example.com/"},
+			{Title: "Image", Href: "../Images/map.png"},
+		},
+		PackageDoc: &PackageDocument{
+			Manifest: Manifest{Items: []ManifestItem{
+				{ID: "c1", Href: "Text/c1.xhtml"},
+				{ID: "c2", Href: "Text/c2.xhtml"},
+				{ID: "c3", Href: "Text/c3.xhtml"},
+				{ID: "map", Href: "Images/map.png"},
+			}},
+			Spine: Spine{Itemrefs: []SpineItemRef{
+				{IDRef: "c3"},
+				{IDRef: "c1", Linear: "no"},
+				{IDRef: "c2"},
+			}},
+		},
+	}
+
+	got := navSpineOrder(vol)
+	want := []SpineItemRef{{IDRef: "c2"}, {IDRef: "c1", Linear: "no"}, {IDRef: "c3"}}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("itemref[%d] = %+v want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMergeEPUBsNavOrder(t *testing.T) {
+	build := func(title string) string {
+		return buildTestEPUBFiles(t, map[string]string{
+			"OEBPS/content.opf": testOPF(testPackage{
+				title:      title,
+				identifier: "urn:test:order",
+				items: []ManifestItem{
+					testItem("nav", "nav.xhtml", "nav"),
+					testItem("a", "a.xhtml"),
+					testItem("b", "b.xhtml"),
+				},
+				spine: []string{"b", "a"},
+			}),
+			"OEBPS/nav.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="a.xhtml">A</a></li><li><a href="b.xhtml">B</a></li></ol></nav></body></html>`,
+			"OEBPS/a.xhtml":   `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>A</p></body></html>`,
+			"OEBPS/b.xhtml":   `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>B</p></body></html>`,
+		})
+	}
+	inputs := []string{build("Vol 1"), build("Vol 2")}
+
+	spineOf := func(order ReadingOrder) []string {
+		out := filepath.Join(t.TempDir(), "merged.epub")
+		if _, err := MergeEPUBs(context.Background(), inputs, MergeOptions{OutPath: out, ReadingOrder: order}); err != nil {
+			t.Fatalf("MergeEPUBs: %v", err)
+		}
+		vol, err := loadVolume(context.Background(), 0, out)
+		if err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		defer os.RemoveAll(vol.TempDir)
+		var ids []string
+		for _, ref := range vol.PackageDoc.Spine.Itemrefs {
+			ids = append(ids, ref.IDRef)
+		}
+		return ids
+	}
+
+	if got := spineOf(ReadingOrderSpine); len(got) != 4 || got[0] != "v0001_b" || got[1] != "v0001_a" {
+		t.Fatalf("spine order = %v", got)
+	}
+	got := spineOf(ReadingOrderNav)
+	want := []string{"v0001_a", "v0001_b", "v0002_a", "v0002_b"}
+	if len(got) != len(want) {
+		t.Fatalf("nav order = %v want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("nav order = %v want %v", got, want)
+		}
+	}
+}
+
+func TestOrderedManifestItems(t *testing.T) {
+	items := []ManifestItem{
+		{ID: "css", Href: "Styles/b.css", MediaType: "text/css"},
+		{ID: "c2", Href: "Text/c2.xhtml", MediaType: "application/xhtml+xml"},
+		{ID: "img", Href: "Images/a.png", MediaType: "image/png"},
+		{ID: "c1", Href: "Text/c1.xhtml", MediaType: "application/xhtml+xml"},
+	}
+	ids := func(items []ManifestItem) string {
+		var out []string
+		for _, item := range items {
+			out = append(out, item.ID)
+		}
+		return strings.Join(out, ",")
+	}
+	for _, tc := range []struct {
+		order ManifestOrder
+		want  string
+	}{
+		{ManifestOrderSource, "css,c2,img,c1"},
+		{ManifestOrderHref, "img,css,c1,c2"},
+		{ManifestOrderType, "c1,c2,img,css"},
+	} {
+		if got := ids(orderedManifestItems(items, tc.order)); got != tc.want {
+			t.Errorf("order %d = %s, want %s", tc.order, got, tc.want)
+		}
+	}
+	if ids(items) != "css,c2,img,c1" {
+		t.Fatalf("input reordered: %s", ids(items))
+	}
+}
+
+func TestMergeEPUBsManifestOrder(t *testing.T) {
+	a := buildCoverTestEPUB(t, "Vol 1", color.White)
+	b := buildChaptersEPUB(t, "Vol 2", "Two", "One")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, ManifestOrder: ManifestOrderType}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+
+	var got []string
+	for _, item := range book.Package.Manifest.Items {
+		got = append(got, item.Href+" "+item.Properties)
+	}
+	want := []string{
+		"Volumes/v0001/chapter.xhtml ",
+		"Volumes/v0001/cover.png cover-image",
+		"Volumes/v0002/c1.xhtml ",
+		"Volumes/v0002/c2.xhtml ",
+		"nav.xhtml nav",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("manifest =\n%s", strings.Join(got, "\n"))
+	}
+	if refs := book.Package.Spine.Itemrefs; len(refs) != 3 || refs[1].IDRef != "v0002_c1" {
+		t.Fatalf("spine = %+v", refs)
+	}
+}
@@ -0,0 +1,142 @@
+package epub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Media overlay (SMIL) documents are copied with the rest of each volume and
+// keep their position relative to the content they reference, so their
+// text/audio src values stay valid under the volume prefix. What needs care is
+// the package metadata: the media-overlay manifest attribute and the
+// media:duration refinements both name manifest ids, which the merge renames.
+
+const (
+	propMediaDuration    = "media:duration"
+	propMediaActiveClass = "media:active-class"
+	propMediaPlayback    = "media:playback-active-class"
+	propMediaNarrator    = "media:narrator"
+)
+
+func hasMediaOverlays(vols []*Volume) bool {
+	for _, vol := range vols {
+		for _, item := range vol.PackageDoc.Manifest.Items {
+			if item.MediaOverlay != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// overlayMetadata returns the media overlay meta entries for the merged
+// package: every per-item media:duration with its refines target renamed
+// through idMaps, a book-level media:duration summing the volume totals,
+// and the active-class settings from the first volume that declares them.
+func overlayMetadata(vols []*Volume, idMaps []map[string]string) []MetaNode {
+	var (
+		out     []MetaNode
+		total   time.Duration
+		summed  bool
+		classes = map[string]string{}
+	)
+	for i, vol := range vols {
+		idMap := idMaps[i]
+		for _, meta := range vol.PackageDoc.Metadata.Meta {
+			switch meta.Property {
+			case propMediaDuration:
+				if meta.Refines == "" {
+					if d, err := parseClockValue(meta.Value); err == nil {
+						total += d
+						summed = true
+					}
+					continue
+				}
+				newID, ok := idMap[strings.TrimPrefix(meta.Refines, "#")]
+				if !ok {
+					continue
+				}
+				out = append(out, MetaNode{
+					Refines:  "#" + newID,
+					Property: propMediaDuration,
+					Value:    strings.TrimSpace(meta.Value),
+				})
+			case propMediaActiveClass, propMediaPlayback, propMediaNarrator:
+				if meta.Refines != "" {
+					continue
+				}
+				if _, ok := classes[meta.Property]; !ok {
+					classes[meta.Property] = strings.TrimSpace(meta.Value)
+				}
+			}
+		}
+	}
+	if summed {
+		out = append(out, MetaNode{Property: propMediaDuration, Value: formatClockValue(total)})
+	}
+	for _, prop := range []string{propMediaActiveClass, propMediaPlayback, propMediaNarrator} {
+		if v, ok := classes[prop]; ok {
+			out = append(out, MetaNode{Property: prop, Value: v})
+		}
+	}
+	return out
+}
+
+// parseClockValue parses a SMIL clock value: full ("1:02:03.5"), partial
+// ("02:03.5") or timecount ("3.5s", "2min", "1.5h", "300ms"; bare numbers
+// are seconds).
+func parseClockValue(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty clock value")
+	}
+	if strings.Contains(s, ":") {
+		parts := strings.Split(s, ":")
+		if len(parts) > 3 {
+			return 0, fmt.Errorf("invalid clock value %q", s)
+		}
+		var total float64
+		for _, p := range parts {
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid clock value %q", s)
+			}
+			total = total*60 + v
+		}
+		return time.Duration(total * float64(time.Second)), nil
+	}
+
+	units := []struct {
+		suffix string
+		scale  time.Duration
+	}{
+		{"ms", time.Millisecond},
+		{"min", time.Minute},
+		{"h", time.Hour},
+		{"s", time.Second},
+	}
+	scale := time.Second
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			scale = u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid clock value %q", s)
+	}
+	return time.Duration(v * float64(scale)), nil
+}
+
+// formatClockValue renders d as a full clock value, e.g. "1:02:03.500".
+func formatClockValue(d time.Duration) string {
+	ms := d.Milliseconds()
+	h := ms / 3600000
+	m := ms / 60000 % 60
+	sec := ms / 1000 % 60
+	return fmt.Sprintf("%d:%02d:%02d.%03d", h, m, sec, ms%1000)
+}
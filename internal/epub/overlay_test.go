@@ -0,0 +1,142 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseClockValue(t *testing.T) {
+	cases := map[string]time.Duration{
+		"1:02:03.5": time.Hour + 2*time.Minute + 3500*time.Millisecond,
+		"02:03":     2*time.Minute + 3*time.Second,
+		"3.5s":      3500 * time.Millisecond,
+		"2min":      2 * time.Minute,
+		"1.5h":      90 * time.Minute,
+		"300ms":     300 * time.Millisecond,
+		"12":        12 * time.Second,
+	}
+	for in, want := range cases {
+		got, err := parseClockValue(in)
+		if err != nil {
+			t.Fatalf("parse %q: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("parse %q = %v want %v", in, got, want)
+		}
+	}
+	if _, err := parseClockValue("soon"); err == nil {
+		t.Fatalf("expected error for invalid clock value")
+	}
+	if got := formatClockValue(time.Hour + 2*time.Minute + 3500*time.Millisecond); got != "1:02:03.500" {
+		t.Fatalf("format = %q", got)
+	}
+}
+
+func TestMergeEPUBsMediaOverlays(t *testing.T) {
+	a := buildOverlayTestEPUB(t, "Vol 1", "0:01:00")
+	b := buildOverlayTestEPUB(t, "Vol 2", "0:00:30.5")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	items := map[string]ManifestItem{}
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		items[item.ID] = item
+	}
+	chap, ok := items["v0002_chap"]
+	if !ok {
+		t.Fatalf("missing v0002_chap in manifest")
+	}
+	if chap.MediaOverlay != "v0002_chap_overlay" {
+		t.Fatalf("media-overlay = %q", chap.MediaOverlay)
+	}
+	smil, ok := items[chap.MediaOverlay]
+	if !ok {
+		t.Fatalf("overlay item %q missing", chap.MediaOverlay)
+	}
+	if _, err := os.Stat(filepath.Join(vol.PackageDir, filepath.FromSlash(smil.Href))); err != nil {
+		t.Fatalf("smil file not copied: %v", err)
+	}
+
+	var total string
+	refined := map[string]string{}
+	activeClass := ""
+	for _, meta := range vol.PackageDoc.Metadata.Meta {
+		switch {
+		case meta.Property == propMediaDuration && meta.Refines == "":
+			total = meta.Value
+		case meta.Property == propMediaDuration:
+			refined[meta.Refines] = meta.Value
+		case meta.Property == propMediaActiveClass:
+			activeClass = meta.Value
+		}
+	}
+	if total != "0:01:30.500" {
+		t.Fatalf("total duration = %q", total)
+	}
+	if refined["#v0001_chap_overlay"] != "0:01:00" || refined["#v0002_chap_overlay"] != "0:00:30.5" {
+		t.Fatalf("per-item durations = %v", refined)
+	}
+	if activeClass != "-epub-media-overlay-active" {
+		t.Fatalf("active class = %q", activeClass)
+	}
+}
+
+func TestMergeEPUBsWithoutOverlaysAddsNoMediaMeta(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	for _, meta := range vol.PackageDoc.Metadata.Meta {
+		if meta.Property == propMediaDuration {
+			t.Fatalf("unexpected media:duration in non-overlay merge")
+		}
+	}
+}
+
+func buildOverlayTestEPUB(t *testing.T, title, duration string) string {
+	t.Helper()
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      title,
+			lang:       "en",
+			identifier: "urn:test:overlay",
+			metadata: []string{
+				`<meta property="media:duration" refines="#chap_overlay">` + duration + `</meta>`,
+				`<meta property="media:duration">` + duration + `</meta>`,
+				`<meta property="media:active-class">-epub-media-overlay-active</meta>`,
+			},
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				{ID: "chap", Href: "Text/chapter.xhtml", MediaType: "application/xhtml+xml", MediaOverlay: "chap_overlay"},
+				testItem("chap_overlay", "Overlays/chapter.smil"),
+				testItem("audio", "Audio/chapter.mp3"),
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/nav.xhtml":             `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="Text/chapter.xhtml">Chapter</a></li></ol></nav></body></html>`,
+		"OEBPS/Text/chapter.xhtml":    `<html xmlns="http://www.w3.org/1999/xhtml"><body><p id="p1">Chapter 1</p></body></html>`,
+		"OEBPS/Overlays/chapter.smil": `<smil xmlns="http://www.w3.org/ns/SMIL" version="3.0"><body><par id="par1"><text src="../Text/chapter.xhtml#p1"/><audio src="../Audio/chapter.mp3" clipBegin="0s" clipEnd="` + duration + `"/></par></body></smil>`,
+		"OEBPS/Audio/chapter.mp3":     "ID3",
+	})
+}
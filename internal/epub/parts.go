@@ -0,0 +1,131 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mergeParts packs whole volumes, in order, into parts of at most
+// opts.MaxSize bytes and merges each part on its own. A single part is
+// written to opts.OutPath as usual.
+func mergeParts(ctx context.Context, sources []string, opts MergeOptions) (MergeStats, error) {
+	var stats MergeStats
+	if opts.OutPath == "" {
+		return stats, fmt.Errorf("output path is required")
+	}
+	if opts.Interleave {
+		return stats, fmt.Errorf("interleave can't be combined with a maximum part size")
+	}
+
+	groups, warnings, err := packBySize(sources, opts.MaxSize)
+	if err != nil {
+		return stats, err
+	}
+	stats.Warnings = warnings
+	if len(groups) == 1 {
+		single := opts
+		single.MaxSize = 0
+		part, err := mergeSources(ctx, sources, single)
+		part.Warnings = append(warnings, part.Warnings...)
+		return part, err
+	}
+
+	if opts.Identifier != "" {
+		return stats, fmt.Errorf("identifier %q can't be shared by %d parts; leave it unset to give each part its own", opts.Identifier, len(groups))
+	}
+	if len(opts.Arcs) > 0 && len(opts.Arcs) != len(sources) {
+		return stats, fmt.Errorf("got %d arc names for %d input EPUB files", len(opts.Arcs), len(sources))
+	}
+
+	stats.OutPath = opts.OutPath
+	offset := 0
+	for i, group := range groups {
+		partOpts := opts
+		partOpts.MaxSize = 0
+		if len(opts.Arcs) > 0 {
+			partOpts.Arcs = opts.Arcs[offset : offset+len(group)]
+		}
+		offset += len(group)
+		partOpts.OutPath = partPath(opts.OutPath, i+1, len(groups))
+		if opts.Title != "" {
+			partOpts.Title = fmt.Sprintf("%s (Part %d)", opts.Title, i+1)
+		}
+		part, err := mergeSources(ctx, group, partOpts)
+		if err != nil {
+			return stats, fmt.Errorf("part %d: %w", i+1, err)
+		}
+		if !opts.DryRun && len(group) > 1 {
+			if info, err := os.Stat(part.OutPath); err == nil && info.Size() > opts.MaxSize {
+				part.Warnings = append(part.Warnings, fmt.Sprintf("%s is %d bytes, over the %d byte limit", part.OutPath, info.Size(), opts.MaxSize))
+			}
+		}
+
+		stats.Volumes += part.Volumes
+		stats.DedupedItems += part.DedupedItems
+		stats.DedupedBytes += part.DedupedBytes
+		stats.GuessedCovers = append(stats.GuessedCovers, part.GuessedCovers...)
+		stats.FlattenedImages += part.FlattenedImages
+		stats.FlattenedBytes += part.FlattenedBytes
+		stats.StagedBytes += part.StagedBytes
+		stats.EstimatedBytes += part.EstimatedBytes
+		stats.Verification = append(stats.Verification, part.Verification...)
+		stats.Generated = append(stats.Generated, part.Generated...)
+		stats.Validation = append(stats.Validation, part.Validation...)
+		stats.MediaTypeFixes = append(stats.MediaTypeFixes, part.MediaTypeFixes...)
+		addRewriteStats(&stats.Rewrite, part.Rewrite, "")
+		stats.KoboDocuments += part.KoboDocuments
+		stats.HeadingsRetagged += part.HeadingsRetagged
+		stats.NavDuplicatesRemoved += part.NavDuplicatesRemoved
+		stats.Illustrations += part.Illustrations
+		stats.LanguageTagged += part.LanguageTagged
+		stats.ProgressAnchors += part.ProgressAnchors
+		stats.ProgressAnchorsAdded += part.ProgressAnchorsAdded
+		stats.Warnings = append(stats.Warnings, part.Warnings...)
+		stats.Parts = append(stats.Parts, part)
+	}
+	return stats, nil
+}
+
+// packBySize groups sources greedily so each group's total file size stays
+// within maxSize. Sources larger than maxSize form a group of their own.
+func packBySize(sources []string, maxSize int64) ([][]string, []string, error) {
+	var (
+		groups   [][]string
+		warnings []string
+		cur      []string
+		curSize  int64
+	)
+	for _, src := range sources {
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, nil, err
+		}
+		size := info.Size()
+		if size > maxSize {
+			warnings = append(warnings, fmt.Sprintf("%s is %d bytes, over the %d byte limit; it gets a part of its own", src, size, maxSize))
+		}
+		if len(cur) > 0 && curSize+size > maxSize {
+			groups = append(groups, cur)
+			cur, curSize = nil, 0
+		}
+		cur = append(cur, src)
+		curSize += size
+	}
+	if len(cur) > 0 {
+		groups = append(groups, cur)
+	}
+	return groups, warnings, nil
+}
+
+// partPath names part n of total after out: merged.epub -> merged.part01.epub.
+func partPath(out string, n, total int) string {
+	ext := filepath.Ext(out)
+	if strings.HasSuffix(strings.ToLower(out), kepubExt) {
+		ext = out[len(out)-len(kepubExt):]
+	}
+	width := max(2, len(fmt.Sprint(total)))
+	return fmt.Sprintf("%s.part%0*d%s", strings.TrimSuffix(out, ext), width, n, ext)
+}
@@ -0,0 +1,147 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPartPath(t *testing.T) {
+	cases := []struct {
+		out      string
+		n, total int
+		want     string
+	}{
+		{"merged.epub", 1, 3, "merged.part01.epub"},
+		{"out/saga.epub", 12, 12, "out/saga.part12.epub"},
+		{"saga.epub", 7, 120, "saga.part007.epub"},
+	}
+	for _, tc := range cases {
+		if got := partPath(tc.out, tc.n, tc.total); got != tc.want {
+			t.Errorf("partPath(%q, %d, %d) = %q want %q", tc.out, tc.n, tc.total, got, tc.want)
+		}
+	}
+}
+
+func TestPackBySize(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string, size int) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, make([]byte, size), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return p
+	}
+	sources := []string{file("a", 40), file("b", 50), file("c", 30), file("big", 200), file("d", 10)}
+
+	groups, warnings, err := packBySize(sources, 100)
+	if err != nil {
+		t.Fatalf("packBySize: %v", err)
+	}
+	var got []string
+	for _, g := range groups {
+		var names []string
+		for _, p := range g {
+			names = append(names, filepath.Base(p))
+		}
+		got = append(got, strings.Join(names, "+"))
+	}
+	if want := "a+b c big d"; strings.Join(got, " ") != want {
+		t.Fatalf("groups = %v want %s", got, want)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "big") {
+		t.Fatalf("warnings = %v", warnings)
+	}
+}
+
+func TestMergeEPUBsMaxSize(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	c := buildTestEPUB(t, "Vol 3", "en")
+	info, err := os.Stat(a)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "saga.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b, c}, MergeOptions{
+		OutPath: out,
+		Title:   "Saga",
+		MaxSize: 2*info.Size() + 10,
+	})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if len(stats.Parts) != 2 || stats.Volumes != 3 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("unsplit output written alongside parts")
+	}
+
+	for i, want := range []struct {
+		path    string
+		title   string
+		volumes int
+	}{
+		{strings.TrimSuffix(out, ".epub") + ".part01.epub", "Saga (Part 1)", 2},
+		{strings.TrimSuffix(out, ".epub") + ".part02.epub", "Saga (Part 2)", 1},
+	} {
+		part := stats.Parts[i]
+		if part.OutPath != want.path || part.Volumes != want.volumes {
+			t.Fatalf("part %d = %+v", i+1, part)
+		}
+		vol, err := loadVolume(context.Background(), 0, part.OutPath)
+		if err != nil {
+			t.Fatalf("reopen part %d: %v", i+1, err)
+		}
+		defer os.RemoveAll(vol.TempDir)
+		if got := vol.PackageDoc.Metadata.Titles[0].Value; got != want.title {
+			t.Fatalf("part %d title = %q", i+1, got)
+		}
+		if len(vol.NavItems) != want.volumes {
+			t.Fatalf("part %d nav = %+v", i+1, vol.NavItems)
+		}
+	}
+}
+
+func TestMergeEPUBsMaxSizeSinglePart(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "saga.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, MaxSize: 1 << 30})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if len(stats.Parts) != 0 || stats.OutPath != out {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Fatalf("output missing: %v", err)
+	}
+}
+
+func TestMergeEPUBsMaxSizeRejectsIdentifier(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	info, err := os.Stat(a)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "saga.epub")
+
+	_, err = MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{
+		OutPath:    out,
+		Identifier: "urn:uuid:0b7c2f4e-2d1a-4c3b-9f8e-1a2b3c4d5e6f",
+		MaxSize:    info.Size() + 10,
+	})
+	if err == nil || !strings.Contains(err.Error(), "identifier") {
+		t.Fatalf("err = %v, want identifier error", err)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(out, ".epub") + ".part01.epub"); !os.IsNotExist(err) {
+		t.Fatalf("part written despite the error")
+	}
+}
@@ -0,0 +1,59 @@
+package epub
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	PhaseLoading = "loading volumes"
+	PhaseStaging = "staging volumes"
+	PhaseWriting = "writing package"
+	PhaseZipping = "writing output"
+	PhaseDone    = "done"
+)
+
+// MergeProgress is updated by MergeEPUBs as it works, so a caller can tell
+// how far an interrupted merge got. It is safe for concurrent use; the zero
+// value is ready, and a nil *MergeProgress is ignored.
+type MergeProgress struct {
+	mu    sync.Mutex
+	state MergeProgressState
+}
+
+type MergeProgressState struct {
+	Phase  string
+	Total  int
+	Loaded int
+	Staged int
+}
+
+// State returns a snapshot of the progress so far.
+func (p *MergeProgress) State() MergeProgressState {
+	if p == nil {
+		return MergeProgressState{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+func (s MergeProgressState) String() string {
+	if s.Phase == "" {
+		return "not started"
+	}
+	return fmt.Sprintf("%s; %d/%d volumes loaded, %d/%d staged", s.Phase, s.Loaded, s.Total, s.Staged, s.Total)
+}
+
+func (p *MergeProgress) update(fn func(s *MergeProgressState)) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	fn(&p.state)
+	p.mu.Unlock()
+}
+
+func (p *MergeProgress) setPhase(phase string) {
+	p.update(func(s *MergeProgressState) { s.Phase = phase })
+}
@@ -0,0 +1,39 @@
+package epub
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeProgress(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+
+	progress := &MergeProgress{}
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Progress: progress}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if got := progress.State(); got != (MergeProgressState{Phase: PhaseDone, Total: 2, Loaded: 2, Staged: 2}) {
+		t.Fatalf("state = %+v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	progress = &MergeProgress{}
+	_, err := MergeEPUBs(ctx, []string{a, b}, MergeOptions{OutPath: out, Progress: progress})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v", err)
+	}
+	if got := progress.State().String(); !strings.HasPrefix(got, PhaseLoading) || !strings.Contains(got, "0/2 volumes loaded") {
+		t.Fatalf("report = %q", got)
+	}
+
+	var none *MergeProgress
+	if none.State().String() != "not started" {
+		t.Fatalf("nil progress should report not started")
+	}
+}
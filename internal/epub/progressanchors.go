@@ -0,0 +1,164 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// progressAnchorPrefix starts the ids addProgressAnchors gives paragraphs.
+const progressAnchorPrefix = "novfmt-progress-"
+
+// volumeProgressAnchors anchors every Nth paragraph of a volume's linear
+// spine documents and returns the anchor ids of each, keyed by its href,
+// along with how many ids it added.
+func volumeProgressAnchors(vol *Volume, every int) (map[string][]string, int, error) {
+	items := make(map[string]ManifestItem, len(vol.PackageDoc.Manifest.Items))
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		items[item.ID] = item
+	}
+	anchors := make(map[string][]string)
+	added := 0
+	for _, ref := range vol.PackageDoc.Spine.Itemrefs {
+		item, ok := items[vol.canonicalID(ref.IDRef)]
+		if !ok || item.MediaType != "application/xhtml+xml" || hasProperty(item.Properties, "nav") || strings.EqualFold(ref.Linear, "no") {
+			continue
+		}
+		p := filepath.Join(vol.PackageDir, filepath.FromSlash(item.Href))
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, added, err
+		}
+		out, ids, n, err := addProgressAnchors(data, every)
+		if err != nil {
+			return nil, added, fmt.Errorf("%s: %w", item.Href, err)
+		}
+		if n > 0 {
+			if err := os.WriteFile(p, out, 0o644); err != nil {
+				return nil, added, err
+			}
+			added += n
+		}
+		if len(ids) > 0 {
+			anchors[item.Href] = ids
+		}
+	}
+	return anchors, added, nil
+}
+
+// addProgressAnchors finds the 1st, every+1th, 2*every+1th, ... <p> of an
+// XHTML document and returns their ids, giving those without one an id of
+// progressAnchorPrefix and a number, and copying the rest byte for byte.
+// Paragraphs that already have an id keep it, so a second pass with the same
+// every adds nothing. It also returns how many ids it added.
+func addProgressAnchors(data []byte, every int) ([]byte, []string, int, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	taken := make(map[string]bool)
+	type insertion struct {
+		at int
+		id string
+	}
+	var (
+		inserts []insertion
+		ids     []string
+		paras   int
+	)
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		id := ""
+		for _, attr := range el.Attr {
+			if attr.Name.Local == "id" {
+				id = attr.Value
+				taken[id] = true
+			}
+		}
+		if !strings.EqualFold(el.Name.Local, "p") {
+			continue
+		}
+		paras++
+		if (paras-1)%every != 0 {
+			continue
+		}
+		if id != "" {
+			ids = append(ids, id)
+			continue
+		}
+		end := int(dec.InputOffset()) - 1
+		if end > int(start) && data[end-1] == '/' {
+			end--
+		}
+		inserts = append(inserts, insertion{at: end})
+		ids = append(ids, "")
+	}
+
+	// Number the new ids once every existing id is known, so none collide.
+	n, next := 0, 1
+	for i, id := range ids {
+		if id != "" {
+			continue
+		}
+		for taken[progressAnchorPrefix+strconv.Itoa(next)] {
+			next++
+		}
+		id = progressAnchorPrefix + strconv.Itoa(next)
+		taken[id] = true
+		ids[i] = id
+		inserts[n].id = id
+		n++
+	}
+	if len(inserts) == 0 {
+		return data, ids, 0, nil
+	}
+
+	var out bytes.Buffer
+	last := 0
+	for _, ins := range inserts {
+		out.Write(data[last:ins.at])
+		out.WriteString(` id="` + ins.id + `"`)
+		last = ins.at
+	}
+	out.Write(data[last:])
+	return out.Bytes(), ids, len(inserts), nil
+}
+
+// progressPageList numbers the anchors of the merged book (keyed by document
+// href) in spine order, as the entries of its nav's hidden page list.
+func progressPageList(refs []SpineItemRef, idHref map[string]string, anchors map[string][]string) []NavItem {
+	var out []NavItem
+	for _, ref := range refs {
+		href := idHref[ref.IDRef]
+		for _, id := range anchors[href] {
+			out = append(out, NavItem{Title: strconv.Itoa(len(out) + 1), Href: href + "#" + id})
+		}
+		// A document listed twice in the spine is only numbered once.
+		delete(anchors, href)
+	}
+	return out
+}
+
+// writePageList writes the generated nav's hidden page list.
+func writePageList(buf *bytes.Buffer, items []NavItem) {
+	buf.WriteString(`<nav epub:type="page-list" id="progress" hidden="hidden">` + "\n<ol>\n")
+	for _, item := range items {
+		writeNavItem(buf, item)
+	}
+	buf.WriteString("</ol>\n</nav>\n")
+}
@@ -0,0 +1,76 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddProgressAnchors(t *testing.T) {
+	doc := `<html xmlns="http://www.w3.org/1999/xhtml"><body>` +
+		`<p>1</p><p>2</p><p id="keep">3</p><p>4</p><p/><div id="novfmt-progress-1"/></body></html>`
+	out, ids, added, err := addProgressAnchors([]byte(doc), 2)
+	if err != nil {
+		t.Fatalf("addProgressAnchors: %v", err)
+	}
+	if strings.Join(ids, ",") != "novfmt-progress-2,keep,novfmt-progress-3" || added != 2 {
+		t.Fatalf("ids = %q, added = %d", ids, added)
+	}
+	want := `<html xmlns="http://www.w3.org/1999/xhtml"><body>` +
+		`<p id="novfmt-progress-2">1</p><p>2</p><p id="keep">3</p><p>4</p><p id="novfmt-progress-3"/><div id="novfmt-progress-1"/></body></html>`
+	if string(out) != want {
+		t.Fatalf("out = %s", out)
+	}
+
+	again, ids2, added, err := addProgressAnchors(out, 2)
+	if err != nil || added != 0 || string(again) != string(out) || strings.Join(ids2, ",") != strings.Join(ids, ",") {
+		t.Fatalf("second pass = %s, %q, %d, %v", again, ids2, added, err)
+	}
+}
+
+func TestMergeEPUBsProgressAnchors(t *testing.T) {
+	a := buildChaptersEPUB(t, "Vol 1", "One", "Two")
+	b := buildChaptersEPUB(t, "Vol 2", "Three")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, ProgressAnchors: 1})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if stats.ProgressAnchors != 3 || stats.ProgressAnchorsAdded != 3 {
+		t.Fatalf("anchors = %d, added = %d", stats.ProgressAnchors, stats.ProgressAnchorsAdded)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	nav, err := vol.readFile(vol.NavHref)
+	if err != nil {
+		t.Fatalf("read nav: %v", err)
+	}
+	if !strings.Contains(string(nav), `<nav epub:type="page-list" id="progress" hidden="hidden">`) {
+		t.Fatalf("nav has no hidden page list:\n%s", nav)
+	}
+	pages, err := scanNav(nav, "page-list", false)
+	if err != nil {
+		t.Fatalf("scanNav: %v", err)
+	}
+	var got []string
+	for _, p := range pages {
+		got = append(got, p.Title+"="+p.Href)
+	}
+	want := "1=Volumes/v0001/c1.xhtml#novfmt-progress-1,2=Volumes/v0001/c2.xhtml#novfmt-progress-1,3=Volumes/v0002/c1.xhtml#novfmt-progress-1"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("page list = %q", got)
+	}
+	doc, err := vol.readFile("Volumes/v0002/c1.xhtml")
+	if err != nil {
+		t.Fatalf("read chapter: %v", err)
+	}
+	if !strings.Contains(string(doc), `<p id="novfmt-progress-1">Three</p>`) {
+		t.Fatalf("chapter = %s", doc)
+	}
+}
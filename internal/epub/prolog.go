@@ -0,0 +1,63 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+)
+
+// splitProlog splits an XML document before its root element. The prolog
+// holds any byte order mark, the XML declaration, processing instructions
+// such as xml-stylesheet, comments and the doctype. Re-encoding code copies
+// it through verbatim, since xml.Encoder rejects an XML declaration that
+// isn't the very first token. A document with no root element is all prolog.
+func splitProlog(data []byte) (prolog, rest []byte) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.CharsetReader = passthroughCharsetReader
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.RawToken()
+		if err != nil {
+			return data, nil
+		}
+		if _, ok := tok.(xml.StartElement); ok {
+			return data[:start], data[start:]
+		}
+	}
+}
+
+var entityDeclPattern = regexp.MustCompile(`<!ENTITY\s+([^\s%"'>]+)\s+(?:"([^"]*)"|'([^']*)')\s*>`)
+
+// prologEntities returns the general entities declared in the doctype's
+// internal subset, e.g. <!ENTITY nbsp "&#160;">, for xml.Decoder.Entity.
+// Without them the decoder passes &nbsp; through as text and the encoder
+// escapes it to &amp;nbsp;.
+func prologEntities(prolog []byte) map[string]string {
+	var entities map[string]string
+	for _, m := range entityDeclPattern.FindAllSubmatch(prolog, -1) {
+		value := m[2]
+		if value == nil {
+			value = m[3]
+		}
+		text, err := unescapeXMLText(value)
+		if err != nil {
+			continue
+		}
+		if entities == nil {
+			entities = map[string]string{}
+		}
+		entities[string(m[1])] = text
+	}
+	return entities
+}
+
+// unescapeXMLText resolves the character references and predefined entities
+// in s.
+func unescapeXMLText(s []byte) (string, error) {
+	var v struct {
+		Text string `xml:",chardata"`
+	}
+	err := xml.Unmarshal(append(append([]byte("<v>"), s...), "</v>"...), &v)
+	return v.Text, err
+}
@@ -0,0 +1,19 @@
+package epub
+
+import "testing"
+
+func TestSplitProlog(t *testing.T) {
+	doc := "\ufeff<?xml version=\"1.0\"?>\n<!-- note -->\n<!DOCTYPE html [<!ENTITY nbsp \"&#160;\"> <!ENTITY me 'A &amp; B'>]>\n<html><body/></html>"
+	prolog, rest := splitProlog([]byte(doc))
+	if string(rest) != "<html><body/></html>" || string(prolog)+string(rest) != doc {
+		t.Fatalf("prolog %q, rest %q", prolog, rest)
+	}
+	entities := prologEntities(prolog)
+	if len(entities) != 2 || entities["nbsp"] != "\u00a0" || entities["me"] != "A & B" {
+		t.Fatalf("entities = %q", entities)
+	}
+
+	if prolog, rest := splitProlog([]byte("<?xml version=\"1.0\"?>")); string(prolog) != "<?xml version=\"1.0\"?>" || rest != nil {
+		t.Fatalf("no root: prolog %q, rest %q", prolog, rest)
+	}
+}
@@ -0,0 +1,80 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SpineOrigin traces one spine item of a merged book back to its source
+// volume. Volume is 1-based and 0 for pages the merge generated itself.
+type SpineOrigin struct {
+	IDRef    string `json:"idref"`
+	Href     string `json:"href"`
+	Volume   int    `json:"volume"`
+	SourceID string `json:"source_id,omitempty"`
+}
+
+// SpineProvenance reports where each spine item of a book merged by novfmt
+// came from, decoding the Volumes/vNNNN href prefix and the vNNNN_ id prefix
+// the merge gives every volume's files. Books merged with a custom
+// MergeOptions.VolumeDirTemplate are traced by the id prefix alone.
+func SpineProvenance(ctx context.Context, input string) ([]SpineOrigin, error) {
+	if input == "" {
+		return nil, fmt.Errorf("input EPUB path is required")
+	}
+
+	book, err := OpenBook(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	hrefs := make(map[string]string)
+	for _, item := range book.Package.Manifest.Items {
+		hrefs[item.ID] = item.Href
+	}
+
+	out := make([]SpineOrigin, 0, len(book.Package.Spine.Itemrefs))
+	for _, ref := range book.Package.Spine.Itemrefs {
+		origin := SpineOrigin{IDRef: ref.IDRef, Href: hrefs[ref.IDRef]}
+		origin.Volume = volumeFromHref(origin.Href)
+		if origin.Volume == 0 && strings.HasPrefix(normalizeEPUBPath(origin.Href), "Volumes/") {
+			origin.Volume = volumeFromID(ref.IDRef)
+		}
+		if origin.Volume > 0 {
+			if prefix := fmt.Sprintf("v%04d_", origin.Volume); strings.HasPrefix(ref.IDRef, prefix) {
+				origin.SourceID = strings.TrimPrefix(ref.IDRef, prefix)
+			}
+		}
+		out = append(out, origin)
+	}
+	return out, nil
+}
+
+// volumeFromHref returns the volume number of a Volumes/vNNNN/... href, or 0.
+func volumeFromHref(href string) int {
+	parts := strings.SplitN(normalizeEPUBPath(href), "/", 3)
+	if len(parts) < 3 || parts[0] != "Volumes" || len(parts[1]) < 2 || parts[1][0] != 'v' {
+		return 0
+	}
+	n, err := strconv.Atoi(parts[1][1:])
+	if err != nil || n < 1 {
+		return 0
+	}
+	return n
+}
+
+// volumeFromID returns the volume number of a vNNNN_ merged id, or 0.
+func volumeFromID(id string) int {
+	prefix, _, ok := strings.Cut(id, "_")
+	if !ok || len(prefix) != 5 || prefix[0] != 'v' {
+		return 0
+	}
+	n, err := strconv.Atoi(prefix[1:])
+	if err != nil || n < 1 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,49 @@
+package epub
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumeFromHref(t *testing.T) {
+	cases := map[string]int{
+		"Volumes/v0003/Text/ch1.xhtml": 3,
+		"Volumes/v0012/c.xhtml":        12,
+		"index.xhtml":                  0,
+		"Volumes/extra/c.xhtml":        0,
+		"Volumes/v0001":                0,
+	}
+	for href, want := range cases {
+		if got := volumeFromHref(href); got != want {
+			t.Errorf("volumeFromHref(%q) = %d want %d", href, got, want)
+		}
+	}
+}
+
+func TestSpineProvenance(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, IndexPage: true}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	got, err := SpineProvenance(context.Background(), out)
+	if err != nil {
+		t.Fatalf("SpineProvenance: %v", err)
+	}
+	want := []SpineOrigin{
+		{IDRef: indexPageID, Href: indexPageHref},
+		{IDRef: "v0001_chap", Href: "Volumes/v0001/chapter.xhtml", Volume: 1, SourceID: "chap"},
+		{IDRef: "v0002_chap", Href: "Volumes/v0002/chapter.xhtml", Volume: 2, SourceID: "chap"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("item %d = %+v want %+v", i, got[i], want[i])
+		}
+	}
+}
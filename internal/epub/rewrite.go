@@ -5,12 +5,16 @@ import (
 	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type RewriteScope int
@@ -19,14 +23,25 @@ const (
 	RewriteScopeBody RewriteScope = iota
 	RewriteScopeMeta
 	RewriteScopeAll
+	// RewriteScopeCover limits body rewrites to the cover and title page
+	// documents (see RewriteStats.ScopeFiles).
+	RewriteScopeCover
 )
 
 type RewriteRule struct {
-	Find       string   `json:"find"`
-	Replace    string   `json:"replace"`
-	Regex      bool     `json:"regex,omitempty"`
-	IgnoreCase bool     `json:"ignore_case,omitempty"`
-	Selectors  []string `json:"selectors,omitempty"`
+	Find       string `json:"find"`
+	Replace    string `json:"replace"`
+	Regex      bool   `json:"regex,omitempty"`
+	IgnoreCase bool   `json:"ignore_case,omitempty"`
+	// WholeWord skips matches that start or end inside a word, so "Rin"
+	// doesn't touch "Ring". Letters, digits and marks of any script count
+	// as word characters.
+	WholeWord bool     `json:"whole_word,omitempty"`
+	Selectors []string `json:"selectors,omitempty"`
+	// Scope narrows where the rule applies within the rewrite's scope:
+	// "body" or "meta" (see RewriteScope), or "" and "all" for everywhere
+	// the rewrite reaches.
+	Scope string `json:"scope,omitempty"`
 }
 
 type RewriteOptions struct {
@@ -34,11 +49,42 @@ type RewriteOptions struct {
 	Scope   RewriteScope
 	Rules   []RewriteRule
 	DryRun  bool
+	// TrimWhitespace also tidies the whitespace of the body documents' text
+	// (see trimWhitespaceDocument); RewriteStats.WhitespaceBytes counts what
+	// it removed.
+	TrimWhitespace bool
+	// StripComments removes the comments of the body documents (see
+	// stripCommentsDocument); RewriteStats.CommentsRemoved counts them.
+	StripComments bool
+	// Threads caps how many documents are rewritten concurrently
+	// (GOMAXPROCS when <= 0).
+	Threads int
 }
 
 type RewriteStats struct {
 	FilesChanged int
 	MatchCount   int
+	// ChangedFiles lists the manifest hrefs of the XHTML documents that had
+	// matches or trimmed whitespace, in manifest order. Metadata edits count
+	// toward FilesChanged but aren't listed here.
+	ChangedFiles []string
+	// WhitespaceBytes is how much TrimWhitespace shrank the documents by.
+	WhitespaceBytes int64
+	// CommentsRemoved is how many comments StripComments removed.
+	CommentsRemoved int
+	// ScopeFiles lists the documents RewriteScopeCover resolved to.
+	ScopeFiles []string
+	// FileMatches gives the rule matches in each XHTML document that had
+	// any, in manifest order, dry runs included; MetadataMatches counts the
+	// rest of MatchCount.
+	FileMatches     []FileMatches
+	MetadataMatches int
+}
+
+// FileMatches is how many rule matches one document had.
+type FileMatches struct {
+	Href    string
+	Matches int
 }
 
 type compiledSelector struct {
@@ -47,9 +93,15 @@ type compiledSelector struct {
 }
 
 type compiledRule struct {
-	raw       RewriteRule
+	raw RewriteRule
+	// re matches Find; it is nil for plain literal rules, which use
+	// strings.ReplaceAll. literal is set when re was built from a literal
+	// Find, whose Replace mustn't be expanded.
 	re        *regexp.Regexp
+	literal   bool
 	selectors []compiledSelector
+	// noBody and noMeta come from the rule's Scope.
+	noBody, noMeta bool
 }
 
 type ruleState struct {
@@ -62,7 +114,7 @@ func RewriteEPUB(ctx context.Context, input string, opts RewriteOptions) (Rewrit
 	if input == "" {
 		return stats, fmt.Errorf("input EPUB path is required")
 	}
-	if len(opts.Rules) == 0 {
+	if len(opts.Rules) == 0 && !opts.TrimWhitespace && !opts.StripComments {
 		return stats, fmt.Errorf("no rewrite rules provided")
 	}
 
@@ -77,6 +129,35 @@ func RewriteEPUB(ctx context.Context, input string, opts RewriteOptions) (Rewrit
 	}
 	defer os.RemoveAll(vol.TempDir)
 
+	stats, err = rewriteVolume(ctx, vol, compiled, opts, false)
+	if err != nil {
+		return stats, err
+	}
+
+	if opts.DryRun {
+		return stats, nil
+	}
+
+	if stats.FilesChanged == 0 {
+		return stats, nil
+	}
+
+	if err := writePackage(vol.PackageDoc, vol.PackagePath); err != nil {
+		return stats, err
+	}
+
+	if err := replaceArchive(vol.RootDir, input, opts.OutPath); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// rewriteVolume applies compiled rules to an extracted volume in the scope
+// opts.Scope, editing its documents in place (and vol.PackageDoc's metadata
+// in memory) unless opts.DryRun is set. The package document isn't written.
+// skipNav leaves the nav document alone, for callers that don't keep it.
+func rewriteVolume(ctx context.Context, vol *Volume, compiled []compiledRule, opts RewriteOptions, skipNav bool) (RewriteStats, error) {
+	var stats RewriteStats
 	pkg := vol.PackageDoc
 
 	// Rewrite metadata if requested.
@@ -84,126 +165,205 @@ func RewriteEPUB(ctx context.Context, input string, opts RewriteOptions) (Rewrit
 		metaRules := metadataApplicableRules(compiled)
 		matches, changed := rewriteMetadata(&pkg.Metadata, metaRules, !opts.DryRun)
 		stats.MatchCount += matches
+		stats.MetadataMatches = matches
 		if changed {
 			stats.FilesChanged++
 		}
 	}
 
 	// Rewrite XHTML content if requested.
-	if opts.Scope == RewriteScopeBody || opts.Scope == RewriteScopeAll {
+	if opts.Scope == RewriteScopeBody || opts.Scope == RewriteScopeAll || opts.Scope == RewriteScopeCover {
+		var only map[string]bool
+		if opts.Scope == RewriteScopeCover {
+			hrefs, err := coverDocuments(vol)
+			if err != nil {
+				return stats, err
+			}
+			if len(hrefs) == 0 {
+				return stats, fmt.Errorf("%s: no cover or title page document found", vol.SourcePath)
+			}
+			stats.ScopeFiles = hrefs
+			only = make(map[string]bool, len(hrefs))
+			for _, href := range hrefs {
+				only[href] = true
+			}
+		}
+		bodyRules := bodyApplicableRules(compiled)
+		var docs []string
 		for _, item := range pkg.Manifest.Items {
 			if item.MediaType != "application/xhtml+xml" {
 				continue
 			}
-			src := filepath.Join(filepath.Dir(vol.PackagePath), filepath.FromSlash(item.Href))
-			fileMatches, changed, rewritten, err := rewriteXHTMLFile(src, compiled)
-			if err != nil {
-				return stats, err
+			if only != nil && !only[item.Href] {
+				continue
 			}
-			stats.MatchCount += fileMatches
-			if changed {
-				stats.FilesChanged++
-				if !opts.DryRun {
-					if err := os.WriteFile(src, rewritten, 0o644); err != nil {
-						return stats, err
-					}
-				}
+			if skipNav && hasProperty(item.Properties, "nav") {
+				continue
 			}
+			docs = append(docs, item.Href)
 		}
-	}
-
-	if opts.DryRun {
-		return stats, nil
-	}
 
-	if stats.FilesChanged == 0 {
-		return stats, nil
-	}
-
-	if err := writePackage(pkg, vol.PackagePath); err != nil {
-		return stats, err
-	}
-
-	outPath := opts.OutPath
-	if outPath == "" {
-		outPath = input
-	}
-
-	tmpFile, err := os.CreateTemp(filepath.Dir(outPath), "novfmt-rewrite-*.epub")
-	if err != nil {
-		return stats, err
-	}
-	tmpPath := tmpFile.Name()
-	tmpFile.Close()
-	defer func() {
-		if tmpPath != "" {
-			os.Remove(tmpPath)
+		type fileResult struct {
+			matches  int
+			trimmed  int
+			comments int
+			changed  bool
+		}
+		results := make([]fileResult, len(docs))
+		err := parallelFor(ctx, len(docs), opts.Threads, func(i int) error {
+			src := filepath.Join(filepath.Dir(vol.PackagePath), filepath.FromSlash(docs[i]))
+			var res fileResult
+			var rewritten []byte
+			if len(bodyRules) > 0 {
+				fileMatches, changed, out, err := rewriteXHTMLFile(src, bodyRules)
+				if err != nil {
+					return err
+				}
+				res = fileResult{matches: fileMatches, changed: changed}
+				rewritten = out
+			}
+			if (opts.TrimWhitespace || opts.StripComments) && rewritten == nil {
+				data, err := os.ReadFile(src)
+				if err != nil {
+					return err
+				}
+				rewritten = data
+			}
+			if opts.StripComments {
+				out, removed, err := stripCommentsDocument(rewritten)
+				if err != nil {
+					return fmt.Errorf("%s: %w", docs[i], err)
+				}
+				if removed > 0 {
+					rewritten, res.comments, res.changed = out, removed, true
+				}
+			}
+			if opts.TrimWhitespace {
+				out, trimmed, err := trimWhitespaceDocument(rewritten)
+				if err != nil {
+					return fmt.Errorf("%s: %w", docs[i], err)
+				}
+				if trimmed > 0 {
+					rewritten, res.trimmed, res.changed = out, trimmed, true
+				}
+			}
+			results[i] = res
+			if res.changed && !opts.DryRun {
+				return os.WriteFile(src, rewritten, 0o644)
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+		for i, res := range results {
+			stats.MatchCount += res.matches
+			if res.matches > 0 {
+				stats.FileMatches = append(stats.FileMatches, FileMatches{Href: docs[i], Matches: res.matches})
+			}
+			stats.WhitespaceBytes += int64(res.trimmed)
+			stats.CommentsRemoved += res.comments
+			if res.changed {
+				stats.FilesChanged++
+				stats.ChangedFiles = append(stats.ChangedFiles, docs[i])
+			}
 		}
-	}()
-
-	if err := writeZip(vol.RootDir, tmpPath); err != nil {
-		return stats, err
-	}
-	if err := os.Rename(tmpPath, outPath); err != nil {
-		return stats, err
 	}
-	tmpPath = ""
 
 	return stats, nil
 }
 
+// compileRules prepares rules for matching (see compileRule). Errors name
+// the rule by its 1-based position in rules.
 func compileRules(rules []RewriteRule) ([]compiledRule, error) {
 	out := make([]compiledRule, 0, len(rules))
-	for _, r := range rules {
-		if r.Find == "" {
-			return nil, fmt.Errorf("rule missing find pattern")
+	for n, r := range rules {
+		cr, err := compileRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", n+1, err)
 		}
-		cr := compiledRule{raw: r}
+		out = append(out, cr)
+	}
+	return out, nil
+}
 
-		if r.Regex {
-			pat := r.Find
-			if r.IgnoreCase && !strings.HasPrefix(pat, "(?i)") {
-				pat = "(?i)" + pat
-			}
-			re, err := regexp.Compile(pat)
-			if err != nil {
-				return nil, fmt.Errorf("compile regex %q: %w", pat, err)
-			}
-			cr.re = re
+// compileRule prepares r for matching. Regex rules compile Find with the
+// regexp package, so Replace may refer to capture groups as $1 or ${name};
+// the others match Find literally, through a quoted regex when IgnoreCase
+// or WholeWord asks for more than strings.ReplaceAll does. IgnoreCase adds
+// (?i) to either kind; WholeWord is checked per match (see wholeWordMatch)
+// rather than with \b, which only knows ASCII words.
+func compileRule(r RewriteRule) (compiledRule, error) {
+	if r.Find == "" {
+		return compiledRule{}, fmt.Errorf("missing find pattern")
+	}
+	cr := compiledRule{raw: r}
+	switch strings.ToLower(strings.TrimSpace(r.Scope)) {
+	case "", "all":
+	case "body":
+		cr.noMeta = true
+	case "meta":
+		cr.noBody = true
+	default:
+		return compiledRule{}, fmt.Errorf("invalid scope %q (want body, meta, all)", r.Scope)
+	}
+
+	if r.Regex || r.IgnoreCase || r.WholeWord {
+		pat := r.Find
+		if !r.Regex {
+			pat = regexp.QuoteMeta(pat)
+			cr.literal = true
+		}
+		if r.IgnoreCase && !strings.HasPrefix(pat, "(?i)") {
+			pat = "(?i)" + pat
+		}
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return compiledRule{}, fmt.Errorf("compile regex %q: %w", pat, err)
 		}
+		cr.re = re
+	}
 
-		for _, sel := range r.Selectors {
-			sel = strings.TrimSpace(sel)
-			if sel == "" {
+	for _, sel := range r.Selectors {
+		sel = strings.TrimSpace(sel)
+		if sel == "" {
+			continue
+		}
+		for _, part := range strings.Split(sel, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
 				continue
 			}
-			for _, part := range strings.Split(sel, ",") {
-				part = strings.TrimSpace(part)
-				if part == "" {
-					continue
-				}
-				outSel := compiledSelector{}
-				token := part
-				if strings.Contains(token, ".") {
-					parts := strings.SplitN(token, ".", 2)
-					outSel.Tag = strings.ToLower(strings.TrimSpace(parts[0]))
-					outSel.Class = strings.TrimSpace(parts[1])
-				} else {
-					outSel.Tag = strings.ToLower(token)
-				}
-				cr.selectors = append(cr.selectors, outSel)
+			outSel := compiledSelector{}
+			token := part
+			if strings.Contains(token, ".") {
+				parts := strings.SplitN(token, ".", 2)
+				outSel.Tag = strings.ToLower(strings.TrimSpace(parts[0]))
+				outSel.Class = strings.TrimSpace(parts[1])
+			} else {
+				outSel.Tag = strings.ToLower(token)
 			}
+			cr.selectors = append(cr.selectors, outSel)
 		}
-
-		out = append(out, cr)
 	}
-	return out, nil
+	return cr, nil
 }
 
 func metadataApplicableRules(rules []compiledRule) []compiledRule {
 	out := make([]compiledRule, 0, len(rules))
 	for _, r := range rules {
-		if len(r.selectors) == 0 {
+		if len(r.selectors) == 0 && !r.noMeta {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// bodyApplicableRules drops the rules scoped to metadata.
+func bodyApplicableRules(rules []compiledRule) []compiledRule {
+	out := make([]compiledRule, 0, len(rules))
+	for _, r := range rules {
+		if !r.noBody {
 			out = append(out, r)
 		}
 	}
@@ -265,10 +425,13 @@ func rewriteXHTMLFile(path string, rules []compiledRule) (int, bool, []byte, err
 		return 0, false, nil, err
 	}
 
-	dec := xml.NewDecoder(bytes.NewReader(data))
+	prolog, body := splitProlog(data)
+	dec := xml.NewDecoder(bytes.NewReader(body))
 	dec.Strict = false
+	dec.Entity = prologEntities(prolog)
 
 	var out bytes.Buffer
+	out.Write(prolog)
 	enc := xml.NewEncoder(&out)
 
 	type frame struct {
@@ -416,47 +579,67 @@ func applyRuleToText(s string, rule compiledRule) (string, int) {
 	if s == "" {
 		return s, 0
 	}
-	if rule.re != nil {
-		matches := len(rule.re.FindAllStringIndex(s, -1))
-		if matches == 0 {
-			return s, 0
-		}
-		out := rule.re.ReplaceAllString(s, rule.raw.Replace)
-		return out, matches
-	}
-	if !rule.raw.IgnoreCase {
+	if rule.re == nil {
 		count := strings.Count(s, rule.raw.Find)
 		if count == 0 {
 			return s, 0
 		}
 		return strings.ReplaceAll(s, rule.raw.Find, rule.raw.Replace), count
 	}
-	// Case-insensitive plain text.
-	findLower := strings.ToLower(rule.raw.Find)
-	if findLower == "" {
-		return s, 0
+	if !rule.raw.WholeWord {
+		matches := len(rule.re.FindAllStringIndex(s, -1))
+		if matches == 0 {
+			return s, 0
+		}
+		if rule.literal {
+			return rule.re.ReplaceAllLiteralString(s, rule.raw.Replace), matches
+		}
+		return rule.re.ReplaceAllString(s, rule.raw.Replace), matches
 	}
-	var buf strings.Builder
-	buf.Grow(len(s))
-	lower := strings.ToLower(s)
-	i := 0
-	matches := 0
-	for {
-		j := strings.Index(lower[i:], findLower)
-		if j < 0 {
-			buf.WriteString(s[i:])
-			break
+
+	var buf []byte
+	last, matches := 0, 0
+	for _, m := range rule.re.FindAllStringSubmatchIndex(s, -1) {
+		if !wholeWordMatch(s, m[0], m[1]) {
+			continue
+		}
+		buf = append(buf, s[last:m[0]]...)
+		if rule.literal {
+			buf = append(buf, rule.raw.Replace...)
+		} else {
+			buf = rule.re.ExpandString(buf, rule.raw.Replace, s, m)
 		}
-		j += i
-		buf.WriteString(s[i:j])
-		buf.WriteString(rule.raw.Replace)
-		i = j + len(rule.raw.Find)
+		last = m[1]
 		matches++
 	}
 	if matches == 0 {
 		return s, 0
 	}
-	return buf.String(), matches
+	return string(append(buf, s[last:]...)), matches
+}
+
+// wholeWordMatch reports whether s[start:end] doesn't start or end in the
+// middle of a word: a match that begins with a word character mustn't
+// follow one, and one that ends with a word character mustn't be followed
+// by one.
+func wholeWordMatch(s string, start, end int) bool {
+	if start < end {
+		first, _ := utf8.DecodeRuneInString(s[start:])
+		before, _ := utf8.DecodeLastRuneInString(s[:start])
+		if start > 0 && isWordRune(first) && isWordRune(before) {
+			return false
+		}
+		final, _ := utf8.DecodeLastRuneInString(s[:end])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		if end < len(s) && isWordRune(final) && isWordRune(after) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
 }
 
 // stripXMLNSAttrs removes xmlns attributes from the list. Go's xml.Encoder
@@ -473,14 +656,142 @@ func stripXMLNSAttrs(attrs []xml.Attr) []xml.Attr {
 	return out
 }
 
+// ruleFileEntry is one rule of a rules file: a RewriteRule that also takes
+// the camelCase spellings of its two-word keys.
+type ruleFileEntry struct {
+	Find          string   `json:"find"`
+	Replace       string   `json:"replace"`
+	Regex         bool     `json:"regex"`
+	IgnoreCase    bool     `json:"ignore_case"`
+	IgnoreCaseAlt bool     `json:"ignoreCase"`
+	WholeWord     bool     `json:"whole_word"`
+	WholeWordAlt  bool     `json:"wholeWord"`
+	Selectors     []string `json:"selectors"`
+	Scope         string   `json:"scope"`
+}
+
+// LoadRewriteRulesJSON reads a JSON array of rule objects with the keys of
+// RewriteRule (ignore_case and whole_word may also be written ignoreCase
+// and wholeWord). null and {} entries are skipped. Each rule is checked as
+// compileRules would, and errors give the file, the rule's 1-based position
+// in the array and the line it starts on; unknown keys are errors too, so
+// that typos don't pass silently.
 func LoadRewriteRulesJSON(path string) ([]RewriteRule, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	var arr []RewriteRule
-	if err := json.Unmarshal(data, &arr); err != nil {
-		return nil, err
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", path, jsonSyntaxError(data, err))
+		}
+		return nil, fmt.Errorf("%s: want a JSON array of rules", path)
+	}
+
+	var rules []RewriteRule
+	for n := 1; dec.More(); n++ {
+		line := lineAt(data, valueStart(data, dec.InputOffset()))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, jsonSyntaxError(data, err))
+		}
+		r, skip, err := parseRuleEntry(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %d (line %d): %w", path, n, line, err)
+		}
+		if !skip {
+			rules = append(rules, r)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, jsonSyntaxError(data, err))
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: unexpected data after the array of rules", path)
+	}
+	return rules, nil
+}
+
+// parseRuleEntry decodes and checks one element of a rules file; skip is
+// set for null and {}.
+func parseRuleEntry(raw json.RawMessage) (r RewriteRule, skip bool, err error) {
+	trimmed := bytes.TrimSpace(raw)
+	if string(trimmed) == "null" {
+		return RewriteRule{}, true, nil
 	}
-	return arr, nil
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return RewriteRule{}, false, fmt.Errorf("want an object with find and replace")
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &keys); err != nil {
+		return RewriteRule{}, false, err
+	}
+	if len(keys) == 0 {
+		return RewriteRule{}, true, nil
+	}
+
+	var e ruleFileEntry
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&e); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return RewriteRule{}, false, fmt.Errorf("%s: got %s, want %s", typeErr.Field, typeErr.Value, jsonKind(typeErr.Type.Kind()))
+		}
+		return RewriteRule{}, false, errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+	r = RewriteRule{
+		Find:       e.Find,
+		Replace:    e.Replace,
+		Regex:      e.Regex,
+		IgnoreCase: e.IgnoreCase || e.IgnoreCaseAlt,
+		WholeWord:  e.WholeWord || e.WholeWordAlt,
+		Selectors:  e.Selectors,
+		Scope:      e.Scope,
+	}
+	if _, err := compileRule(r); err != nil {
+		return RewriteRule{}, false, err
+	}
+	return r, false, nil
+}
+
+func jsonKind(k reflect.Kind) string {
+	switch k {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "a list of strings"
+	}
+	return k.String()
+}
+
+// jsonSyntaxError adds the line a JSON syntax error is on.
+func jsonSyntaxError(data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Errorf("line %d: %v", lineAt(data, int(syntaxErr.Offset)), syntaxErr)
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return fmt.Errorf("line %d: unexpected end of JSON input", lineAt(data, len(data)))
+	}
+	return err
+}
+
+// valueStart skips the whitespace and comma between JSON array elements
+// from off.
+func valueStart(data []byte, off int64) int {
+	i := int(off)
+	for i < len(data) && strings.IndexByte(" \t\r\n,", data[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+// lineAt returns the 1-based line of data[off].
+func lineAt(data []byte, off int) int {
+	off = min(off, len(data))
+	return 1 + bytes.Count(data[:off], []byte("\n"))
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -154,6 +155,152 @@ func TestRewriteSelectors(t *testing.T) {
 	}
 }
 
+func TestCompileRulesRegex(t *testing.T) {
+	cr, err := compileRules([]RewriteRule{
+		{Find: "Chapter", Replace: "Ch."},
+		{Find: `Ch\.\s+(\d+)`, Replace: "Chapter $1", Regex: true},
+		{Find: "a.b", Replace: "x"},
+	})
+	if err != nil {
+		t.Fatalf("compileRules: %v", err)
+	}
+	got, matches := applyRulesToText("Chapter  12, Chapter 3; a.b aXb", cr)
+	if want := "Chapter 12, Chapter 3; x aXb"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if matches != 5 {
+		t.Errorf("matches = %d, want 5", matches)
+	}
+
+	_, err = compileRules([]RewriteRule{{Find: "ok", Regex: true}, {Find: "(unclosed", Regex: true}})
+	if err == nil || !strings.HasPrefix(err.Error(), "rule 2: ") {
+		t.Errorf("err = %v, want it to name rule 2", err)
+	}
+	_, err = compileRules([]RewriteRule{{Find: "ok"}, {Find: "ok"}, {Replace: "x"}})
+	if err == nil || !strings.HasPrefix(err.Error(), "rule 3: ") {
+		t.Errorf("err = %v, want it to name rule 3", err)
+	}
+}
+
+func TestCompileRulesCaseAndWholeWord(t *testing.T) {
+	const text = "Rin met RIN at the Ring; rin's friend Zoë, not Zoëla, waved. Rin_2 stayed."
+	cases := []struct {
+		name    string
+		rule    RewriteRule
+		want    string
+		matches int
+	}{
+		{"plain", RewriteRule{Find: "Rin", Replace: "Len"},
+			"Len met RIN at the Leng; rin's friend Zoë, not Zoëla, waved. Len_2 stayed.", 3},
+		{"ignore case", RewriteRule{Find: "rin", Replace: "Len", IgnoreCase: true},
+			"Len met Len at the Leng; Len's friend Zoë, not Zoëla, waved. Len_2 stayed.", 5},
+		{"whole word", RewriteRule{Find: "Rin", Replace: "Len", WholeWord: true},
+			"Len met RIN at the Ring; rin's friend Zoë, not Zoëla, waved. Rin_2 stayed.", 1},
+		{"both", RewriteRule{Find: "rin", Replace: "Len", IgnoreCase: true, WholeWord: true},
+			"Len met Len at the Ring; Len's friend Zoë, not Zoëla, waved. Rin_2 stayed.", 3},
+		{"non-ASCII word", RewriteRule{Find: "Zoë", Replace: "Zoe", WholeWord: true},
+			"Rin met RIN at the Ring; rin's friend Zoe, not Zoëla, waved. Rin_2 stayed.", 1},
+		{"literal replace", RewriteRule{Find: "rin", Replace: "$1", IgnoreCase: true, WholeWord: true},
+			"$1 met $1 at the Ring; $1's friend Zoë, not Zoëla, waved. Rin_2 stayed.", 3},
+		{"literal find", RewriteRule{Find: "Ring;", Replace: "ring.", WholeWord: true},
+			"Rin met RIN at the ring. rin's friend Zoë, not Zoëla, waved. Rin_2 stayed.", 1},
+		{"regex whole word", RewriteRule{Find: `R(i)n`, Replace: "L${1}n", Regex: true, IgnoreCase: true, WholeWord: true},
+			"Lin met LIn at the Ring; Lin's friend Zoë, not Zoëla, waved. Rin_2 stayed.", 3},
+	}
+	for _, tc := range cases {
+		cr, err := compileRules([]RewriteRule{tc.rule})
+		if err != nil {
+			t.Fatalf("%s: compileRules: %v", tc.name, err)
+		}
+		got, matches := applyRulesToText(text, cr)
+		if got != tc.want || matches != tc.matches {
+			t.Errorf("%s: got %q (%d matches), want %q (%d)", tc.name, got, matches, tc.want, tc.matches)
+		}
+	}
+}
+
+func TestLoadRewriteRulesJSON(t *testing.T) {
+	write := func(data string) string {
+		p := filepath.Join(t.TempDir(), "rules.json")
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	rules, err := LoadRewriteRulesJSON(write(`[
+  {"find": "Rin", "replace": "Len", "wholeWord": true, "ignoreCase": true},
+  {},
+  null,
+  {"find": "Chapter\\s+(\\d+)", "replace": "Chapter $1", "regex": true, "selectors": ["h1"], "scope": "body"},
+  {"find": "Mr ", "replace": "Mr. ", "ignore_case": true, "whole_word": true, "scope": "meta"}
+]`))
+	if err != nil {
+		t.Fatalf("LoadRewriteRulesJSON: %v", err)
+	}
+	want := []RewriteRule{
+		{Find: "Rin", Replace: "Len", IgnoreCase: true, WholeWord: true},
+		{Find: `Chapter\s+(\d+)`, Replace: "Chapter $1", Regex: true, Selectors: []string{"h1"}, Scope: "body"},
+		{Find: "Mr ", Replace: "Mr. ", IgnoreCase: true, WholeWord: true, Scope: "meta"},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %+v\nwant %+v", rules, want)
+	}
+
+	bad := map[string]string{
+		"[\n  {\"find\": \"a\"},\n  {\"find\": \"(\", \"regex\": true}\n]": "rule 2 (line 3): compile regex",
+		"[\n  {\"find\": \"a\"},\n\n  {\"replace\": \"b\"}\n]":             "rule 2 (line 4): missing find pattern",
+		"[{\"find\": \"a\", \"ignorecase\": true, \"fnd\": \"b\"}]":        `rule 1 (line 1): unknown field "fnd"`,
+		"[{\"find\": \"a\", \"regex\": \"yes\"}]":                          "rule 1 (line 1): regex: got string, want true or false",
+		"[{\"find\": \"a\", \"scope\": \"cover\"}]":                        `rule 1 (line 1): invalid scope "cover"`,
+		"[\"a=b\"]": "rule 1 (line 1): want an object",
+		"[\n  {\"find\": \"a\"}\n  {\"find\": \"b\"}\n]": "line 3:",
+		"{\"find\": \"a\"}":  "want a JSON array of rules",
+		"":                   "want a JSON array of rules",
+		"[{\"find\": \"a\"}": "line 1: unexpected end of JSON input",
+	}
+	for data, msg := range bad {
+		p := write(data)
+		_, err := LoadRewriteRulesJSON(p)
+		if err == nil || !strings.Contains(err.Error(), msg) || !strings.HasPrefix(err.Error(), p+": ") {
+			t.Errorf("%q: err = %v, want %q", data, err, msg)
+		}
+	}
+}
+
+func TestRewriteRuleScope(t *testing.T) {
+	input := buildTestEPUB(t, "Chapter Title", "en")
+	stats, err := RewriteEPUB(context.Background(), input, RewriteOptions{
+		Scope: RewriteScopeAll,
+		Rules: []RewriteRule{
+			{Find: "Chapter", Replace: "Part", Scope: "meta"},
+			{Find: "Chapter", Replace: "Section", Scope: "body"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("RewriteEPUB: %v", err)
+	}
+	if stats.MatchCount < 2 {
+		t.Errorf("MatchCount = %d", stats.MatchCount)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	if got := vol.PackageDoc.Metadata.Titles[0].Value; got != "Part Title" {
+		t.Errorf("title = %q", got)
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(vol.PackagePath), "chapter.xhtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Section") || strings.Contains(string(data), "Part") {
+		t.Errorf("body rules applied wrongly: %s", data)
+	}
+}
+
 func TestRewriteDryRunNoMutation(t *testing.T) {
 	input := buildTestEPUB(t, "Old Title", "en")
 	defer os.Remove(input)
@@ -174,6 +321,19 @@ func TestRewriteDryRunNoMutation(t *testing.T) {
 	if stats.MatchCount == 0 {
 		t.Fatalf("expected matches in dry-run")
 	}
+	if got := strings.Join(stats.ChangedFiles, ","); got != "nav.xhtml,chapter.xhtml" {
+		t.Fatalf("changed files = %q", got)
+	}
+	sum := stats.MetadataMatches
+	for _, fm := range stats.FileMatches {
+		if fm.Matches <= 0 {
+			t.Errorf("%s listed with %d matches", fm.Href, fm.Matches)
+		}
+		sum += fm.Matches
+	}
+	if len(stats.FileMatches) != 2 || stats.FileMatches[1].Href != "chapter.xhtml" || sum != stats.MatchCount {
+		t.Fatalf("file matches = %+v, total %d", stats.FileMatches, stats.MatchCount)
+	}
 
 	vol, err := loadVolume(context.Background(), 0, input)
 	if err != nil {
@@ -190,3 +350,204 @@ func TestRewriteDryRunNoMutation(t *testing.T) {
 		t.Fatalf("dry-run should not mutate files")
 	}
 }
+
+func TestRewriteCoverScope(t *testing.T) {
+	opf := func(extra ...ManifestItem) string {
+		return testOPF(testPackage{
+			title:      "Tltle",
+			identifier: "urn:test:cover",
+			items: append(extra,
+				testItem("img", "Images/cover.png", "cover-image"),
+				testItem("cover", "Text/cover.xhtml"),
+				testItem("chap", "Text/chapter.xhtml"),
+			),
+			spine: []string{"cover", "chap"},
+		})
+	}
+	files := func(withNav bool) map[string]string {
+		m := map[string]string{
+			"OEBPS/Images/cover.png":   "png",
+			"OEBPS/Text/cover.xhtml":   `<html xmlns="http://www.w3.org/1999/xhtml"><body><img src="../Images/cover.png"/><h1>Tltle</h1></body></html>`,
+			"OEBPS/Text/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Tltle is a word here.</p></body></html>`,
+		}
+		if withNav {
+			m["OEBPS/content.opf"] = opf(testItem("nav", "Text/nav.xhtml", "nav"))
+			m["OEBPS/Text/nav.xhtml"] = `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol><li><a href="chapter.xhtml">Chapter</a></li></ol></nav>
+<nav epub:type="landmarks"><ol><li><a epub:type="cover" href="cover.xhtml">Cover</a></li></ol></nav>
+</body></html>`
+		} else {
+			m["OEBPS/content.opf"] = opf()
+		}
+		return m
+	}
+
+	for _, withNav := range []bool{true, false} {
+		input := buildTestEPUBFiles(t, files(withNav))
+		stats, err := RewriteEPUB(context.Background(), input, RewriteOptions{
+			Scope: RewriteScopeCover,
+			Rules: []RewriteRule{{Find: "Tltle", Replace: "Title"}},
+		})
+		if err != nil {
+			t.Fatalf("nav=%v: RewriteEPUB: %v", withNav, err)
+		}
+		if len(stats.ScopeFiles) != 1 || stats.ScopeFiles[0] != "Text/cover.xhtml" {
+			t.Fatalf("nav=%v: scope files = %v", withNav, stats.ScopeFiles)
+		}
+		if stats.MatchCount != 1 || len(stats.ChangedFiles) != 1 || stats.ChangedFiles[0] != "Text/cover.xhtml" {
+			t.Fatalf("nav=%v: stats = %+v", withNav, stats)
+		}
+	}
+
+	noCover := files(false)
+	noCover["OEBPS/Text/cover.xhtml"] = `<html xmlns="http://www.w3.org/1999/xhtml"><body><h1>Tltle</h1></body></html>`
+	_, err := RewriteEPUB(context.Background(), buildTestEPUBFiles(t, noCover), RewriteOptions{
+		Scope: RewriteScopeCover,
+		Rules: []RewriteRule{{Find: "Tltle", Replace: "Title"}},
+	})
+	if err == nil {
+		t.Fatalf("expected error when no cover document is found")
+	}
+}
+
+func TestRewriteKeepsInlineSVG(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Plates",
+			identifier: "urn:test:svg",
+			items: []ManifestItem{
+				testItem("img", "Images/plate.jpg"),
+				testItem("chap", "Text/chapter.xhtml", "svg"),
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/Images/plate.jpg": "jpg",
+		"OEBPS/Text/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:xl="http://www.w3.org/1999/xlink"><body>
+<p>Plate one</p>
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><image width="10" height="10" xl:href="../Images/plate.jpg"/></svg>
+</body></html>`,
+	})
+
+	if _, err := RewriteEPUB(context.Background(), input, RewriteOptions{
+		Scope: RewriteScopeBody,
+		Rules: []RewriteRule{{Find: "Plate", Replace: "Figure"}},
+	}); err != nil {
+		t.Fatalf("RewriteEPUB: %v", err)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, input)
+	if err != nil {
+		t.Fatalf("reopen epub: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	data, err := os.ReadFile(filepath.Join(vol.PackageDir, "Text", "chapter.xhtml"))
+	if err != nil {
+		t.Fatalf("read chapter: %v", err)
+	}
+	doc := string(data)
+	if !strings.Contains(doc, "Figure one") {
+		t.Fatalf("replacement not applied: %s", doc)
+	}
+	// The re-encoded image must still carry an href bound to the XLink
+	// namespace, or the illustration stops resolving.
+	if !strings.Contains(doc, `xmlns:xlink="http://www.w3.org/1999/xlink" xlink:href="../Images/plate.jpg"`) {
+		t.Fatalf("svg image href lost: %s", doc)
+	}
+	if got := localRefs(data, "Text/chapter.xhtml", false); len(got) != 1 || got[0] != "Images/plate.jpg" {
+		t.Fatalf("refs = %q", got)
+	}
+}
+
+func TestRewriteKeepsProlog(t *testing.T) {
+	prolog := "\ufeff<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" +
+		`<?xml-stylesheet href="../Styles/legacy.css" type="text/css"?>` + "\n" +
+		`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd" [<!ENTITY ndash "&#8211;">]>` + "\n"
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Legacy",
+			identifier: "urn:test:pi",
+			items: []ManifestItem{
+				testItem("chap", "Text/chapter.xhtml"),
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/Text/chapter.xhtml": prolog + `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Chapter one &ndash; start</p><?pagebreak n="12"?></body></html>`,
+	})
+
+	if _, err := RewriteEPUB(context.Background(), input, RewriteOptions{
+		Scope: RewriteScopeBody,
+		Rules: []RewriteRule{{Find: "Chapter", Replace: "Part"}},
+	}); err != nil {
+		t.Fatalf("RewriteEPUB: %v", err)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, input)
+	if err != nil {
+		t.Fatalf("reopen epub: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+
+	data, err := os.ReadFile(filepath.Join(vol.PackageDir, "Text", "chapter.xhtml"))
+	if err != nil {
+		t.Fatalf("read chapter: %v", err)
+	}
+	doc := string(data)
+	if !strings.HasPrefix(doc, prolog) {
+		t.Fatalf("prolog not kept:\n%s", doc)
+	}
+	if !strings.Contains(doc, "Part one \u2013 start") || !strings.Contains(doc, `<?pagebreak n="12"?>`) {
+		t.Fatalf("body = %s", doc)
+	}
+}
+
+func TestMergeEPUBsRewriteRules(t *testing.T) {
+	a := buildChaptersEPUB(t, "Vol 1", "Chaptre one", "Chaptre two")
+	b := buildChaptersEPUB(t, "Vol 2", "Chaptre three")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{
+		OutPath:      out,
+		RewriteRules: []RewriteRule{{Find: "Chaptre", Replace: "Chapter"}},
+	})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if stats.Rewrite.MatchCount != 3 || stats.Rewrite.FilesChanged != 3 {
+		t.Fatalf("rewrite stats = %+v", stats.Rewrite)
+	}
+	want := []string{"Volumes/v0001/c1.xhtml", "Volumes/v0001/c2.xhtml", "Volumes/v0002/c1.xhtml"}
+	if strings.Join(stats.Rewrite.ChangedFiles, " ") != strings.Join(want, " ") {
+		t.Fatalf("changed files = %q", stats.Rewrite.ChangedFiles)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	data, err := os.ReadFile(filepath.Join(vol.PackageDir, "Volumes", "v0002", "c1.xhtml"))
+	if err != nil {
+		t.Fatalf("read chapter: %v", err)
+	}
+	if !strings.Contains(string(data), "Chapter three") {
+		t.Fatalf("rule not applied: %s", data)
+	}
+
+	// The sources themselves are left as they were.
+	src, err := loadVolume(context.Background(), 0, b)
+	if err != nil {
+		t.Fatalf("reopen source: %v", err)
+	}
+	defer os.RemoveAll(src.TempDir)
+	if data, _ := os.ReadFile(filepath.Join(src.PackageDir, "c1.xhtml")); !strings.Contains(string(data), "Chaptre three") {
+		t.Fatalf("source modified: %s", data)
+	}
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{
+		OutPath:      out,
+		RewriteRules: []RewriteRule{{Find: "(", Regex: true}},
+	}); err == nil {
+		t.Fatalf("expected error for a bad rule")
+	}
+}
@@ -0,0 +1,79 @@
+package epub
+
+import (
+	"compress/flate"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Per-entry archive overhead: local file header, central directory record
+// and data descriptor, each added to twice the name length.
+const zipEntryOverhead = 30 + 46 + 16
+
+// compressibleExts are the staged files worth deflating for an estimate;
+// images, fonts and media are already compressed and count at full size.
+var compressibleExts = map[string]bool{
+	".xhtml": true, ".html": true, ".htm": true, ".css": true, ".opf": true,
+	".ncx": true, ".svg": true, ".xml": true, ".smil": true, ".js": true,
+	".txt": true,
+}
+
+// estimateArchiveSize walks a staged tree and returns its total size and an
+// estimate of the EPUB it would zip into at the given deflate level: text
+// files are deflated in memory, everything else is counted as stored.
+func estimateArchiveSize(root string, level int) (int64, int64, error) {
+	var raw, est int64
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		raw += info.Size()
+		est += zipEntryOverhead + 2*int64(len(filepath.ToSlash(rel)))
+
+		if rel == "mimetype" || level == flate.NoCompression || !compressibleExts[strings.ToLower(filepath.Ext(p))] {
+			est += info.Size()
+			return nil
+		}
+		n, err := deflatedSize(p, level)
+		if err != nil {
+			return err
+		}
+		est += n
+		return nil
+	})
+	return raw, est, err
+}
+
+type countWriter struct{ n int64 }
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func deflatedSize(p string, level int) (int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var cw countWriter
+	fw, err := flate.NewWriter(&cw, level)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(fw, f); err != nil {
+		return 0, err
+	}
+	if err := fw.Close(); err != nil {
+		return 0, err
+	}
+	return cw.n, nil
+}
@@ -0,0 +1,499 @@
+package epub
+
+import (
+	"compress/flate"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type SplitOptions struct {
+	OutDir string
+	// NameTemplate names each volume's file in OutDir: a printf format
+	// given the volume's 1-based number, such as "Vol %02d.epub". The
+	// default is the input's name followed by " %02d.epub".
+	NameTemplate string
+}
+
+// SplitVolume is one book SplitEPUB wrote.
+type SplitVolume struct {
+	Title string
+	Path  string
+	// Documents is the number of spine documents and Files the number of
+	// manifest items, nav included.
+	Documents int
+	Files     int
+}
+
+// splitGroup is the part of a merged book that becomes one volume.
+type splitGroup struct {
+	refs  []SpineItemRef
+	title string
+	nav   []NavItem
+}
+
+// SplitEPUB breaks a merged book back into one EPUB per volume in
+// opts.OutDir. Spine documents are grouped by their Volumes/<folder>/
+// directory, which is how MergeEPUBs lays volumes out, and pages the merge
+// generated (nav, index, grid cover) are left out; books laid out some other
+// way are split at each top-level nav entry instead. Each volume is titled
+// after its top-level nav entry, gets a nav of that entry's children, and
+// holds only its spine documents and the stylesheets, fonts and images they
+// reach. The other metadata (language, creators, rights, series, subjects)
+// is copied from the merged book, and each volume gets a new identifier.
+// The input is not modified.
+func SplitEPUB(ctx context.Context, input string, opts SplitOptions) ([]SplitVolume, error) {
+	if input == "" {
+		return nil, fmt.Errorf("input EPUB path is required")
+	}
+	if opts.OutDir == "" {
+		return nil, fmt.Errorf("output directory is required")
+	}
+	tmpl := opts.NameTemplate
+	if tmpl == "" {
+		stem := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+		tmpl = strings.ReplaceAll(stem, "%", "%%") + " %02d.epub"
+	}
+	if name := fmt.Sprintf(tmpl, 1); strings.Contains(name, "%!") || name == fmt.Sprintf(tmpl, 2) {
+		return nil, fmt.Errorf("invalid name template %q (want one number verb, e.g. \"Vol %%02d.epub\")", tmpl)
+	}
+
+	book, err := OpenBook(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	groups := splitGroups(book)
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("%s has no volumes to split: no spine documents under Volumes/ and no top-level nav entries", input)
+	}
+	if err := os.MkdirAll(opts.OutDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	out := make([]SplitVolume, 0, len(groups))
+	for i, group := range groups {
+		if err := ctx.Err(); err != nil {
+			return out, err
+		}
+		if group.title == "" {
+			group.title = fmt.Sprintf("Volume %d", i+1)
+			if titles := book.Package.Metadata.Titles; len(titles) > 0 && strings.TrimSpace(titles[0].Value) != "" {
+				group.title = fmt.Sprintf("%s %d", strings.TrimSpace(titles[0].Value), i+1)
+			}
+		}
+		dest := filepath.Join(opts.OutDir, fmt.Sprintf(tmpl, i+1))
+		vol, err := writeSplitVolume(book, group, i+1, dest)
+		if err != nil {
+			return out, fmt.Errorf("volume %d: %w", i+1, err)
+		}
+		out = append(out, vol)
+	}
+	return out, nil
+}
+
+// splitGroups divides the book's spine into volumes, by Volumes/<folder>/
+// prefix when any spine document has one and otherwise at each top-level
+// nav entry.
+func splitGroups(book *Book) []splitGroup {
+	pkg := book.Package
+	hrefs := make(map[string]string, len(pkg.Manifest.Items))
+	for _, item := range pkg.Manifest.Items {
+		hrefs[item.ID] = normalizeEPUBPath(item.Href)
+	}
+	nav := cloneNavItems(book.NavItems, path.Dir(book.NavHref))
+
+	var groups []splitGroup
+	index := make(map[string]int)
+	for _, ref := range pkg.Spine.Itemrefs {
+		dir := volumeFolder(hrefs[ref.IDRef])
+		if dir == "" {
+			continue
+		}
+		i, ok := index[dir]
+		if !ok {
+			i = len(groups)
+			index[dir] = i
+			groups = append(groups, splitGroup{})
+		}
+		groups[i].refs = append(groups[i].refs, ref)
+	}
+	if len(groups) > 0 {
+		for _, entry := range nav {
+			i, ok := index[volumeFolder(navDocument(firstNavHref([]NavItem{entry})))]
+			if ok && groups[i].title == "" {
+				groups[i].title = entry.Title
+				groups[i].nav = entryNav(entry)
+			}
+		}
+		for i := range groups {
+			groups[i].nav = scopeNav(groups[i].nav, groupDocs(groups[i].refs, hrefs))
+		}
+		return groups
+	}
+
+	// No volume folders: start a volume at each top-level entry's document.
+	spineAt := make(map[string]int, len(pkg.Spine.Itemrefs))
+	for i, ref := range pkg.Spine.Itemrefs {
+		if _, seen := spineAt[hrefs[ref.IDRef]]; !seen {
+			spineAt[hrefs[ref.IDRef]] = i
+		}
+	}
+	type start struct {
+		at    int
+		entry NavItem
+	}
+	var starts []start
+	for _, entry := range nav {
+		at, ok := spineAt[navDocument(firstNavHref([]NavItem{entry}))]
+		if !ok || len(starts) > 0 && at <= starts[len(starts)-1].at {
+			continue
+		}
+		starts = append(starts, start{at: at, entry: entry})
+	}
+	for n, s := range starts {
+		from, to := s.at, len(pkg.Spine.Itemrefs)
+		if n == 0 {
+			from = 0
+		}
+		if n+1 < len(starts) {
+			to = starts[n+1].at
+		}
+		refs := pkg.Spine.Itemrefs[from:to]
+		groups = append(groups, splitGroup{
+			refs:  refs,
+			title: s.entry.Title,
+			nav:   scopeNav(entryNav(s.entry), groupDocs(refs, hrefs)),
+		})
+	}
+	return groups
+}
+
+// volumeFolder returns the Volumes/<folder> directory of a merged href, or
+// "" for files outside one.
+func volumeFolder(href string) string {
+	parts := strings.SplitN(href, "/", 3)
+	if len(parts) < 3 || parts[0] != "Volumes" || parts[1] == "" {
+		return ""
+	}
+	return parts[0] + "/" + parts[1]
+}
+
+// navDocument strips the fragment from a package-relative nav href.
+func navDocument(href string) string {
+	doc, _, _ := strings.Cut(href, "#")
+	return normalizeEPUBPath(doc)
+}
+
+// entryNav is the TOC a volume gets from its top-level entry in the merged
+// nav: the entry's children, or the entry itself when it has none.
+func entryNav(entry NavItem) []NavItem {
+	if len(entry.Children) > 0 {
+		return entry.Children
+	}
+	return []NavItem{entry}
+}
+
+func groupDocs(refs []SpineItemRef, hrefs map[string]string) map[string]bool {
+	docs := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		docs[hrefs[ref.IDRef]] = true
+	}
+	return docs
+}
+
+// scopeNav drops the entries that link outside docs, keeping an entry
+// without a link of its own only if some child survives.
+func scopeNav(items []NavItem, docs map[string]bool) []NavItem {
+	var out []NavItem
+	for _, item := range items {
+		item.Children = scopeNav(item.Children, docs)
+		if item.Href != "" && !docs[navDocument(item.Href)] {
+			item.Href = ""
+		}
+		if item.Href == "" && len(item.Children) == 0 {
+			continue
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+// writeSplitVolume writes one group of book as the EPUB dest.
+func writeSplitVolume(book *Book, group splitGroup, number int, dest string) (SplitVolume, error) {
+	pkg := book.Package
+	byID := make(map[string]int, len(pkg.Manifest.Items))
+	byHref := make(map[string]int, len(pkg.Manifest.Items))
+	inSpine := make(map[string]bool, len(pkg.Spine.Itemrefs))
+	for i, item := range pkg.Manifest.Items {
+		byID[item.ID] = i
+		byHref[normalizeEPUBPath(item.Href)] = i
+	}
+	for _, ref := range pkg.Spine.Itemrefs {
+		inSpine[ref.IDRef] = true
+	}
+
+	// Collect the group's documents and everything they reach, apart from
+	// other volumes' documents they happen to link to.
+	keep := make(map[int]bool)
+	var queue []int
+	add := func(i int) {
+		if !keep[i] {
+			keep[i] = true
+			queue = append(queue, i)
+		}
+	}
+	for _, ref := range group.refs {
+		if i, ok := byID[ref.IDRef]; ok {
+			add(i)
+		}
+	}
+	// The book's cover image goes with the volume whose folder holds it,
+	// even when no page shows it.
+	if i, ok := byID[book.CoverID]; ok {
+		if dir := volumeFolder(normalizeEPUBPath(pkg.Manifest.Items[i].Href)); dir != "" {
+			for _, ref := range group.refs {
+				if j, ok := byID[ref.IDRef]; ok && volumeFolder(normalizeEPUBPath(pkg.Manifest.Items[j].Href)) == dir {
+					add(i)
+					break
+				}
+			}
+		}
+	}
+	for len(queue) > 0 {
+		item := pkg.Manifest.Items[queue[0]]
+		queue = queue[1:]
+		if i, ok := byID[item.Fallback]; ok {
+			add(i)
+		}
+		var isCSS bool
+		switch item.MediaType {
+		case "application/xhtml+xml", "image/svg+xml":
+		case "text/css":
+			isCSS = true
+		default:
+			continue
+		}
+		data, err := book.ReadFile(item.Href)
+		if err != nil {
+			continue
+		}
+		for _, ref := range localRefs(data, item.Href, isCSS) {
+			i, ok := byHref[ref]
+			if !ok || inSpine[pkg.Manifest.Items[i].ID] || hasProperty(pkg.Manifest.Items[i].Properties, "nav") {
+				continue
+			}
+			add(i)
+		}
+	}
+
+	var kept []ManifestItem
+	for i, item := range pkg.Manifest.Items {
+		if keep[i] {
+			kept = append(kept, item)
+		}
+	}
+	strip := commonDir(kept)
+	ids := splitIDs(kept)
+
+	stageDir, err := os.MkdirTemp("", "novfmt-split-*")
+	if err != nil {
+		return SplitVolume{}, err
+	}
+	defer os.RemoveAll(stageDir)
+	oebpsDir := filepath.Join(stageDir, DefaultContentDir)
+
+	taken := make(map[string]bool)
+	manifest := Manifest{}
+	coverID := ""
+	for _, item := range kept {
+		data, err := book.ReadFile(item.Href)
+		if err != nil {
+			return SplitVolume{}, fmt.Errorf("read %s: %w", item.Href, err)
+		}
+		href := strings.TrimPrefix(normalizeEPUBPath(item.Href), strip)
+		p := filepath.Join(oebpsDir, filepath.FromSlash(href))
+		if err := ensureParentDir(p); err != nil {
+			return SplitVolume{}, err
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			return SplitVolume{}, err
+		}
+		taken[strings.ToLower(href)] = true
+		if item.ID == book.CoverID || hasProperty(item.Properties, "cover-image") {
+			coverID = ids[item.ID]
+		}
+		item.ID = ids[item.ID]
+		item.Href = href
+		item.Fallback = ids[item.Fallback]
+		item.MediaOverlay = ""
+		manifest.Items = append(manifest.Items, item)
+	}
+
+	navHref := uniqueFileName("nav.xhtml", taken)
+	navID := "nav"
+	for _, id := range ids {
+		if id == navID {
+			navID = "novfmt-nav"
+			break
+		}
+	}
+	navItems := cloneNavItems(group.nav, ".")
+	for i := range navItems {
+		navItems[i] = stripNavPrefix(navItems[i], strip)
+	}
+	nav := renderNav(navItems, group.title, nil, nil, nil)
+	if err := os.WriteFile(filepath.Join(oebpsDir, filepath.FromSlash(navHref)), nav, 0o644); err != nil {
+		return SplitVolume{}, err
+	}
+	manifest.Items = append(manifest.Items, ManifestItem{ID: navID, Href: navHref, MediaType: "application/xhtml+xml", Properties: "nav"})
+
+	spine := Spine{PageProgressionDirection: pkg.Spine.PageProgressionDirection}
+	for _, ref := range group.refs {
+		if _, ok := byID[ref.IDRef]; ok {
+			spine.Itemrefs = append(spine.Itemrefs, SpineItemRef{IDRef: ids[ref.IDRef], Linear: ref.Linear})
+		}
+	}
+
+	out := &PackageDocument{
+		XMLNS:            nsOPF,
+		XMLNSDC:          nsDC,
+		XMLNSOPF:         nsOPF,
+		Version:          "3.0",
+		UniqueIdentifier: "bookid",
+		Lang:             pkg.Lang,
+		Metadata:         splitMetadata(pkg.Metadata, group.title, number, coverID),
+		Manifest:         manifest,
+		Spine:            spine,
+	}
+	if err := writePackage(out, filepath.Join(oebpsDir, DefaultPackageName)); err != nil {
+		return SplitVolume{}, err
+	}
+	if err := writeContainer(filepath.Join(stageDir, "META-INF"), DefaultContentDir+"/"+DefaultPackageName); err != nil {
+		return SplitVolume{}, err
+	}
+	if err := os.WriteFile(filepath.Join(stageDir, "mimetype"), []byte(epubMimetype), 0o644); err != nil {
+		return SplitVolume{}, err
+	}
+	if err := ensureParentDir(dest); err != nil {
+		return SplitVolume{}, err
+	}
+	if _, err := writeZipWith(stageDir, dest, zipOptions{level: flate.DefaultCompression}); err != nil {
+		return SplitVolume{}, err
+	}
+	return SplitVolume{Title: group.title, Path: dest, Documents: len(spine.Itemrefs), Files: len(manifest.Items)}, nil
+}
+
+// commonDir returns the deepest directory (with a trailing slash) holding
+// every item, or "". Moving only those files keeps their references to each
+// other intact, so a volume that kept to its own folder gets it as its root.
+func commonDir(items []ManifestItem) string {
+	var dir string
+	for n, item := range items {
+		d := path.Dir(normalizeEPUBPath(item.Href)) + "/"
+		if n == 0 {
+			dir = d
+			continue
+		}
+		for !strings.HasPrefix(d, dir) {
+			dir = path.Dir(strings.TrimSuffix(dir, "/")) + "/"
+			if dir == "./" || dir == "/" {
+				return ""
+			}
+		}
+	}
+	if dir == "./" {
+		return ""
+	}
+	return dir
+}
+
+// splitIDs maps the items' merged ids to the ones they get in the split
+// volume: with the merge's vNNNN_ prefix removed when every item carries
+// the same one and that keeps them distinct, and unchanged otherwise. The
+// empty id maps to itself.
+func splitIDs(items []ManifestItem) map[string]string {
+	ids := map[string]string{"": ""}
+	prefix := ""
+	for _, item := range items {
+		p, _, ok := strings.Cut(item.ID, "_")
+		if !ok || volumeFromID(item.ID) == 0 || prefix != "" && p != prefix {
+			prefix = ""
+			break
+		}
+		prefix = p
+	}
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		id := strings.TrimPrefix(item.ID, prefix+"_")
+		if prefix == "" || seen[id] {
+			for _, item := range items {
+				ids[item.ID] = item.ID
+			}
+			return ids
+		}
+		seen[id] = true
+		ids[item.ID] = id
+	}
+	return ids
+}
+
+func stripNavPrefix(item NavItem, prefix string) NavItem {
+	item.Href = strings.TrimPrefix(item.Href, prefix)
+	for i := range item.Children {
+		item.Children[i] = stripNavPrefix(item.Children[i], prefix)
+	}
+	return item
+}
+
+// splitMetadata is a split volume's metadata: its own title, number within
+// the series and a new identifier, with the merged book's creators and
+// their refinements, languages, descriptions, rights, series and other
+// elements carried over.
+func splitMetadata(merged Metadata, title string, number int, coverID string) Metadata {
+	meta := Metadata{
+		Titles:       []DCMeta{{Value: title}},
+		Identifiers:  []DCMeta{{ID: "bookid", Value: randomURN()}},
+		Creators:     merged.Creators,
+		Languages:    merged.Languages,
+		Descriptions: merged.Descriptions,
+		Rights:       merged.Rights,
+		Extra:        merged.Extra,
+	}
+	if len(meta.Languages) == 0 {
+		meta.Languages = []DCMeta{{Value: "en"}}
+	}
+	refined := make(map[string]bool)
+	for _, c := range merged.Creators {
+		if c.ID != "" {
+			refined["#"+c.ID] = true
+		}
+	}
+	for _, m := range merged.Meta {
+		if m.Property == "belongs-to-collection" && m.ID != "" {
+			refined["#"+m.ID] = true
+		}
+	}
+	for _, m := range merged.Meta {
+		switch {
+		case m.Property == "belongs-to-collection", refined[m.Refines] && m.Property != "group-position":
+			meta.Meta = append(meta.Meta, m)
+			if m.Property == "belongs-to-collection" && m.ID != "" {
+				meta.Meta = append(meta.Meta, MetaNode{Refines: "#" + m.ID, Property: "group-position", Value: fmt.Sprintf("%d", number)})
+			}
+		}
+	}
+	meta.Meta = append(meta.Meta, MetaNode{
+		Property: "dcterms:modified",
+		Value:    time.Now().UTC().Format(time.RFC3339),
+	})
+	if coverID != "" {
+		meta.Meta = append(meta.Meta, MetaNode{Name: "cover", Content: coverID})
+	}
+	return meta
+}
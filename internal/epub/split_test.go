@@ -0,0 +1,113 @@
+package epub
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSplitEPUB(t *testing.T) {
+	a := buildSharedTestEPUB(t, "Vol 1", "cover1", "font-one")
+	b := buildSharedTestEPUB(t, "Vol 2", "cover2", "font-two")
+	merged := filepath.Join(t.TempDir(), "saga.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: merged, Creators: []string{"Author"}, Collection: "Saga"}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	dir := t.TempDir()
+	vols, err := SplitEPUB(context.Background(), merged, SplitOptions{OutDir: dir, NameTemplate: "Vol %02d.epub"})
+	if err != nil {
+		t.Fatalf("SplitEPUB: %v", err)
+	}
+	if len(vols) != 2 || vols[1].Title != "Vol 2" || vols[1].Path != filepath.Join(dir, "Vol 02.epub") || vols[1].Documents != 1 {
+		t.Fatalf("vols = %+v", vols)
+	}
+
+	book, err := OpenBook(context.Background(), vols[1].Path)
+	if err != nil {
+		t.Fatalf("open split volume: %v", err)
+	}
+	defer book.Close()
+	if issues, err := ValidateEPUB(context.Background(), vols[1].Path); err != nil || len(issues) != 0 {
+		t.Fatalf("validate: %v %+v", err, issues)
+	}
+
+	var hrefs []string
+	for _, item := range book.Package.Manifest.Items {
+		hrefs = append(hrefs, item.ID+"="+item.Href)
+	}
+	sort.Strings(hrefs)
+	if got := strings.Join(hrefs, " "); got != "chap=Text/chapter.xhtml cover=Images/cover.png css=Styles/main.css font=Fonts/serif.ttf nav=nav.xhtml orn=Images/orn.png" {
+		t.Fatalf("manifest = %s", got)
+	}
+	if font, err := book.ReadFile("Fonts/serif.ttf"); err != nil || string(font) != "font-two" {
+		t.Fatalf("font = %q, %v", font, err)
+	}
+	meta := book.Package.Metadata
+	if meta.Titles[0].Value != "Vol 2" || len(meta.Creators) != 1 || meta.Creators[0].Value != "Author" || volumeCollection(meta) != "Saga" {
+		t.Fatalf("metadata = %+v", meta)
+	}
+	if len(book.NavItems) != 1 || book.NavItems[0].Href != "Text/chapter.xhtml" {
+		t.Fatalf("nav = %+v", book.NavItems)
+	}
+}
+
+func TestSplitEPUBSharedResources(t *testing.T) {
+	a := buildSharedTestEPUB(t, "Vol 1", "cover1", "font-one")
+	b := buildSharedTestEPUB(t, "Vol 2", "cover2", "font-one")
+	merged := filepath.Join(t.TempDir(), "saga.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: merged, DedupeResources: true}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	dir := t.TempDir()
+	vols, err := SplitEPUB(context.Background(), merged, SplitOptions{OutDir: dir})
+	if err != nil {
+		t.Fatalf("SplitEPUB: %v", err)
+	}
+	if len(vols) != 2 || vols[0].Path != filepath.Join(dir, "saga 01.epub") {
+		t.Fatalf("vols = %+v", vols)
+	}
+	book, err := OpenBook(context.Background(), vols[1].Path)
+	if err != nil {
+		t.Fatalf("open split volume: %v", err)
+	}
+	defer book.Close()
+	for _, href := range []string{"Shared/main.css", "Shared/serif.ttf", "Shared/orn.png", "Volumes/v0002/Images/cover.png"} {
+		if !book.Exists(href) {
+			t.Errorf("volume 2 lacks %s", href)
+		}
+	}
+	if book.Exists("Volumes/v0001/Images/cover.png") {
+		t.Errorf("volume 2 has volume 1's cover")
+	}
+}
+
+func TestSplitEPUBByNav(t *testing.T) {
+	input := buildChaptersEPUB(t, "Omnibus", "Book One", "Book Two", "Book Three")
+	vols, err := SplitEPUB(context.Background(), input, SplitOptions{OutDir: t.TempDir(), NameTemplate: "%d.epub"})
+	if err != nil {
+		t.Fatalf("SplitEPUB: %v", err)
+	}
+	var titles []string
+	for _, vol := range vols {
+		titles = append(titles, vol.Title)
+	}
+	if strings.Join(titles, ",") != "Book One,Book Two,Book Three" {
+		t.Fatalf("titles = %v", titles)
+	}
+	book, err := OpenBook(context.Background(), vols[2].Path)
+	if err != nil {
+		t.Fatalf("open split volume: %v", err)
+	}
+	defer book.Close()
+	if len(book.Package.Spine.Itemrefs) != 1 || book.Package.Spine.Itemrefs[0].IDRef != "c3" {
+		t.Fatalf("spine = %+v", book.Package.Spine.Itemrefs)
+	}
+
+	if _, err := SplitEPUB(context.Background(), input, SplitOptions{OutDir: t.TempDir(), NameTemplate: "volume.epub"}); err == nil {
+		t.Fatalf("expected an error for a template without a number")
+	}
+}
@@ -0,0 +1,97 @@
+package epub
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadMetadataTemplate reads a <metadata> fragment for
+// MergeOptions.MetadataTemplate. It must carry a dc:title and a valid
+// dc:language; an empty dc:identifier is filled in with a generated URN.
+func LoadMetadataTemplate(p string) (*Metadata, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("read metadata template: %w", err)
+	}
+	var meta Metadata
+	if err := xml.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("parse metadata template %s: %w", p, err)
+	}
+	if strings.TrimSpace(firstDCValue(meta.Titles)) == "" {
+		return nil, fmt.Errorf("metadata template %s: missing dc:title", p)
+	}
+	if len(meta.Languages) == 0 {
+		return nil, fmt.Errorf("metadata template %s: missing dc:language", p)
+	}
+	for _, l := range meta.Languages {
+		if _, err := NormalizeLanguage(l.Value); err != nil {
+			return nil, fmt.Errorf("metadata template %s: %w", p, err)
+		}
+	}
+	return &meta, nil
+}
+
+// metadataFromTemplate copies tmpl for the merged package, applying the
+// explicit title/language/creator options and making sure the first
+// identifier has an id and a value. novfmt-managed meta (modified time,
+// cover, source count) is dropped so buildPackage can append fresh values.
+// It returns the metadata and the unique identifier's id.
+func metadataFromTemplate(tmpl *Metadata, opts MergeOptions) (Metadata, string) {
+	meta := Metadata{
+		Titles:       append([]DCMeta(nil), tmpl.Titles...),
+		Creators:     append([]DCMeta(nil), tmpl.Creators...),
+		Languages:    append([]DCMeta(nil), tmpl.Languages...),
+		Identifiers:  append([]DCMeta(nil), tmpl.Identifiers...),
+		Descriptions: append([]DCMeta(nil), tmpl.Descriptions...),
+		Rights:       append([]DCMeta(nil), tmpl.Rights...),
+		Extra:        append([]RawElement(nil), tmpl.Extra...),
+	}
+	// Refinements of template creators go with them when -creator replaces
+	// the list.
+	replaced := map[string]bool{}
+	if len(opts.Creators) > 0 {
+		for _, c := range tmpl.Creators {
+			if c.ID != "" {
+				replaced["#"+c.ID] = true
+			}
+		}
+	}
+	for _, m := range tmpl.Meta {
+		switch {
+		case m.Property == "dcterms:modified",
+			strings.HasPrefix(m.Property, "novfmt:"),
+			strings.EqualFold(m.Name, "cover"),
+			replaced[m.Refines]:
+			continue
+		}
+		meta.Meta = append(meta.Meta, m)
+	}
+
+	if opts.Title != "" {
+		meta.Titles = []DCMeta{{Value: opts.Title}}
+	}
+	if opts.Language != "" {
+		meta.Languages = []DCMeta{{Value: opts.Language}}
+	}
+	for i := range meta.Languages {
+		meta.Languages[i].Value = canonicalLanguage(meta.Languages[i].Value)
+	}
+	if len(opts.Creators) > 0 {
+		var roles []MetaNode
+		meta.Creators, roles = creatorMetadata(opts.Creators)
+		meta.Meta = append(meta.Meta, roles...)
+	}
+
+	if len(meta.Identifiers) == 0 {
+		meta.Identifiers = []DCMeta{{}}
+	}
+	if meta.Identifiers[0].ID == "" {
+		meta.Identifiers[0].ID = "bookid"
+	}
+	if opts.Identifier != "" || strings.TrimSpace(meta.Identifiers[0].Value) == "" {
+		meta.Identifiers[0].Value = bookIdentifier(opts)
+	}
+	return meta, meta.Identifiers[0].ID
+}
@@ -0,0 +1,112 @@
+package epub
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMetadataTemplate = `<metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+  <dc:title>Complete Saga</dc:title>
+  <dc:language>en_US</dc:language>
+  <dc:identifier id="isbn">urn:isbn:9780000000002</dc:identifier>
+  <dc:creator id="tmpl-author">Template Author</dc:creator>
+  <meta refines="#tmpl-author" property="file-as">Author, Template</meta>
+  <dc:publisher>Small Press</dc:publisher>
+  <dc:subject>Fantasy</dc:subject>
+  <meta property="dcterms:modified">2001-01-01T00:00:00Z</meta>
+  <meta property="belongs-to-collection">Saga</meta>
+</metadata>
+`
+
+func writeTemplate(t *testing.T, body string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "meta.xml")
+	if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	return p
+}
+
+func TestLoadMetadataTemplateErrors(t *testing.T) {
+	cases := map[string]string{
+		"not-xml":     `<metadata`,
+		"wrong-root":  `<package/>`,
+		"no-title":    `<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:language>en</dc:language></metadata>`,
+		"no-language": `<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>T</dc:title></metadata>`,
+		"bad-lang":    `<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>T</dc:title><dc:language>english</dc:language></metadata>`,
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadMetadataTemplate(writeTemplate(t, body)); err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
+
+func TestBuildPackageFromTemplate(t *testing.T) {
+	tmpl, err := LoadMetadataTemplate(writeTemplate(t, testMetadataTemplate))
+	if err != nil {
+		t.Fatalf("LoadMetadataTemplate: %v", err)
+	}
+	vols := []*Volume{{
+		DisplayName: "Vol 1",
+		PackageDoc: &PackageDocument{Metadata: Metadata{
+			Titles:    []DCMeta{{Value: "Source Title"}},
+			Languages: []DCMeta{{Value: "ja"}},
+			Creators:  []DCMeta{{Value: "Source Author"}},
+		}},
+	}}
+
+	pkg := buildPackage(vols, Manifest{}, Spine{}, MergeOptions{MetadataTemplate: tmpl, Creators: []string{"Flag Author"}}, "cover")
+
+	meta := pkg.Metadata
+	if meta.Titles[0].Value != "Complete Saga" || pkg.Lang != "en-US" || meta.Languages[0].Value != "en-US" {
+		t.Fatalf("title/lang = %q/%q/%q", meta.Titles[0].Value, pkg.Lang, meta.Languages[0].Value)
+	}
+	if len(meta.Creators) != 1 || meta.Creators[0].Value != "Flag Author" {
+		t.Fatalf("creators = %+v", meta.Creators)
+	}
+	for _, m := range meta.Meta {
+		if m.Refines == "#tmpl-author" {
+			t.Fatalf("refinement of a replaced creator kept: %+v", m)
+		}
+	}
+	if pkg.UniqueIdentifier != "isbn" || meta.Identifiers[0].Value != "urn:isbn:9780000000002" {
+		t.Fatalf("identifier = %s %+v", pkg.UniqueIdentifier, meta.Identifiers)
+	}
+	if len(meta.Extra) != 2 || meta.Extra[0].XMLName.Local != "publisher" {
+		t.Fatalf("extra elements = %+v", meta.Extra)
+	}
+
+	var modified []string
+	var collection, cover bool
+	for _, m := range meta.Meta {
+		switch {
+		case m.Property == "dcterms:modified":
+			modified = append(modified, m.Value)
+		case m.Property == "belongs-to-collection":
+			collection = true
+		case m.Name == "cover":
+			cover = m.Content == "cover"
+		}
+	}
+	if len(modified) != 1 || strings.HasPrefix(modified[0], "2001") {
+		t.Fatalf("dcterms:modified should be regenerated, got %v", modified)
+	}
+	if !collection || !cover {
+		t.Fatalf("meta = %+v", meta.Meta)
+	}
+}
+
+func TestMetadataTemplateFillsIdentifier(t *testing.T) {
+	meta, uid := metadataFromTemplate(&Metadata{
+		Titles:      []DCMeta{{Value: "T"}},
+		Identifiers: []DCMeta{{ID: "pub-id"}},
+	}, MergeOptions{})
+	if uid != "pub-id" || !strings.HasPrefix(meta.Identifiers[0].Value, "urn:uuid:") {
+		t.Fatalf("identifier = %s %+v", uid, meta.Identifiers)
+	}
+}
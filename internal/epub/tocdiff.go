@@ -0,0 +1,208 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Kinds of TOCChange.
+const (
+	TOCAdded    = "added"
+	TOCRemoved  = "removed"
+	TOCMoved    = "moved"
+	TOCRetitled = "retitled"
+	TOCRelinked = "relinked"
+)
+
+// TOCChange is one difference between two books' TOCs. Positions are
+// dotted 1-based paths into the nav tree ("2.3" is the third entry under the
+// second top-level one); the Old fields describe the entry in the first
+// book and are empty for additions.
+type TOCChange struct {
+	Kind        string `json:"kind"`
+	Title       string `json:"title"`
+	Href        string `json:"href,omitempty"`
+	Position    string `json:"position,omitempty"`
+	OldTitle    string `json:"old_title,omitempty"`
+	OldHref     string `json:"old_href,omitempty"`
+	OldPosition string `json:"old_position,omitempty"`
+}
+
+type TOCDiffOptions struct {
+	// IgnoreHrefs matches entries by title alone, so a changed link target
+	// is neither reported nor used to pair entries.
+	IgnoreHrefs bool
+}
+
+// tocEntry is a NavItem flattened for diffing. Parent is the index of the
+// entry it is nested under, or -1 at the top level.
+type tocEntry struct {
+	title   string
+	href    string
+	pos     string
+	parent  int
+	sibling int
+}
+
+// flattenTOC lists a nav tree depth-first with hrefs resolved against
+// navDir, so books that keep their nav in different folders still compare.
+func flattenTOC(items []NavItem, navDir string) []tocEntry {
+	var out []tocEntry
+	var walk func(items []NavItem, prefix string, parent int)
+	walk = func(items []NavItem, prefix string, parent int) {
+		for i, item := range items {
+			pos := prefix + strconv.Itoa(i+1)
+			out = append(out, tocEntry{
+				title:   normalizeSpace(item.Title),
+				href:    joinHref(navDir, item.Href),
+				pos:     pos,
+				parent:  parent,
+				sibling: i,
+			})
+			walk(item.Children, pos+".", len(out)-1)
+		}
+	}
+	walk(items, "", -1)
+	return out
+}
+
+// DiffTOC compares the TOCs of two EPUBs and reports entries added to or
+// removed from b, entries that moved to another parent or out of order
+// among their siblings, and changed titles and link targets. Entries are
+// paired by href first and then by title. Read-only.
+func DiffTOC(ctx context.Context, a, b string, opts TOCDiffOptions) ([]TOCChange, error) {
+	if a == "" || b == "" {
+		return nil, fmt.Errorf("two EPUB paths are required")
+	}
+	var trees [2][]tocEntry
+	for i, input := range []string{a, b} {
+		book, err := OpenBook(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		navHref := book.NavHref
+		items := book.NavItems
+		book.Close()
+		if navHref == "" {
+			return nil, fmt.Errorf("%s: no nav document", input)
+		}
+		trees[i] = flattenTOC(items, path.Dir(navHref))
+	}
+	return diffTOCEntries(trees[0], trees[1], opts), nil
+}
+
+func diffTOCEntries(old, cur []tocEntry, opts TOCDiffOptions) []TOCChange {
+	toCur := make([]int, len(old))
+	toOld := make([]int, len(cur))
+	for i := range toCur {
+		toCur[i] = -1
+	}
+	for i := range toOld {
+		toOld[i] = -1
+	}
+	pair := func(key func(tocEntry) string) {
+		queues := make(map[string][]int)
+		for j, e := range cur {
+			if k := key(e); k != "" && toOld[j] < 0 {
+				queues[k] = append(queues[k], j)
+			}
+		}
+		for i, e := range old {
+			k := key(e)
+			if k == "" || toCur[i] >= 0 || len(queues[k]) == 0 {
+				continue
+			}
+			j := queues[k][0]
+			queues[k] = queues[k][1:]
+			toCur[i], toOld[j] = j, i
+		}
+	}
+	if !opts.IgnoreHrefs {
+		pair(func(e tocEntry) string { return e.href })
+	}
+	pair(func(e tocEntry) string { return strings.ToLower(e.title) })
+
+	moved := make([]bool, len(old))
+	groups := make(map[int][]int)
+	for i, e := range old {
+		j := toCur[i]
+		if j < 0 {
+			continue
+		}
+		p, q := e.parent, cur[j].parent
+		if (p < 0) != (q < 0) || (p >= 0 && toCur[p] != q) {
+			moved[i] = true
+			continue
+		}
+		groups[p] = append(groups[p], i)
+	}
+	for _, group := range groups {
+		order := make([]int, len(group))
+		for k, i := range group {
+			order[k] = cur[toCur[i]].sibling
+		}
+		keep := increasingRun(order)
+		for k, i := range group {
+			if !keep[k] {
+				moved[i] = true
+			}
+		}
+	}
+
+	var changes []TOCChange
+	for i, e := range old {
+		if toCur[i] < 0 {
+			changes = append(changes, TOCChange{Kind: TOCRemoved, Title: e.title, OldHref: e.href, OldPosition: e.pos})
+		}
+	}
+	for j, e := range cur {
+		i := toOld[j]
+		if i < 0 {
+			changes = append(changes, TOCChange{Kind: TOCAdded, Title: e.title, Href: e.href, Position: e.pos})
+			continue
+		}
+		o := old[i]
+		change := TOCChange{Title: e.title, Href: e.href, Position: e.pos, OldTitle: o.title, OldHref: o.href, OldPosition: o.pos}
+		if moved[i] {
+			change.Kind = TOCMoved
+			changes = append(changes, change)
+		}
+		if o.title != e.title {
+			change.Kind = TOCRetitled
+			changes = append(changes, change)
+		}
+		if !opts.IgnoreHrefs && o.href != e.href {
+			change.Kind = TOCRelinked
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// increasingRun marks a longest increasing subsequence of order: the
+// entries that kept their relative order, so only the rest count as moved.
+func increasingRun(order []int) []bool {
+	n := len(order)
+	length := make([]int, n)
+	prev := make([]int, n)
+	best := -1
+	for i := range order {
+		length[i], prev[i] = 1, -1
+		for k := 0; k < i; k++ {
+			if order[k] < order[i] && length[k]+1 > length[i] {
+				length[i], prev[i] = length[k]+1, k
+			}
+		}
+		if best < 0 || length[i] > length[best] {
+			best = i
+		}
+	}
+	keep := make([]bool, n)
+	for i := best; i >= 0; i = prev[i] {
+		keep[i] = true
+	}
+	return keep
+}
@@ -0,0 +1,133 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func describeTOCChanges(changes []TOCChange) string {
+	var lines []string
+	for _, c := range changes {
+		switch c.Kind {
+		case TOCAdded:
+			lines = append(lines, fmt.Sprintf("added %s %s", c.Position, c.Title))
+		case TOCRemoved:
+			lines = append(lines, fmt.Sprintf("removed %s %s", c.OldPosition, c.Title))
+		case TOCMoved:
+			lines = append(lines, fmt.Sprintf("moved %s>%s %s", c.OldPosition, c.Position, c.Title))
+		case TOCRetitled:
+			lines = append(lines, fmt.Sprintf("retitled %s %s>%s", c.Position, c.OldTitle, c.Title))
+		case TOCRelinked:
+			lines = append(lines, fmt.Sprintf("relinked %s %s>%s", c.Position, c.OldHref, c.Href))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDiffTOCEntries(t *testing.T) {
+	old := []NavItem{
+		{Title: "Prologue", Href: "p.xhtml"},
+		{Title: "Part One", Href: "part1.xhtml", Children: []NavItem{
+			{Title: "Chapter 1", Href: "c1.xhtml"},
+			{Title: "Chapter 2", Href: "c2.xhtml"},
+			{Title: "Chapter 3", Href: "c3.xhtml"},
+		}},
+		{Title: "Afterword", Href: "after.xhtml"},
+	}
+	cur := []NavItem{
+		{Title: "Prologue", Href: "p.xhtml"},
+		{Title: "Part One", Href: "part1.xhtml", Children: []NavItem{
+			{Title: "Chapter 2", Href: "c2.xhtml"},
+			{Title: "Chapter 1", Href: "c1.xhtml"},
+			{Title: "Chapter 3: The Storm", Href: "c3.xhtml"},
+		}},
+		{Title: "Chapter 4", Href: "c4.xhtml"},
+		{Title: "Afterword", Href: "afterword.xhtml"},
+	}
+
+	got := describeTOCChanges(diffTOCEntries(flattenTOC(old, "."), flattenTOC(cur, "."), TOCDiffOptions{}))
+	want := strings.Join([]string{
+		"moved 2.2>2.1 Chapter 2",
+		"retitled 2.3 Chapter 3>Chapter 3: The Storm",
+		"added 3 Chapter 4",
+		"relinked 4 after.xhtml>afterword.xhtml",
+	}, "\n")
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = describeTOCChanges(diffTOCEntries(flattenTOC(old, "."), flattenTOC(cur, "."), TOCDiffOptions{IgnoreHrefs: true}))
+	want = strings.Join([]string{
+		"removed 2.3 Chapter 3",
+		"moved 2.2>2.1 Chapter 2",
+		"added 2.3 Chapter 3: The Storm",
+		"added 3 Chapter 4",
+	}, "\n")
+	if got != want {
+		t.Fatalf("ignore hrefs got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if changes := diffTOCEntries(flattenTOC(old, "."), flattenTOC(old, "."), TOCDiffOptions{}); len(changes) != 0 {
+		t.Fatalf("identical TOCs differ: %v", changes)
+	}
+}
+
+func TestDiffTOCEntriesReparented(t *testing.T) {
+	old := []NavItem{
+		{Title: "Part One", Href: "part1.xhtml", Children: []NavItem{{Title: "Interlude", Href: "i.xhtml"}}},
+		{Title: "Part Two", Href: "part2.xhtml"},
+	}
+	cur := []NavItem{
+		{Title: "Part One", Href: "part1.xhtml"},
+		{Title: "Part Two", Href: "part2.xhtml", Children: []NavItem{{Title: "Interlude", Href: "i.xhtml"}}},
+	}
+	got := describeTOCChanges(diffTOCEntries(flattenTOC(old, "."), flattenTOC(cur, "."), TOCDiffOptions{}))
+	if got != "moved 1.1>2.1 Interlude" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func buildTOCEPUB(t *testing.T, navDir, nav string) string {
+	t.Helper()
+	navHref := "nav.xhtml"
+	if navDir != "" {
+		navHref = navDir + "/nav.xhtml"
+	}
+	return buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "TOC",
+			identifier: "urn:test:toc",
+			items: []ManifestItem{
+				testItem("nav", navHref, "nav"),
+				testItem("c1", "Text/c1.xhtml"),
+			},
+			spine: []string{"c1"},
+		}),
+		"OEBPS/" + navHref:    `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol>` + nav + `</ol></nav></body></html>`,
+		"OEBPS/Text/c1.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p></body></html>`,
+	})
+}
+
+func TestDiffTOC(t *testing.T) {
+	a := buildTOCEPUB(t, "", `<li><a href="Text/c1.xhtml">One</a></li><li><a href="Text/c1.xhtml#two">Two</a></li>`)
+	// The same TOC from a nav kept in Text/: hrefs resolve to the same files.
+	b := buildTOCEPUB(t, "Text", `<li><a href="c1.xhtml">One</a></li><li><a href="c1.xhtml#two">Two</a></li>`)
+	changes, err := DiffTOC(context.Background(), a, b, TOCDiffOptions{})
+	if err != nil {
+		t.Fatalf("DiffTOC: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changes)
+	}
+
+	c := buildTOCEPUB(t, "", `<li><a href="Text/c1.xhtml">One</a></li>`)
+	changes, err = DiffTOC(context.Background(), a, c, TOCDiffOptions{})
+	if err != nil {
+		t.Fatalf("DiffTOC: %v", err)
+	}
+	if got := describeTOCChanges(changes); got != "removed 2 Two" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,34 @@
+package epub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// writeIDTrace dumps how a merge remapped ids: for each volume, every source
+// manifest id with the id it became (or why it has none), then the final
+// manifest's id -> href table, generated items included.
+func writeIDTrace(w io.Writer, vols []*Volume, idMaps []map[string]string, manifest Manifest) error {
+	bw := bufio.NewWriter(w)
+	for _, vol := range vols {
+		fmt.Fprintf(bw, "ids: %s (%s)\n", vol.Prefix, vol.SourcePath)
+		idMap := idMaps[vol.Index]
+		for _, item := range vol.PackageDoc.Manifest.Items {
+			newID, ok := idMap[item.ID]
+			switch {
+			case ok:
+				fmt.Fprintf(bw, "  %s -> %s\n", item.ID, newID)
+			case hasProperty(item.Properties, "nav"):
+				fmt.Fprintf(bw, "  %s -> (replaced by the merged nav)\n", item.ID)
+			default:
+				fmt.Fprintf(bw, "  %s -> (dropped)\n", item.ID)
+			}
+		}
+	}
+	fmt.Fprintf(bw, "manifest: %d items\n", len(manifest.Items))
+	for _, item := range manifest.Items {
+		fmt.Fprintf(bw, "  %s = %s\n", item.ID, item.Href)
+	}
+	return bw.Flush()
+}
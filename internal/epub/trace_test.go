@@ -0,0 +1,34 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeEPUBsTraceIDs(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	var trace bytes.Buffer
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, TraceIDs: &trace}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	got := trace.String()
+	for _, want := range []string{
+		"ids: Volumes/v0002 (" + b + ")\n",
+		"  chap -> v0002_chap\n",
+		"  nav -> (replaced by the merged nav)\n",
+		"manifest: 3 items\n",
+		"  v0001_chap = Volumes/v0001/chapter.xhtml\n",
+		"  nav = nav.xhtml\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("trace missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package epub
 
-import "encoding/xml"
+import (
+	"encoding/xml"
+	"io"
+)
 
 const (
 	nsDC  = "http://purl.org/dc/elements/1.1/"
@@ -20,6 +23,9 @@ type PackageDocument struct {
 	Metadata Metadata `xml:"metadata"`
 	Manifest Manifest `xml:"manifest"`
 	Spine    Spine    `xml:"spine"`
+	// Guide is the EPUB 2 guide, kept so a book that has one doesn't lose
+	// it when its package is rewritten. Merged and split books have none.
+	Guide *Guide `xml:"guide,omitempty"`
 }
 
 type Metadata struct {
@@ -29,18 +35,34 @@ type Metadata struct {
 	Languages    []DCMeta   `xml:"http://purl.org/dc/elements/1.1/ language"`
 	Identifiers  []DCMeta   `xml:"http://purl.org/dc/elements/1.1/ identifier"`
 	Descriptions []DCMeta   `xml:"http://purl.org/dc/elements/1.1/ description"`
+	Rights       []DCMeta   `xml:"http://purl.org/dc/elements/1.1/ rights"`
 	Meta         []MetaNode `xml:"meta"`
+	// Extra keeps the children the fields above don't model (dc:publisher,
+	// dc:subject, link, ...) so they survive a read/write round trip.
+	Extra []RawElement `xml:",any"`
+}
+
+type RawElement struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   string     `xml:",innerxml"`
 }
 
 type DCMeta struct {
 	ID     string `xml:"id,attr,omitempty"`
 	Role   string `xml:"opf:role,attr,omitempty"`
 	FileAs string `xml:"opf:file-as,attr,omitempty"`
+	Scheme string `xml:"opf:scheme,attr,omitempty"`
+	Lang   string `xml:"http://www.w3.org/XML/1998/namespace lang,attr,omitempty"`
+	Dir    string `xml:"dir,attr,omitempty"`
 	Value  string `xml:",chardata"`
 }
 
 type MetaNode struct {
+	ID       string `xml:"id,attr,omitempty"`
+	Refines  string `xml:"refines,attr,omitempty"`
 	Property string `xml:"property,attr,omitempty"`
+	Scheme   string `xml:"scheme,attr,omitempty"`
 	Name     string `xml:"name,attr,omitempty"`
 	Content  string `xml:"content,attr,omitempty"`
 	Value    string `xml:",chardata"`
@@ -51,35 +73,324 @@ type Manifest struct {
 }
 
 type ManifestItem struct {
-	ID         string `xml:"id,attr"`
-	Href       string `xml:"href,attr"`
-	MediaType  string `xml:"media-type,attr"`
-	Properties string `xml:"properties,attr,omitempty"`
-	Fallback   string `xml:"fallback,attr,omitempty"`
+	ID           string `xml:"id,attr"`
+	Href         string `xml:"href,attr"`
+	MediaType    string `xml:"media-type,attr"`
+	Properties   string `xml:"properties,attr,omitempty"`
+	Fallback     string `xml:"fallback,attr,omitempty"`
+	MediaOverlay string `xml:"media-overlay,attr,omitempty"`
 }
 
 type Spine struct {
 	ID                       string         `xml:"id,attr,omitempty"`
 	PageProgressionDirection string         `xml:"page-progression-direction,attr,omitempty"`
 	Itemrefs                 []SpineItemRef `xml:"itemref"`
+	// Toc is the manifest id of the NCX. EPUB 3 readers use the nav, but
+	// some still look the NCX up through this attribute.
+	Toc string `xml:"toc,attr,omitempty"`
 }
 
-type SpineItemRef struct {
-	IDRef  string `xml:"idref,attr"`
-	Linear string `xml:"linear,attr,omitempty"`
+type Guide struct {
+	References []GuideReference `xml:"reference"`
 }
 
-type containerRoot struct {
-	Rootfiles []rootfile `xml:"rootfiles>rootfile"`
+type GuideReference struct {
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr,omitempty"`
+	Href  string `xml:"href,attr"`
 }
 
-type rootfile struct {
-	FullPath string `xml:"full-path,attr"`
+type SpineItemRef struct {
+	IDRef  string `xml:"idref,attr"`
+	Linear string `xml:"linear,attr,omitempty"`
 }
 
 type MergeOptions struct {
 	OutPath  string
 	Title    string
 	Language string
+	// Creators replace the volumes' creators. Each is a name, optionally
+	// followed by a MARC relator code ("Name:trl"); plain names are authors.
 	Creators []string
+	// Identifier is used verbatim as the merged book's unique dc:identifier
+	// (and, from a metadata template, replaces its first identifier), so a
+	// book re-merged over time keeps one identity. It must be a URN such as
+	// "urn:uuid:..." or an ISBN. When empty a random urn:uuid is generated.
+	// It can't be set when MaxSize splits the merge into several parts,
+	// since each part is a book of its own.
+	Identifier string
+	// KeepSourceISBNs adds the ISBNs the volumes identify themselves with
+	// (opf:scheme="ISBN", an ISBN identifier-type refinement or a urn:isbn:
+	// value) as further dc:identifiers of the merged book, each with
+	// opf:scheme="ISBN" and an identifier-type refinement. The unique
+	// identifier is still Identifier or the generated URN.
+	KeepSourceISBNs bool
+	// SortCreators lists the merged creators alphabetically instead of in
+	// the order given, or for volume creators the order they first appear.
+	SortCreators bool
+	// WriteChecksum writes the output's SHA-256 to OutPath + ".sha256".
+	WriteChecksum bool
+	// PreserveTimes stamps each archive entry with the modified time its
+	// file had in the source volume (files novfmt generates or rewrites get
+	// the time they were written). Otherwise entry times are left unset.
+	PreserveTimes bool
+	// TempDir is where volumes are extracted and staged (system default when empty).
+	TempDir string
+	// ContentDir and PackageName place the publication inside the archive
+	// (DefaultContentDir and DefaultPackageName when empty), e.g. "EPUB" and
+	// "package.opf" for the EPUB 3 convention.
+	ContentDir  string
+	PackageName string
+	// Compression is the deflate level for the output: CompressionDefault,
+	// CompressionStore to store entries uncompressed, or 1-9.
+	Compression int
+	// StripTitlePrefix is removed from the start of each volume title before
+	// it becomes a TOC entry. StripTitleRegex treats it as a regular expression.
+	StripTitlePrefix string
+	StripTitleRegex  bool
+	// RenameTitleConflicts numbers volume TOC titles that would otherwise
+	// repeat, e.g. "Series (1)", "Series (2)".
+	RenameTitleConflicts bool
+	// TOCTitle is the merged nav's <title> and heading. When empty it is the
+	// usual heading for Language if one is known, else "Table of Contents".
+	TOCTitle string
+	// EmitNCX also writes a toc.ncx of the merged TOC, named by the spine's
+	// toc attribute, for older readers and Kindle conversion. It has no
+	// effect when a source nav is kept, which brings its own.
+	EmitNCX bool
+	// Arcs, when set, holds a story arc name for each source, in order.
+	// Consecutive volumes of one arc are nested under a heading entry named
+	// after it in the nav; volumes with an empty arc stay at the top level.
+	// The spine keeps the input order either way.
+	Arcs []string
+	// CollapseTOC folds nav entries that have a single child into one entry
+	// (see collapseNavItems), flattening redundant levels of nesting.
+	CollapseTOC bool
+	// DedupeNav drops generated nav entries that repeat an earlier entry's
+	// title and href (see dedupeNavItems). Applied before CollapseTOC.
+	DedupeNav bool
+	// Rights sets the merged dc:rights, replacing whatever the volumes or
+	// the metadata template carry. When empty, RightsFrom picks among the
+	// volumes' statements: RightsFirst (default), RightsLast, or RightsAll
+	// to keep every distinct one.
+	Rights     string
+	RightsFrom string
+	// Publisher and Description set the merged dc:publisher and
+	// dc:description. When empty, each is taken from the first volume that
+	// has one, as are dc:date and (with the default RightsFrom) dc:rights;
+	// dc:subject lists every volume's subjects once. A metadata template's
+	// own values are kept.
+	Publisher   string
+	Description string
+	// Collection names the series the merged book belongs to, written as a
+	// belongs-to-collection of type "set". When empty it is the collection
+	// the volumes declare (opts.Title, else the first volume's, if they
+	// disagree); a metadata template's own collection is kept. The volumes'
+	// group-position refinements are dropped: the merged book is one unit.
+	Collection string
+	// RewriteRules, when set, are applied to each volume's XHTML content
+	// documents before they are staged, as RewriteEPUB does with
+	// RewriteScopeBody (the volumes' own nav documents are left out unless
+	// PreserveNav keeps one); MergeStats.Rewrite sums the results.
+	RewriteRules []RewriteRule
+	// StripComments removes comments from the volumes' content documents
+	// in the same pass; MergeStats.Rewrite.CommentsRemoved counts them.
+	StripComments bool
+	// Cover selects the merged cover: CoverFirst (default) adopts the first
+	// volume's cover and CoverLast the last one's, CoverNone leaves the book
+	// without one, and CoverGrid tiles every volume's cover into one image
+	// laid out in CoverColumns columns (0 = automatic) over CoverBackground
+	// (a #rrggbb color, default white). Anything else is the path of a
+	// JPEG, PNG, GIF, WebP or SVG image to use instead, copied into the
+	// book as cover.<ext>.
+	Cover           string
+	CoverColumns    int
+	CoverBackground string
+	// PageProgression sets the merged spine's page-progression-direction:
+	// "ltr", "rtl" or "default". When empty the first volume that declares
+	// one decides, and a warning is added if rtl volumes are mixed with ones
+	// that aren't.
+	PageProgression string
+	// ReadingOrder picks whether each volume contributes its spine as-is
+	// (ReadingOrderSpine, default) or reordered to follow its nav.
+	ReadingOrder ReadingOrder
+	// ManifestOrder orders each volume's items in the merged manifest:
+	// ManifestOrderSource (default) as in the volume, or sorted by href or
+	// by media type and href for stable, readable package documents.
+	ManifestOrder ManifestOrder
+	// PreserveNav keeps the source nav document, with its landmarks, page
+	// list and styling, instead of generating one. That only works when the
+	// output holds a single volume (which PreserveNav allows as input, for
+	// repackaging one book) that has a nav and no IndexPage is requested;
+	// otherwise the nav is generated as usual and a warning says why.
+	PreserveNav bool
+	// Landmarks adds a landmarks nav to the generated nav, listing the
+	// table of contents and a bodymatter entry for where each volume's story
+	// starts (skipping covers, title pages and contents pages). The first
+	// volume's is the book's "begin reading" point. Ignored when a source
+	// nav is kept.
+	Landmarks bool
+	// CoverEntries makes each volume's cover page (its cover landmark, or a
+	// first spine document that shows the cover image or only an image)
+	// the first entry under the volume in the nav, titled CoverEntryTitle
+	// ("Cover" when empty). Entries of the volume's own nav that just link
+	// to the cover page are dropped.
+	CoverEntries    bool
+	CoverEntryTitle string
+	// VolumeDividers starts each volume with a generated page showing its
+	// title, Volumes/<folder>/_divider.xhtml, which its nav entry then
+	// links to. The page is styled inline, so it looks the same whatever
+	// the volumes' stylesheets.
+	VolumeDividers bool
+	// GuessCover gives a volume that declares no cover (no cover meta or
+	// cover-image item) the image of its first spine document as its cover,
+	// when that page is a single full-page image (see guessCoverID).
+	GuessCover bool
+	// VolumeDirTemplate names each volume's folder under Volumes/, with
+	// {index} (or {index:N}, zero-padded to N digits) for the volume number
+	// and {title} for its title made safe for file names. Empty means
+	// DefaultVolumeDirTemplate. Manifest ids keep their vNNNN_ prefix
+	// whatever the folders are called.
+	VolumeDirTemplate string
+	// NormalizeHeadings, when set, retags each volume's headings so its
+	// chapters (the highest heading level it uses) start at the same level:
+	// "h1"-"h6", or HeadingsAuto for the level most volumes already use.
+	// Lower levels move by the same amount, stopping at h6.
+	NormalizeHeadings string
+	// Illustrations, when set, adds a list of illustrations nav to the
+	// merged book, grouped by volume. It names where to find each volume's
+	// illustrations: IllustrationsAuto, or a comma-separated list of
+	// IllustrationsLOI, IllustrationsTOC and IllustrationsPages, tried in
+	// order until one finds any.
+	Illustrations string
+	// IllustrationPattern is the regular expression TOC titles must match
+	// for IllustrationsTOC (DefaultIllustrationPattern when empty).
+	IllustrationPattern string
+	// ProgressAnchors, when positive, gives every ProgressAnchors-th
+	// paragraph of each linear spine document an id (keeping any it has)
+	// and lists them, numbered in reading order, in a hidden page-list nav,
+	// so readers that count progress by page list can report it more finely.
+	ProgressAnchors int
+	// Interleave alternates the spine documents of exactly two volumes, for
+	// parallel-text editions, and groups each pair in the nav. Both volumes
+	// must have the same number of spine documents.
+	Interleave bool
+	// MetadataTemplate, when set, is the base of the merged <metadata> in
+	// place of values taken from the volumes (see LoadMetadataTemplate).
+	// Title, Language and Creators still override it when given.
+	MetadataTemplate *Metadata
+	// NoToolMeta leaves out the novfmt:source-count meta and the novfmt
+	// prefix declaration, so the package uses only standard vocabularies.
+	NoToolMeta bool
+	// DedupeImages collapses byte-identical images across volumes into one
+	// manifest item and redirects references to it. Each volume's cover is
+	// always kept as its own item.
+	DedupeImages bool
+	// DedupeResources stores byte-identical stylesheets, fonts and images
+	// that several volumes ship once, in a shared Shared/ folder, and points
+	// every reference at that copy. Content documents stay in their
+	// volumes, and so does each volume's cover. It covers DedupeImages. Off
+	// by default, since some readers mishandle references that leave a
+	// volume's folder.
+	DedupeResources bool
+	// FlattenImages, when positive, inlines raster images of at most this
+	// many bytes as data URIs in the <img> elements that show them, and
+	// drops their files and manifest items. Covers and images referenced
+	// any other way (CSS, SVG, srcset, links) are kept as files.
+	FlattenImages int64
+	// IndexPage adds a generated landing page at the start of the spine that
+	// links to each volume; IndexCovers also shows each volume's cover there.
+	IndexPage   bool
+	IndexCovers bool
+	// Verify re-reads the output and checks each volume section on its own
+	// (see VerifyMerged), filling MergeStats.Verification.
+	Verify bool
+	// ValidateAfter runs ValidateEPUB on the output once it is written,
+	// filling MergeStats.Validation.
+	ValidateAfter bool
+	// DryRun stages the merge but writes no output; MergeStats.StagedBytes
+	// and EstimatedBytes report how big it would be.
+	DryRun bool
+	// PrintOPF, with DryRun, keeps the generated package document and nav
+	// in MergeStats.Generated so they can be inspected.
+	PrintOPF bool
+	// Progress, when set, is kept up to date while the merge runs.
+	Progress *MergeProgress
+	// TraceIDs, when set, receives the id remapping table: each volume's
+	// source ids with the merged ids that replace them, then the final
+	// manifest.
+	TraceIDs io.Writer
+	// Threads caps how many volumes are extracted concurrently
+	// (GOMAXPROCS when <= 0).
+	Threads int
+	// Kobo (experimental) wraps each sentence of the spine documents in the
+	// koboSpan spans Kobo readers use for kepubs, and renames OutPath to end
+	// in .kepub.epub.
+	Kobo bool
+	// MaxSize, when positive, splits the output into parts of at most this
+	// many bytes (judged by the source files' sizes), named like
+	// merged.part01.epub. Parts break only between volumes; a volume larger
+	// than MaxSize gets a part of its own and a warning.
+	MaxSize int64
+}
+
+// GeneratedFile is a file the merge generated, by its path in the archive.
+type GeneratedFile struct {
+	Path string
+	Data []byte
+}
+
+type MergeStats struct {
+	OutPath string
+	Volumes int
+	SHA256  string
+	// StagedBytes is the total size of the staged files and EstimatedBytes
+	// a rough size of the archive they'd zip into. Only set for DryRun.
+	StagedBytes    int64
+	EstimatedBytes int64
+	// DedupedItems and DedupedBytes count the duplicate resources dropped
+	// by deduplication and the bytes they took up.
+	DedupedItems int
+	DedupedBytes int64
+	// GuessedCovers lists the volumes GuessCover found a cover for.
+	GuessedCovers []string
+	// FlattenedImages counts the images FlattenImages inlined, and
+	// FlattenedBytes the resulting change in size: the data URIs added to
+	// documents less the files removed, negative when the book shrank.
+	FlattenedImages int
+	FlattenedBytes  int64
+	// Generated holds the package document and, unless a source nav was
+	// kept, the nav, when MergeOptions.DryRun and PrintOPF are set.
+	Generated []GeneratedFile
+	// Verification holds one check per volume when MergeOptions.Verify is set.
+	Verification []VolumeCheck
+	// Validation holds the issues ValidateEPUB found in the output when
+	// MergeOptions.ValidateAfter is set (for every part, with MaxSize).
+	Validation []ValidationIssue
+	// KoboDocuments counts the documents given kobo spans.
+	KoboDocuments int
+	// HeadingsRetagged counts the headings NormalizeHeadings changed.
+	HeadingsRetagged int
+	// LanguageTagged counts the documents given their volume's language,
+	// which happens when the volumes are in different languages.
+	LanguageTagged int
+	// Illustrations counts the entries in the list of illustrations.
+	Illustrations int
+	// ProgressAnchors counts the entries of the progress page list, and
+	// ProgressAnchorsAdded the paragraph ids added for them.
+	ProgressAnchors      int
+	ProgressAnchorsAdded int
+	// NavDuplicatesRemoved counts the nav entries DedupeNav dropped.
+	NavDuplicatesRemoved int
+	// Rewrite counts the MergeOptions.RewriteRules matches over all volumes.
+	// Its ChangedFiles are hrefs in the merged package.
+	Rewrite RewriteStats
+	// MediaTypeFixes lists source manifest items that had no media-type or
+	// a non-canonical one.
+	MediaTypeFixes []MediaTypeFix
+	// Parts holds each part's own stats when MergeOptions.MaxSize split the
+	// output; the fields above then sum or collect over all parts.
+	Parts []MergeStats
+	// Warnings are problems that didn't stop the merge.
+	Warnings []string
 }
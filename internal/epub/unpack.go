@@ -0,0 +1,85 @@
+package epub
+
+import (
+	"compress/flate"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Unpack extracts an EPUB into dir, keeping the archive's directory
+// structure, so its files can be inspected or edited by hand and repacked
+// with Pack. dir is created if needed and must otherwise be empty. It
+// returns the number of files written.
+func Unpack(ctx context.Context, input, dir string) (int, error) {
+	if input == "" {
+		return 0, fmt.Errorf("input EPUB path is required")
+	}
+	if dir == "" {
+		return 0, fmt.Errorf("output directory is required")
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return 0, fmt.Errorf("%s is not empty", dir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	if err := unzip(input, dir); err != nil {
+		return 0, fmt.Errorf("extract %s: %w", input, err)
+	}
+
+	files := 0
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files++
+		}
+		return err
+	})
+	return files, err
+}
+
+// Pack zips a directory laid out like an unpacked EPUB into outPath, with the
+// mimetype entry first, stored and reading application/epub+zip, and the
+// other files in sorted order. The directory must hold a
+// META-INF/container.xml whose rootfile is a package document that parses;
+// nothing is written otherwise. The directory itself is not modified.
+func Pack(ctx context.Context, dir, outPath string) error {
+	if dir == "" {
+		return fmt.Errorf("input directory is required")
+	}
+	if outPath == "" {
+		return fmt.Errorf("output EPUB path is required")
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	if _, err := readPackageInfo(dir, func(name string) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	}); err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(outPath); err == nil {
+		if absDir, err := filepath.Abs(dir); err == nil && isWithinDir(absDir, abs) {
+			return fmt.Errorf("output %s is inside the directory being packed", outPath)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := ensureParentDir(outPath); err != nil {
+		return err
+	}
+	return writeArchive(dir, outPath, zipOptions{level: flate.DefaultCompression, canonical: true})
+}
@@ -0,0 +1,98 @@
+package epub
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnpackPack(t *testing.T) {
+	input := buildTestEPUB(t, "Vol 1", "en")
+	dir := filepath.Join(t.TempDir(), "book")
+	files, err := Unpack(context.Background(), input, dir)
+	if err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+	if files == 0 {
+		t.Fatal("Unpack wrote no files")
+	}
+	if _, err := Unpack(context.Background(), input, dir); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Fatalf("Unpack into a full directory: %v", err)
+	}
+
+	// Hand edits: a stray mimetype and a changed chapter.
+	if err := os.WriteFile(filepath.Join(dir, "mimetype"), []byte("application/zip\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	book, err := OpenBook(context.Background(), input)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	chapter := book.Package.Spine.Itemrefs[0].IDRef
+	var href string
+	for _, item := range book.Package.Manifest.Items {
+		if item.ID == chapter {
+			href = item.Href
+		}
+	}
+	pkgDir := filepath.Dir(filepath.Join(dir, filepath.FromSlash(book.PackagePath)))
+	book.Close()
+	edited := `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Edited</p></body></html>`
+	if err := os.WriteFile(filepath.Join(pkgDir, filepath.FromSlash(href)), []byte(edited), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out", "book.epub")
+	if err := Pack(context.Background(), dir, out); err != nil {
+		t.Fatalf("Pack: %v", err)
+	}
+	if problems, err := checkMimetype(out); err != nil || len(problems) != 0 {
+		t.Fatalf("packed mimetype: %v, %v", problems, err)
+	}
+	r, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	second := r.File[1].Name
+	r.Close()
+	if second != "META-INF/container.xml" {
+		t.Fatalf("second entry = %s", second)
+	}
+	book, err = OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook packed: %v", err)
+	}
+	defer book.Close()
+	if data, err := book.ReadFile(href); err != nil || string(data) != edited {
+		t.Fatalf("packed chapter = %s, %v", data, err)
+	}
+
+	if err := Pack(context.Background(), dir, filepath.Join(dir, "self.epub")); err == nil {
+		t.Fatal("expected an error packing into the packed directory")
+	}
+}
+
+func TestPackRequiresPackage(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(t.TempDir(), "book.epub")
+	if err := Pack(context.Background(), dir, out); err == nil || !strings.Contains(err.Error(), "container.xml") {
+		t.Fatalf("Pack without container.xml: %v", err)
+	}
+
+	container := `<?xml version="1.0"?><container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`
+	if err := os.MkdirAll(filepath.Join(dir, "META-INF"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "META-INF", "container.xml"), []byte(container), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Pack(context.Background(), dir, out); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("Pack without the package document: %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("output written for an invalid directory: %v", err)
+	}
+}
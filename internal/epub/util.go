@@ -1,6 +1,11 @@
 package epub
 
-import "strings"
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+)
 
 func hasProperty(props, target string) bool {
 	for _, token := range strings.Fields(props) {
@@ -23,3 +28,86 @@ func addProperty(props, target string) string {
 	}
 	return props + " " + target
 }
+
+func removeProperty(props, target string) string {
+	fields := strings.Fields(props)
+	out := fields[:0]
+	for _, token := range fields {
+		if token != target {
+			out = append(out, token)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// workerCount resolves a Threads option: values <= 0 mean GOMAXPROCS, and no
+// more workers than jobs are started.
+func workerCount(threads, jobs int) int {
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	if threads > jobs {
+		threads = jobs
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	return threads
+}
+
+// parallelFor calls fn(i) for every i in [0, n) on up to threads goroutines.
+// After the first error, or once ctx is done, no new calls start; the first
+// error (or ctx.Err()) is returned after running calls finish.
+func parallelFor(ctx context.Context, n, threads int, fn func(i int) error) error {
+	if n == 0 {
+		return ctx.Err()
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	jobs := make(chan int)
+	for w := workerCount(threads, n); w > 0; w-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if failed() {
+					continue
+				}
+				if err := fn(i); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			fail(err)
+			break
+		}
+		if failed() {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,62 @@
+package epub
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallelFor(t *testing.T) {
+	var (
+		running, peak int32
+		seen          = make([]int32, 50)
+	)
+	err := parallelFor(context.Background(), len(seen), 3, func(i int) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		atomic.AddInt32(&seen[i], 1)
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("parallelFor: %v", err)
+	}
+	if peak > 3 {
+		t.Fatalf("peak concurrency %d exceeds 3 threads", peak)
+	}
+	for i, n := range seen {
+		if n != 1 {
+			t.Fatalf("job %d ran %d times", i, n)
+		}
+	}
+}
+
+func TestParallelForStopsOnError(t *testing.T) {
+	boom := errors.New("boom")
+	var calls int32
+	err := parallelFor(context.Background(), 100, 1, func(i int) error {
+		atomic.AddInt32(&calls, 1)
+		if i == 2 {
+			return boom
+		}
+		return nil
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("err = %v", err)
+	}
+	if calls > 4 {
+		t.Fatalf("%d calls after the first error", calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := parallelFor(ctx, 5, 2, func(int) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled ctx: err = %v", err)
+	}
+}
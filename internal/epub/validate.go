@@ -0,0 +1,304 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Severities of a ValidationIssue. Errors break the book in some readers;
+// warnings are worth fixing but usually harmless.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// ValidationIssue is one structural problem found by ValidateEPUB. Path is
+// the manifest href (or archive entry) it concerns, if any.
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+}
+
+// CountIssues returns how many issues are errors and how many warnings.
+func CountIssues(issues []ValidationIssue) (errors, warnings int) {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			errors++
+		} else {
+			warnings++
+		}
+	}
+	return errors, warnings
+}
+
+// ValidateEPUB checks the structure of an EPUB: the mimetype entry, the
+// required metadata, that manifest files exist and ids are unique, that the
+// spine and id references resolve, that the cover is an image, that there is
+// exactly one nav whose links resolve, and that XHTML documents are
+// well-formed. It is not a replacement for epubcheck, only a quick check for
+// the mistakes a merge or edit could make. The error is for I/O failures;
+// problems with the book, including one too broken to open, are issues.
+// Read-only.
+func ValidateEPUB(ctx context.Context, input string) ([]ValidationIssue, error) {
+	if input == "" {
+		return nil, fmt.Errorf("input EPUB path is required")
+	}
+	var issues []ValidationIssue
+	report := func(severity, p, format string, args ...any) {
+		issues = append(issues, ValidationIssue{Severity: severity, Path: p, Message: fmt.Sprintf(format, args...)})
+	}
+
+	problems, err := checkMimetype(input)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range problems {
+		report(SeverityError, "mimetype", "%s", p)
+	}
+
+	book, err := OpenBook(ctx, input)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		report(SeverityError, "", "%v", err)
+		return issues, nil
+	}
+	defer book.Close()
+	for _, w := range book.Warnings {
+		report(SeverityWarning, "", "%s", w)
+	}
+
+	pkg := book.Package
+	opf := book.PackagePath
+	if pkg.Version == "" {
+		report(SeverityError, opf, "package has no version")
+	}
+	if len(pkg.Metadata.Titles) == 0 || strings.TrimSpace(pkg.Metadata.Titles[0].Value) == "" {
+		report(SeverityError, opf, "no dc:title")
+	}
+	if len(pkg.Metadata.Languages) == 0 || strings.TrimSpace(pkg.Metadata.Languages[0].Value) == "" {
+		report(SeverityError, opf, "no dc:language")
+	}
+	uid := false
+	for _, id := range pkg.Metadata.Identifiers {
+		if pkg.UniqueIdentifier != "" && id.ID == pkg.UniqueIdentifier && strings.TrimSpace(id.Value) != "" {
+			uid = true
+		}
+	}
+	if !uid {
+		report(SeverityError, opf, "unique-identifier %q names no dc:identifier", pkg.UniqueIdentifier)
+	}
+
+	items := make(map[string]ManifestItem, len(pkg.Manifest.Items))
+	hrefs := make(map[string]string, len(pkg.Manifest.Items))
+	navs := 0
+	for _, item := range pkg.Manifest.Items {
+		if _, dup := items[item.ID]; dup {
+			report(SeverityError, item.Href, "manifest id %q is used more than once", item.ID)
+		}
+		items[item.ID] = item
+		href := normalizeEPUBPath(item.Href)
+		if other, dup := hrefs[href]; dup {
+			report(SeverityError, item.Href, "manifest items %q and %q share an href", other, item.ID)
+		} else {
+			hrefs[href] = item.ID
+		}
+		if strings.TrimSpace(item.MediaType) == "" {
+			report(SeverityError, item.Href, "manifest item %q has no media-type", item.ID)
+		}
+		if !book.Exists(item.Href) {
+			report(SeverityError, item.Href, "manifest item %q: file is missing", item.ID)
+		}
+		if hasProperty(item.Properties, "nav") {
+			navs++
+		}
+	}
+	for _, item := range pkg.Manifest.Items {
+		if item.Fallback != "" {
+			if _, ok := items[item.Fallback]; !ok {
+				report(SeverityError, item.Href, "fallback %q has no manifest item", item.Fallback)
+			}
+		}
+		if item.MediaOverlay != "" {
+			if _, ok := items[item.MediaOverlay]; !ok {
+				report(SeverityError, item.Href, "media-overlay %q has no manifest item", item.MediaOverlay)
+			}
+		}
+	}
+	if strings.HasPrefix(pkg.Version, "3") && navs != 1 {
+		report(SeverityError, opf, "EPUB 3 needs exactly one nav item, found %d", navs)
+	}
+
+	if len(pkg.Spine.Itemrefs) == 0 {
+		report(SeverityError, opf, "spine is empty")
+	}
+	inSpine := make(map[string]bool, len(pkg.Spine.Itemrefs))
+	for _, ref := range pkg.Spine.Itemrefs {
+		if inSpine[ref.IDRef] {
+			report(SeverityError, opf, "spine lists %q more than once", ref.IDRef)
+		}
+		inSpine[ref.IDRef] = true
+		if _, ok := items[ref.IDRef]; !ok {
+			report(SeverityError, opf, "spine itemref %q has no manifest item", ref.IDRef)
+		}
+	}
+	if pkg.Spine.Toc != "" {
+		if _, ok := items[pkg.Spine.Toc]; !ok {
+			report(SeverityError, opf, "spine toc %q has no manifest item", pkg.Spine.Toc)
+		}
+	}
+	if issue, ok := coverIssue(pkg, book.CoverID); ok {
+		issues = append(issues, issue)
+	}
+
+	navDir := path.Dir(book.NavHref)
+	var walk func(navItems []NavItem)
+	walk = func(navItems []NavItem) {
+		for _, n := range navItems {
+			walk(n.Children)
+			base, _, _ := strings.Cut(n.Href, "#")
+			if base == "" || isAbsoluteURL(base) {
+				continue
+			}
+			if unescaped, err := url.PathUnescape(base); err == nil {
+				base = unescaped
+			}
+			if _, ok := hrefs[normalizeEPUBPath(path.Join(navDir, base))]; !ok {
+				report(SeverityError, book.NavHref, "nav link %q points outside the manifest", n.Href)
+			}
+		}
+	}
+	walk(book.NavItems)
+
+	for _, item := range pkg.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" || !book.Exists(item.Href) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := book.ReadFile(item.Href)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkWellFormed(data); err != nil {
+			report(SeverityError, item.Href, "not well-formed XHTML: %v", err)
+		}
+	}
+	return issues, nil
+}
+
+// validateVolume checks a loaded source volume for the problems that would
+// otherwise only show up as a broken merge: mimetype, manifest files that
+// aren't in the archive, spine itemrefs without a manifest item and a cover
+// that isn't an image. The mimetype is rewritten by every command that
+// writes a book, so its problems are only warnings here.
+func validateVolume(vol *Volume) []ValidationIssue {
+	var issues []ValidationIssue
+	report := func(severity, p, format string, args ...any) {
+		issues = append(issues, ValidationIssue{Severity: severity, Path: p, Message: fmt.Sprintf(format, args...)})
+	}
+
+	problems, err := checkMimetype(vol.SourcePath)
+	if err != nil {
+		report(SeverityError, "mimetype", "%v", err)
+	}
+	for _, p := range problems {
+		report(SeverityWarning, "mimetype", "%s", p)
+	}
+
+	pkg := vol.PackageDoc
+	items := make(map[string]bool, len(pkg.Manifest.Items))
+	for _, item := range pkg.Manifest.Items {
+		items[item.ID] = true
+		if !volumeFileExists(vol, item.Href) {
+			report(SeverityError, item.Href, "manifest item %q: file is missing", item.ID)
+		}
+	}
+	for _, ref := range pkg.Spine.Itemrefs {
+		if !items[ref.IDRef] {
+			report(SeverityError, vol.PackagePath, "spine itemref %q has no manifest item", ref.IDRef)
+		}
+	}
+	if issue, ok := coverIssue(pkg, vol.CoverID); ok {
+		issue.Path = vol.PackagePath
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+// checkSourceVolume runs validateVolume on a volume about to be merged and
+// adds what it found to stats.Warnings, so problems in a source are
+// reported up front. None of them stop the merge: books with a stray
+// manifest entry or an EPUB 2 cover meta naming the cover page merged fine
+// before these checks existed.
+func checkSourceVolume(vol *Volume, stats *MergeStats) {
+	for _, issue := range validateVolume(vol) {
+		msg := issue.Message
+		if issue.Path != "" {
+			msg = issue.Path + ": " + msg
+		}
+		stats.Warnings = append(stats.Warnings, vol.SourcePath+": "+msg)
+	}
+}
+
+// volumeFileExists reports whether the extracted volume has the file at a
+// package-relative href, which may be percent-encoded.
+func volumeFileExists(vol *Volume, href string) bool {
+	base, _, _ := strings.Cut(href, "#")
+	candidates := []string{base}
+	if unescaped, err := url.PathUnescape(base); err == nil && unescaped != base {
+		candidates = append(candidates, unescaped)
+	}
+	for _, c := range candidates {
+		if info, err := os.Stat(filepath.Join(vol.PackageDir, filepath.FromSlash(c))); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// coverIssue reports a cover id (from the cover meta or the cover-image
+// property) that names no manifest item, or one that isn't an image. Both
+// are warnings: many EPUB 2 books point the cover meta at their cover page.
+func coverIssue(pkg *PackageDocument, coverID string) (ValidationIssue, bool) {
+	if coverID == "" {
+		return ValidationIssue{}, false
+	}
+	for _, item := range pkg.Manifest.Items {
+		if item.ID != coverID {
+			continue
+		}
+		if isImageItem(item) {
+			return ValidationIssue{}, false
+		}
+		return ValidationIssue{Severity: SeverityWarning, Path: item.Href, Message: fmt.Sprintf("cover item %q is %s, not an image", item.ID, item.MediaType)}, true
+	}
+	return ValidationIssue{Severity: SeverityWarning, Message: fmt.Sprintf("cover %q has no manifest item", coverID)}, true
+}
+
+// checkWellFormed parses data as strict XML. HTML entities such as &nbsp;
+// are errors here, as they are to XHTML readers.
+func checkWellFormed(data []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = passthroughCharsetReader
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
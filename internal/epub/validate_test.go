@@ -0,0 +1,154 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateEPUB(t *testing.T) {
+	broken := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="BookId" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Broken</dc:title>
+    <dc:identifier id="OtherId">urn:test:broken</dc:identifier>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="c1" href="c1.xhtml" media-type="application/xhtml+xml" fallback="gone"/>
+    <item id="c2" href="c2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="missing" href="missing.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="c1"/>
+    <itemref idref="c1"/>
+    <itemref idref="nowhere"/>
+  </spine>
+</package>
+`,
+		"OEBPS/nav.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="c1.xhtml">One</a></li><li><a href="lost.xhtml">Lost</a></li></ol></nav></body></html>`,
+		"OEBPS/c1.xhtml":  `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One&nbsp;two</p></body></html>`,
+		"OEBPS/c2.xhtml":  `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Fine</p></body></html>`,
+	})
+	issues, err := ValidateEPUB(context.Background(), broken)
+	if err != nil {
+		t.Fatalf("ValidateEPUB: %v", err)
+	}
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.Severity+" "+issue.Path+": "+issue.Message)
+	}
+	all := strings.Join(got, "\n")
+	for _, want := range []string{
+		"error OEBPS/content.opf: no dc:language",
+		`error OEBPS/content.opf: unique-identifier "BookId" names no dc:identifier`,
+		`error missing.xhtml: manifest item "missing": file is missing`,
+		`error c1.xhtml: fallback "gone" has no manifest item`,
+		`error OEBPS/content.opf: spine lists "c1" more than once`,
+		`error OEBPS/content.opf: spine itemref "nowhere" has no manifest item`,
+		`error nav.xhtml: nav link "lost.xhtml" points outside the manifest`,
+		"error c1.xhtml: not well-formed XHTML",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("missing %q in:\n%s", want, all)
+		}
+	}
+	if strings.Contains(all, "c2.xhtml") {
+		t.Errorf("c2.xhtml is fine:\n%s", all)
+	}
+	if errs, warns := CountIssues(issues); errs != len(issues) || warns != 0 {
+		t.Errorf("CountIssues = %d, %d", errs, warns)
+	}
+}
+
+func TestMergeEPUBsValidateAfter(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, ValidateAfter: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if len(stats.Validation) != 0 {
+		t.Fatalf("merged book has issues: %+v", stats.Validation)
+	}
+
+	notEPUB := writeRawZip(t, [][2]string{{"hello.txt", "hi"}})
+	issues, err := ValidateEPUB(context.Background(), notEPUB)
+	if err != nil {
+		t.Fatalf("ValidateEPUB: %v", err)
+	}
+	if errs, _ := CountIssues(issues); errs < 2 {
+		t.Fatalf("expected mimetype and container errors, got %+v", issues)
+	}
+}
+
+func TestMergeEPUBsChecksSources(t *testing.T) {
+	broken := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Broken",
+			identifier: "urn:test:broken",
+			metadata: []string{
+				`<meta name="cover" content="c1"/>`,
+			},
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				testItem("c1", "c1.xhtml"),
+				testItem("img", "Images/plate%201.png"),
+				testItem("missing", "missing.css"),
+			},
+			spine: []string{"c1", "nowhere"},
+		}),
+		"OEBPS/nav.xhtml":          `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="c1.xhtml">One</a></li></ol></nav></body></html>`,
+		"OEBPS/c1.xhtml":           `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One</p></body></html>`,
+		"OEBPS/Images/plate 1.png": "png",
+	})
+
+	issues, err := ValidateEPUB(context.Background(), broken)
+	if err != nil {
+		t.Fatalf("ValidateEPUB: %v", err)
+	}
+	found := false
+	for _, issue := range issues {
+		if issue.Severity == SeverityWarning && issue.Message == `cover item "c1" is application/xhtml+xml, not an image` {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("ValidateEPUB missed the cover: %+v", issues)
+	}
+
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	stats, err := MergeEPUBs(context.Background(), []string{broken, buildTestEPUB(t, "Fine", "en")}, MergeOptions{OutPath: out})
+	if err != nil {
+		t.Fatalf("a source with problems should still merge: %v", err)
+	}
+	warnings := strings.Join(stats.Warnings, "\n")
+	for _, want := range []string{
+		`missing.css: manifest item "missing": file is missing`,
+		`spine itemref "nowhere" has no manifest item`,
+		`cover item "c1" is application/xhtml+xml, not an image`,
+	} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("warnings lack %q:\n%s", want, warnings)
+		}
+	}
+	if strings.Contains(warnings, "plate") {
+		t.Errorf("percent-encoded href should resolve:\n%s", warnings)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, out)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	for _, item := range vol.PackageDoc.Manifest.Items {
+		if hasProperty(item.Properties, "cover-image") && !isImageItem(item) {
+			t.Errorf("%s became the cover image", item.Href)
+		}
+	}
+}
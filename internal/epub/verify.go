@@ -0,0 +1,155 @@
+package epub
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// VolumeCheck is the result of verifying one Volumes/vNNNN section of a
+// merged book.
+type VolumeCheck struct {
+	Prefix    string
+	Documents int
+	Problems  []string
+}
+
+func (c VolumeCheck) OK() bool { return len(c.Problems) == 0 }
+
+// VerifyMerged reopens a merged EPUB and checks every volume section on its
+// own: each manifest file exists, each spine itemref resolves, and each nav
+// link into the section points at a manifest document (and an existing id
+// when it has a fragment).
+func VerifyMerged(ctx context.Context, input string) ([]VolumeCheck, error) {
+	book, err := OpenBook(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	checks := map[string]*VolumeCheck{}
+	section := func(href string) *VolumeCheck {
+		parts := strings.SplitN(normalizeEPUBPath(href), "/", 3)
+		if len(parts) < 2 || parts[0] != "Volumes" {
+			return nil
+		}
+		prefix := parts[0] + "/" + parts[1]
+		c, ok := checks[prefix]
+		if !ok {
+			c = &VolumeCheck{Prefix: prefix}
+			checks[prefix] = c
+		}
+		return c
+	}
+
+	pkg := book.Package
+	items := make(map[string]ManifestItem)
+	hrefs := make(map[string]bool)
+	for _, item := range pkg.Manifest.Items {
+		items[item.ID] = item
+		hrefs[normalizeEPUBPath(item.Href)] = true
+		c := section(item.Href)
+		if c == nil {
+			continue
+		}
+		if !book.Exists(item.Href) {
+			c.Problems = append(c.Problems, fmt.Sprintf("manifest item %s: %s is missing", item.ID, item.Href))
+		}
+	}
+
+	for _, ref := range pkg.Spine.Itemrefs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		item, ok := items[ref.IDRef]
+		if !ok {
+			// Merged ids carry their volume as a "vNNNN_" prefix.
+			volID, _, _ := strings.Cut(ref.IDRef, "_")
+			if c := section("Volumes/" + volID); c != nil {
+				c.Problems = append(c.Problems, fmt.Sprintf("spine itemref %s has no manifest item", ref.IDRef))
+			}
+			continue
+		}
+		if c := section(item.Href); c != nil {
+			c.Documents++
+		}
+	}
+
+	ids := map[string]map[string]bool{}
+	navDir := path.Dir(book.NavHref)
+	var walk func(items []NavItem)
+	walk = func(navItems []NavItem) {
+		for _, n := range navItems {
+			walk(n.Children)
+			base, frag, _ := strings.Cut(n.Href, "#")
+			if base == "" || isAbsoluteURL(base) {
+				continue
+			}
+			if unescaped, err := url.PathUnescape(base); err == nil {
+				base = unescaped
+			}
+			target := normalizeEPUBPath(path.Join(navDir, base))
+			c := section(target)
+			if c == nil {
+				continue
+			}
+			if !hrefs[target] {
+				c.Problems = append(c.Problems, fmt.Sprintf("nav link %q points outside the manifest", n.Href))
+				continue
+			}
+			if frag == "" {
+				continue
+			}
+			docIDs, ok := ids[target]
+			if !ok {
+				docIDs = documentIDs(book, target)
+				ids[target] = docIDs
+			}
+			if !docIDs[frag] {
+				c.Problems = append(c.Problems, fmt.Sprintf("nav link %q: no element with id %q", n.Href, frag))
+			}
+		}
+	}
+	walk(book.NavItems)
+
+	out := make([]VolumeCheck, 0, len(checks))
+	for _, c := range checks {
+		if c.Documents == 0 {
+			c.Problems = append(c.Problems, "no spine documents")
+		}
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Prefix < out[j].Prefix })
+	return out, nil
+}
+
+// documentIDs collects the id attributes of the XHTML document at href.
+// Unreadable or malformed documents yield whatever was found before the
+// problem.
+func documentIDs(book *Book, href string) map[string]bool {
+	out := map[string]bool{}
+	data, err := book.ReadFile(href)
+	if err != nil {
+		return out
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return out
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			for _, attr := range se.Attr {
+				if attr.Name.Local == "id" {
+					out[attr.Value] = true
+				}
+			}
+		}
+	}
+}
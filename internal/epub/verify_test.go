@@ -0,0 +1,89 @@
+package epub
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeEPUBsVerify(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, Verify: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if len(stats.Verification) != 2 {
+		t.Fatalf("checks = %+v", stats.Verification)
+	}
+	for _, c := range stats.Verification {
+		if !c.OK() || c.Documents != 1 {
+			t.Fatalf("check %+v", c)
+		}
+	}
+}
+
+func TestVerifyMergedReportsBrokenVolume(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	merged := filepath.Join(t.TempDir(), "merged.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: merged}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+
+	// Drop the second volume's chapter from the archive.
+	broken := filepath.Join(t.TempDir(), "broken.epub")
+	copyZipWithout(t, merged, broken, "OEBPS/Volumes/v0002/chapter.xhtml")
+
+	checks, err := VerifyMerged(context.Background(), broken)
+	if err != nil {
+		t.Fatalf("VerifyMerged: %v", err)
+	}
+	if len(checks) != 2 || !checks[0].OK() || checks[1].OK() {
+		t.Fatalf("checks = %+v", checks)
+	}
+	if !strings.Contains(strings.Join(checks[1].Problems, "\n"), "Volumes/v0002/chapter.xhtml is missing") {
+		t.Fatalf("problems = %v", checks[1].Problems)
+	}
+}
+
+func copyZipWithout(t *testing.T, src, dst, skip string) {
+	t.Helper()
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		t.Fatalf("open %s: %v", src, err)
+	}
+	defer r.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		t.Fatalf("create %s: %v", dst, err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, entry := range r.File {
+		if entry.Name == skip {
+			continue
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			t.Fatalf("open entry: %v", err)
+		}
+		w, err := zw.CreateHeader(&entry.FileHeader)
+		if err != nil {
+			t.Fatalf("create entry: %v", err)
+		}
+		if _, err := io.Copy(w, rc); err != nil {
+			t.Fatalf("copy entry: %v", err)
+		}
+		rc.Close()
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+}
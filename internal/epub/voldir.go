@@ -0,0 +1,96 @@
+package epub
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultVolumeDirTemplate names volume folders v0001, v0002, ...
+const DefaultVolumeDirTemplate = "v{index:4}"
+
+// volumeDirPlaceholder matches {index}, {index:N} (zero-padded to N digits)
+// and {title}.
+var volumeDirPlaceholder = regexp.MustCompile(`\{(?:index(?::([1-9]))?|title)\}`)
+
+// maxVolumeDirTitle caps how many characters of a title go into a folder
+// name, keeping archive paths short.
+const maxVolumeDirTitle = 48
+
+// checkVolumeDirTemplate rejects templates that could not name a single
+// folder under Volumes/.
+func checkVolumeDirTemplate(tmpl string) error {
+	if strings.ContainsAny(tmpl, `/\`) {
+		return fmt.Errorf("invalid volume folder template %q: it names one folder and can't contain / or \\", tmpl)
+	}
+	if rest := volumeDirPlaceholder.ReplaceAllString(tmpl, ""); strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("invalid volume folder template %q (placeholders are {index}, {index:N} and {title})", tmpl)
+	}
+	return nil
+}
+
+// volumeDirName expands a template for the volume at 0-based index.
+func volumeDirName(tmpl string, index int, title string) string {
+	name := volumeDirPlaceholder.ReplaceAllStringFunc(tmpl, func(m string) string {
+		if m == "{title}" {
+			title := []rune(sanitizePathSegment(title))
+			if len(title) > maxVolumeDirTitle {
+				title = title[:maxVolumeDirTitle]
+			}
+			return strings.TrimRight(string(title), "-. ")
+		}
+		n := strconv.Itoa(index + 1)
+		if width, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(m, "{index:"), "}")); err == nil && len(n) < width {
+			n = strings.Repeat("0", width-len(n)) + n
+		}
+		return n
+	})
+	return sanitizePathSegment(name)
+}
+
+// sanitizePathSegment makes s safe as one folder name on any filesystem:
+// spaces and characters Windows or zip tools reject become "-", repeated
+// dashes are folded, and leading or trailing dashes and dots are dropped.
+func sanitizePathSegment(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range s {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(`<>:"/\|?*#%`, r) {
+			if !dash {
+				b.WriteByte('-')
+			}
+			dash = true
+			continue
+		}
+		b.WriteRune(r)
+		dash = r == '-'
+	}
+	return strings.Trim(b.String(), "-. ")
+}
+
+// volumeDirNames names each volume's folder under Volumes/ from tmpl (the
+// default when empty) and the volumes' display titles, indexed by
+// Volume.Index. Names must differ even ignoring case, since some
+// filesystems would fold them together.
+func volumeDirNames(tmpl string, vols []*Volume) ([]string, error) {
+	if tmpl == "" {
+		tmpl = DefaultVolumeDirTemplate
+	}
+	names := make([]string, len(vols))
+	seen := make(map[string]string, len(vols))
+	for _, vol := range vols {
+		name := volumeDirName(tmpl, vol.Index, vol.DisplayName)
+		if name == "" {
+			return nil, fmt.Errorf("volume folder template %q gives an empty name for %s", tmpl, vol.SourcePath)
+		}
+		key := strings.ToLower(name)
+		if other, dup := seen[key]; dup {
+			return nil, fmt.Errorf("volume folder template %q gives %q for both %s and %s; add {index} to tell them apart", tmpl, name, other, vol.SourcePath)
+		}
+		seen[key] = vol.SourcePath
+		names[vol.Index] = name
+	}
+	return names, nil
+}
@@ -0,0 +1,85 @@
+package epub
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVolumeDirName(t *testing.T) {
+	cases := []struct {
+		tmpl  string
+		index int
+		title string
+		want  string
+	}{
+		{DefaultVolumeDirTemplate, 3, "Ignored", "v0004"},
+		{"v{index:2}", 3, "", "v04"},
+		{"v{index}", 11, "", "v12"},
+		{"Vol-{index:2}-{title}", 3, "The Title", "Vol-04-The-Title"},
+		{"{index:3} {title}", 0, `What: "Really"? <Part 1/2>`, "001-What-Really-Part-1-2"},
+		{"{title}", 0, "  ..Hidden.  ", "Hidden"},
+		{"{title}", 0, "ソードアート・オンライン 1", "ソードアート・オンライン-1"},
+		{"{index}-{title}", 0, strings.Repeat("long ", 20), "1-" + strings.Repeat("long-", 9) + "lon"},
+	}
+	for _, c := range cases {
+		if got := volumeDirName(c.tmpl, c.index, c.title); got != c.want {
+			t.Errorf("volumeDirName(%q, %d, %q) = %q want %q", c.tmpl, c.index, c.title, got, c.want)
+		}
+	}
+}
+
+func TestCheckVolumeDirTemplate(t *testing.T) {
+	for _, ok := range []string{"", DefaultVolumeDirTemplate, "Vol-{index:2}-{title}", "book"} {
+		if err := checkVolumeDirTemplate(ok); err != nil {
+			t.Errorf("checkVolumeDirTemplate(%q): %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"a/{index}", `a\{index}`, "{volume}", "{index:0}", "v{index"} {
+		if err := checkVolumeDirTemplate(bad); err == nil {
+			t.Errorf("checkVolumeDirTemplate(%q): expected error", bad)
+		}
+	}
+}
+
+func TestMergeEPUBsVolumeDirTemplate(t *testing.T) {
+	a := buildTestEPUB(t, "Vol 1", "en")
+	b := buildTestEPUB(t, "Vol 2", "en")
+	out := filepath.Join(t.TempDir(), "merged.epub")
+
+	if _, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, VolumeDirTemplate: "book"}); err == nil || !strings.Contains(err.Error(), "{index}") {
+		t.Fatalf("expected duplicate folder names to be rejected, got %v", err)
+	}
+
+	stats, err := MergeEPUBs(context.Background(), []string{a, b}, MergeOptions{OutPath: out, VolumeDirTemplate: "{index:2}-{title}", Verify: true})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	for _, c := range stats.Verification {
+		if !c.OK() {
+			t.Fatalf("verify %s: %q", c.Prefix, c.Problems)
+		}
+	}
+
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	hrefs := map[string]string{}
+	for _, item := range book.Package.Manifest.Items {
+		hrefs[item.ID] = item.Href
+	}
+	book.Close()
+	if hrefs["v0001_chap"] != "Volumes/01-Vol-1/chapter.xhtml" || hrefs["v0002_chap"] != "Volumes/02-Vol-2/chapter.xhtml" {
+		t.Fatalf("hrefs = %v", hrefs)
+	}
+
+	origins, err := SpineProvenance(context.Background(), out)
+	if err != nil {
+		t.Fatalf("SpineProvenance: %v", err)
+	}
+	if len(origins) != 2 || origins[0].Volume != 1 || origins[1].Volume != 2 {
+		t.Fatalf("provenance = %+v", origins)
+	}
+}
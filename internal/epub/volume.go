@@ -2,11 +2,15 @@ package epub
 
 import (
 	"archive/zip"
+	"bytes"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -25,14 +29,36 @@ type Volume struct {
 	Prefix      string
 	FirstHref   string
 	CoverID     string
+	// CoverHref is the merged href of the volume's cover page, when
+	// MergeOptions.CoverEntries found one.
+	CoverHref string
+	// Arc is the story arc the volume is listed under in the merged nav
+	// (MergeOptions.Arcs), or "" for the top level.
+	Arc string
+	// IDAliases maps the ids of manifest items dropped for sharing an href
+	// with an earlier item to that item's id (see collapseDuplicateHrefs).
+	IDAliases map[string]string
+	// Warnings are problems with the source that loading worked around.
+	Warnings []string
+}
+
+// readFile reads the extracted file at a package-relative href.
+func (v *Volume) readFile(href string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(v.PackageDir, filepath.FromSlash(href)))
 }
 
 func loadVolume(ctx context.Context, idx int, source string) (*Volume, error) {
+	return loadVolumeIn(ctx, idx, source, "")
+}
+
+// loadVolumeIn is loadVolume with the extraction directory created under
+// tempRoot (the system default when empty).
+func loadVolumeIn(ctx context.Context, idx int, source, tempRoot string) (*Volume, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	tmpDir, err := os.MkdirTemp("", "novfmt-volume-*")
+	tmpDir, err := os.MkdirTemp(tempRoot, "novfmt-volume-*")
 	if err != nil {
 		return nil, fmt.Errorf("mktemp: %w", err)
 	}
@@ -50,95 +76,236 @@ func loadVolume(ctx context.Context, idx int, source string) (*Volume, error) {
 		return cleanup(fmt.Errorf("extract %s: %w", source, err))
 	}
 
-	containerPath := filepath.Join(tmpDir, "META-INF", "container.xml")
-	if err := ctx.Err(); err != nil {
+	info, err := readPackageInfo(source, func(name string) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return os.ReadFile(filepath.Join(tmpDir, filepath.FromSlash(name)))
+	})
+	if err != nil {
 		return cleanup(err)
 	}
+	pkgPath := filepath.Join(tmpDir, filepath.FromSlash(info.PackagePath))
 
-	data, err := os.ReadFile(containerPath)
-	if err != nil {
-		return cleanup(fmt.Errorf("read container.xml: %w", err))
+	display := fmt.Sprintf("Volume %d", idx+1)
+	if len(info.Package.Metadata.Titles) > 0 && strings.TrimSpace(info.Package.Metadata.Titles[0].Value) != "" {
+		display = info.Package.Metadata.Titles[0].Value
 	}
 
-	var root containerRoot
-	if err := xml.Unmarshal(data, &root); err != nil {
-		return cleanup(fmt.Errorf("parse container.xml: %w", err))
-	}
+	return &Volume{
+		Index:       idx,
+		SourcePath:  source,
+		TempDir:     tmpDir,
+		RootDir:     tmpDir,
+		PackagePath: pkgPath,
+		PackageDir:  filepath.Dir(pkgPath),
+		PackageDoc:  info.Package,
+		NavHref:     info.NavHref,
+		NavItems:    info.NavItems,
+		DisplayName: display,
+		CoverID:     info.CoverID,
+		Warnings:    info.Warnings,
+	}, nil
+}
 
-	if len(root.Rootfiles) == 0 {
-		return cleanup(fmt.Errorf("container missing rootfile"))
-	}
+// packageInfo is what loading finds out about a book from its container,
+// package document and nav, whether it was extracted or not.
+type packageInfo struct {
+	// PackagePath is the archive path of the package document.
+	PackagePath string
+	Package     *PackageDocument
+	NavHref     string
+	NavItems    []NavItem
+	CoverID     string
+	Warnings    []string
+}
 
-	pkgRel := filepath.Clean(root.Rootfiles[0].FullPath)
-	pkgPath := filepath.Join(tmpDir, filepath.FromSlash(pkgRel))
-	if err := ctx.Err(); err != nil {
-		return cleanup(err)
+// readPackageInfo parses the container, package document and nav of the
+// book at source (or, for EPUB 2 books without a nav, the NCX), reading
+// archive entries (slash-separated paths from the archive root) through
+// read. A missing entry must be a fs.ErrNotExist.
+func readPackageInfo(source string, read func(name string) ([]byte, error)) (*packageInfo, error) {
+	data, err := read("META-INF/container.xml")
+	if err != nil {
+		return nil, fmt.Errorf("read container.xml: %w", err)
 	}
 
-	pkgBytes, err := os.ReadFile(pkgPath)
+	pkgRel, err := parseContainer(data)
 	if err != nil {
-		return cleanup(fmt.Errorf("read package %s: %w", pkgRel, err))
+		return nil, fmt.Errorf("parse container.xml: %w", err)
+	}
+	pkgBytes, err := read(pkgRel)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("container.xml rootfile %q not found in %s", pkgRel, source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read package %s: %w", pkgRel, err)
 	}
 
 	var pkg PackageDocument
 	if err := xml.Unmarshal(pkgBytes, &pkg); err != nil {
-		return cleanup(fmt.Errorf("parse package: %w", err))
+		return nil, fmt.Errorf("parse package: %w", err)
 	}
+	info := &packageInfo{PackagePath: pkgRel, Package: &pkg}
 
-	var navHref string
 	for _, item := range pkg.Manifest.Items {
 		if hasProperty(item.Properties, "nav") {
-			navHref = item.Href
+			info.NavHref = item.Href
 			break
 		}
 	}
 
-	var coverID string
+	if info.NavHref == "" && strings.HasPrefix(pkg.Version, "3") {
+		if i := findUnflaggedNav(&pkg, path.Dir(pkgRel), read); i >= 0 {
+			item := &pkg.Manifest.Items[i]
+			item.Properties = addProperty(item.Properties, "nav")
+			info.NavHref = item.Href
+			info.Warnings = append(info.Warnings, fmt.Sprintf("%s: no manifest item has the nav property; using spine document %s, which has a toc nav", source, item.Href))
+		}
+	}
+
 	for _, meta := range pkg.Metadata.Meta {
 		if strings.EqualFold(meta.Name, "cover") && strings.TrimSpace(meta.Content) != "" {
-			coverID = strings.TrimSpace(meta.Content)
+			info.CoverID = strings.TrimSpace(meta.Content)
 			break
 		}
 	}
-	if coverID == "" {
+	if info.CoverID == "" {
 		for _, item := range pkg.Manifest.Items {
 			if hasProperty(item.Properties, "cover-image") {
-				coverID = item.ID
+				info.CoverID = item.ID
 				break
 			}
 		}
 	}
 
-	var navItems []NavItem
-	if navHref != "" {
-		navPath := filepath.Join(filepath.Dir(pkgPath), filepath.FromSlash(navHref))
-		items, err := parseNavFile(navPath)
+	if info.NavHref != "" {
+		data, err := read(path.Join(path.Dir(pkgRel), info.NavHref))
+		if err != nil {
+			return nil, fmt.Errorf("parse nav %s: %w", info.NavHref, err)
+		}
+		items, untyped, err := parseNavTOC(data)
 		if err != nil {
-			return cleanup(fmt.Errorf("parse nav %s: %w", navHref, err))
+			return nil, fmt.Errorf("parse nav %s: %w", info.NavHref, err)
+		}
+		info.NavItems = items
+		if untyped {
+			info.Warnings = append(info.Warnings, fmt.Sprintf("%s: nav %s has no epub:type=\"toc\" nav; using its first untyped <nav> as the TOC", source, info.NavHref))
 		}
-		navItems = items
 	}
+	if info.NavHref == "" {
+		if item := ncxItem(&pkg); item != nil {
+			// The NCX was optional before the nav and may be broken in books
+			// that otherwise load, so a bad one only costs the TOC.
+			data, err := read(path.Join(path.Dir(pkgRel), item.Href))
+			var items []NavItem
+			if err == nil {
+				items, err = parseNCX(data, path.Dir(item.Href))
+			}
+			if err != nil {
+				info.Warnings = append(info.Warnings, fmt.Sprintf("%s: ncx %s: %v; the volume has no TOC", source, item.Href, err))
+			}
+			info.NavItems = items
+		}
+	}
+	return info, nil
+}
 
-	display := fmt.Sprintf("Volume %d", idx+1)
-	if len(pkg.Metadata.Titles) > 0 && strings.TrimSpace(pkg.Metadata.Titles[0].Value) != "" {
-		display = pkg.Metadata.Titles[0].Value
+// findUnflaggedNav returns the manifest index of the first spine document
+// holding an epub:type="toc" nav, for EPUB 3 books that forgot to give their
+// nav document the nav property, or -1. pkgDir is the package document's
+// directory in the archive.
+func findUnflaggedNav(pkg *PackageDocument, pkgDir string, read func(name string) ([]byte, error)) int {
+	index := make(map[string]int, len(pkg.Manifest.Items))
+	for i, item := range pkg.Manifest.Items {
+		index[item.ID] = i
 	}
+	for _, ref := range pkg.Spine.Itemrefs {
+		i, ok := index[ref.IDRef]
+		if !ok || pkg.Manifest.Items[i].MediaType != "application/xhtml+xml" {
+			continue
+		}
+		data, err := read(path.Join(pkgDir, pkg.Manifest.Items[i].Href))
+		if err != nil || !bytes.Contains(data, []byte("toc")) {
+			continue
+		}
+		if items, err := scanTOCNav(data, false); err == nil && len(items) > 0 {
+			return i
+		}
+	}
+	return -1
+}
 
-	return &Volume{
-		Index:       idx,
-		SourcePath:  source,
-		TempDir:     tmpDir,
-		RootDir:     tmpDir,
-		PackagePath: pkgPath,
-		PackageDir:  filepath.Dir(pkgPath),
-		PackageDoc:  &pkg,
-		NavHref:     navHref,
-		NavItems:    navItems,
-		DisplayName: display,
-		CoverID:     coverID,
-	}, nil
+const packageMediaType = "application/oebps-package+xml"
+
+// parseContainer returns the package document path named by container.xml.
+// Elements and attributes are matched by local name so namespace prefixes
+// don't matter, and the OEBPS rootfile is preferred when several are listed.
+func parseContainer(data []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.CharsetReader = passthroughCharsetReader
+
+	var first, preferred string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "rootfile" {
+			continue
+		}
+		var fullPath, mediaType string
+		for _, attr := range se.Attr {
+			switch attr.Name.Local {
+			case "full-path":
+				fullPath = strings.TrimSpace(attr.Value)
+			case "media-type":
+				mediaType = strings.TrimSpace(attr.Value)
+			}
+		}
+		if fullPath == "" {
+			continue
+		}
+		if first == "" {
+			first = fullPath
+		}
+		if preferred == "" && strings.EqualFold(mediaType, packageMediaType) {
+			preferred = fullPath
+		}
+	}
+
+	chosen := preferred
+	if chosen == "" {
+		chosen = first
+	}
+	if chosen == "" {
+		return "", fmt.Errorf("container missing rootfile")
+	}
+	chosen = path.Clean(strings.TrimPrefix(strings.ReplaceAll(chosen, "\\", "/"), "/"))
+	if chosen == "." || chosen == ".." || strings.HasPrefix(chosen, "../") {
+		return "", fmt.Errorf("rootfile path %q escapes the archive", chosen)
+	}
+	return chosen, nil
+}
+
+// passthroughCharsetReader accepts ASCII-compatible encoding declarations.
+// container.xml only carries ASCII paths, so no transcoding is needed.
+func passthroughCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8", "us-ascii", "ascii", "iso-8859-1", "latin1", "windows-1252":
+		return input, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", charset)
 }
 
+// unzip extracts the archive at src into dst. Entries with absolute paths or
+// paths that climb out of dst, and links or other special files, are an
+// error rather than written.
 func unzip(src, dst string) error {
 	r, err := zip.OpenReader(src)
 	if err != nil {
@@ -146,11 +313,15 @@ func unzip(src, dst string) error {
 	}
 	defer r.Close()
 
+	dst = filepath.Clean(dst)
 	for _, f := range r.File {
 		target := filepath.Join(dst, filepath.FromSlash(f.Name))
-		if !strings.HasPrefix(target, dst) {
+		if path.IsAbs(f.Name) || filepath.IsAbs(filepath.FromSlash(f.Name)) || !isWithinDir(dst, target) {
 			return fmt.Errorf("zip entry %s escapes destination", f.Name)
 		}
+		if f.Mode()&(fs.ModeSymlink|fs.ModeDevice|fs.ModeNamedPipe|fs.ModeSocket) != 0 {
+			return fmt.Errorf("zip entry %s is not a regular file", f.Name)
+		}
 
 		if f.FileInfo().IsDir() {
 			if err := os.MkdirAll(target, 0o755); err != nil {
@@ -168,7 +339,7 @@ func unzip(src, dst string) error {
 			return err
 		}
 
-		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode())
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode().Perm()|0o600)
 		if err != nil {
 			rc.Close()
 			return err
@@ -180,7 +351,14 @@ func unzip(src, dst string) error {
 			return err
 		}
 		rc.Close()
-		out.Close()
+		if err := out.Close(); err != nil {
+			return err
+		}
+		if !f.Modified.IsZero() {
+			if err := os.Chtimes(target, f.Modified, f.Modified); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -0,0 +1,169 @@
+package epub
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseContainerNamespaced(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="us-ascii"?>
+<c:container version="1.0" xmlns:c="urn:oasis:names:tc:opendocument:xmlns:container">
+  <c:rootfiles>
+    <c:rootfile c:full-path="
+        OEBPS/alt.pdf " media-type="application/pdf"/>
+    <c:rootfile c:full-path="
+        OEBPS/content.opf " c:media-type="application/oebps-package+xml" extra="1"/>
+  </c:rootfiles>
+</c:container>`)
+
+	got, err := parseContainer(data)
+	if err != nil {
+		t.Fatalf("parseContainer: %v", err)
+	}
+	if got != "OEBPS/content.opf" {
+		t.Fatalf("rootfile = %q", got)
+	}
+}
+
+func TestParseContainerErrors(t *testing.T) {
+	cases := map[string]string{
+		"no-rootfile": `<container><rootfiles/></container>`,
+		"escapes":     `<container><rootfiles><rootfile full-path="../content.opf"/></rootfiles></container>`,
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseContainer([]byte(data)); err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
+
+func TestLoadVolumeMissingRootfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.epub")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("META-INF/container.xml")
+	if err != nil {
+		t.Fatalf("create entry: %v", err)
+	}
+	container := `<container xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="OEBPS/missing.opf"/></rootfiles></container>`
+	if _, err := w.Write([]byte(container)); err != nil {
+		t.Fatalf("write entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	f.Close()
+
+	_, err = loadVolume(context.Background(), 0, path)
+	if err == nil {
+		t.Fatalf("expected error for missing package document")
+	}
+	if !strings.Contains(err.Error(), "OEBPS/missing.opf") {
+		t.Fatalf("error should name the missing path, got %v", err)
+	}
+}
+
+func TestLoadVolumeUntypedNav(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Untyped",
+			identifier: "urn:test:untyped-nav",
+			items: []ManifestItem{
+				testItem("nav", "nav.xhtml", "nav"),
+				testItem("chap", "chapter.xhtml"),
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/nav.xhtml":     `<html xmlns="http://www.w3.org/1999/xhtml"><body><nav><h1>Contents</h1><ol><li><a href="chapter.xhtml">Chapter</a></li></ol></nav></body></html>`,
+		"OEBPS/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Text</p></body></html>`,
+	})
+
+	vol, err := loadVolume(context.Background(), 0, input)
+	if err != nil {
+		t.Fatalf("loadVolume: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	if len(vol.NavItems) != 1 || vol.NavItems[0].Href != "chapter.xhtml" {
+		t.Fatalf("nav items = %+v", vol.NavItems)
+	}
+	if len(vol.Warnings) != 1 || !strings.Contains(vol.Warnings[0], "nav.xhtml") {
+		t.Fatalf("warnings = %q", vol.Warnings)
+	}
+
+	stats, err := MergeEPUBs(context.Background(), []string{input, input}, MergeOptions{OutPath: filepath.Join(t.TempDir(), "merged.epub")})
+	if err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	if len(stats.Warnings) != 2 {
+		t.Fatalf("merge warnings = %q", stats.Warnings)
+	}
+}
+
+func TestLoadVolumeUnflaggedNav(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Unflagged",
+			lang:       "en",
+			identifier: "urn:test:unflagged-nav",
+			items: []ManifestItem{
+				testItem("chap", "Text/chapter.xhtml"),
+				testItem("toc", "Text/toc.xhtml"),
+			},
+			spine: []string{"toc", "chap"},
+		}),
+		"OEBPS/Text/toc.xhtml":     `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body><nav epub:type="toc"><ol><li><a href="chapter.xhtml">Chapter</a></li></ol></nav></body></html>`,
+		"OEBPS/Text/chapter.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Text with a toc word</p></body></html>`,
+	})
+
+	vol, err := loadVolume(context.Background(), 0, input)
+	if err != nil {
+		t.Fatalf("loadVolume: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	if vol.NavHref != "Text/toc.xhtml" || len(vol.NavItems) != 1 || vol.NavItems[0].Href != "chapter.xhtml" {
+		t.Fatalf("nav %q, items %+v", vol.NavHref, vol.NavItems)
+	}
+	if len(vol.Warnings) != 1 || !strings.Contains(vol.Warnings[0], "Text/toc.xhtml") {
+		t.Fatalf("warnings = %q", vol.Warnings)
+	}
+
+	out := filepath.Join(t.TempDir(), "merged.epub")
+	if _, err := MergeEPUBs(context.Background(), []string{input, input}, MergeOptions{OutPath: out}); err != nil {
+		t.Fatalf("MergeEPUBs: %v", err)
+	}
+	book, err := OpenBook(context.Background(), out)
+	if err != nil {
+		t.Fatalf("OpenBook: %v", err)
+	}
+	defer book.Close()
+	// The source nav is replaced by the generated one like any other.
+	if n := len(book.Package.Spine.Itemrefs); n != 2 || book.NavHref != "nav.xhtml" {
+		t.Fatalf("spine has %d items, nav %q", n, book.NavHref)
+	}
+}
+
+func TestUnzipRejectsEscapingEntries(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "../book-evil.txt", "OEBPS/../../evil.txt", "/etc/evil.txt"} {
+		src := writeRawZip(t, [][2]string{{"mimetype", epubMimetype}, {name, "x"}})
+		parent := t.TempDir()
+		dst := filepath.Join(parent, "book")
+		if err := os.Mkdir(dst, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := unzip(src, dst); err == nil || !strings.Contains(err.Error(), "escapes") {
+			t.Errorf("%s: err = %v", name, err)
+		}
+		if entries, _ := os.ReadDir(parent); len(entries) != 1 {
+			t.Errorf("%s: written outside the destination", name)
+		}
+	}
+}
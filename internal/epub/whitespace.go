@@ -0,0 +1,104 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// whitespaceKeep holds elements whose text keeps its whitespace as written.
+var whitespaceKeep = map[string]bool{
+	"pre": true, "textarea": true, "script": true, "style": true,
+}
+
+// trimWhitespaceDocument tidies the text nodes of an XHTML document: each
+// run of ASCII whitespace that holds a line break becomes a single newline
+// (dropping trailing spaces and indentation) and any other run of two or
+// more becomes one space. Runs are shortened, never removed, so rendering
+// is unchanged, and ideographic spaces and no-break spaces are left alone,
+// which keeps CJK indentation intact. Tags, attributes, comments and CDATA
+// are copied byte for byte. It returns the new document and the number of
+// bytes removed.
+func trimWhitespaceDocument(data []byte) ([]byte, int, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	var (
+		out   bytes.Buffer
+		keep  int
+		last  int64
+		saved int
+	)
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if keep > 0 || whitespaceKeep[strings.ToLower(t.Name.Local)] {
+				keep++
+			}
+		case xml.EndElement:
+			if keep > 0 {
+				keep--
+			}
+		case xml.CharData:
+			end := dec.InputOffset()
+			raw := data[start:end]
+			if keep > 0 || bytes.HasPrefix(raw, []byte("<![CDATA[")) {
+				continue
+			}
+			trimmed := collapseASCIISpace(raw)
+			if len(trimmed) == len(raw) {
+				continue
+			}
+			out.Write(data[last:start])
+			out.Write(trimmed)
+			last = end
+			saved += len(raw) - len(trimmed)
+		}
+	}
+	if saved == 0 {
+		return data, 0, nil
+	}
+	out.Write(data[last:])
+	return out.Bytes(), saved, nil
+}
+
+func isASCIISpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// collapseASCIISpace shortens the whitespace runs of raw text as described
+// for trimWhitespaceDocument.
+func collapseASCIISpace(raw []byte) []byte {
+	out := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); {
+		if !isASCIISpace(raw[i]) {
+			out = append(out, raw[i])
+			i++
+			continue
+		}
+		j, newline := i, false
+		for j < len(raw) && isASCIISpace(raw[j]) {
+			newline = newline || raw[j] == '\n' || raw[j] == '\r'
+			j++
+		}
+		switch {
+		case newline:
+			out = append(out, '\n')
+		case j-i > 1:
+			out = append(out, ' ')
+		default:
+			out = append(out, raw[i])
+		}
+		i = j
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package epub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrimWhitespaceDocument(t *testing.T) {
+	doc := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\r\n" +
+		"<html xmlns=\"http://www.w3.org/1999/xhtml\">\n" +
+		"<body>  \n" +
+		"    <p class=\"a  b\">Two  spaces and\ttab, trailing   \n      indented line.</p>\n" +
+		"    <p>　全角の字下げ。  続き  NBSP</p>\n" +
+		"<pre>  keep   this  \n   as is</pre>\n" +
+		"<!--   comment   --><p><![CDATA[  raw   ]]></p>\n" +
+		"</body></html>"
+	want := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" +
+		"<html xmlns=\"http://www.w3.org/1999/xhtml\">\n" +
+		"<body>\n" +
+		"<p class=\"a  b\">Two spaces and\ttab, trailing\nindented line.</p>\n" +
+		"<p>　全角の字下げ。 続き  NBSP</p>\n" +
+		"<pre>  keep   this  \n   as is</pre>\n" +
+		"<!--   comment   --><p><![CDATA[  raw   ]]></p>\n" +
+		"</body></html>"
+
+	out, saved, err := trimWhitespaceDocument([]byte(doc))
+	if err != nil {
+		t.Fatalf("trimWhitespaceDocument: %v", err)
+	}
+	if string(out) != want {
+		t.Fatalf("got:\n%q\nwant:\n%q", out, want)
+	}
+	if saved != len(doc)-len(want) {
+		t.Fatalf("saved = %d want %d", saved, len(doc)-len(want))
+	}
+
+	if _, saved, _ := trimWhitespaceDocument(out); saved != 0 {
+		t.Fatalf("second pass saved %d bytes", saved)
+	}
+}
+
+func TestRewriteEPUBTrimWhitespace(t *testing.T) {
+	input := buildTestEPUBFiles(t, map[string]string{
+		"OEBPS/content.opf": testOPF(testPackage{
+			title:      "Spacey",
+			identifier: "urn:test:whitespace",
+			items: []ManifestItem{
+				testItem("chap", "chapter.xhtml"),
+			},
+			spine: []string{"chap"},
+		}),
+		"OEBPS/chapter.xhtml": "<html xmlns=\"http://www.w3.org/1999/xhtml\"><body>\n    <p>Chapter   text.</p>   \n</body></html>",
+	})
+
+	if _, err := RewriteEPUB(context.Background(), input, RewriteOptions{}); err == nil {
+		t.Fatalf("expected an error with neither rules nor TrimWhitespace")
+	}
+	stats, err := RewriteEPUB(context.Background(), input, RewriteOptions{
+		TrimWhitespace: true,
+		Rules:          []RewriteRule{{Find: "Chapter", Replace: "Section"}},
+	})
+	if err != nil {
+		t.Fatalf("RewriteEPUB: %v", err)
+	}
+	if stats.MatchCount != 1 || stats.FilesChanged != 1 || stats.WhitespaceBytes == 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	vol, err := loadVolume(context.Background(), 0, input)
+	if err != nil {
+		t.Fatalf("reopen epub: %v", err)
+	}
+	defer os.RemoveAll(vol.TempDir)
+	data, err := os.ReadFile(filepath.Join(vol.PackageDir, "chapter.xhtml"))
+	if err != nil {
+		t.Fatalf("read chapter: %v", err)
+	}
+	if doc := string(data); !strings.Contains(doc, "Section text.") || strings.Contains(doc, "  ") {
+		t.Fatalf("chapter = %q", doc)
+	}
+}
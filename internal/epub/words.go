@@ -0,0 +1,107 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ChapterWords is the word count of one spine document.
+type ChapterWords struct {
+	Href  string
+	Words int
+	// Cover is set for the cover and title page documents, which are
+	// expected to have little or no text.
+	Cover bool
+}
+
+// ChapterWordCounts returns the word count of every XHTML spine document in
+// reading order.
+func ChapterWordCounts(ctx context.Context, input string) ([]ChapterWords, error) {
+	if input == "" {
+		return nil, fmt.Errorf("input EPUB path is required")
+	}
+
+	book, err := OpenBook(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	covers := map[string]bool{}
+	hrefs, err := findCoverDocuments(book.Package, book.NavHref, book.CoverID, book.ReadFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, href := range hrefs {
+		covers[normalizeEPUBPath(href)] = true
+	}
+
+	items := make(map[string]ManifestItem)
+	for _, item := range book.Package.Manifest.Items {
+		items[item.ID] = item
+	}
+
+	var out []ChapterWords
+	for _, ref := range book.Package.Spine.Itemrefs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		item, ok := items[ref.IDRef]
+		if !ok || item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		data, err := book.ReadFile(item.Href)
+		if err != nil {
+			return nil, err
+		}
+		text, err := documentText(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", item.Href, err)
+		}
+		out = append(out, ChapterWords{
+			Href:  item.Href,
+			Words: countWords(text),
+			Cover: covers[normalizeEPUBPath(item.Href)],
+		})
+	}
+	return out, nil
+}
+
+// documentText returns the text of an XHTML document's body, leaving out
+// scripts, styles and ruby annotations, with a space at each block
+// boundary so words in separate paragraphs don't run together.
+func documentText(data []byte) (string, error) {
+	var b strings.Builder
+	err := walkDocumentText(data, func(text []byte) { b.Write(text) }, func() { b.WriteByte(' ') })
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// countWords counts runs of letters and digits. Chinese and Japanese text
+// isn't space-separated, so each Han, Hiragana or Katakana character counts
+// as a word of its own.
+func countWords(s string) int {
+	n := 0
+	inWord := false
+	for _, r := range s {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+			n++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+			if !inWord {
+				n++
+				inWord = true
+			}
+		case r == '\'' || r == '’' || r == '-':
+			// Keep contractions and hyphenated words whole.
+		default:
+			inWord = false
+		}
+	}
+	return n
+}
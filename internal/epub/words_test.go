@@ -0,0 +1,53 @@
+package epub
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCountWords(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"  The quick, brown fox!  ", 4},
+		{"don't over-think it", 3},
+		{"第一章 はじめに", 7},
+		{"Volume 2: 第二章", 5},
+		{"— … ***", 0},
+	}
+	for _, tc := range cases {
+		if got := countWords(tc.in); got != tc.want {
+			t.Errorf("countWords(%q) = %d want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestDocumentText(t *testing.T) {
+	doc := `<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Skip me</title><style>p{}</style></head>` +
+		`<body><p>One<br/>two</p><script>var x = 1;</script><p>three&nbsp;four</p>` +
+		`<p>sp<i>lit</i></p><p><ruby>漢<rt>かん</rt></ruby>字</p></body></html>`
+	text, err := documentText([]byte(doc))
+	if err != nil {
+		t.Fatalf("documentText: %v", err)
+	}
+	if got := countWords(text); got != 7 {
+		t.Fatalf("text %q has %d words, want 7", text, got)
+	}
+}
+
+func TestChapterWordCounts(t *testing.T) {
+	input := buildChaptersEPUB(t, "Book", "A real chapter", "x")
+
+	counts, err := ChapterWordCounts(context.Background(), input)
+	if err != nil {
+		t.Fatalf("ChapterWordCounts: %v", err)
+	}
+	if len(counts) != 2 {
+		t.Fatalf("counts = %+v", counts)
+	}
+	if counts[0].Href != "c1.xhtml" || counts[0].Words != 3 || counts[1].Words != 1 {
+		t.Fatalf("counts = %+v", counts)
+	}
+}